@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+
+	"golb/internal/config"
+	"golb/internal/l4"
+	"golb/internal/proxy"
+	"golb/internal/proxyproto"
+)
+
+// listeners are the client-facing servers: plaintext HTTP/1.1 (plus h2c
+// when enabled), HTTPS with HTTP/2, and HTTP/3 over QUIC, all serving the
+// same handler so that the middleware chain and hot-reload apply to every
+// protocol alike; and the layer-4 tcp and udp listeners, which take their
+// pools from the gateway's current table.
+type listeners struct {
+	plain *http.Server
+	tls   *http.Server
+	h3    *http3.Server
+	tcp   []*l4.TCP
+	udp   []*l4.UDP
+
+	// PROXY protocol headers accepted on the plain and tls listeners; QUIC
+	// has no byte stream to prefix them to.
+	proxyTrusted []*net.IPNet
+	proxyTimeout time.Duration
+
+	unixMode os.FileMode // permissions of unix:// listeners; 0 leaves the umask's
+}
+
+// newListeners builds the servers cfg asks for. The certificate is loaded
+// once here; changing it, or the layer-4 listeners, takes a restart.
+func newListeners(cfg config.Config, handler http.Handler, gw *proxy.Gateway) (*listeners, error) {
+	s := cfg.Server
+	trusted, _ := s.ProxyProtocol.ParsedTrustedCIDRs() // validated on load
+	l := &listeners{proxyTrusted: trusted, proxyTimeout: s.ProxyProtocol.ParsedTimeout(), unixMode: s.ParsedUnixSocketMode()}
+	for _, lc := range cfg.TCP {
+		l.tcp = append(l.tcp, l4.NewTCP(lc, poolFunc(gw)))
+	}
+	for _, lc := range cfg.UDP {
+		l.udp = append(l.udp, l4.NewUDP(lc, poolFunc(gw)))
+	}
+	if !s.TLS.Enabled() || s.TLS.ListenAddr != "" {
+		protocols := new(http.Protocols)
+		protocols.SetHTTP1(true)
+		protocols.SetUnencryptedHTTP2(s.H2C)
+		l.plain = newServer(cfg, cfg.ListenAddr, handler)
+		l.plain.Protocols = protocols
+	}
+	if !s.TLS.Enabled() {
+		return l, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.TLS.CertFile, s.TLS.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("server tls: %w", err)
+	}
+	tlsHandler := handler
+	if s.HTTP3.Enabled {
+		addr := cfg.HTTP3ListenAddr()
+		udp, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("server http3: %w", err)
+		}
+		l.h3 = &http3.Server{
+			Addr:       addr,
+			Handler:    handler,
+			TLSConfig:  http3.ConfigureTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}),
+			QUICConfig: quicConfig(s),
+		}
+		tlsHandler = altSvc(handler, udp.Port, s.HTTP3.ParsedAltSvcAge())
+	}
+
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	l.tls = newServer(cfg, cfg.TLSListenAddr(), tlsHandler)
+	l.tls.Protocols = protocols
+	l.tls.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	return l, nil
+}
+
+// poolFunc resolves pools in gw's table at the time of each connection.
+func poolFunc(gw *proxy.Gateway) l4.PoolFunc {
+	return func(name string) l4.Upstream {
+		if p := gw.Table().Pool(name); p != nil {
+			return l4.Upstream{Picker: p.Picker(), ProxyProtocol: p.ProxyProtocol()}
+		}
+		return l4.Upstream{}
+	}
+}
+
+// newServer returns an http.Server with the configured timeouts and HTTP/2
+// tuning.
+func newServer(cfg config.Config, addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       cfg.Server.ParsedReadTimeout(),
+		ReadHeaderTimeout: cfg.Server.ParsedReadHeaderTimeout(),
+		WriteTimeout:      cfg.Server.ParsedWriteTimeout(),
+		IdleTimeout:       cfg.Server.ParsedIdleTimeout(),
+		HTTP2: &http.HTTP2Config{
+			MaxConcurrentStreams:          cfg.Server.HTTP2.MaxConcurrentStreams,
+			MaxReceiveBufferPerStream:     cfg.Server.HTTP2.MaxReceiveBufferPerStream,
+			MaxReceiveBufferPerConnection: cfg.Server.HTTP2.MaxReceiveBufferPerConnection,
+		},
+	}
+}
+
+// quicConfig applies the HTTP/2 stream and window limits to HTTP/3, so that
+// one set of knobs bounds a client whichever protocol it speaks.
+func quicConfig(s config.ServerCfg) *quic.Config {
+	q := &quic.Config{MaxIdleTimeout: s.ParsedIdleTimeout()}
+	if n := s.HTTP2.MaxConcurrentStreams; n > 0 {
+		q.MaxIncomingStreams = int64(n)
+	}
+	if n := s.HTTP2.MaxReceiveBufferPerStream; n > 0 {
+		q.MaxStreamReceiveWindow = uint64(n)
+		q.InitialStreamReceiveWindow = min(uint64(n), 512<<10)
+	}
+	if n := s.HTTP2.MaxReceiveBufferPerConnection; n > 0 {
+		q.MaxConnectionReceiveWindow = uint64(n)
+		q.InitialConnectionReceiveWindow = min(uint64(n), 512<<10)
+	}
+	return q
+}
+
+// altSvc advertises the HTTP/3 listener on every HTTPS response (RFC 7838).
+func altSvc(next http.Handler, port int, maxAge time.Duration) http.Handler {
+	value := fmt.Sprintf(`h3=":%d"; ma=%d`, port, int(maxAge.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Alt-Svc", value)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// start runs every server in its own goroutine. A listener that fails to
+// start brings the process down.
+func (l *listeners) start() {
+	if l.plain != nil {
+		go serve("http", l.plain.Addr, func() error {
+			ln, err := l.listen(l.plain.Addr)
+			if err != nil {
+				return err
+			}
+			return l.plain.Serve(ln)
+		})
+	}
+	if l.tls != nil {
+		go serve("https", l.tls.Addr, func() error {
+			ln, err := l.listen(l.tls.Addr)
+			if err != nil {
+				return err
+			}
+			return l.tls.ServeTLS(ln, "", "")
+		})
+	}
+	if l.h3 != nil {
+		go serve("http3", l.h3.Addr, l.h3.ListenAndServe)
+	}
+	for _, t := range l.tcp {
+		go serve("tcp", t.Addr, t.ListenAndServe)
+	}
+	for _, u := range l.udp {
+		go serve("udp", u.Addr, u.ListenAndServe)
+	}
+}
+
+// listen binds an address for an HTTP server: a unix:// socket path, or a
+// TCP address reading PROXY protocol headers from trusted sources.
+func (l *listeners) listen(addr string) (net.Listener, error) {
+	if path, ok := config.UnixSocketPath(addr); ok {
+		return l.listenUnix(path)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return proxyproto.NewListener(ln, l.proxyTrusted, l.proxyTimeout), nil
+}
+
+// listenUnix binds the Unix socket at path, replacing a stale socket left by
+// a process that did not shut down cleanly, and applies the configured
+// permissions. The socket file is removed when the listener closes.
+func (l *listeners) listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("unix socket %s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if l.unixMode != 0 {
+		if err := os.Chmod(path, l.unixMode); err != nil {
+			_ = ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+func serve(protocol, addr string, listen func() error) {
+	slog.Info("listener started", "protocol", protocol, "addr", addr)
+	if err := listen(); err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, l4.ErrListenerClosed) {
+		slog.Error("server error", "protocol", protocol, "addr", addr, "error", err)
+		os.Exit(1)
+	}
+}
+
+// shutdown drains every server, waiting for in-flight requests until ctx is
+// done, and returns the first error.
+func (l *listeners) shutdown(ctx context.Context) error {
+	var errs []error
+	if l.h3 != nil {
+		errs = append(errs, l.h3.Shutdown(ctx))
+	}
+	if l.tls != nil {
+		errs = append(errs, l.tls.Shutdown(ctx))
+	}
+	if l.plain != nil {
+		errs = append(errs, l.plain.Shutdown(ctx))
+	}
+	for _, t := range l.tcp {
+		errs = append(errs, t.Shutdown(ctx))
+	}
+	for _, u := range l.udp {
+		errs = append(errs, u.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// addrs lists the listening addresses for the startup log.
+func (l *listeners) addrs() []string {
+	var out []string
+	if l.plain != nil {
+		out = append(out, displayAddr("http", l.plain.Addr))
+	}
+	if l.tls != nil {
+		out = append(out, displayAddr("https", l.tls.Addr))
+	}
+	if l.h3 != nil {
+		out = append(out, "h3://"+l.h3.Addr)
+	}
+	for _, t := range l.tcp {
+		out = append(out, "tcp://"+t.Addr)
+	}
+	for _, u := range l.udp {
+		out = append(out, "udp://"+u.Addr)
+	}
+	return out
+}
+
+// displayAddr prefixes a TCP address with scheme; unix:// addresses already
+// carry theirs.
+func displayAddr(scheme, addr string) string {
+	if _, ok := config.UnixSocketPath(addr); ok {
+		return addr
+	}
+	return scheme + "://" + addr
+}
@@ -7,7 +7,7 @@
 // The gateway supports zero-downtime hot-reload: edit gateway.yaml while the
 // process is running and changes take effect immediately — no restart needed.
 // Shutdown is graceful: send SIGINT or SIGTERM and in-flight requests are
-// given up to 10 seconds to complete.
+// given up to server.shutdown_timeout (default 10 seconds) to complete.
 package main
 
 import (
@@ -23,11 +23,14 @@ import (
 	"syscall"
 	"time"
 
+	"golb/internal/admin"
+	"golb/internal/cache"
+	"golb/internal/canary"
 	"golb/internal/config"
+	"golb/internal/discovery"
 	"golb/internal/health"
 	"golb/internal/middleware"
 	"golb/internal/proxy"
-	"golb/internal/strategy"
 )
 
 // Version information — set at build time via -ldflags.
@@ -64,7 +67,7 @@ func main() {
 	}
 
 	// ── Build runtime objects ─────────────────────────────────────────────────
-	gw, monitor, err := buildGateway(cfg)
+	gw, monitor, rollouts, discoveries, err := buildGateway(cfg)
 	if err != nil {
 		slog.Error("failed to initialise gateway", "error", err)
 		os.Exit(1)
@@ -73,10 +76,17 @@ func main() {
 	if cfg.HealthCheck.Enabled {
 		monitor.Start()
 	}
+	rollouts.Start()
+
+	// The shutdown grace period is read at shutdown time, so it follows
+	// hot-reloads; the other server timeouts apply from startup only.
+	var shutdownTimeout atomic.Int64
+	shutdownTimeout.Store(int64(cfg.Server.ParsedShutdownTimeout()))
 
 	// ── Build middleware chain ────────────────────────────────────────────────
 	// The atomicHandler lets us swap the entire chain at runtime (hot-reload
-	// of rate-limit or auth settings) without restarting the server.
+	// of rate-limit, auth or compression settings) without restarting the
+	// server.
 	var current atomic.Value
 	buildChain := func(c config.Config) http.Handler {
 		var h http.Handler = gw
@@ -86,6 +96,12 @@ func main() {
 		if c.RateLimit.Enabled {
 			h = middleware.RateLimiter(c.RateLimit.RPS, c.RateLimit.Burst)(h)
 		}
+		// Compression sits inside the logger so logged sizes are bytes on the
+		// wire, and outside everything else so gateway-generated responses
+		// are compressed too.
+		if c.Compression.Enabled {
+			h = middleware.Compress(c.Compression.Encodings, c.Compression.MinSize, c.Compression.ContentTypes)(h)
+		}
 		return middleware.Logger(h)
 	}
 	current.Store(buildChain(cfg))
@@ -97,25 +113,35 @@ func main() {
 	// ── Hot-reload ────────────────────────────────────────────────────────────
 	if v != nil {
 		config.Watch(v, func(newCfg config.Config) {
-			newBackends, err := strategy.NewBackends(newCfg.Backends)
+			table, err := proxy.BuildTable(newCfg)
 			if err != nil {
-				slog.Error("hot-reload: invalid backends", "error", err)
+				slog.Error("hot-reload: failed to rebuild routing table", "error", err)
 				return
 			}
-			newPicker, err := strategy.New(newCfg.Strategy, newBackends)
+			pages, err := proxy.NewErrorPages(newCfg.Errors)
 			if err != nil {
-				slog.Error("hot-reload: failed to rebuild picker", "error", err)
+				slog.Error("hot-reload: invalid error pages", "error", err)
 				return
 			}
-			gw.UpdatePicker(newPicker)
-			monitor.UpdateBackends(newBackends)
+			// Rollouts re-apply their current canary weights, and discovery
+			// the backends found so far, to the new table before it goes live.
+			rollouts.Update(newCfg, table)
+			discoveries.Update(newCfg, table)
+			gw.UpdateTable(table)
+			gw.SetErrorPages(pages)
+			gw.Cache().SetLimits(newCfg.Cache.ParsedMaxSize(), newCfg.Cache.ParsedMaxEntrySize())
+			monitor.UpdateTargets(table.HealthTargets())
 			current.Store(buildChain(newCfg))
+			shutdownTimeout.Store(int64(newCfg.Server.ParsedShutdownTimeout()))
 
 			slog.Info("hot-reload applied",
 				"backends", len(newCfg.Backends),
+				"pools", len(table.Pools()),
+				"routes", len(table.Routes()),
 				"strategy", newCfg.Strategy,
 				"rate_limit", newCfg.RateLimit.Enabled,
 				"auth", newCfg.Auth.Enabled,
+				"compression", newCfg.Compression.Enabled,
 			)
 		})
 	}
@@ -132,30 +158,43 @@ func main() {
 	})
 	mux.Handle("/", atomicHandler)
 
-	// ── HTTP server ───────────────────────────────────────────────────────────
-	srv := &http.Server{
-		Addr:         cfg.ListenAddr,
-		Handler:      mux,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  120 * time.Second,
+	// ── HTTP servers ──────────────────────────────────────────────────────────
+	srv, err := newListeners(cfg, mux, gw)
+	if err != nil {
+		slog.Error("failed to start listeners", "error", err)
+		os.Exit(1)
 	}
 
-	go func() {
-		slog.Info("gateway listening",
-			"addr", cfg.ListenAddr,
-			"strategy", cfg.Strategy,
-			"backends", len(cfg.Backends),
-			"health_check", cfg.HealthCheck.Enabled,
-			"rate_limit", cfg.RateLimit.Enabled,
-			"auth", cfg.Auth.Enabled,
-			"version", version,
-		)
-		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			slog.Error("server error", "error", err)
-			os.Exit(1)
+	var adminSrv *http.Server
+	if cfg.Admin.Enabled {
+		adminHandler := admin.New(gw)
+		adminHandler.SetRollouts(rollouts)
+		adminHandler.SetTCP(srv.tcp)
+		adminHandler.SetUDP(srv.udp)
+		adminSrv = &http.Server{
+			Addr:         cfg.Admin.ListenAddr,
+			Handler:      adminHandler,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
 		}
-	}()
+		go func() {
+			slog.Info("admin API listening", "addr", cfg.Admin.ListenAddr)
+			if err := adminSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("admin server error", "error", err)
+			}
+		}()
+	}
+
+	slog.Info("gateway listening",
+		"addrs", srv.addrs(),
+		"strategy", cfg.Strategy,
+		"backends", len(cfg.Backends),
+		"health_check", cfg.HealthCheck.Enabled,
+		"rate_limit", cfg.RateLimit.Enabled,
+		"auth", cfg.Auth.Enabled,
+		"version", version,
+	)
+	srv.start()
 
 	// ── Graceful shutdown ─────────────────────────────────────────────────────
 	quit := make(chan os.Signal, 1)
@@ -165,11 +204,16 @@ func main() {
 	slog.Info("shutting down gateway")
 
 	monitor.Stop()
+	rollouts.Stop()
+	discoveries.Stop()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(shutdownTimeout.Load()))
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
+	if adminSrv != nil {
+		_ = adminSrv.Shutdown(ctx)
+	}
+	if err := srv.shutdown(ctx); err != nil {
 		slog.Error("forced shutdown", "error", err)
 		os.Exit(1)
 	}
@@ -177,26 +221,36 @@ func main() {
 	slog.Info("gateway stopped")
 }
 
-// buildGateway constructs the Gateway and its associated health Monitor from
-// the given Config.
-func buildGateway(cfg config.Config) (*proxy.Gateway, *health.Monitor, error) {
-	backends, err := strategy.NewBackends(cfg.Backends)
+// buildGateway constructs the Gateway and its associated health Monitor,
+// canary rollout Manager and discovery Manager from the given Config. The
+// discovery providers start right away.
+func buildGateway(cfg config.Config) (*proxy.Gateway, *health.Monitor, *canary.Manager, *discovery.Manager, error) {
+	table, err := proxy.BuildTable(cfg)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, nil, err
 	}
 
-	picker, err := strategy.New(cfg.Strategy, backends)
+	pages, err := proxy.NewErrorPages(cfg.Errors)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, nil, err
 	}
 
-	gw := proxy.New(picker)
+	rollouts := canary.NewManager()
+	rollouts.Update(cfg, table)
 
-	mon := health.New(backends, health.Config{
+	gw := proxy.NewWithTable(table)
+	gw.SetErrorPages(pages)
+	gw.SetCache(cache.New(cfg.Cache.ParsedMaxSize(), cfg.Cache.ParsedMaxEntrySize()))
+
+	mon := health.New(table.HealthTargets(), health.Config{
 		Interval: cfg.HealthCheck.ParsedInterval(),
 		Timeout:  cfg.HealthCheck.ParsedTimeout(),
 		Path:     cfg.HealthCheck.Path,
 	})
 
-	return gw, mon, nil
+	// Discovered backends are probed from the moment they join their pool.
+	discoveries := discovery.NewManager(func() { mon.UpdateTargets(gw.Table().HealthTargets()) })
+	discoveries.Update(cfg, table)
+
+	return gw, mon, rollouts, discoveries, nil
 }
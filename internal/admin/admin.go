@@ -0,0 +1,181 @@
+// Package admin implements the gateway's admin API, served on its own
+// listener (default :9091) so it is never reachable through the public port.
+//
+// Endpoints:
+//   - GET  /metrics                   — Prometheus text-format counters and gauges.
+//   - GET  /backends                  — JSON snapshot of every backend's runtime state.
+//   - GET  /rollouts                  — JSON status of every canary rollout.
+//   - GET  /rollouts/{route}          — JSON status of one rollout.
+//   - POST /rollouts/{route}/{action} — pause, resume, promote, rollback or restart.
+//   - GET  /cache                     — JSON size and hit counters of the response cache.
+//   - POST /cache/purge               — drop cached responses by ?path= or ?prefix=, or all.
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"golb/internal/canary"
+	"golb/internal/l4"
+	"golb/internal/proxy"
+	"golb/internal/strategy"
+)
+
+// Server is the admin API http.Handler. It reads live state from the Gateway
+// on every request, so it never needs updating on hot-reload.
+type Server struct {
+	gw       *proxy.Gateway
+	rollouts *canary.Manager
+	tcp      []*l4.TCP
+	udp      []*l4.UDP
+	mux      *http.ServeMux
+}
+
+// New creates an admin Server reporting on gw.
+func New(gw *proxy.Gateway) *Server {
+	s := &Server{gw: gw, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /metrics", s.handleMetrics)
+	s.mux.HandleFunc("GET /backends", s.handleBackends)
+	s.mux.HandleFunc("GET /rollouts", s.handleRollouts)
+	s.mux.HandleFunc("GET /rollouts/{route}", s.handleRollout)
+	s.mux.HandleFunc("POST /rollouts/{route}/{action}", s.handleRolloutAction)
+	s.mux.HandleFunc("GET /cache", s.handleCache)
+	s.mux.HandleFunc("POST /cache/purge", s.handleCachePurge)
+	return s
+}
+
+// SetRollouts exposes the canary rollouts of m. Must be called before the
+// server starts handling requests.
+func (s *Server) SetRollouts(m *canary.Manager) { s.rollouts = m }
+
+// SetTCP exposes the counters of the layer-4 tcp listeners. Must be called
+// before the server starts handling requests.
+func (s *Server) SetTCP(listeners []*l4.TCP) { s.tcp = listeners }
+
+// SetUDP exposes the counters of the layer-4 udp listeners. Must be called
+// before the server starts handling requests.
+func (s *Server) SetUDP(listeners []*l4.UDP) { s.udp = listeners }
+
+// ServeHTTP satisfies http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// backendState is the JSON shape of one entry in GET /backends.
+type backendState struct {
+	Pool          string            `json:"pool"`
+	URL           string            `json:"url"`
+	Weight        int               `json:"weight"`
+	Healthy       bool              `json:"healthy"`
+	ActiveConns   int64             `json:"active_conns"`
+	MaxConns      int               `json:"max_conns"`
+	TotalRequests int64             `json:"total_requests"`
+	TotalErrors   int64             `json:"total_errors"`
+	Labels        map[string]string `json:"labels,omitempty"`
+}
+
+func (s *Server) handleBackends(w http.ResponseWriter, _ *http.Request) {
+	var out []backendState
+	for _, pool := range s.gw.Table().Pools() {
+		for _, b := range pool.Backends() {
+			out = append(out, backendState{
+				Pool:          pool.Name,
+				URL:           b.RawURL,
+				Weight:        b.Weight,
+				Healthy:       b.IsHealthy(),
+				ActiveConns:   b.ActiveConns(),
+				MaxConns:      b.MaxConns,
+				TotalRequests: b.TotalRequests(),
+				TotalErrors:   b.TotalErrors(),
+				Labels:        b.Labels,
+			})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+func (s *Server) handleRollouts(w http.ResponseWriter, _ *http.Request) {
+	out := []canary.Status{}
+	if s.rollouts != nil {
+		out = s.rollouts.Statuses()
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) handleRollout(w http.ResponseWriter, r *http.Request) {
+	ro, ok := s.rollout(r.PathValue("route"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no rollout for this route"})
+		return
+	}
+	writeJSON(w, http.StatusOK, ro.Status())
+}
+
+func (s *Server) handleRolloutAction(w http.ResponseWriter, r *http.Request) {
+	ro, ok := s.rollout(r.PathValue("route"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no rollout for this route"})
+		return
+	}
+	if err := ro.Control(r.PathValue("action"), time.Now()); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, canary.ErrUnknownAction) {
+			status = http.StatusBadRequest
+		}
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, ro.Status())
+}
+
+func (s *Server) rollout(route string) (*canary.Rollout, bool) {
+	if s.rollouts == nil {
+		return nil, false
+	}
+	return s.rollouts.Get(route)
+}
+
+func (s *Server) handleCache(w http.ResponseWriter, _ *http.Request) {
+	c := s.gw.Cache()
+	if c == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no response cache"})
+		return
+	}
+	writeJSON(w, http.StatusOK, c.Stats())
+}
+
+// handleCachePurge drops the cached responses for one exact path (?path=),
+// for every path under a prefix (?prefix=), or all of them.
+func (s *Server) handleCachePurge(w http.ResponseWriter, r *http.Request) {
+	c := s.gw.Cache()
+	if c == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no response cache"})
+		return
+	}
+	q := r.URL.Query()
+	var match func(string) bool
+	switch {
+	case q.Has("path"):
+		path := q.Get("path")
+		match = func(p string) bool { return p == path }
+	case q.Has("prefix"):
+		prefix := q.Get("prefix")
+		match = func(p string) bool { return strings.HasPrefix(p, prefix) }
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"purged": c.Purge(match)})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// queueStatser is implemented by pickers that queue requests (strategy.Queue).
+type queueStatser interface {
+	Stats() strategy.QueueStats
+}
@@ -0,0 +1,259 @@
+package admin_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"golb/internal/admin"
+	"golb/internal/cache"
+	"golb/internal/canary"
+	"golb/internal/config"
+	"golb/internal/l4"
+	"golb/internal/proxy"
+	"golb/internal/strategy"
+)
+
+// ── helpers ──────────────────────────────────────────────────────────────────
+
+func get(t *testing.T, h http.Handler, path string) (int, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	return rec.Code, string(body)
+}
+
+// ── Tests ─────────────────────────────────────────────────────────────────────
+
+func TestMetrics_BackendAndQueueSeries(t *testing.T) {
+	b, err := strategy.NewBackend("http://b1:80", 1)
+	require.NoError(t, err)
+	b.MaxConns = 4
+	b.IncConns()
+
+	q := strategy.NewQueue(strategy.NewRoundRobin([]*strategy.Backend{b}), 25, time.Second)
+	srv := admin.New(proxy.New(q))
+
+	status, body := get(t, srv, "/metrics")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `flux_backend_active_connections{pool="default",backend="http://b1:80"} 1`)
+	assert.Contains(t, body, `flux_backend_max_connections{pool="default",backend="http://b1:80"} 4`)
+	assert.Contains(t, body, `flux_backend_healthy{pool="default",backend="http://b1:80"} 1`)
+	assert.Contains(t, body, `flux_queue_depth{pool="default"} 0`)
+	assert.Contains(t, body, `flux_queue_max_size{pool="default"} 25`)
+	assert.Contains(t, body, `flux_queue_wait_seconds_count{pool="default"} 0`)
+}
+
+func TestMetrics_NoQueueSeriesWithoutQueue(t *testing.T) {
+	b, err := strategy.NewBackend("http://b1:80", 1)
+	require.NoError(t, err)
+	srv := admin.New(proxy.New(strategy.NewRoundRobin([]*strategy.Backend{b})))
+
+	_, body := get(t, srv, "/metrics")
+	assert.NotContains(t, body, "flux_queue_depth")
+}
+
+func TestBackends_ReturnsJSONState(t *testing.T) {
+	b1, err := strategy.NewBackend("http://b1:80", 2)
+	require.NoError(t, err)
+	b2, err := strategy.NewBackend("http://b2:80", 1)
+	require.NoError(t, err)
+	b2.SetHealthy(false)
+	b2.Labels = map[string]string{"zone": "eu-1"}
+	srv := admin.New(proxy.New(strategy.NewRoundRobin([]*strategy.Backend{b1, b2})))
+
+	status, body := get(t, srv, "/backends")
+	require.Equal(t, http.StatusOK, status)
+
+	var got []map[string]any
+	require.NoError(t, json.Unmarshal([]byte(body), &got))
+	require.Len(t, got, 2)
+	assert.Equal(t, "default", got[0]["pool"])
+	assert.Equal(t, "http://b1:80", got[0]["url"])
+	assert.Equal(t, float64(2), got[0]["weight"])
+	assert.Equal(t, false, got[1]["healthy"])
+	assert.NotContains(t, got[0], "labels", "static backends have none")
+	assert.Equal(t, map[string]any{"zone": "eu-1"}, got[1]["labels"])
+}
+
+func TestMetrics_PoolAndSplitSeries(t *testing.T) {
+	stable, err := strategy.NewBackend("http://stable:80", 1)
+	require.NoError(t, err)
+	canary, err := strategy.NewBackend("http://canary:80", 1)
+	require.NoError(t, err)
+	canary.IncRequests()
+	canary.IncServerErrors()
+	canary.IncErrors()
+
+	stablePool := proxy.NewPool("stable", strategy.NewRoundRobin([]*strategy.Backend{stable}), nil)
+	canaryPool := proxy.NewPool("canary", strategy.NewRoundRobin([]*strategy.Backend{canary}), nil)
+	split := proxy.NewSplitter([]*proxy.SplitTarget{
+		{Pool: stablePool, Weight: 90},
+		{Pool: canaryPool, Weight: 10},
+	}, nil, "")
+	gw := proxy.NewWithTable(proxy.NewTable([]*proxy.Route{
+		{Name: "web", PathPrefix: "/", Pool: stablePool, Split: split},
+	}))
+
+	_, body := get(t, admin.New(gw), "/metrics")
+	assert.Contains(t, body, `flux_pool_requests_total{pool="canary"} 1`)
+	assert.Contains(t, body, `flux_pool_errors_total{pool="canary"} 2`)
+	assert.Contains(t, body, `flux_pool_errors_total{pool="stable"} 0`)
+	assert.Contains(t, body, `flux_route_split_weight{route="web",pool="canary"} 10`)
+	assert.Contains(t, body, `flux_route_split_requests_total{route="web",pool="stable"} 0`)
+	assert.NotContains(t, body, "flux_coalesce_requests_total")
+}
+
+func TestMetrics_CoalesceHedgeAndTunnelSeries(t *testing.T) {
+	b, err := strategy.NewBackend("http://b1:80", 1)
+	require.NoError(t, err)
+	pool := proxy.NewPool("api", strategy.NewRoundRobin([]*strategy.Backend{b}), nil)
+	gw := proxy.NewWithTable(proxy.NewTable([]*proxy.Route{
+		{Name: "api", PathPrefix: "/", Pool: pool, Coalesce: proxy.NewCoalescer(config.CoalesceCfg{Enabled: true}),
+			Hedge: proxy.NewHedge(config.HedgeCfg{Enabled: true}), Upgrade: proxy.NewUpgrade(config.UpgradeCfg{Protocols: []string{"websocket"}})},
+	}))
+
+	_, body := get(t, admin.New(gw), "/metrics")
+	assert.Contains(t, body, `flux_coalesce_requests_total{route="api",result="leader"} 0`)
+	assert.Contains(t, body, `flux_coalesce_requests_total{route="api",result="shared"} 0`)
+	assert.Contains(t, body, `flux_coalesce_requests_total{route="api",result="bypassed"} 0`)
+	assert.Contains(t, body, `flux_hedge_requests_total{route="api",result="sent"} 0`)
+	assert.Contains(t, body, `flux_hedge_requests_total{route="api",result="won"} 0`)
+	assert.Contains(t, body, `flux_hedge_requests_total{route="api",result="throttled"} 0`)
+	assert.Contains(t, body, `flux_tunnels_active{route="api"} 0`)
+	assert.Contains(t, body, `flux_tunnels_total{route="api"} 0`)
+	assert.Contains(t, body, `flux_tunnel_bytes_total{route="api",direction="in"} 0`)
+	assert.Contains(t, body, `flux_tunnel_bytes_total{route="api",direction="out"} 0`)
+}
+
+func TestMetrics_TCPListenerSeries(t *testing.T) {
+	cfg := config.Config{
+		Strategy: "round_robin",
+		Pools: []config.PoolCfg{{
+			Name:        "pg",
+			Backends:    []config.BackendCfg{{URL: "tcp://db1:5432", Weight: 1}},
+			HealthCheck: config.PoolHealthCheckCfg{Type: "tcp"},
+		}},
+		TCP: []config.TCPListenerCfg{{Name: "postgres", ListenAddr: ":5432", Pool: "pg"}},
+	}
+	table, err := proxy.BuildTable(cfg)
+	require.NoError(t, err)
+	srv := admin.New(proxy.NewWithTable(table))
+	srv.SetTCP([]*l4.TCP{l4.NewTCP(cfg.TCP[0], nil)})
+
+	_, body := get(t, srv, "/metrics")
+	assert.Contains(t, body, `flux_backend_active_connections{pool="pg",backend="tcp://db1:5432"} 0`, "tcp pools are reported like the others")
+	assert.Contains(t, body, `flux_tcp_connections_active{listener="postgres"} 0`)
+	assert.Contains(t, body, `flux_tcp_connections_total{listener="postgres",result="accepted"} 0`)
+	assert.Contains(t, body, `flux_tcp_connections_total{listener="postgres",result="rejected"} 0`)
+	assert.Contains(t, body, `flux_tcp_connections_total{listener="postgres",result="failed"} 0`)
+	assert.Contains(t, body, `flux_tcp_bytes_total{listener="postgres",direction="in"} 0`)
+	assert.Contains(t, body, `flux_tcp_bytes_total{listener="postgres",direction="out"} 0`)
+}
+
+func TestMetrics_UDPListenerSeries(t *testing.T) {
+	cfg := config.Config{
+		Strategy: "round_robin",
+		Pools: []config.PoolCfg{{
+			Name:        "dns",
+			Backends:    []config.BackendCfg{{URL: "udp://ns1:53", Weight: 1}},
+			HealthCheck: config.PoolHealthCheckCfg{Type: "udp"},
+		}},
+		UDP: []config.UDPListenerCfg{{Name: "dns", ListenAddr: ":53", Pool: "dns"}},
+	}
+	table, err := proxy.BuildTable(cfg)
+	require.NoError(t, err)
+	srv := admin.New(proxy.NewWithTable(table))
+	srv.SetUDP([]*l4.UDP{l4.NewUDP(cfg.UDP[0], nil)})
+
+	_, body := get(t, srv, "/metrics")
+	assert.Contains(t, body, `flux_backend_active_connections{pool="dns",backend="udp://ns1:53"} 0`, "udp pools are reported like the others")
+	assert.Contains(t, body, `flux_udp_sessions_active{listener="dns"} 0`)
+	assert.Contains(t, body, `flux_udp_sessions_total{listener="dns"} 0`)
+	assert.Contains(t, body, `flux_udp_datagrams_total{listener="dns",direction="in"} 0`)
+	assert.Contains(t, body, `flux_udp_datagrams_dropped_total{listener="dns",reason="max_sessions"} 0`)
+	assert.Contains(t, body, `flux_udp_datagrams_dropped_total{listener="dns",reason="no_backend"} 0`)
+	assert.Contains(t, body, `flux_udp_bytes_total{listener="dns",direction="out"} 0`)
+}
+
+func TestRollouts_StatusAndControl(t *testing.T) {
+	cfg := config.Config{
+		Strategy: "round_robin",
+		Pools: []config.PoolCfg{
+			{Name: "stable", Backends: []config.BackendCfg{{URL: "http://stable:80", Weight: 1}}},
+			{Name: "canary", Backends: []config.BackendCfg{{URL: "http://canary:80", Weight: 1}}},
+		},
+		Routes: []config.RouteCfg{{
+			Name:       "web",
+			PathPrefix: "/",
+			Split:      []config.SplitTargetCfg{{Pool: "stable", Weight: 100}, {Pool: "canary", Weight: 0}},
+			Canary:     config.CanaryCfg{Steps: []int{5, 50, 100}},
+		}},
+	}
+	table, err := proxy.BuildTable(cfg)
+	require.NoError(t, err)
+	rollouts := canary.NewManager()
+	rollouts.Update(cfg, table)
+	srv := admin.New(proxy.NewWithTable(table))
+	srv.SetRollouts(rollouts)
+
+	status, body := get(t, srv, "/rollouts")
+	require.Equal(t, http.StatusOK, status)
+	var list []canary.Status
+	require.NoError(t, json.Unmarshal([]byte(body), &list))
+	require.Len(t, list, 1)
+	assert.Equal(t, "web", list[0].Route)
+	assert.Equal(t, 5, list[0].Weight)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/rollouts/web/rollback", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var st canary.Status
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &st))
+	assert.Equal(t, canary.RolledBack, st.State)
+	assert.Equal(t, 0, table.Routes()[0].Split.Weights()["canary"])
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/rollouts/web/explode", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	status, _ = get(t, srv, "/rollouts/missing")
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestCache_StatsPurgeAndMetrics(t *testing.T) {
+	b, err := strategy.NewBackend("http://b1:80", 1)
+	require.NoError(t, err)
+	gw := proxy.New(strategy.NewRoundRobin([]*strategy.Backend{b}))
+	srv := admin.New(gw)
+
+	status, _ := get(t, srv, "/cache")
+	assert.Equal(t, http.StatusNotFound, status, "no cache configured")
+
+	gw.SetCache(cache.New(1<<20, 1<<10))
+	status, body := get(t, srv, "/cache")
+	require.Equal(t, http.StatusOK, status)
+	var st cache.Stats
+	require.NoError(t, json.Unmarshal([]byte(body), &st))
+	assert.Equal(t, int64(1<<20), st.MaxSize)
+	assert.Equal(t, int64(1<<10), st.MaxEntrySize)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/cache/purge?prefix=/api", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"purged":0}`, rec.Body.String())
+
+	_, body = get(t, srv, "/metrics")
+	assert.Contains(t, body, `flux_cache_requests_total{result="HIT"} 0`)
+	assert.Contains(t, body, "flux_cache_entries 0")
+	assert.Contains(t, body, "flux_cache_size_bytes 0")
+	assert.Contains(t, body, "flux_cache_evictions_total 0")
+}
@@ -0,0 +1,331 @@
+package admin
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"golb/internal/cache"
+	"golb/internal/l4"
+	"golb/internal/proxy"
+	"golb/internal/strategy"
+)
+
+// handleMetrics writes gateway state in the Prometheus text exposition format.
+// Metrics are rendered by hand to keep the binary free of a client library.
+func (s *Server) handleMetrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	pools := s.gw.Table().Pools()
+	m := &metricWriter{w: w}
+
+	backendGauge := func(name, typ, help string, value func(*strategy.Backend) float64) {
+		m.help(name, typ, help)
+		for _, p := range pools {
+			for _, b := range p.Backends() {
+				m.sample(name, value(b), "pool", p.Name, "backend", b.RawURL)
+			}
+		}
+	}
+	backendGauge("flux_backend_healthy", "gauge", "1 if the backend is currently healthy.",
+		func(b *strategy.Backend) float64 { return boolFloat(b.IsHealthy()) })
+	backendGauge("flux_backend_active_connections", "gauge", "Requests currently in flight to the backend.",
+		func(b *strategy.Backend) float64 { return float64(b.ActiveConns()) })
+	backendGauge("flux_backend_max_connections", "gauge", "Configured connection cap (0 = unlimited).",
+		func(b *strategy.Backend) float64 { return float64(b.MaxConns) })
+	backendGauge("flux_backend_requests_total", "counter", "Requests forwarded to the backend.",
+		func(b *strategy.Backend) float64 { return float64(b.TotalRequests()) })
+	backendGauge("flux_backend_errors_total", "counter", "Requests to the backend that failed at the transport level.",
+		func(b *strategy.Backend) float64 { return float64(b.TotalErrors()) })
+	backendGauge("flux_backend_server_errors_total", "counter", "5xx responses returned by the backend.",
+		func(b *strategy.Backend) float64 { return float64(b.TotalServerErrors()) })
+
+	// Pool totals make pools comparable at a glance, e.g. canary vs stable.
+	poolSeries := func(name, help string, value func(*strategy.Backend) int64) {
+		m.help(name, "counter", help)
+		for _, p := range pools {
+			var sum int64
+			for _, b := range p.Backends() {
+				sum += value(b)
+			}
+			m.sample(name, float64(sum), "pool", p.Name)
+		}
+	}
+	poolSeries("flux_pool_requests_total", "Requests forwarded to the pool.",
+		func(b *strategy.Backend) int64 { return b.TotalRequests() })
+	poolSeries("flux_pool_errors_total", "Requests to the pool that failed or returned a 5xx response.",
+		func(b *strategy.Backend) int64 { return b.TotalErrors() + b.TotalServerErrors() })
+
+	s.writeSplitMetrics(m)
+	s.writeMirrorMetrics(m)
+	s.writeCoalesceMetrics(m)
+	s.writeHedgeMetrics(m)
+	s.writeUpgradeMetrics(m)
+	s.writeTCPMetrics(m)
+	s.writeUDPMetrics(m)
+	s.writeCacheMetrics(m)
+
+	var queues []string
+	stats := map[string]strategy.QueueStats{}
+	for _, p := range pools {
+		if q, ok := p.Picker().(queueStatser); ok {
+			queues = append(queues, p.Name)
+			stats[p.Name] = q.Stats()
+		}
+	}
+	if len(queues) == 0 {
+		return
+	}
+	queueSeries := func(name, typ, help string, value func(strategy.QueueStats) float64) {
+		if help != "" {
+			m.help(name, typ, help)
+		}
+		for _, pool := range queues {
+			m.sample(name, value(stats[pool]), "pool", pool)
+		}
+	}
+	queueSeries("flux_queue_depth", "gauge", "Requests currently waiting for a backend connection slot.",
+		func(st strategy.QueueStats) float64 { return float64(st.Depth) })
+	queueSeries("flux_queue_max_size", "gauge", "Configured maximum queue depth.",
+		func(st strategy.QueueStats) float64 { return float64(st.MaxSize) })
+	queueSeries("flux_queue_timeouts_total", "counter", "Queued requests that timed out (answered 503).",
+		func(st strategy.QueueStats) float64 { return float64(st.Timeouts) })
+	queueSeries("flux_queue_rejected_total", "counter", "Requests refused because the queue was full (answered 503).",
+		func(st strategy.QueueStats) float64 { return float64(st.Rejected) })
+	m.help("flux_queue_wait_seconds", "summary", "Time requests spent waiting in the queue.")
+	queueSeries("flux_queue_wait_seconds_sum", "", "",
+		func(st strategy.QueueStats) float64 { return st.WaitTime.Seconds() })
+	queueSeries("flux_queue_wait_seconds_count", "", "",
+		func(st strategy.QueueStats) float64 { return float64(st.Queued) })
+}
+
+// writeSplitMetrics reports the current weight and pick count of every
+// traffic-split target.
+func (s *Server) writeSplitMetrics(m *metricWriter) {
+	var splits []*proxy.Route
+	for _, rt := range s.gw.Table().Routes() {
+		if rt.Split != nil {
+			splits = append(splits, rt)
+		}
+	}
+	if len(splits) == 0 {
+		return
+	}
+	m.help("flux_route_split_weight", "gauge", "Current traffic-split weight of a pool on a route.")
+	for _, rt := range splits {
+		weights := rt.Split.Weights()
+		for _, t := range rt.Split.Targets() {
+			m.sample("flux_route_split_weight", float64(weights[t.Pool.Name]), "route", rt.Name, "pool", t.Pool.Name)
+		}
+	}
+	m.help("flux_route_split_requests_total", "counter", "Requests the route's split sent to a pool (overrides excluded).")
+	for _, rt := range splits {
+		for _, t := range rt.Split.Targets() {
+			m.sample("flux_route_split_requests_total", float64(t.Picks()), "route", rt.Name, "pool", t.Pool.Name)
+		}
+	}
+}
+
+// writeMirrorMetrics reports the outcome of shadow requests per mirroring
+// route.
+func (s *Server) writeMirrorMetrics(m *metricWriter) {
+	var mirrors []*proxy.Route
+	for _, rt := range s.gw.Table().Routes() {
+		if rt.Mirror != nil {
+			mirrors = append(mirrors, rt)
+		}
+	}
+	if len(mirrors) == 0 {
+		return
+	}
+	m.help("flux_mirror_requests_total", "counter", "Shadow requests by result: sent, failed or dropped.")
+	for _, rt := range mirrors {
+		st := rt.Mirror.Stats()
+		pool := rt.Mirror.Pool.Name
+		m.sample("flux_mirror_requests_total", float64(st.Sent), "route", rt.Name, "pool", pool, "result", "sent")
+		m.sample("flux_mirror_requests_total", float64(st.Failed), "route", rt.Name, "pool", pool, "result", "failed")
+		m.sample("flux_mirror_requests_total", float64(st.Dropped), "route", rt.Name, "pool", pool, "result", "dropped")
+	}
+}
+
+// writeCoalesceMetrics reports how requests on coalescing routes were served.
+func (s *Server) writeCoalesceMetrics(m *metricWriter) {
+	var routes []*proxy.Route
+	for _, rt := range s.gw.Table().Routes() {
+		if rt.Coalesce != nil {
+			routes = append(routes, rt)
+		}
+	}
+	if len(routes) == 0 {
+		return
+	}
+	m.help("flux_coalesce_requests_total", "counter", "Requests on coalescing routes by result: leader, shared or bypassed.")
+	for _, rt := range routes {
+		st := rt.Coalesce.Stats()
+		m.sample("flux_coalesce_requests_total", float64(st.Leaders), "route", rt.Name, "result", "leader")
+		m.sample("flux_coalesce_requests_total", float64(st.Shared), "route", rt.Name, "result", "shared")
+		m.sample("flux_coalesce_requests_total", float64(st.Bypassed), "route", rt.Name, "result", "bypassed")
+	}
+}
+
+// writeHedgeMetrics reports second attempts per hedging route.
+func (s *Server) writeHedgeMetrics(m *metricWriter) {
+	var routes []*proxy.Route
+	for _, rt := range s.gw.Table().Routes() {
+		if rt.Hedge != nil {
+			routes = append(routes, rt)
+		}
+	}
+	if len(routes) == 0 {
+		return
+	}
+	m.help("flux_hedge_requests_total", "counter", "Hedged attempts by result: sent, won (answered first) or throttled (budget spent or no other backend).")
+	for _, rt := range routes {
+		st := rt.Hedge.Stats()
+		m.sample("flux_hedge_requests_total", float64(st.Sent), "route", rt.Name, "result", "sent")
+		m.sample("flux_hedge_requests_total", float64(st.Won), "route", rt.Name, "result", "won")
+		m.sample("flux_hedge_requests_total", float64(st.Throttled), "route", rt.Name, "result", "throttled")
+	}
+}
+
+// writeUpgradeMetrics reports open tunnels and their traffic per route that
+// allows upgrades.
+func (s *Server) writeUpgradeMetrics(m *metricWriter) {
+	var routes []*proxy.Route
+	for _, rt := range s.gw.Table().Routes() {
+		if rt.Upgrade != nil {
+			routes = append(routes, rt)
+		}
+	}
+	if len(routes) == 0 {
+		return
+	}
+	stats := make([]proxy.UpgradeStats, len(routes))
+	for i, rt := range routes {
+		stats[i] = rt.Upgrade.Stats()
+	}
+	m.help("flux_tunnels_active", "gauge", "Upgraded connections (WebSocket and the like) currently open.")
+	for i, rt := range routes {
+		m.sample("flux_tunnels_active", float64(stats[i].Active), "route", rt.Name)
+	}
+	m.help("flux_tunnels_total", "counter", "Upgraded connections opened.")
+	for i, rt := range routes {
+		m.sample("flux_tunnels_total", float64(stats[i].Total), "route", rt.Name)
+	}
+	m.help("flux_tunnel_bytes_total", "counter", "Bytes relayed through tunnels, from clients (in) or to clients (out).")
+	for i, rt := range routes {
+		m.sample("flux_tunnel_bytes_total", float64(stats[i].BytesIn), "route", rt.Name, "direction", "in")
+		m.sample("flux_tunnel_bytes_total", float64(stats[i].BytesOut), "route", rt.Name, "direction", "out")
+	}
+}
+
+// writeTCPMetrics reports connections and traffic per layer-4 listener.
+func (s *Server) writeTCPMetrics(m *metricWriter) {
+	if len(s.tcp) == 0 {
+		return
+	}
+	stats := make([]l4.TCPStats, len(s.tcp))
+	for i, t := range s.tcp {
+		stats[i] = t.Stats()
+	}
+	m.help("flux_tcp_connections_active", "gauge", "Client connections currently open on the tcp listener.")
+	for i, t := range s.tcp {
+		m.sample("flux_tcp_connections_active", float64(stats[i].Active), "listener", t.Name)
+	}
+	m.help("flux_tcp_connections_total", "counter", "Client connections accepted, rejected at max_conns, or accepted but failed to reach a backend.")
+	for i, t := range s.tcp {
+		m.sample("flux_tcp_connections_total", float64(stats[i].Total), "listener", t.Name, "result", "accepted")
+		m.sample("flux_tcp_connections_total", float64(stats[i].Rejected), "listener", t.Name, "result", "rejected")
+		m.sample("flux_tcp_connections_total", float64(stats[i].Failed), "listener", t.Name, "result", "failed")
+	}
+	m.help("flux_tcp_bytes_total", "counter", "Bytes relayed by the tcp listener, from clients (in) or to clients (out).")
+	for i, t := range s.tcp {
+		m.sample("flux_tcp_bytes_total", float64(stats[i].BytesIn), "listener", t.Name, "direction", "in")
+		m.sample("flux_tcp_bytes_total", float64(stats[i].BytesOut), "listener", t.Name, "direction", "out")
+	}
+}
+
+// writeUDPMetrics reports sessions and traffic per layer-4 udp listener.
+func (s *Server) writeUDPMetrics(m *metricWriter) {
+	if len(s.udp) == 0 {
+		return
+	}
+	stats := make([]l4.UDPStats, len(s.udp))
+	for i, u := range s.udp {
+		stats[i] = u.Stats()
+	}
+	m.help("flux_udp_sessions_active", "gauge", "Client sessions currently open on the udp listener.")
+	for i, u := range s.udp {
+		m.sample("flux_udp_sessions_active", float64(stats[i].Active), "listener", u.Name)
+	}
+	m.help("flux_udp_sessions_total", "counter", "Client sessions opened by the udp listener.")
+	for i, u := range s.udp {
+		m.sample("flux_udp_sessions_total", float64(stats[i].Total), "listener", u.Name)
+	}
+	m.help("flux_udp_datagrams_total", "counter", "Datagrams relayed by the udp listener, from clients (in) or to clients (out).")
+	for i, u := range s.udp {
+		m.sample("flux_udp_datagrams_total", float64(stats[i].DatagramsIn), "listener", u.Name, "direction", "in")
+		m.sample("flux_udp_datagrams_total", float64(stats[i].DatagramsOut), "listener", u.Name, "direction", "out")
+	}
+	m.help("flux_udp_datagrams_dropped_total", "counter", "Client datagrams dropped at max_sessions or because no backend could be reached.")
+	for i, u := range s.udp {
+		m.sample("flux_udp_datagrams_dropped_total", float64(stats[i].Rejected), "listener", u.Name, "reason", "max_sessions")
+		m.sample("flux_udp_datagrams_dropped_total", float64(stats[i].Failed), "listener", u.Name, "reason", "no_backend")
+	}
+	m.help("flux_udp_bytes_total", "counter", "Bytes relayed by the udp listener, from clients (in) or to clients (out).")
+	for i, u := range s.udp {
+		m.sample("flux_udp_bytes_total", float64(stats[i].BytesIn), "listener", u.Name, "direction", "in")
+		m.sample("flux_udp_bytes_total", float64(stats[i].BytesOut), "listener", u.Name, "direction", "out")
+	}
+}
+
+// writeCacheMetrics reports the response cache's size and how requests to
+// caching routes were answered.
+func (s *Server) writeCacheMetrics(m *metricWriter) {
+	c := s.gw.Cache()
+	if c == nil {
+		return
+	}
+	st := c.Stats()
+	m.help("flux_cache_requests_total", "counter", "Requests to caching routes by result: HIT, MISS, STALE, REVALIDATED or BYPASS.")
+	for _, r := range cache.Results {
+		m.sample("flux_cache_requests_total", float64(st.Requests[r]), "result", string(r))
+	}
+	m.help("flux_cache_entries", "gauge", "Responses held in the cache.")
+	m.sample("flux_cache_entries", float64(st.Entries))
+	m.help("flux_cache_size_bytes", "gauge", "Approximate memory used by cached responses.")
+	m.sample("flux_cache_size_bytes", float64(st.Size))
+	m.help("flux_cache_evictions_total", "counter", "Entries evicted to stay within cache.max_size.")
+	m.sample("flux_cache_evictions_total", float64(st.Evictions))
+}
+
+// metricWriter renders Prometheus text-format lines. Write errors are ignored:
+// a scraper that hangs up mid-response simply gets a truncated page.
+type metricWriter struct {
+	w io.Writer
+}
+
+func (m *metricWriter) help(name, typ, text string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, text, name, typ)
+}
+
+// sample writes one sample line; labels are alternating name/value pairs.
+func (m *metricWriter) sample(name string, value float64, labels ...string) {
+	if len(labels) == 0 {
+		fmt.Fprintf(m.w, "%s %g\n", name, value)
+		return
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	fmt.Fprintf(m.w, "%s{%s} %g\n", name, strings.Join(pairs, ","), value)
+}
+
+func boolFloat(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,247 @@
+// Package cache is the gateway's shared in-memory HTTP response cache.
+//
+// It follows the rules RFC 9111 sets for a shared cache: responses are stored
+// only when Cache-Control, Expires and the status code allow it; freshness
+// comes from s-maxage, max-age, Expires or a Last-Modified heuristic; Vary
+// selects between stored variants; stale entries are revalidated upstream
+// with If-None-Match / If-Modified-Since; and stale-while-revalidate and
+// stale-if-error let stale responses be served while a backend is refreshed
+// or failing. Entries live in one LRU bounded by total size, shared by every
+// route that enables caching.
+package cache
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Header reports how the cache handled a request.
+const Header = "X-Cache"
+
+// Result is the value of the X-Cache header.
+type Result string
+
+const (
+	Hit         Result = "HIT"         // served fresh from the cache
+	Miss        Result = "MISS"        // fetched from a backend
+	Stale       Result = "STALE"       // served stale (stale-while-revalidate, stale-if-error, max-stale)
+	Revalidated Result = "REVALIDATED" // stale entry confirmed by a 304 from the backend
+	Bypass      Result = "BYPASS"      // request asked not to use the cache (no-store)
+)
+
+// Results lists every Result in a fixed order, e.g. for metrics.
+var Results = []Result{Hit, Miss, Stale, Revalidated, Bypass}
+
+// Store is a size-bounded LRU of cached responses. It is safe for concurrent
+// use.
+type Store struct {
+	mu           sync.Mutex
+	maxSize      int64
+	maxEntrySize int64
+	size         int64
+	lru          *list.List               // of *entry, most recently used first
+	entries      map[string]*list.Element // full key → element
+	primaries    map[string]*primary      // primary key → its variants
+	revalidating map[string]bool          // keys with a background revalidation in flight
+
+	now       func() time.Time
+	results   map[Result]*atomic.Int64
+	evictions atomic.Int64
+}
+
+// primary tracks the variants stored under one primary key and the request
+// headers they vary on.
+type primary struct {
+	vary []string
+	keys map[string]struct{}
+}
+
+// Stats is a snapshot of the cache's size and counters.
+type Stats struct {
+	Entries      int              `json:"entries"`
+	Size         int64            `json:"size_bytes"`
+	MaxSize      int64            `json:"max_size_bytes"`
+	MaxEntrySize int64            `json:"max_entry_size_bytes"`
+	Requests     map[Result]int64 `json:"requests"`
+	Evictions    int64            `json:"evictions"`
+}
+
+// New creates a Store holding at most maxSize bytes, with no single entry
+// larger than maxEntrySize.
+func New(maxSize, maxEntrySize int64) *Store {
+	s := &Store{
+		maxSize:      maxSize,
+		maxEntrySize: maxEntrySize,
+		lru:          list.New(),
+		entries:      map[string]*list.Element{},
+		primaries:    map[string]*primary{},
+		revalidating: map[string]bool{},
+		now:          time.Now,
+		results:      map[Result]*atomic.Int64{},
+	}
+	for _, r := range Results {
+		s.results[r] = &atomic.Int64{}
+	}
+	return s
+}
+
+// SetLimits changes the size limits, evicting entries if the cache has
+// become too large. It is called on hot-reload.
+func (s *Store) SetLimits(maxSize, maxEntrySize int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxSize, s.maxEntrySize = maxSize, maxEntrySize
+	s.evict()
+}
+
+// Stats returns the cache's current size and counters.
+func (s *Store) Stats() Stats {
+	s.mu.Lock()
+	st := Stats{Entries: s.lru.Len(), Size: s.size, MaxSize: s.maxSize, MaxEntrySize: s.maxEntrySize}
+	s.mu.Unlock()
+	st.Requests = map[Result]int64{}
+	for r, n := range s.results {
+		st.Requests[r] = n.Load()
+	}
+	st.Evictions = s.evictions.Load()
+	return st
+}
+
+// Purge removes every entry whose request path satisfies match, or every
+// entry when match is nil, and returns how many were removed.
+func (s *Store) Purge(match func(path string) bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for el := s.lru.Front(); el != nil; {
+		next := el.Next()
+		if e := el.Value.(*entry); match == nil || match(e.path) {
+			s.remove(el)
+			n++
+		}
+		el = next
+	}
+	return n
+}
+
+// invalidate removes every variant stored for uri (host + request URI),
+// whatever its cache key.
+func (s *Store) invalidate(uri string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for el := s.lru.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*entry).uri == uri {
+			s.remove(el)
+		}
+		el = next
+	}
+}
+
+// lookup returns the variant stored under primaryKey that matches r's
+// Vary-selected headers, marking it recently used.
+func (s *Store) lookup(primaryKey string, r *http.Request) *entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.primaries[primaryKey]
+	if p == nil {
+		return nil
+	}
+	el := s.entries[variantKey(primaryKey, p.vary, r.Header)]
+	if el == nil {
+		return nil
+	}
+	s.lru.MoveToFront(el)
+	return el.Value.(*entry)
+}
+
+// put stores e, replacing any entry with the same key. A response that
+// varies on different headers than its predecessors drops them, since they
+// can no longer be selected.
+func (s *Store) put(e *entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e.size > s.maxEntrySize || e.size > s.maxSize {
+		return
+	}
+	p := s.primaries[e.primary]
+	if p != nil && !equalStrings(p.vary, e.vary) {
+		for key := range p.keys {
+			s.remove(s.entries[key])
+		}
+		p = nil
+	}
+	if p == nil {
+		p = &primary{vary: e.vary, keys: map[string]struct{}{}}
+		s.primaries[e.primary] = p
+	}
+	if el := s.entries[e.key]; el != nil {
+		s.remove(el)
+		s.primaries[e.primary] = p
+	}
+	p.keys[e.key] = struct{}{}
+	s.entries[e.key] = s.lru.PushFront(e)
+	s.size += e.size
+	s.evict()
+}
+
+// evict drops least recently used entries until the cache fits. Callers hold
+// s.mu.
+func (s *Store) evict() {
+	for s.size > s.maxSize {
+		el := s.lru.Back()
+		if el == nil {
+			return
+		}
+		s.remove(el)
+		s.evictions.Add(1)
+	}
+}
+
+// remove deletes el. Callers hold s.mu.
+func (s *Store) remove(el *list.Element) {
+	e := s.lru.Remove(el).(*entry)
+	delete(s.entries, e.key)
+	s.size -= e.size
+	if p := s.primaries[e.primary]; p != nil {
+		delete(p.keys, e.key)
+		if len(p.keys) == 0 {
+			delete(s.primaries, e.primary)
+		}
+	}
+}
+
+// startRevalidation claims a background revalidation of key, reporting false
+// when one is already running.
+func (s *Store) startRevalidation(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.revalidating[key] {
+		return false
+	}
+	s.revalidating[key] = true
+	return true
+}
+
+func (s *Store) endRevalidation(key string) {
+	s.mu.Lock()
+	delete(s.revalidating, key)
+	s.mu.Unlock()
+}
+
+func (s *Store) count(r Result) { s.results[r].Add(1) }
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,451 @@
+package cache_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwtlib "github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"golb/internal/cache"
+	"golb/internal/config"
+	"golb/internal/middleware"
+)
+
+// ── helpers ──────────────────────────────────────────────────────────────────
+
+// origin is a fake backend that counts requests and remembers the last one.
+type origin struct {
+	calls   atomic.Int64
+	mu      sync.Mutex
+	last    http.Header
+	handler http.HandlerFunc
+}
+
+func (o *origin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.calls.Add(1)
+	o.mu.Lock()
+	o.last = r.Header.Clone()
+	o.mu.Unlock()
+	o.handler(w, r)
+}
+
+func (o *origin) lastHeader() http.Header {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.last
+}
+
+// respond returns an origin handler writing body with the given headers.
+func respond(body string, headers ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		for i := 0; i+1 < len(headers); i += 2 {
+			w.Header().Add(headers[i], headers[i+1])
+		}
+		_, _ = w.Write([]byte(body))
+	}
+}
+
+// serve sends one request through the cache and returns the response.
+func serve(s *cache.Store, p *cache.Policy, o http.Handler, method, target string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	s.Serve(rec, req, p, o)
+	return rec
+}
+
+func policy(cfg config.RouteCacheCfg) *cache.Policy {
+	cfg.Enabled = true
+	return cache.PolicyFrom(cfg)
+}
+
+// ── Storage and freshness ────────────────────────────────────────────────────
+
+func TestServe_CachesFreshResponses(t *testing.T) {
+	s := cache.New(1<<20, 1<<20)
+	o := &origin{handler: respond("hello", "Cache-Control", "max-age=60", "Content-Type", "text/plain")}
+	p := policy(config.RouteCacheCfg{})
+
+	first := serve(s, p, o, http.MethodGet, "/a")
+	assert.Equal(t, "MISS", first.Header().Get(cache.Header))
+	assert.Equal(t, "hello", first.Body.String())
+
+	second := serve(s, p, o, http.MethodGet, "/a")
+	assert.Equal(t, "HIT", second.Header().Get(cache.Header))
+	assert.Equal(t, "hello", second.Body.String())
+	assert.Equal(t, "text/plain", second.Header().Get("Content-Type"))
+	assert.Equal(t, "0", second.Header().Get("Age"))
+
+	head := serve(s, p, o, http.MethodHead, "/a")
+	assert.Equal(t, "HIT", head.Header().Get(cache.Header), "HEAD is answered from a stored GET")
+	assert.Empty(t, head.Body.String())
+
+	serve(s, p, o, http.MethodGet, "/a?page=2")
+	assert.EqualValues(t, 2, o.calls.Load(), "a different query is a different entry")
+
+	st := s.Stats()
+	assert.Equal(t, 2, st.Entries)
+	assert.EqualValues(t, 2, st.Requests[cache.Hit])
+	assert.EqualValues(t, 2, st.Requests[cache.Miss])
+}
+
+func TestServe_DoesNotStoreUncacheableResponses(t *testing.T) {
+	cases := []struct {
+		name    string
+		headers []string
+		reqHdrs []string
+	}{
+		{"no-store", []string{"Cache-Control", "no-store, max-age=60"}, nil},
+		{"private", []string{"Cache-Control", "private, max-age=60"}, nil},
+		{"set-cookie", []string{"Cache-Control", "max-age=60", "Set-Cookie", "sid=1"}, nil},
+		{"vary star", []string{"Cache-Control", "max-age=60", "Vary", "*"}, nil},
+		{"no freshness or validator", nil, nil},
+		{"authorization", []string{"Cache-Control", "max-age=60"}, []string{"Authorization", "Bearer x"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := cache.New(1<<20, 1<<20)
+			o := &origin{handler: respond("x", tc.headers...)}
+			p := policy(config.RouteCacheCfg{})
+			serve(s, p, o, http.MethodGet, "/a", tc.reqHdrs...)
+			rec := serve(s, p, o, http.MethodGet, "/a", tc.reqHdrs...)
+			assert.Equal(t, "MISS", rec.Header().Get(cache.Header))
+			assert.EqualValues(t, 2, o.calls.Load())
+		})
+	}
+}
+
+func TestServe_AuthorizedResponseStoredWhenPublic(t *testing.T) {
+	s := cache.New(1<<20, 1<<20)
+	o := &origin{handler: respond("x", "Cache-Control", "public, max-age=60")}
+	p := policy(config.RouteCacheCfg{})
+	serve(s, p, o, http.MethodGet, "/a", "Authorization", "Bearer x")
+	rec := serve(s, p, o, http.MethodGet, "/a", "Authorization", "Bearer y")
+	assert.Equal(t, "HIT", rec.Header().Get(cache.Header))
+}
+
+func TestServe_ExpiresAndStaleEntries(t *testing.T) {
+	s := cache.New(1<<20, 1<<20)
+	o := &origin{handler: respond("x",
+		"Date", time.Now().UTC().Format(http.TimeFormat),
+		"Expires", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))}
+	p := policy(config.RouteCacheCfg{})
+	serve(s, p, o, http.MethodGet, "/a")
+	assert.Equal(t, "HIT", serve(s, p, o, http.MethodGet, "/a").Header().Get(cache.Header))
+
+	// An origin Age beyond max-age makes the stored response stale at once.
+	stale := &origin{handler: respond("x", "Cache-Control", "max-age=10", "Age", "30")}
+	serve(s, p, stale, http.MethodGet, "/b")
+	rec := serve(s, p, stale, http.MethodGet, "/b")
+	assert.Equal(t, "MISS", rec.Header().Get(cache.Header), "stale without validators is refetched")
+	assert.EqualValues(t, 2, stale.calls.Load())
+}
+
+func TestServe_RequestDirectives(t *testing.T) {
+	s := cache.New(1<<20, 1<<20)
+	o := &origin{handler: respond("x", "Cache-Control", "max-age=60", "Age", "20")}
+	p := policy(config.RouteCacheCfg{})
+	serve(s, p, o, http.MethodGet, "/a")
+
+	assert.Equal(t, "HIT", serve(s, p, o, http.MethodGet, "/a").Header().Get(cache.Header))
+	assert.Equal(t, "MISS", serve(s, p, o, http.MethodGet, "/a", "Cache-Control", "max-age=10").Header().Get(cache.Header))
+	assert.Equal(t, "MISS", serve(s, p, o, http.MethodGet, "/a", "Cache-Control", "min-fresh=50").Header().Get(cache.Header))
+	assert.Equal(t, "MISS", serve(s, p, o, http.MethodGet, "/a", "Pragma", "no-cache").Header().Get(cache.Header))
+	assert.Equal(t, "BYPASS", serve(s, p, o, http.MethodGet, "/a", "Cache-Control", "no-store").Header().Get(cache.Header))
+
+	rec := serve(s, p, o, http.MethodGet, "/missing", "Cache-Control", "only-if-cached")
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+}
+
+// ── Vary and cache keys ──────────────────────────────────────────────────────
+
+func TestServe_VarySelectsVariants(t *testing.T) {
+	s := cache.New(1<<20, 1<<20)
+	o := &origin{handler: func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		_, _ = w.Write([]byte("lang=" + r.Header.Get("Accept-Language")))
+	}}
+	p := policy(config.RouteCacheCfg{})
+
+	serve(s, p, o, http.MethodGet, "/a", "Accept-Language", "en")
+	serve(s, p, o, http.MethodGet, "/a", "Accept-Language", "de")
+	en := serve(s, p, o, http.MethodGet, "/a", "Accept-Language", "en")
+	de := serve(s, p, o, http.MethodGet, "/a", "Accept-Language", "de")
+
+	assert.Equal(t, "HIT", en.Header().Get(cache.Header))
+	assert.Equal(t, "lang=en", en.Body.String())
+	assert.Equal(t, "HIT", de.Header().Get(cache.Header))
+	assert.Equal(t, "lang=de", de.Body.String())
+	assert.EqualValues(t, 2, o.calls.Load())
+}
+
+func TestServe_KeyHeaders(t *testing.T) {
+	s := cache.New(1<<20, 1<<20)
+	o := &origin{handler: func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("tenant=" + r.Header.Get("X-Tenant")))
+	}}
+	p := policy(config.RouteCacheCfg{KeyHeaders: []string{"x-tenant"}})
+
+	serve(s, p, o, http.MethodGet, "/a", "X-Tenant", "acme")
+	rec := serve(s, p, o, http.MethodGet, "/a", "X-Tenant", "globex")
+	assert.Equal(t, "MISS", rec.Header().Get(cache.Header))
+	assert.Equal(t, "tenant=globex", rec.Body.String())
+
+	rec = serve(s, p, o, http.MethodGet, "/a", "X-Tenant", "acme")
+	assert.Equal(t, "HIT", rec.Header().Get(cache.Header))
+	assert.Equal(t, "tenant=acme", rec.Body.String())
+}
+
+func TestServe_KeyClaimsCachePerUser(t *testing.T) {
+	const secret = "test-secret"
+	token := func(sub string) string {
+		tok := jwtlib.NewWithClaims(jwtlib.SigningMethodHS256, jwtlib.MapClaims{
+			"sub": sub,
+			"exp": time.Now().Add(time.Hour).Unix(),
+		})
+		signed, err := tok.SignedString([]byte(secret))
+		require.NoError(t, err)
+		return "Bearer " + signed
+	}
+
+	s := cache.New(1<<20, 1<<20)
+	o := &origin{handler: func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("user=" + middleware.Claims(r.Context())["sub"].(string)))
+	}}
+	p := policy(config.RouteCacheCfg{KeyClaims: []string{"sub"}})
+	h := middleware.JWTAuth(secret, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Serve(w, r, p, o)
+	}))
+
+	get := func(sub string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", token(sub))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	get("alice")
+	bob := get("bob")
+	assert.Equal(t, "MISS", bob.Header().Get(cache.Header))
+	assert.Equal(t, "user=bob", bob.Body.String())
+
+	alice := get("alice")
+	assert.Equal(t, "HIT", alice.Header().Get(cache.Header))
+	assert.Equal(t, "user=alice", alice.Body.String())
+}
+
+// ── Revalidation ─────────────────────────────────────────────────────────────
+
+func TestServe_RevalidatesWithETag(t *testing.T) {
+	s := cache.New(1<<20, 1<<20)
+	o := &origin{}
+	o.handler = func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=10")
+		w.Header().Set("Age", "30")
+		_, _ = w.Write([]byte("body"))
+	}
+	p := policy(config.RouteCacheCfg{})
+
+	serve(s, p, o, http.MethodGet, "/a")
+	rec := serve(s, p, o, http.MethodGet, "/a", "If-None-Match", `"other"`)
+	assert.Equal(t, "REVALIDATED", rec.Header().Get(cache.Header))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "body", rec.Body.String())
+	assert.Equal(t, `"v1"`, o.lastHeader().Get("If-None-Match"), "the cache's own validator replaces the client's")
+	assert.Equal(t, "max-age=60", rec.Header().Get("Cache-Control"), "304 headers are merged into the entry")
+
+	rec = serve(s, p, o, http.MethodGet, "/a")
+	assert.Equal(t, "HIT", rec.Header().Get(cache.Header), "the refreshed entry is fresh again")
+	assert.EqualValues(t, 2, o.calls.Load())
+}
+
+func TestServe_RevalidatesWithLastModified(t *testing.T) {
+	lm := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	s := cache.New(1<<20, 1<<20)
+	o := &origin{}
+	o.handler = func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-Modified-Since") == lm {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Last-Modified", lm)
+		_, _ = w.Write([]byte("body"))
+	}
+	p := policy(config.RouteCacheCfg{})
+
+	serve(s, p, o, http.MethodGet, "/a")
+	rec := serve(s, p, o, http.MethodGet, "/a")
+	assert.Equal(t, "REVALIDATED", rec.Header().Get(cache.Header), "no-cache entries are always revalidated")
+	assert.Equal(t, "body", rec.Body.String())
+}
+
+func TestServe_AnswersClientConditionalsFromCache(t *testing.T) {
+	s := cache.New(1<<20, 1<<20)
+	o := &origin{handler: respond("body", "Cache-Control", "max-age=60", "ETag", `"v1"`)}
+	p := policy(config.RouteCacheCfg{})
+	serve(s, p, o, http.MethodGet, "/a")
+
+	rec := serve(s, p, o, http.MethodGet, "/a", "If-None-Match", `W/"v1"`)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Empty(t, rec.Body.String())
+	assert.Equal(t, "HIT", rec.Header().Get(cache.Header))
+	assert.EqualValues(t, 1, o.calls.Load())
+}
+
+func TestServe_StaleWhileRevalidate(t *testing.T) {
+	s := cache.New(1<<20, 1<<20)
+	var version atomic.Int64
+	o := &origin{handler: func(w http.ResponseWriter, _ *http.Request) {
+		v := version.Add(1)
+		w.Header().Set("Cache-Control", "max-age=10, stale-while-revalidate=60")
+		if v == 1 {
+			w.Header().Set("Age", "15")
+		}
+		_, _ = w.Write([]byte("v" + strconv.FormatInt(v, 10)))
+	}}
+	p := policy(config.RouteCacheCfg{})
+
+	serve(s, p, o, http.MethodGet, "/a")
+	rec := serve(s, p, o, http.MethodGet, "/a")
+	assert.Equal(t, "STALE", rec.Header().Get(cache.Header))
+	assert.Equal(t, "v1", rec.Body.String(), "the stale copy is served without waiting")
+
+	require.Eventually(t, func() bool {
+		rec := serve(s, p, o, http.MethodGet, "/a")
+		return rec.Header().Get(cache.Header) == "HIT" && rec.Body.String() == "v2"
+	}, time.Second, 10*time.Millisecond, "the entry is refreshed in the background")
+	assert.EqualValues(t, 2, o.calls.Load())
+}
+
+func TestServe_StaleIfError(t *testing.T) {
+	s := cache.New(1<<20, 1<<20)
+	var failing atomic.Bool
+	o := &origin{handler: func(w http.ResponseWriter, _ *http.Request) {
+		if failing.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=10, stale-if-error=60")
+		w.Header().Set("Age", "15")
+		_, _ = w.Write([]byte("good"))
+	}}
+	p := policy(config.RouteCacheCfg{})
+
+	serve(s, p, o, http.MethodGet, "/a")
+	failing.Store(true)
+	rec := serve(s, p, o, http.MethodGet, "/a")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "STALE", rec.Header().Get(cache.Header))
+	assert.Equal(t, "good", rec.Body.String())
+
+	// must-revalidate forbids it.
+	s2 := cache.New(1<<20, 1<<20)
+	failing.Store(false)
+	o2 := &origin{handler: func(w http.ResponseWriter, _ *http.Request) {
+		if failing.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=10, stale-if-error=60, must-revalidate")
+		w.Header().Set("Age", "15")
+		_, _ = w.Write([]byte("good"))
+	}}
+	serve(s2, p, o2, http.MethodGet, "/a")
+	failing.Store(true)
+	rec = serve(s2, p, o2, http.MethodGet, "/a")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "MISS", rec.Header().Get(cache.Header))
+}
+
+// ── Size limits, invalidation and purging ────────────────────────────────────
+
+func TestStore_EvictsLeastRecentlyUsed(t *testing.T) {
+	body := strings.Repeat("x", 400)
+	s := cache.New(1000, 1000)
+	o := &origin{handler: respond(body, "Cache-Control", "max-age=60")}
+	p := policy(config.RouteCacheCfg{})
+
+	serve(s, p, o, http.MethodGet, "/a")
+	serve(s, p, o, http.MethodGet, "/b")
+	serve(s, p, o, http.MethodGet, "/a") // /a is now the most recently used
+	serve(s, p, o, http.MethodGet, "/c") // evicts /b
+
+	assert.Equal(t, "HIT", serve(s, p, o, http.MethodGet, "/a").Header().Get(cache.Header))
+	assert.Equal(t, "MISS", serve(s, p, o, http.MethodGet, "/b").Header().Get(cache.Header))
+	st := s.Stats()
+	assert.LessOrEqual(t, st.Size, int64(1000))
+	assert.Positive(t, st.Evictions)
+
+	big := &origin{handler: respond(strings.Repeat("y", 2000), "Cache-Control", "max-age=60")}
+	rec := serve(s, p, big, http.MethodGet, "/big")
+	assert.Len(t, rec.Body.String(), 2000, "oversized responses still reach the client")
+	assert.Equal(t, "MISS", serve(s, p, big, http.MethodGet, "/big").Header().Get(cache.Header))
+
+	s.SetLimits(500, 500)
+	assert.LessOrEqual(t, s.Stats().Size, int64(500))
+}
+
+func TestServe_UnsafeMethodsInvalidate(t *testing.T) {
+	s := cache.New(1<<20, 1<<20)
+	o := &origin{handler: func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.Header().Set("Location", "/items/2")
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("list"))
+	}}
+	p := policy(config.RouteCacheCfg{})
+
+	serve(s, p, o, http.MethodGet, "/items")
+	serve(s, p, o, http.MethodGet, "/items/2")
+	serve(s, p, o, http.MethodGet, "/other")
+	rec := serve(s, p, o, http.MethodPost, "/items")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	assert.Equal(t, "MISS", serve(s, p, o, http.MethodGet, "/items").Header().Get(cache.Header))
+	assert.Equal(t, "MISS", serve(s, p, o, http.MethodGet, "/items/2").Header().Get(cache.Header), "Location target is invalidated")
+	assert.Equal(t, "HIT", serve(s, p, o, http.MethodGet, "/other").Header().Get(cache.Header))
+}
+
+func TestStore_Purge(t *testing.T) {
+	s := cache.New(1<<20, 1<<20)
+	o := &origin{handler: respond("x", "Cache-Control", "max-age=60")}
+	p := policy(config.RouteCacheCfg{})
+	for _, path := range []string{"/api/a", "/api/b", "/static/c"} {
+		serve(s, p, o, http.MethodGet, path)
+	}
+
+	assert.Equal(t, 1, s.Purge(func(path string) bool { return path == "/api/a" }))
+	assert.Equal(t, 1, s.Purge(func(path string) bool { return strings.HasPrefix(path, "/api/") }))
+	assert.Equal(t, 1, s.Purge(nil))
+	assert.Zero(t, s.Stats().Entries)
+	assert.Zero(t, s.Stats().Size)
+}
+
+func TestPolicyFrom_DisabledIsNil(t *testing.T) {
+	assert.Nil(t, cache.PolicyFrom(config.RouteCacheCfg{}))
+	assert.NotNil(t, cache.PolicyFrom(config.RouteCacheCfg{Enabled: true}))
+}
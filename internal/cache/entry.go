@@ -0,0 +1,252 @@
+package cache
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golb/internal/middleware"
+)
+
+// heuristicStatuses may be cached without explicit freshness information
+// (RFC 9110 §15.1).
+var heuristicStatuses = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// maxHeuristic caps the freshness guessed from Last-Modified.
+const maxHeuristic = 24 * time.Hour
+
+// entry is one stored response. Entries are immutable once stored; a
+// revalidation replaces the entry rather than updating it.
+type entry struct {
+	key     string   // full key: primary key plus the Vary-selected request headers
+	primary string   // primary key
+	vary    []string // request headers the response varies on
+	uri     string   // host + request URI, for invalidation
+	path    string   // request path, for purges
+
+	status int
+	header http.Header
+	body   []byte
+	size   int64
+
+	cc           directives
+	responseTime time.Time
+	initialAge   time.Duration // corrected initial age (RFC 9111 §4.2.3)
+	lifetime     time.Duration // freshness lifetime (RFC 9111 §4.2.1)
+}
+
+// newEntry builds an entry for a response received at respTime to a request
+// sent at reqTime.
+func newEntry(primaryKey string, r *http.Request, status int, h http.Header, body []byte, reqTime, respTime time.Time) *entry {
+	vary := varyHeaders(h)
+	e := &entry{
+		key:     variantKey(primaryKey, vary, r.Header),
+		primary: primaryKey,
+		vary:    vary,
+		uri:     requestURI(r),
+		path:    r.URL.Path,
+		status:  status,
+		header:  h,
+		body:    body,
+	}
+	e.setTiming(reqTime, respTime)
+	e.size = int64(len(e.key) + len(body))
+	for k, vs := range h {
+		for _, v := range vs {
+			e.size += int64(len(k) + len(v))
+		}
+	}
+	return e
+}
+
+// setTiming computes the entry's age and freshness from its headers.
+func (e *entry) setTiming(reqTime, respTime time.Time) {
+	e.cc = parseDirectives(e.header.Values("Cache-Control"))
+	e.responseTime = respTime
+
+	date, err := http.ParseTime(e.header.Get("Date"))
+	if err != nil {
+		date = respTime
+	}
+	apparentAge := max(respTime.Sub(date), 0)
+	ageValue, _ := strconv.Atoi(e.header.Get("Age"))
+	correctedAge := time.Duration(max(ageValue, 0))*time.Second + respTime.Sub(reqTime)
+	e.initialAge = max(apparentAge, correctedAge)
+
+	e.lifetime = e.freshnessLifetime(date)
+}
+
+// freshnessLifetime follows RFC 9111 §4.2.1: s-maxage, max-age, Expires,
+// then a heuristic of 10% of the time since Last-Modified.
+func (e *entry) freshnessLifetime(date time.Time) time.Duration {
+	if d, ok := e.cc.seconds("s-maxage"); ok {
+		return d
+	}
+	if d, ok := e.cc.seconds("max-age"); ok {
+		return d
+	}
+	if exp := e.header.Get("Expires"); exp != "" {
+		t, err := http.ParseTime(exp)
+		if err != nil {
+			return 0 // an invalid Expires means already expired
+		}
+		return max(t.Sub(date), 0)
+	}
+	if heuristicStatuses[e.status] {
+		if lm, err := http.ParseTime(e.header.Get("Last-Modified")); err == nil && lm.Before(date) {
+			return min(date.Sub(lm)/10, maxHeuristic)
+		}
+	}
+	return 0
+}
+
+// age is the entry's current age.
+func (e *entry) age(now time.Time) time.Duration {
+	return e.initialAge + now.Sub(e.responseTime)
+}
+
+// mustRevalidate reports whether the entry may never be served stale. For a
+// shared cache, s-maxage implies proxy-revalidate.
+func (e *entry) mustRevalidate() bool {
+	return e.cc.has("must-revalidate") || e.cc.has("proxy-revalidate") ||
+		e.cc.has("s-maxage") || e.cc.has("no-cache")
+}
+
+// revalidated returns a copy of e with the headers of a 304 response merged
+// in (RFC 9111 §3.2) and its freshness recomputed.
+func (e *entry) revalidated(h http.Header, reqTime, respTime time.Time) *entry {
+	out := *e
+	out.header = e.header.Clone()
+	for k, vs := range h {
+		switch k {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding", "Content-Range":
+			continue
+		}
+		out.header[k] = vs
+	}
+	out.setTiming(reqTime, respTime)
+	return &out
+}
+
+// storable reports whether a shared cache may store the response (RFC 9111
+// §3). Authenticated requests are only stored when the response explicitly
+// allows it, or when the route's key includes the claims of a verified JWT so
+// that every user gets their own entries.
+func storable(r *http.Request, p *Policy, status int, h http.Header) bool {
+	if r.Method != http.MethodGet || status == http.StatusPartialContent || status == http.StatusNotModified || status < 200 {
+		return false
+	}
+	cc := parseDirectives(h.Values("Cache-Control"))
+	if cc.has("no-store") || cc.has("private") {
+		return false
+	}
+	for _, v := range varyHeaders(h) {
+		if v == "*" {
+			return false
+		}
+	}
+	// Cookies set for one client must never be replayed to another.
+	if h.Get("Set-Cookie") != "" {
+		return false
+	}
+	perUser := len(p.KeyClaims) > 0 && middleware.Claims(r.Context()) != nil
+	if r.Header.Get("Authorization") != "" && !perUser &&
+		!cc.has("public") && !cc.has("s-maxage") && !cc.has("must-revalidate") {
+		return false
+	}
+	hasFreshness := cc.has("s-maxage") || cc.has("max-age") || h.Get("Expires") != ""
+	if !hasFreshness && !cc.has("public") && !heuristicStatuses[status] {
+		return false
+	}
+	// Without freshness or a validator the entry could never be used.
+	return hasFreshness || h.Get("ETag") != "" || h.Get("Last-Modified") != ""
+}
+
+// directives are parsed Cache-Control directives; names are lower-cased and
+// values unquoted.
+type directives map[string]string
+
+func parseDirectives(values []string) directives {
+	d := directives{}
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			name, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name == "" {
+				continue
+			}
+			d[strings.ToLower(name)] = strings.Trim(val, `"`)
+		}
+	}
+	return d
+}
+
+// requestDirectives parses a request's Cache-Control, honouring the legacy
+// Pragma: no-cache when Cache-Control is absent.
+func requestDirectives(h http.Header) directives {
+	if h.Get("Cache-Control") == "" && strings.EqualFold(h.Get("Pragma"), "no-cache") {
+		return directives{"no-cache": ""}
+	}
+	return parseDirectives(h.Values("Cache-Control"))
+}
+
+func (d directives) has(name string) bool {
+	_, ok := d[name]
+	return ok
+}
+
+// seconds returns a delta-seconds directive.
+func (d directives) seconds(name string) (time.Duration, bool) {
+	v, ok := d[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// varyHeaders returns the canonical, sorted request header names listed in
+// a response's Vary header.
+func varyHeaders(h http.Header) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name != "" && !seen[name] {
+				seen[name] = true
+				out = append(out, name)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// variantKey extends a primary key with the values of the request headers a
+// response varies on.
+func variantKey(primaryKey string, vary []string, h http.Header) string {
+	if len(vary) == 0 {
+		return primaryKey
+	}
+	var b strings.Builder
+	b.WriteString(primaryKey)
+	for _, name := range vary {
+		b.WriteString("\x00")
+		b.WriteString(name)
+		b.WriteString("=")
+		b.WriteString(strings.Join(h.Values(name), ","))
+	}
+	return b.String()
+}
+
+func requestURI(r *http.Request) string {
+	return strings.ToLower(r.Host) + r.URL.RequestURI()
+}
@@ -0,0 +1,338 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Serve answers r from the cache when it can and otherwise forwards it to
+// next, storing what comes back. next is the rest of the request path (the
+// proxy); it is also used for conditional and background revalidation
+// requests. Unsafe methods are forwarded and, when they succeed, invalidate
+// the stored responses for their URI.
+func (s *Store) Serve(w http.ResponseWriter, r *http.Request, p *Policy, next http.Handler) {
+	switch {
+	case r.Method != http.MethodGet && r.Method != http.MethodHead:
+		s.serveUnsafe(w, r, next)
+		return
+	case r.Header.Get("Upgrade") != "":
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	reqCC := requestDirectives(r.Header)
+	if reqCC.has("no-store") {
+		s.count(Bypass)
+		w.Header().Set(Header, string(Bypass))
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	primaryKey := p.key(r)
+	e := s.lookup(primaryKey, r)
+	if e == nil {
+		if reqCC.has("only-if-cached") {
+			http.Error(w, "not cached", http.StatusGatewayTimeout)
+			return
+		}
+		s.fetch(w, r, p, primaryKey, nil, false, next)
+		return
+	}
+
+	now := s.now()
+	age := e.age(now)
+	ttl := e.lifetime - age // remaining freshness; negative once stale
+	if fresh(e, reqCC, age, ttl) {
+		s.count(Hit)
+		serveEntry(w, r, e, now, Hit)
+		return
+	}
+
+	staleness := -ttl
+	if ttl <= 0 && !e.mustRevalidate() && !reqCC.has("no-cache") {
+		if v, ok := reqCC["max-stale"]; ok {
+			if d, ok := reqCC.seconds("max-stale"); v == "" || ok && staleness <= d {
+				s.count(Stale)
+				serveEntry(w, r, e, now, Stale)
+				return
+			}
+		}
+		if d, ok := e.cc.seconds("stale-while-revalidate"); ok && staleness <= d {
+			s.count(Stale)
+			serveEntry(w, r, e, now, Stale)
+			s.revalidateAsync(r, p, primaryKey, e, next)
+			return
+		}
+	}
+	if reqCC.has("only-if-cached") {
+		http.Error(w, "not cached", http.StatusGatewayTimeout)
+		return
+	}
+
+	// Revalidate. A still-fresh entry (no-cache) may stand in for a failing
+	// backend, and so may a stale one within stale-if-error.
+	fallback := ttl > 0
+	if !e.mustRevalidate() {
+		for _, cc := range []directives{e.cc, reqCC} {
+			if d, ok := cc.seconds("stale-if-error"); ok && staleness <= d {
+				fallback = true
+			}
+		}
+	}
+	s.fetch(w, r, p, primaryKey, e, fallback, next)
+}
+
+// fresh reports whether e can be served without contacting the backend,
+// given the request's own Cache-Control constraints.
+func fresh(e *entry, reqCC directives, age, ttl time.Duration) bool {
+	if ttl <= 0 || e.cc.has("no-cache") || reqCC.has("no-cache") {
+		return false
+	}
+	if d, ok := reqCC.seconds("max-age"); ok && age > d {
+		return false
+	}
+	if d, ok := reqCC.seconds("min-fresh"); ok && ttl < d {
+		return false
+	}
+	return true
+}
+
+// fetch forwards r to next and stores the response if it may. With a stored
+// entry, the request is made conditional on its validators: a 304 refreshes
+// the entry, and when fallback is set a 5xx is answered with the entry
+// instead. w is nil for background revalidation.
+func (s *Store) fetch(w http.ResponseWriter, r *http.Request, p *Policy, primaryKey string, stored *entry, fallback bool, next http.Handler) {
+	out := r
+	if stored != nil {
+		out = r.Clone(r.Context())
+		for _, h := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range"} {
+			out.Header.Del(h)
+		}
+		if etag := stored.header.Get("ETag"); etag != "" {
+			out.Header.Set("If-None-Match", etag)
+		}
+		if lm := stored.header.Get("Last-Modified"); lm != "" {
+			out.Header.Set("If-Modified-Since", lm)
+		}
+	}
+
+	cw := &captureWriter{client: w, header: http.Header{}, limit: s.entryLimit()}
+	if stored != nil {
+		cw.absorb = func(status int) bool {
+			return status == http.StatusNotModified || fallback && status >= 500
+		}
+	}
+	reqTime := s.now()
+	next.ServeHTTP(cw, out)
+	cw.finish()
+	respTime := s.now()
+
+	switch {
+	case cw.absorbed && cw.status == http.StatusNotModified:
+		e := stored.revalidated(cw.header, reqTime, respTime)
+		s.put(e)
+		if w != nil {
+			s.count(Revalidated)
+			serveEntry(w, r, e, respTime, Revalidated)
+		}
+	case cw.absorbed:
+		if w != nil {
+			s.count(Stale)
+			serveEntry(w, r, stored, respTime, Stale)
+		}
+	default:
+		if w != nil {
+			s.count(Miss)
+		}
+		if !cw.overflow && storable(r, p, cw.status, cw.header) {
+			s.put(newEntry(primaryKey, r, cw.status, cw.header, cw.body.Bytes(), reqTime, respTime))
+		}
+	}
+}
+
+// revalidateAsync refreshes e in the background for stale-while-revalidate,
+// at most once at a time per entry.
+func (s *Store) revalidateAsync(r *http.Request, p *Policy, primaryKey string, e *entry, next http.Handler) {
+	if !s.startRevalidation(e.key) {
+		return
+	}
+	// The refresh outlives the client's request but keeps its values
+	// (route, claims) for the proxy.
+	bg := r.Clone(context.WithoutCancel(r.Context()))
+	bg.Method = http.MethodGet
+	bg.Body = http.NoBody
+	go func() {
+		defer s.endRevalidation(e.key)
+		s.fetch(nil, bg, p, primaryKey, e, true, next)
+	}()
+}
+
+// serveUnsafe forwards a request with an unsafe method and, if it did not
+// fail, invalidates the entries for its URI and for same-host Location and
+// Content-Location targets (RFC 9111 §4.4).
+func (s *Store) serveUnsafe(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	next.ServeHTTP(sw, r)
+	switch r.Method {
+	case http.MethodOptions, http.MethodTrace:
+		return
+	}
+	if sw.status >= 400 {
+		return
+	}
+	s.invalidate(requestURI(r))
+	for _, h := range []string{"Location", "Content-Location"} {
+		loc := w.Header().Get(h)
+		if loc == "" {
+			continue
+		}
+		if u, err := r.URL.Parse(loc); err == nil && (u.Host == "" || strings.EqualFold(u.Host, r.Host)) {
+			s.invalidate(strings.ToLower(r.Host) + (&url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery}).RequestURI())
+		}
+	}
+}
+
+func (s *Store) entryLimit() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return min(s.maxEntrySize, s.maxSize)
+}
+
+// serveEntry writes a stored response, or 304 when the client's own
+// conditional headers match it.
+func serveEntry(w http.ResponseWriter, r *http.Request, e *entry, now time.Time, result Result) {
+	h := w.Header()
+	for k, vs := range e.header {
+		// Copy the values: later handlers may append to them.
+		h[k] = append([]string(nil), vs...)
+	}
+	h.Set("Age", strconv.FormatInt(int64(e.age(now)/time.Second), 10))
+	h.Set(Header, string(result))
+	if e.status == http.StatusOK && notModified(r, e) {
+		h.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(e.status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(e.body)
+	}
+}
+
+// notModified evaluates If-None-Match, or else If-Modified-Since, against a
+// stored response (RFC 9110 §13.2.2).
+func notModified(r *http.Request, e *entry) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(e.header.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, t := range strings.Split(inm, ",") {
+			t = strings.TrimSpace(t)
+			if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lm, err := http.ParseTime(e.header.Get("Last-Modified"))
+	return err == nil && !lm.After(ims)
+}
+
+// captureWriter relays a backend response to the client while keeping a
+// copy of the body for the cache, up to limit bytes. Responses that absorb
+// accepts (a 304 to a revalidation, or a 5xx that a stored entry stands in
+// for) are kept from the client entirely.
+type captureWriter struct {
+	client http.ResponseWriter // nil for background revalidation
+	header http.Header
+	absorb func(status int) bool
+	limit  int64
+
+	status      int
+	wroteHeader bool
+	absorbed    bool
+	overflow    bool
+	body        bytes.Buffer
+}
+
+func (cw *captureWriter) Header() http.Header { return cw.header }
+
+func (cw *captureWriter) WriteHeader(code int) {
+	if cw.wroteHeader || code < 200 {
+		return // informational responses are not relayed through the cache
+	}
+	cw.status, cw.wroteHeader = code, true
+	if cw.absorb != nil && cw.absorb(code) {
+		cw.absorbed = true
+		return
+	}
+	if cw.client != nil {
+		h := cw.client.Header()
+		for k, vs := range cw.header {
+			h[k] = vs
+		}
+		h.Set(Header, string(Miss))
+		cw.client.WriteHeader(code)
+	}
+}
+
+func (cw *captureWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.absorbed {
+		return len(p), nil
+	}
+	if !cw.overflow {
+		if int64(cw.body.Len()+len(p)) > cw.limit {
+			cw.overflow = true
+			cw.body = bytes.Buffer{}
+		} else {
+			cw.body.Write(p)
+		}
+	}
+	if cw.client == nil {
+		return len(p), nil
+	}
+	return cw.client.Write(p)
+}
+
+// Flush keeps streamed responses streaming through the cache.
+func (cw *captureWriter) Flush() {
+	if cw.client != nil && !cw.absorbed {
+		_ = http.NewResponseController(cw.client).Flush()
+	}
+}
+
+// finish sends the header of a response that had no body.
+func (cw *captureWriter) finish() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+}
+
+// statusWriter records the status of a response written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if code >= 200 {
+		sw.status = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (sw *statusWriter) Unwrap() http.ResponseWriter { return sw.ResponseWriter }
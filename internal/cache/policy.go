@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golb/internal/config"
+	"golb/internal/middleware"
+)
+
+// Policy is a route's caching settings.
+type Policy struct {
+	KeyHeaders []string // request headers added to the cache key, canonicalised
+	KeyClaims  []string // JWT claims added to the cache key
+}
+
+// PolicyFrom converts a route's cache config, or returns nil when the route
+// does not cache.
+func PolicyFrom(cfg config.RouteCacheCfg) *Policy {
+	if !cfg.Enabled {
+		return nil
+	}
+	p := &Policy{KeyClaims: cfg.KeyClaims}
+	for _, h := range cfg.KeyHeaders {
+		p.KeyHeaders = append(p.KeyHeaders, http.CanonicalHeaderKey(h))
+	}
+	return p
+}
+
+// key is the primary cache key of r: host and request URI, plus the
+// configured headers and claims. GET and HEAD share keys so that HEAD can be
+// answered from a stored GET.
+func (p *Policy) key(r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(requestURI(r))
+	for _, name := range p.KeyHeaders {
+		b.WriteString("\x00")
+		b.WriteString(name)
+		b.WriteString("=")
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+	if len(p.KeyClaims) > 0 {
+		claims := middleware.Claims(r.Context())
+		for _, name := range p.KeyClaims {
+			b.WriteString("\x00jwt.")
+			b.WriteString(name)
+			b.WriteString("=")
+			if v, ok := claims[name]; ok {
+				b.WriteString(fmt.Sprint(v))
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,338 @@
+// Package canary implements automated progressive rollouts on traffic-split
+// routes.
+//
+// A Rollout steps the canary pool's split weight through a schedule (for
+// example 1% → 5% → 25% → 100%). While a step is held it compares the canary
+// pool against the stable pool using the request, error and latency counters
+// on strategy.Backend, measured over the current step only. If the canary's
+// error rate or p99 latency exceeds the configured thresholds the rollout is
+// rolled back to 0% and a structured event is logged.
+//
+// A Manager owns the rollouts of the current routing table and keeps their
+// state across hot-reloads.
+package canary
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"golb/internal/config"
+	"golb/internal/proxy"
+	"golb/internal/strategy"
+)
+
+// State is the lifecycle state of a rollout.
+type State string
+
+const (
+	Progressing State = "progressing"
+	Paused      State = "paused"
+	Promoted    State = "promoted"
+	RolledBack  State = "rolled_back"
+)
+
+// ErrUnknownAction is returned by Rollout.Control for unsupported actions.
+var ErrUnknownAction = errors.New("canary: unknown action")
+
+// Config holds the parameters of one rollout.
+type Config struct {
+	Steps             []int // canary weight per step, percent, increasing
+	StepInterval      time.Duration
+	CheckInterval     time.Duration
+	MinRequests       int64
+	MaxErrorRateDelta float64
+	MaxP99Ratio       float64
+}
+
+// ConfigFrom converts the YAML settings into a Config with defaults applied.
+func ConfigFrom(c config.CanaryCfg) Config {
+	return Config{
+		Steps:             append([]int(nil), c.Steps...),
+		StepInterval:      c.ParsedStepInterval(),
+		CheckInterval:     c.ParsedCheckInterval(),
+		MinRequests:       int64(c.ParsedMinRequests()),
+		MaxErrorRateDelta: c.ParsedMaxErrorRateDelta(),
+		MaxP99Ratio:       c.ParsedMaxP99Ratio(),
+	}
+}
+
+func (c Config) equal(o Config) bool {
+	if len(c.Steps) != len(o.Steps) {
+		return false
+	}
+	for i := range c.Steps {
+		if c.Steps[i] != o.Steps[i] {
+			return false
+		}
+	}
+	return c.StepInterval == o.StepInterval && c.CheckInterval == o.CheckInterval &&
+		c.MinRequests == o.MinRequests && c.MaxErrorRateDelta == o.MaxErrorRateDelta &&
+		c.MaxP99Ratio == o.MaxP99Ratio
+}
+
+// PoolStats summarises one pool over the current step.
+type PoolStats struct {
+	Pool      string  `json:"pool"`
+	Requests  int64   `json:"requests"`
+	Errors    int64   `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	P99       float64 `json:"p99_seconds"`
+}
+
+// Status is a snapshot of a rollout, as reported by the admin API.
+type Status struct {
+	Route       string    `json:"route"`
+	State       State     `json:"state"`
+	Step        int       `json:"step"` // index into Steps
+	Steps       []int     `json:"steps"`
+	Weight      int       `json:"canary_weight"`
+	StepStarted time.Time `json:"step_started"`
+	Reason      string    `json:"reason,omitempty"` // why the rollout last changed state
+	Stable      PoolStats `json:"stable"`
+	Canary      PoolStats `json:"canary"`
+}
+
+// counters is a point-in-time sum of a pool's backend counters.
+type counters struct {
+	requests int64
+	errors   int64
+	latency  strategy.LatencySnapshot
+}
+
+func poolCounters(p *proxy.Pool) counters {
+	var c counters
+	for _, b := range p.Backends() {
+		c.requests += b.TotalRequests()
+		c.errors += b.TotalErrors() + b.TotalServerErrors()
+		c.latency = c.latency.Add(b.Latency())
+	}
+	return c
+}
+
+func (c counters) sub(o counters) counters {
+	return counters{
+		requests: c.requests - o.requests,
+		errors:   c.errors - o.errors,
+		latency:  c.latency.Sub(o.latency),
+	}
+}
+
+func (c counters) stats(pool string) PoolStats {
+	s := PoolStats{
+		Pool:     pool,
+		Requests: c.requests,
+		Errors:   c.errors,
+		P99:      c.latency.Quantile(0.99).Seconds(),
+	}
+	if c.requests > 0 {
+		s.ErrorRate = float64(c.errors) / float64(c.requests)
+	}
+	return s
+}
+
+// Rollout drives one route's canary. It is safe for concurrent use.
+type Rollout struct {
+	route string
+	cfg   Config
+
+	mu          sync.Mutex
+	split       *proxy.Splitter
+	stable      *proxy.Pool
+	canary      *proxy.Pool
+	state       State
+	step        int
+	stepStarted time.Time
+	lastCheck   time.Time
+	reason      string
+	baseStable  counters
+	baseCanary  counters
+}
+
+// NewRollout starts a rollout of rt's split at the first step. The route's
+// first split pool is the stable pool and its last the canary.
+func NewRollout(rt *proxy.Route, cfg Config, now time.Time) *Rollout {
+	r := &Rollout{route: rt.Name, cfg: cfg, state: Progressing}
+	r.attach(rt, now)
+	r.startStep(0, now)
+	return r
+}
+
+// Route returns the name of the route being rolled out.
+func (r *Rollout) Route() string { return r.route }
+
+// attach points the rollout at a (possibly rebuilt) route, re-applies the
+// current weight and restarts the analysis window: counters of a rebuilt
+// table start from zero.
+func (r *Rollout) attach(rt *proxy.Route, now time.Time) {
+	targets := rt.Split.Targets()
+	r.split = rt.Split
+	r.stable = targets[0].Pool
+	r.canary = targets[len(targets)-1].Pool
+	r.resetWindow(now)
+	r.applyWeight()
+}
+
+func (r *Rollout) resetWindow(now time.Time) {
+	r.baseStable = poolCounters(r.stable)
+	r.baseCanary = poolCounters(r.canary)
+	r.lastCheck = now
+}
+
+func (r *Rollout) startStep(i int, now time.Time) {
+	r.step = i
+	r.stepStarted = now
+	r.resetWindow(now)
+	r.applyWeight()
+}
+
+// weight returns the canary weight implied by the current state.
+func (r *Rollout) weight() int {
+	switch r.state {
+	case Promoted:
+		return 100
+	case RolledBack:
+		return 0
+	}
+	return r.cfg.Steps[r.step]
+}
+
+func (r *Rollout) applyWeight() {
+	w := r.weight()
+	r.split.SetWeights(map[string]int{r.stable.Name: 100 - w, r.canary.Name: w})
+}
+
+// Status returns a snapshot of the rollout.
+func (r *Rollout) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return Status{
+		Route:       r.route,
+		State:       r.state,
+		Step:        r.step,
+		Steps:       append([]int(nil), r.cfg.Steps...),
+		Weight:      r.weight(),
+		StepStarted: r.stepStarted,
+		Reason:      r.reason,
+		Stable:      poolCounters(r.stable).sub(r.baseStable).stats(r.stable.Name),
+		Canary:      poolCounters(r.canary).sub(r.baseCanary).stats(r.canary.Name),
+	}
+}
+
+// Evaluate runs the analysis if the check interval has elapsed: it rolls back
+// on a threshold breach, or advances to the next step once the current one
+// has been held for StepInterval with enough canary traffic to judge it.
+func (r *Rollout) Evaluate(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.state != Progressing || now.Sub(r.lastCheck) < r.cfg.CheckInterval {
+		return
+	}
+	r.lastCheck = now
+
+	stable := poolCounters(r.stable).sub(r.baseStable).stats(r.stable.Name)
+	canary := poolCounters(r.canary).sub(r.baseCanary).stats(r.canary.Name)
+	if canary.Requests < r.cfg.MinRequests {
+		return // not enough traffic to judge this step yet
+	}
+
+	if reason := r.breach(stable, canary); reason != "" {
+		r.state = RolledBack
+		r.reason = reason
+		r.applyWeight()
+		slog.Warn("canary: rolled back",
+			"event", "canary_rollback",
+			"route", r.route,
+			"reason", reason,
+			"step", r.step,
+			"canary_weight", r.cfg.Steps[r.step],
+			"canary_requests", canary.Requests,
+			"canary_error_rate", canary.ErrorRate,
+			"stable_error_rate", stable.ErrorRate,
+			"canary_p99_seconds", canary.P99,
+			"stable_p99_seconds", stable.P99,
+		)
+		return
+	}
+
+	if now.Sub(r.stepStarted) < r.cfg.StepInterval {
+		return
+	}
+	if r.step == len(r.cfg.Steps)-1 || r.cfg.Steps[r.step+1] >= 100 {
+		r.promote("all steps passed")
+		return
+	}
+	r.startStep(r.step+1, now)
+	slog.Info("canary: step advanced",
+		"event", "canary_step",
+		"route", r.route,
+		"step", r.step,
+		"canary_weight", r.cfg.Steps[r.step],
+		"canary_error_rate", canary.ErrorRate,
+		"canary_p99_seconds", canary.P99,
+	)
+}
+
+// breach returns a description of the first threshold the canary exceeds, or
+// "" when it is within bounds. Latency is only compared once the stable pool
+// has latency samples of its own.
+func (r *Rollout) breach(stable, canary PoolStats) string {
+	if canary.ErrorRate-stable.ErrorRate > r.cfg.MaxErrorRateDelta {
+		return "error rate"
+	}
+	if stable.P99 > 0 && canary.P99 > stable.P99*r.cfg.MaxP99Ratio {
+		return "p99 latency"
+	}
+	return ""
+}
+
+func (r *Rollout) promote(reason string) {
+	r.state = Promoted
+	r.reason = reason
+	r.applyWeight()
+	slog.Info("canary: promoted",
+		"event", "canary_promoted",
+		"route", r.route,
+		"reason", reason,
+	)
+}
+
+// Control applies an operator action: pause, resume, promote, rollback or
+// restart (back to the first step).
+func (r *Rollout) Control(action string, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch action {
+	case "pause":
+		if r.state == Progressing {
+			r.state = Paused
+			r.reason = "paused by operator"
+		}
+	case "resume":
+		if r.state == Paused {
+			r.state = Progressing
+			r.reason = "resumed by operator"
+			r.resetWindow(now)
+		}
+	case "promote":
+		r.promote("promoted by operator")
+	case "rollback":
+		r.state = RolledBack
+		r.reason = "rolled back by operator"
+		r.applyWeight()
+		slog.Warn("canary: rolled back",
+			"event", "canary_rollback",
+			"route", r.route,
+			"reason", r.reason,
+			"step", r.step,
+		)
+	case "restart":
+		r.state = Progressing
+		r.reason = "restarted by operator"
+		r.startStep(0, now)
+	default:
+		return ErrUnknownAction
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package canary_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"golb/internal/canary"
+	"golb/internal/config"
+	"golb/internal/proxy"
+	"golb/internal/strategy"
+)
+
+// ── helpers ──────────────────────────────────────────────────────────────────
+
+func splitRoute(t *testing.T) (*proxy.Route, *strategy.Backend, *strategy.Backend) {
+	t.Helper()
+	stable, err := strategy.NewBackend("http://stable:80", 1)
+	require.NoError(t, err)
+	cn, err := strategy.NewBackend("http://canary:80", 1)
+	require.NoError(t, err)
+	stablePool := proxy.NewPool("stable", strategy.NewRoundRobin([]*strategy.Backend{stable}), nil)
+	canaryPool := proxy.NewPool("canary", strategy.NewRoundRobin([]*strategy.Backend{cn}), nil)
+	split := proxy.NewSplitter([]*proxy.SplitTarget{
+		{Pool: stablePool, Weight: 100},
+		{Pool: canaryPool, Weight: 0},
+	}, nil, "")
+	return &proxy.Route{Name: "web", PathPrefix: "/", Pool: stablePool, Split: split}, stable, cn
+}
+
+func testConfig() canary.Config {
+	return canary.Config{
+		Steps:             []int{5, 25, 100},
+		StepInterval:      time.Minute,
+		CheckInterval:     10 * time.Second,
+		MinRequests:       10,
+		MaxErrorRateDelta: 0.05,
+		MaxP99Ratio:       2,
+	}
+}
+
+// serve simulates n requests to b, of which errs returned 5xx, each taking d.
+func serve(b *strategy.Backend, n, errs int, d time.Duration) {
+	for i := 0; i < n; i++ {
+		b.IncRequests()
+		b.ObserveLatency(d)
+		if i < errs {
+			b.IncServerErrors()
+		}
+	}
+}
+
+// ── Tests ─────────────────────────────────────────────────────────────────────
+
+func TestRollout_StepsThroughScheduleAndPromotes(t *testing.T) {
+	rt, stable, cn := splitRoute(t)
+	start := time.Now()
+	r := canary.NewRollout(rt, testConfig(), start)
+	assert.Equal(t, map[string]int{"stable": 95, "canary": 5}, rt.Split.Weights())
+
+	serve(stable, 100, 0, 20*time.Millisecond)
+	serve(cn, 20, 0, 20*time.Millisecond)
+	r.Evaluate(start.Add(30 * time.Second))
+	assert.Equal(t, 5, r.Status().Weight, "step is held for step_interval")
+
+	r.Evaluate(start.Add(time.Minute))
+	st := r.Status()
+	assert.Equal(t, canary.Progressing, st.State)
+	assert.Equal(t, 1, st.Step)
+	assert.Equal(t, map[string]int{"stable": 75, "canary": 25}, rt.Split.Weights())
+	assert.Zero(t, st.Canary.Requests, "each step is judged on its own traffic")
+
+	serve(stable, 100, 0, 20*time.Millisecond)
+	serve(cn, 20, 0, 20*time.Millisecond)
+	r.Evaluate(start.Add(2 * time.Minute))
+	assert.Equal(t, canary.Promoted, r.Status().State)
+	assert.Equal(t, map[string]int{"stable": 0, "canary": 100}, rt.Split.Weights())
+}
+
+func TestRollout_HoldsStepWithoutEnoughTraffic(t *testing.T) {
+	rt, _, cn := splitRoute(t)
+	start := time.Now()
+	r := canary.NewRollout(rt, testConfig(), start)
+
+	serve(cn, 5, 5, time.Millisecond)
+	r.Evaluate(start.Add(5 * time.Minute))
+	st := r.Status()
+	assert.Equal(t, canary.Progressing, st.State, "5 requests are below min_requests")
+	assert.Equal(t, 0, st.Step)
+}
+
+func TestRollout_RollsBackOnErrorRate(t *testing.T) {
+	rt, stable, cn := splitRoute(t)
+	start := time.Now()
+	r := canary.NewRollout(rt, testConfig(), start)
+
+	serve(stable, 100, 1, 20*time.Millisecond)
+	serve(cn, 20, 4, 20*time.Millisecond)
+	r.Evaluate(start.Add(10 * time.Second))
+
+	st := r.Status()
+	assert.Equal(t, canary.RolledBack, st.State)
+	assert.Equal(t, "error rate", st.Reason)
+	assert.Equal(t, map[string]int{"stable": 100, "canary": 0}, rt.Split.Weights())
+
+	r.Evaluate(start.Add(time.Hour))
+	assert.Equal(t, canary.RolledBack, r.Status().State, "a rolled-back rollout stays put")
+}
+
+func TestRollout_RollsBackOnP99Latency(t *testing.T) {
+	rt, stable, cn := splitRoute(t)
+	start := time.Now()
+	r := canary.NewRollout(rt, testConfig(), start)
+
+	serve(stable, 100, 0, 20*time.Millisecond)
+	serve(cn, 20, 0, 400*time.Millisecond)
+	r.Evaluate(start.Add(10 * time.Second))
+
+	st := r.Status()
+	assert.Equal(t, canary.RolledBack, st.State)
+	assert.Equal(t, "p99 latency", st.Reason)
+	assert.Greater(t, st.Canary.P99, st.Stable.P99)
+}
+
+func TestRollout_Control(t *testing.T) {
+	rt, _, cn := splitRoute(t)
+	start := time.Now()
+	r := canary.NewRollout(rt, testConfig(), start)
+
+	require.NoError(t, r.Control("pause", start))
+	serve(cn, 20, 0, time.Millisecond)
+	r.Evaluate(start.Add(time.Hour))
+	assert.Equal(t, canary.Paused, r.Status().State)
+	assert.Equal(t, 5, r.Status().Weight, "paused rollouts keep their weight")
+
+	require.NoError(t, r.Control("resume", start))
+	assert.Equal(t, canary.Progressing, r.Status().State)
+
+	require.NoError(t, r.Control("rollback", start))
+	assert.Equal(t, 0, rt.Split.Weights()["canary"])
+
+	require.NoError(t, r.Control("restart", start))
+	assert.Equal(t, 5, rt.Split.Weights()["canary"])
+
+	require.NoError(t, r.Control("promote", start))
+	assert.Equal(t, 100, rt.Split.Weights()["canary"])
+
+	assert.ErrorIs(t, r.Control("explode", start), canary.ErrUnknownAction)
+}
+
+func TestManager_UpdateKeepsStateAcrossReload(t *testing.T) {
+	cfg := config.Config{
+		Strategy: "round_robin",
+		Pools: []config.PoolCfg{
+			{Name: "stable", Backends: []config.BackendCfg{{URL: "http://stable:80", Weight: 1}}},
+			{Name: "canary", Backends: []config.BackendCfg{{URL: "http://canary:80", Weight: 1}}},
+		},
+		Routes: []config.RouteCfg{{
+			Name:       "web",
+			PathPrefix: "/",
+			Split:      []config.SplitTargetCfg{{Pool: "stable", Weight: 100}, {Pool: "canary", Weight: 0}},
+			Canary:     config.CanaryCfg{Steps: []int{10, 50}},
+		}},
+	}
+	table, err := proxy.BuildTable(cfg)
+	require.NoError(t, err)
+
+	m := canary.NewManager()
+	m.Update(cfg, table)
+	ro, ok := m.Get("web")
+	require.True(t, ok)
+	require.NoError(t, ro.Control("pause", time.Now()))
+	assert.Equal(t, 10, table.Routes()[0].Split.Weights()["canary"])
+
+	// A reload builds a fresh split with the static config weights; the
+	// rollout re-applies its own before the table goes live.
+	reloaded, err := proxy.BuildTable(cfg)
+	require.NoError(t, err)
+	m.Update(cfg, reloaded)
+	assert.Equal(t, 10, reloaded.Routes()[0].Split.Weights()["canary"])
+	require.Len(t, m.Statuses(), 1)
+	assert.Equal(t, canary.Paused, m.Statuses()[0].State)
+
+	cfg.Routes[0].Canary = config.CanaryCfg{}
+	m.Update(cfg, reloaded)
+	assert.Empty(t, m.Statuses())
+}
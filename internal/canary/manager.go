@@ -0,0 +1,137 @@
+package canary
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"golb/internal/config"
+	"golb/internal/proxy"
+)
+
+// tick is how often the Manager's loop offers each rollout a chance to run
+// its analysis; each rollout then applies its own check interval.
+const tick = time.Second
+
+// Manager owns the rollouts of the active routing table. It is safe to call
+// Update while the manager is running.
+type Manager struct {
+	mu       sync.RWMutex
+	rollouts map[string]*Rollout
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewManager creates a Manager with no rollouts; call Update to load them and
+// Start to begin the analysis loop.
+func NewManager() *Manager {
+	return &Manager{rollouts: map[string]*Rollout{}}
+}
+
+// Update syncs the rollouts with cfg and table. It must be called before
+// table is installed in the Gateway, so the table goes live with the
+// rollouts' current weights rather than the static ones from the config.
+//
+// Rollouts whose settings are unchanged keep their state and step and are
+// re-attached to the new table's split; new or changed ones start over at the
+// first step; rollouts of removed routes are dropped.
+func (m *Manager) Update(cfg config.Config, table *proxy.Table) {
+	routes := map[string]*proxy.Route{}
+	for _, rt := range table.Routes() {
+		routes[rt.Name] = rt
+	}
+
+	now := time.Now()
+	next := map[string]*Rollout{}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, rc := range cfg.ResolvedRoutes() {
+		rt := routes[rc.Name]
+		if !rc.Canary.Enabled() || rt == nil || rt.Split == nil {
+			continue
+		}
+		c := ConfigFrom(rc.Canary)
+		if old, ok := m.rollouts[rc.Name]; ok && old.cfg.equal(c) {
+			old.mu.Lock()
+			old.attach(rt, now)
+			old.mu.Unlock()
+			next[rc.Name] = old
+			continue
+		}
+		next[rc.Name] = NewRollout(rt, c, now)
+		slog.Info("canary: rollout started",
+			"event", "canary_start",
+			"route", rc.Name,
+			"steps", c.Steps,
+		)
+	}
+	m.rollouts = next
+}
+
+// Get returns the rollout of the named route.
+func (m *Manager) Get(route string) (*Rollout, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	r, ok := m.rollouts[route]
+	return r, ok
+}
+
+// Statuses returns the status of every rollout, sorted by route name.
+func (m *Manager) Statuses() []Status {
+	m.mu.RLock()
+	rollouts := make([]*Rollout, 0, len(m.rollouts))
+	for _, r := range m.rollouts {
+		rollouts = append(rollouts, r)
+	}
+	m.mu.RUnlock()
+
+	out := make([]Status, 0, len(rollouts))
+	for _, r := range rollouts {
+		out = append(out, r.Status())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Route < out[j].Route })
+	return out
+}
+
+// Evaluate runs every rollout's analysis as of now.
+func (m *Manager) Evaluate(now time.Time) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, r := range m.rollouts {
+		r.Evaluate(now)
+	}
+}
+
+// Start begins the background analysis loop.
+func (m *Manager) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				m.Evaluate(now)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop shuts down the background goroutine and waits for it to exit.
+func (m *Manager) Stop() {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.wg.Wait()
+}
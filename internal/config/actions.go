@@ -0,0 +1,84 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// RedirectCfg answers a route's requests with a redirect instead of
+// proxying them.
+type RedirectCfg struct {
+	To     string `mapstructure:"to"`     // target template; defaults to the request's own path and query
+	Regex  string `mapstructure:"regex"`  // matched against the path; its groups are ${1} or ${name} in To
+	Status int    `mapstructure:"status"` // 301, 302, 303, 307 or 308
+	HTTPS  bool   `mapstructure:"https"`  // send the client to the https:// version of the target
+}
+
+// Enabled reports whether the route redirects.
+func (r RedirectCfg) Enabled() bool { return r.To != "" || r.HTTPS }
+
+// ParsedStatus returns Status, defaulting to 302 Found.
+func (r RedirectCfg) ParsedStatus() int {
+	if r.Status == 0 {
+		return 302
+	}
+	return r.Status
+}
+
+// RespondCfg answers a route's requests with a fixed response, e.g.
+// /robots.txt, a maintenance notice or a 410 for a retired API.
+type RespondCfg struct {
+	Status   int               `mapstructure:"status"`
+	Headers  map[string]string `mapstructure:"headers"`   // values may use ${...} placeholders
+	Body     string            `mapstructure:"body"`      // inline body
+	BodyFile string            `mapstructure:"body_file"` // body read from a file when the config is (re)loaded
+}
+
+// Enabled reports whether the route responds itself.
+func (r RespondCfg) Enabled() bool {
+	return r.Status != 0 || len(r.Headers) > 0 || r.Body != "" || r.BodyFile != ""
+}
+
+// ParsedStatus returns Status, defaulting to 200 OK.
+func (r RespondCfg) ParsedStatus() int {
+	if r.Status == 0 {
+		return 200
+	}
+	return r.Status
+}
+
+// Proxies reports whether the route forwards requests to a pool, as opposed
+// to answering them with a redirect or fixed response.
+func (r RouteCfg) Proxies() bool { return !r.Redirect.Enabled() && !r.Respond.Enabled() }
+
+// validateAction checks a redirect or respond route. Such routes never reach
+// a backend, so the proxying settings make no sense on them.
+func validateAction(i int, r RouteCfg) error {
+	if r.Redirect.Enabled() && r.Respond.Enabled() {
+		return fmt.Errorf("config: route[%d] sets both redirect and respond", i)
+	}
+	if r.Pool != "" || len(r.Split) > 0 || r.Mirror.Enabled() || r.Canary.Enabled() ||
+		r.StripPrefix || r.AddPrefix != "" || len(r.Rewrite) > 0 || !r.RequestHeaders.Empty() ||
+		r.Cache.Enabled || r.Coalesce.Enabled || r.Hedge.Enabled || len(r.Upgrade.Protocols) > 0 {
+		return fmt.Errorf("config: route[%d] redirect and respond routes cannot set pool, split, mirror, canary, path rewrites, request_headers, cache, coalesce, hedge or upgrade", i)
+	}
+	if rd := r.Redirect; rd.Enabled() {
+		switch rd.ParsedStatus() {
+		case 301, 302, 303, 307, 308:
+		default:
+			return fmt.Errorf("config: route[%d] redirect status %d must be 301, 302, 303, 307 or 308", i, rd.Status)
+		}
+		if _, err := regexp.Compile(rd.Regex); err != nil {
+			return fmt.Errorf("config: route[%d] redirect regex: %w", i, err)
+		}
+	}
+	if rs := r.Respond; rs.Enabled() {
+		if s := rs.ParsedStatus(); s < 200 || s > 599 {
+			return fmt.Errorf("config: route[%d] respond status %d must be in 200–599", i, rs.Status)
+		}
+		if rs.Body != "" && rs.BodyFile != "" {
+			return fmt.Errorf("config: route[%d] respond sets both body and body_file", i)
+		}
+	}
+	return nil
+}
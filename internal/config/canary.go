@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CanaryCfg drives an automated progressive rollout on a split route. The
+// first split pool is the stable pool and the last one the canary. The
+// canary's weight walks through Steps (percent), holding each step for
+// StepInterval while its error rate and p99 latency are compared against the
+// stable pool; a breach rolls the canary back to 0%.
+type CanaryCfg struct {
+	Steps             []int   `mapstructure:"steps"`                // canary weight per step, e.g. [1, 5, 25, 100]
+	StepInterval      string  `mapstructure:"step_interval"`        // time spent at each step
+	CheckInterval     string  `mapstructure:"check_interval"`       // how often the analysis runs
+	MinRequests       int     `mapstructure:"min_requests"`         // canary requests needed before a step is judged
+	MaxErrorRateDelta float64 `mapstructure:"max_error_rate_delta"` // allowed canary minus stable error rate, 0–1
+	MaxP99Ratio       float64 `mapstructure:"max_p99_ratio"`        // allowed canary p99 / stable p99
+}
+
+// Enabled reports whether the route has a rollout configured.
+func (c CanaryCfg) Enabled() bool { return len(c.Steps) > 0 }
+
+func (c CanaryCfg) ParsedStepInterval() time.Duration {
+	return parseDuration(c.StepInterval, 5*time.Minute)
+}
+
+func (c CanaryCfg) ParsedCheckInterval() time.Duration {
+	return parseDuration(c.CheckInterval, 10*time.Second)
+}
+
+// ParsedMinRequests returns MinRequests, defaulting to 100.
+func (c CanaryCfg) ParsedMinRequests() int {
+	if c.MinRequests <= 0 {
+		return 100
+	}
+	return c.MinRequests
+}
+
+// ParsedMaxErrorRateDelta returns MaxErrorRateDelta, defaulting to 0.01
+// (one percentage point).
+func (c CanaryCfg) ParsedMaxErrorRateDelta() float64 {
+	if c.MaxErrorRateDelta <= 0 {
+		return 0.01
+	}
+	return c.MaxErrorRateDelta
+}
+
+// ParsedMaxP99Ratio returns MaxP99Ratio, defaulting to 1.5.
+func (c CanaryCfg) ParsedMaxP99Ratio() float64 {
+	if c.MaxP99Ratio <= 0 {
+		return 1.5
+	}
+	return c.MaxP99Ratio
+}
+
+// validateCanary checks a route's rollout settings. Rollouts are addressed by
+// route name in the admin API, so canary routes need a unique name that fits
+// in a URL path segment (names defaulted from path_prefix never do).
+func validateCanary(i int, r RouteCfg, routeNames map[string]int) error {
+	if !r.Canary.Enabled() {
+		return nil
+	}
+	if len(r.Split) != 2 {
+		return fmt.Errorf("config: route[%d] canary requires a split with exactly two pools (stable, canary)", i)
+	}
+	if strings.Contains(r.Name, "/") {
+		return fmt.Errorf("config: route[%d] canary requires a name without slashes", i)
+	}
+	if routeNames[r.Name] > 1 {
+		return fmt.Errorf("config: route[%d] canary route name %q is not unique", i, r.Name)
+	}
+	prev := 0
+	for _, s := range r.Canary.Steps {
+		if s <= prev || s > 100 {
+			return fmt.Errorf("config: route[%d] canary steps must increase within 1–100", i)
+		}
+		prev = s
+	}
+	for _, f := range []struct{ name, value string }{
+		{"step_interval", r.Canary.StepInterval},
+		{"check_interval", r.Canary.CheckInterval},
+	} {
+		if f.value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(f.value); err != nil || d <= 0 {
+			return fmt.Errorf("config: route[%d] canary %s %q must be a positive duration", i, f.name, f.value)
+		}
+	}
+	return nil
+}
@@ -13,8 +13,9 @@ import (
 
 // BackendCfg is the YAML representation of a single upstream server.
 type BackendCfg struct {
-	URL    string `mapstructure:"url"`
-	Weight int    `mapstructure:"weight"`
+	URL      string `mapstructure:"url"`
+	Weight   int    `mapstructure:"weight"`
+	MaxConns int    `mapstructure:"max_conns"` // 0 means unlimited
 }
 
 // HealthCheckCfg controls active health probing.
@@ -23,6 +24,13 @@ type HealthCheckCfg struct {
 	Interval string `mapstructure:"interval"`
 	Timeout  string `mapstructure:"timeout"`
 	Path     string `mapstructure:"path"`
+	Type     string `mapstructure:"type"`    // "http" (default) or "grpc"; pools may override
+	Service  string `mapstructure:"service"` // gRPC service to check; pools may override
+}
+
+// PoolDefaults returns the probe settings that pools inherit.
+func (h HealthCheckCfg) PoolDefaults() PoolHealthCheckCfg {
+	return PoolHealthCheckCfg{Type: h.Type, Path: h.Path, Service: h.Service}
 }
 
 // ParsedInterval returns the interval as a time.Duration, defaulting to 10s.
@@ -43,6 +51,71 @@ func (h HealthCheckCfg) ParsedTimeout() time.Duration {
 	return d
 }
 
+// QueueCfg controls the bounded FIFO queue that requests wait in when every
+// backend has reached its max_conns limit.
+type QueueCfg struct {
+	MaxSize int    `mapstructure:"max_size"` // 0 disables queueing (fail fast with 503)
+	Timeout string `mapstructure:"timeout"`  // maximum time a request may wait
+}
+
+// ParsedTimeout returns the queue timeout as a time.Duration, defaulting to 5s.
+func (q QueueCfg) ParsedTimeout() time.Duration {
+	d, _ := time.ParseDuration(q.Timeout)
+	if d <= 0 {
+		return 5 * time.Second
+	}
+	return d
+}
+
+// ServerCfg holds the client-facing HTTP server timeouts and protocols.
+// Except for shutdown_timeout, these take effect on restart only.
+type ServerCfg struct {
+	ReadTimeout       string           `mapstructure:"read_timeout"`
+	ReadHeaderTimeout string           `mapstructure:"read_header_timeout"`
+	WriteTimeout      string           `mapstructure:"write_timeout"`
+	IdleTimeout       string           `mapstructure:"idle_timeout"`
+	ShutdownTimeout   string           `mapstructure:"shutdown_timeout"` // graceful drain window on SIGTERM
+	TLS               ServerTLSCfg     `mapstructure:"tls"`
+	H2C               bool             `mapstructure:"h2c"` // accept cleartext HTTP/2 (prior knowledge) on the plaintext listener
+	HTTP2             HTTP2Cfg         `mapstructure:"http2"`
+	HTTP3             HTTP3Cfg         `mapstructure:"http3"`
+	ProxyProtocol     ProxyProtocolCfg `mapstructure:"proxy_protocol"`   // headers from trusted load balancers on the TCP listeners
+	UnixSocketMode    string           `mapstructure:"unix_socket_mode"` // octal permissions of unix:// listeners, e.g. "0660"
+}
+
+func (s ServerCfg) ParsedReadTimeout() time.Duration {
+	return parseDuration(s.ReadTimeout, 15*time.Second)
+}
+
+func (s ServerCfg) ParsedReadHeaderTimeout() time.Duration {
+	return parseDuration(s.ReadHeaderTimeout, 10*time.Second)
+}
+
+func (s ServerCfg) ParsedWriteTimeout() time.Duration {
+	return parseDuration(s.WriteTimeout, 30*time.Second)
+}
+
+func (s ServerCfg) ParsedIdleTimeout() time.Duration {
+	return parseDuration(s.IdleTimeout, 120*time.Second)
+}
+
+func (s ServerCfg) ParsedShutdownTimeout() time.Duration {
+	return parseDuration(s.ShutdownTimeout, 10*time.Second)
+}
+
+// ErrorsCfg controls the body format of responses the gateway generates
+// itself (404 no route, 502 bad gateway, 503 no capacity, 504 timeout).
+type ErrorsCfg struct {
+	Format    string            `mapstructure:"format"`    // text | json | problem | html
+	Templates map[string]string `mapstructure:"templates"` // status code → HTML template file (format html)
+}
+
+// AdminCfg controls the admin API listener (metrics and runtime state).
+type AdminCfg struct {
+	Enabled    bool   `mapstructure:"enabled"`
+	ListenAddr string `mapstructure:"listen_addr"`
+}
+
 // RateLimitCfg controls per-IP token-bucket rate limiting.
 type RateLimitCfg struct {
 	Enabled bool    `mapstructure:"enabled"`
@@ -50,6 +123,37 @@ type RateLimitCfg struct {
 	Burst   int     `mapstructure:"burst"` // maximum burst size
 }
 
+// CompressionCfg controls response compression at the gateway.
+type CompressionCfg struct {
+	Enabled      bool     `mapstructure:"enabled"`
+	Encodings    []string `mapstructure:"encodings"`     // gzip, br, zstd in preference order; default zstd, br, gzip
+	MinSize      int      `mapstructure:"min_size"`      // smaller bodies are sent uncompressed
+	ContentTypes []string `mapstructure:"content_types"` // media types to compress; "text/*" matches every subtype
+}
+
+// CacheCfg sizes the shared response cache used by routes with cache
+// enabled.
+type CacheCfg struct {
+	MaxSize      int64 `mapstructure:"max_size"`       // bytes; least recently used entries are evicted beyond it
+	MaxEntrySize int64 `mapstructure:"max_entry_size"` // larger responses are not stored
+}
+
+// ParsedMaxSize returns MaxSize, defaulting to 64 MiB.
+func (c CacheCfg) ParsedMaxSize() int64 {
+	if c.MaxSize <= 0 {
+		return 64 << 20
+	}
+	return c.MaxSize
+}
+
+// ParsedMaxEntrySize returns MaxEntrySize, defaulting to 1 MiB.
+func (c CacheCfg) ParsedMaxEntrySize() int64 {
+	if c.MaxEntrySize <= 0 {
+		return 1 << 20
+	}
+	return c.MaxEntrySize
+}
+
 // AuthCfg controls JWT Bearer-token authentication.
 type AuthCfg struct {
 	Enabled bool     `mapstructure:"enabled"`
@@ -59,12 +163,24 @@ type AuthCfg struct {
 
 // Config is the top-level gateway configuration.
 type Config struct {
-	ListenAddr  string         `mapstructure:"listen_addr"`
-	Strategy    string         `mapstructure:"strategy"` // round_robin | weighted_round_robin | least_connections
-	Backends    []BackendCfg   `mapstructure:"backends"`
-	HealthCheck HealthCheckCfg `mapstructure:"health_check"`
-	RateLimit   RateLimitCfg   `mapstructure:"rate_limit"`
-	Auth        AuthCfg        `mapstructure:"auth"`
+	ListenAddr  string           `mapstructure:"listen_addr"`
+	Strategy    string           `mapstructure:"strategy"` // round_robin | weighted_round_robin | least_connections
+	Backends    []BackendCfg     `mapstructure:"backends"` // the implicit "default" pool
+	Pools       []PoolCfg        `mapstructure:"pools"`
+	Routes      []RouteCfg       `mapstructure:"routes"`
+	TCP         []TCPListenerCfg `mapstructure:"tcp"` // layer-4 listeners
+	UDP         []UDPListenerCfg `mapstructure:"udp"` // layer-4 datagram listeners
+	Headers     HeadersCfg       `mapstructure:"headers"`
+	Server      ServerCfg        `mapstructure:"server"`
+	Transport   TransportCfg     `mapstructure:"transport"`
+	HealthCheck HealthCheckCfg   `mapstructure:"health_check"`
+	Queue       QueueCfg         `mapstructure:"queue"`
+	RateLimit   RateLimitCfg     `mapstructure:"rate_limit"`
+	Auth        AuthCfg          `mapstructure:"auth"`
+	Compression CompressionCfg   `mapstructure:"compression"`
+	Cache       CacheCfg         `mapstructure:"cache"`
+	Errors      ErrorsCfg        `mapstructure:"errors"`
+	Admin       AdminCfg         `mapstructure:"admin"`
 }
 
 // Default returns a sensible single-backend config for development / Phase 1.
@@ -79,8 +195,30 @@ func Default() Config {
 			Timeout:  "2s",
 			Path:     "/healthz",
 		},
+		Server: ServerCfg{
+			ReadTimeout:       "15s",
+			ReadHeaderTimeout: "10s",
+			WriteTimeout:      "30s",
+			IdleTimeout:       "120s",
+			ShutdownTimeout:   "10s",
+		},
+		Transport: TransportCfg{
+			DialTimeout:           "5s",
+			KeepAlive:             "30s",
+			TLSHandshakeTimeout:   "10s",
+			ResponseHeaderTimeout: "30s",
+			ExpectContinueTimeout: "1s",
+			IdleConnTimeout:       "90s",
+		},
+		Queue:     QueueCfg{MaxSize: 100, Timeout: "5s"},
 		RateLimit: RateLimitCfg{Enabled: false, RPS: 100, Burst: 200},
 		Auth:      AuthCfg{Enabled: false},
+		Errors:    ErrorsCfg{Format: "text"},
+		Admin:     AdminCfg{Enabled: false, ListenAddr: ":9091"},
+		Compression: CompressionCfg{
+			Enabled: false,
+			MinSize: 1024,
+		},
 	}
 }
 
@@ -111,6 +249,8 @@ func Watch(v *viper.Viper, onChange func(Config)) {
 		}
 		slog.Info("config hot-reloaded",
 			"backends", len(cfg.Backends),
+			"pools", len(cfg.Pools),
+			"routes", len(cfg.Routes),
 			"strategy", cfg.Strategy,
 		)
 		onChange(cfg)
@@ -128,10 +268,30 @@ func newViper(path string) *viper.Viper {
 	v.SetDefault("health_check.interval", "10s")
 	v.SetDefault("health_check.timeout", "2s")
 	v.SetDefault("health_check.path", "/healthz")
+	v.SetDefault("server.read_timeout", "15s")
+	v.SetDefault("server.read_header_timeout", "10s")
+	v.SetDefault("server.write_timeout", "30s")
+	v.SetDefault("server.idle_timeout", "120s")
+	v.SetDefault("server.shutdown_timeout", "10s")
+	v.SetDefault("transport.dial_timeout", "5s")
+	v.SetDefault("transport.keep_alive", "30s")
+	v.SetDefault("transport.tls_handshake_timeout", "10s")
+	v.SetDefault("transport.response_header_timeout", "30s")
+	v.SetDefault("transport.expect_continue_timeout", "1s")
+	v.SetDefault("transport.idle_conn_timeout", "90s")
+	v.SetDefault("transport.max_idle_conns", 100)
+	v.SetDefault("transport.max_idle_conns_per_host", 10)
+	v.SetDefault("queue.max_size", 100)
+	v.SetDefault("queue.timeout", "5s")
 	v.SetDefault("rate_limit.enabled", false)
 	v.SetDefault("rate_limit.rps", 100.0)
 	v.SetDefault("rate_limit.burst", 200)
 	v.SetDefault("auth.enabled", false)
+	v.SetDefault("compression.enabled", false)
+	v.SetDefault("compression.min_size", 1024)
+	v.SetDefault("errors.format", "text")
+	v.SetDefault("admin.enabled", false)
+	v.SetDefault("admin.listen_addr", ":9091")
 
 	return v
 }
@@ -141,16 +301,49 @@ func unmarshal(v *viper.Viper) (Config, error) {
 	if err := v.Unmarshal(&cfg); err != nil {
 		return Config{}, fmt.Errorf("config: parsing: %w", err)
 	}
-	if len(cfg.Backends) == 0 {
-		return Config{}, fmt.Errorf("config: at least one backend must be defined")
+	if err := normalizeBackends("backend", cfg.Backends); err != nil {
+		return Config{}, err
 	}
-	for i, b := range cfg.Backends {
-		if b.URL == "" {
-			return Config{}, fmt.Errorf("config: backend[%d] has empty url", i)
-		}
-		if b.Weight <= 0 {
-			cfg.Backends[i].Weight = 1
+	if err := validatePools(&cfg); err != nil {
+		return Config{}, err
+	}
+	if cfg.Queue.MaxSize < 0 {
+		return Config{}, fmt.Errorf("config: queue.max_size must not be negative")
+	}
+	if v := cfg.Queue.Timeout; v != "" {
+		if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+			return Config{}, fmt.Errorf("config: queue.timeout %q must be a positive duration", v)
 		}
 	}
+	if err := validateCompression(cfg.Compression); err != nil {
+		return Config{}, err
+	}
+	if err := validateServer(cfg.Server); err != nil {
+		return Config{}, err
+	}
+	if err := validateTCP(&cfg); err != nil {
+		return Config{}, err
+	}
+	if err := validateUDP(&cfg); err != nil {
+		return Config{}, err
+	}
+	if err := validateUnix(&cfg); err != nil {
+		return Config{}, err
+	}
 	return cfg, nil
 }
+
+// validateCompression checks the encodings and minimum size.
+func validateCompression(c CompressionCfg) error {
+	for _, e := range c.Encodings {
+		switch e {
+		case "gzip", "br", "zstd":
+		default:
+			return fmt.Errorf("config: compression encoding %q must be gzip, br or zstd", e)
+		}
+	}
+	if c.MinSize < 0 {
+		return fmt.Errorf("config: compression.min_size must not be negative")
+	}
+	return nil
+}
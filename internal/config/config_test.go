@@ -1,7 +1,9 @@
 package config_test
 
 import (
+	"net"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -93,6 +95,1085 @@ backends:
 	assert.Equal(t, 1, cfg.Backends[0].Weight)
 }
 
+func TestLoad_MaxConnsAndQueue(t *testing.T) {
+	yaml := `
+backends:
+  - url: "http://backend:8080"
+    max_conns: 25
+queue:
+  max_size: 50
+  timeout: "3s"
+`
+	f := writeTempYAML(t, yaml)
+	cfg, _, err := config.Load(f)
+	require.NoError(t, err)
+	assert.Equal(t, 25, cfg.Backends[0].MaxConns)
+	assert.Equal(t, 50, cfg.Queue.MaxSize)
+	assert.Equal(t, 3*time.Second, cfg.Queue.ParsedTimeout())
+}
+
+func TestLoad_QueueDefaults(t *testing.T) {
+	f := writeTempYAML(t, "backends:\n  - url: \"http://backend:8080\"\n")
+	cfg, _, err := config.Load(f)
+	require.NoError(t, err)
+	assert.Equal(t, 0, cfg.Backends[0].MaxConns, "max_conns defaults to unlimited")
+	assert.Equal(t, 100, cfg.Queue.MaxSize)
+	assert.Equal(t, 5*time.Second, cfg.Queue.ParsedTimeout())
+	assert.False(t, cfg.Admin.Enabled)
+	assert.Equal(t, ":9091", cfg.Admin.ListenAddr)
+}
+
+func TestLoad_InvalidQueueTimeout_ReturnsError(t *testing.T) {
+	for _, timeout := range []string{"5", "soon", "0s", "-1s"} {
+		t.Run(timeout, func(t *testing.T) {
+			yaml := "backends:\n  - url: \"http://backend:8080\"\nqueue:\n  timeout: \"" + timeout + "\"\n"
+			_, _, err := config.Load(writeTempYAML(t, yaml))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoad_NegativeMaxConns_ReturnsError(t *testing.T) {
+	yaml := `
+backends:
+  - url: "http://backend:8080"
+    max_conns: -1
+`
+	f := writeTempYAML(t, yaml)
+	_, _, err := config.Load(f)
+	assert.Error(t, err)
+}
+
+func TestLoad_PoolsAndRoutes(t *testing.T) {
+	yaml := `
+strategy: "least_connections"
+transport:
+  dial_timeout: "2s"
+  response_header_timeout: "10s"
+pools:
+  - name: api
+    strategy: round_robin
+    backends:
+      - url: "http://api:8080"
+    transport:
+      response_header_timeout: "3s"
+  - name: static
+    backends:
+      - url: "http://static:8080"
+routes:
+  - name: api
+    path_prefix: /api
+    pool: api
+    timeout: "20s"
+  - path_prefix: /
+    pool: static
+`
+	f := writeTempYAML(t, yaml)
+	cfg, _, err := config.Load(f)
+	require.NoError(t, err)
+
+	pools := cfg.ResolvedPools()
+	require.Len(t, pools, 2, "no top-level backends means no default pool")
+	assert.Equal(t, "round_robin", pools[0].Strategy)
+	assert.Equal(t, 3*time.Second, pools[0].Transport.ParsedResponseHeaderTimeout(), "pool override wins")
+	assert.Equal(t, 2*time.Second, pools[0].Transport.ParsedDialTimeout(), "unset fields inherit the global value")
+	assert.Equal(t, "least_connections", pools[1].Strategy, "strategy inherits the top-level value")
+	assert.Equal(t, 10*time.Second, pools[1].Transport.ParsedResponseHeaderTimeout())
+
+	routes := cfg.ResolvedRoutes()
+	require.Len(t, routes, 2)
+	assert.Equal(t, 20*time.Second, routes[0].ParsedTimeout(0))
+	assert.Equal(t, "/", routes[1].Name, "unnamed routes are named after their prefix")
+}
+
+func TestLoad_DefaultRouteFromTopLevelBackends(t *testing.T) {
+	f := writeTempYAML(t, "backends:\n  - url: \"http://backend:8080\"\n")
+	cfg, _, err := config.Load(f)
+	require.NoError(t, err)
+
+	routes := cfg.ResolvedRoutes()
+	require.Len(t, routes, 1)
+	assert.Equal(t, "/", routes[0].PathPrefix)
+	assert.Equal(t, config.DefaultPool, routes[0].Pool)
+	require.Len(t, cfg.ResolvedPools(), 1)
+	assert.Equal(t, config.DefaultPool, cfg.ResolvedPools()[0].Name)
+}
+
+func TestLoad_RouteUnknownPool_ReturnsError(t *testing.T) {
+	yaml := `
+backends:
+  - url: "http://backend:8080"
+routes:
+  - path_prefix: /api
+    pool: missing
+`
+	f := writeTempYAML(t, yaml)
+	_, _, err := config.Load(f)
+	assert.Error(t, err)
+}
+
+func TestLoad_RouteSplit(t *testing.T) {
+	yaml := `
+pools:
+  - name: stable
+    backends:
+      - url: "http://stable:8080"
+  - name: canary
+    backends:
+      - url: "http://canary:8080"
+routes:
+  - path_prefix: /
+    split:
+      - pool: stable
+        weight: 95
+      - pool: canary
+        weight: 5
+    overrides:
+      - header: X-Canary
+        value: "true"
+        pool: canary
+    hash_key: "cookie:session"
+`
+	f := writeTempYAML(t, yaml)
+	cfg, _, err := config.Load(f)
+	require.NoError(t, err)
+
+	routes := cfg.ResolvedRoutes()
+	require.Len(t, routes, 1)
+	assert.Empty(t, routes[0].Pool, "split routes do not default to the default pool")
+	assert.Equal(t, "stable", routes[0].PrimaryPool())
+	require.Len(t, routes[0].Split, 2)
+	assert.Equal(t, 5, routes[0].Split[1].Weight)
+	assert.Equal(t, "X-Canary", routes[0].Overrides[0].Header)
+	assert.Equal(t, "cookie:session", routes[0].HashKey)
+}
+
+func TestLoad_InvalidRouteSplit_ReturnsError(t *testing.T) {
+	base := `
+pools:
+  - name: stable
+    backends:
+      - url: "http://stable:8080"
+routes:
+  - path_prefix: /
+`
+	cases := map[string]string{
+		"unknown pool":      "    split:\n      - pool: missing\n        weight: 1\n",
+		"zero total":        "    split:\n      - pool: stable\n        weight: 0\n",
+		"pool and split":    "    pool: stable\n    split:\n      - pool: stable\n        weight: 1\n",
+		"bad hash key":      "    split:\n      - pool: stable\n        weight: 1\n    hash_key: user\n",
+		"override no match": "    split:\n      - pool: stable\n        weight: 1\n    overrides:\n      - pool: stable\n",
+		"hash no split":     "    pool: stable\n    hash_key: ip\n",
+	}
+	for name, route := range cases {
+		t.Run(name, func(t *testing.T) {
+			f := writeTempYAML(t, base+route)
+			_, _, err := config.Load(f)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoad_RouteCanary(t *testing.T) {
+	yaml := `
+pools:
+  - name: stable
+    backends:
+      - url: "http://stable:8080"
+  - name: canary
+    backends:
+      - url: "http://canary:8080"
+routes:
+  - name: web
+    path_prefix: /
+    split:
+      - pool: stable
+        weight: 100
+      - pool: canary
+        weight: 0
+    canary:
+      steps: [1, 5, 25, 100]
+      step_interval: "10m"
+      max_p99_ratio: 1.2
+`
+	f := writeTempYAML(t, yaml)
+	cfg, _, err := config.Load(f)
+	require.NoError(t, err)
+
+	c := cfg.ResolvedRoutes()[0].Canary
+	assert.True(t, c.Enabled())
+	assert.Equal(t, []int{1, 5, 25, 100}, c.Steps)
+	assert.Equal(t, 10*time.Minute, c.ParsedStepInterval())
+	assert.Equal(t, 10*time.Second, c.ParsedCheckInterval())
+	assert.Equal(t, 100, c.ParsedMinRequests())
+	assert.Equal(t, 0.01, c.ParsedMaxErrorRateDelta())
+	assert.Equal(t, 1.2, c.ParsedMaxP99Ratio())
+}
+
+func TestLoad_InvalidRouteCanary_ReturnsError(t *testing.T) {
+	base := `
+pools:
+  - name: stable
+    backends:
+      - url: "http://stable:8080"
+  - name: canary
+    backends:
+      - url: "http://canary:8080"
+routes:
+`
+	split := "    split:\n      - pool: stable\n        weight: 100\n      - pool: canary\n        weight: 0\n"
+	cases := map[string]string{
+		"unnamed route":    "  - path_prefix: /\n" + split + "    canary:\n      steps: [5, 100]\n",
+		"decreasing steps": "  - name: web\n" + split + "    canary:\n      steps: [50, 5]\n",
+		"step above 100":   "  - name: web\n" + split + "    canary:\n      steps: [5, 150]\n",
+		"single pool":      "  - name: web\n    split:\n      - pool: canary\n        weight: 1\n    canary:\n      steps: [5]\n",
+		"unitless step":    "  - name: web\n" + split + "    canary:\n      steps: [5, 100]\n      step_interval: \"10\"\n",
+		"zero step":        "  - name: web\n" + split + "    canary:\n      steps: [5, 100]\n      step_interval: 0s\n",
+		"malformed check":  "  - name: web\n" + split + "    canary:\n      steps: [5, 100]\n      check_interval: often\n",
+		"negative check":   "  - name: web\n" + split + "    canary:\n      steps: [5, 100]\n      check_interval: -10s\n",
+	}
+	for name, route := range cases {
+		t.Run(name, func(t *testing.T) {
+			f := writeTempYAML(t, base+route)
+			_, _, err := config.Load(f)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoad_RouteMirror(t *testing.T) {
+	yaml := `
+backends:
+  - url: "http://app:8080"
+pools:
+  - name: shadow
+    backends:
+      - url: "http://app-v2:8080"
+routes:
+  - path_prefix: /
+    mirror:
+      pool: shadow
+      percent: 12.5
+`
+	f := writeTempYAML(t, yaml)
+	cfg, _, err := config.Load(f)
+	require.NoError(t, err)
+
+	m := cfg.ResolvedRoutes()[0].Mirror
+	assert.True(t, m.Enabled())
+	assert.Equal(t, 12.5, m.Percent)
+	assert.Equal(t, int64(1<<20), m.ParsedMaxBodyBytes())
+	assert.Equal(t, 5*time.Second, m.ParsedTimeout())
+	assert.Equal(t, 100, m.ParsedMaxInflight())
+
+	bad := strings.Replace(yaml, "percent: 12.5", "percent: 0", 1)
+	_, _, err = config.Load(writeTempYAML(t, bad))
+	assert.Error(t, err, "percent is required")
+
+	bad = strings.Replace(yaml, "pool: shadow", "pool: missing", 1)
+	_, _, err = config.Load(writeTempYAML(t, bad))
+	assert.Error(t, err)
+
+	for _, timeout := range []string{"5", "soon", "0s", "-1s"} {
+		bad = strings.Replace(yaml, "percent: 12.5", "percent: 12.5\n      timeout: "+timeout, 1)
+		_, _, err = config.Load(writeTempYAML(t, bad))
+		assert.ErrorContains(t, err, "mirror timeout", timeout)
+	}
+}
+
+func TestLoad_HeaderRules(t *testing.T) {
+	yaml := `
+backends:
+  - url: "http://app:8080"
+headers:
+  strip_response: [Server, X-Powered-By]
+routes:
+  - path_prefix: /
+    request_headers:
+      set:
+        X-Client-IP: "${client_ip}"
+      remove: [Cookie]
+      rename:
+        X-Old: X-New
+    response_headers:
+      add:
+        X-Route: "${route}"
+`
+	f := writeTempYAML(t, yaml)
+	cfg, _, err := config.Load(f)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"Server", "X-Powered-By"}, cfg.Headers.StripResponse)
+	r := cfg.ResolvedRoutes()[0]
+	// Map keys come back lower-cased; header names are case-insensitive.
+	assert.Equal(t, "${client_ip}", r.RequestHeaders.Set["x-client-ip"])
+	assert.Equal(t, []string{"Cookie"}, r.RequestHeaders.Remove)
+	assert.Equal(t, "X-New", r.RequestHeaders.Rename["x-old"])
+	assert.False(t, r.ResponseHeaders.Empty())
+}
+
+func TestLoad_PathRewrite(t *testing.T) {
+	yaml := `
+backends:
+  - url: "http://app:8080/v2"
+routes:
+  - path_prefix: /users
+    strip_prefix: true
+    add_prefix: /people
+    rewrite:
+      - regex: '^/old/(.*)$'
+        replace: '/new/$1'
+`
+	f := writeTempYAML(t, yaml)
+	cfg, _, err := config.Load(f)
+	require.NoError(t, err)
+
+	r := cfg.ResolvedRoutes()[0]
+	assert.True(t, r.StripPrefix)
+	assert.Equal(t, "/people", r.AddPrefix)
+	assert.Equal(t, []config.RewriteCfg{{Regex: "^/old/(.*)$", Replace: "/new/$1"}}, r.Rewrite)
+}
+
+func TestLoad_InvalidPathRewrite_ReturnsError(t *testing.T) {
+	for name, route := range map[string]string{
+		"bad regex":       "rewrite:\n      - regex: '('\n        replace: /",
+		"relative prefix": "add_prefix: people",
+	} {
+		t.Run(name, func(t *testing.T) {
+			yaml := "backends:\n  - url: \"http://app:8080\"\nroutes:\n  - path_prefix: /\n    " + route + "\n"
+			_, _, err := config.Load(writeTempYAML(t, yaml))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoad_RedirectAndRespondRoutes(t *testing.T) {
+	yaml := `
+backends:
+  - url: "http://app:8080"
+routes:
+  - path_prefix: /
+  - path_prefix: /blog
+    redirect:
+      regex: '^/blog/(\d+)$'
+      to: "https://blog.example/p/${1}"
+      status: 301
+  - path_prefix: /robots.txt
+    respond:
+      headers:
+        Cache-Control: "max-age=3600"
+      body: "User-agent: *"
+`
+	f := writeTempYAML(t, yaml)
+	cfg, _, err := config.Load(f)
+	require.NoError(t, err)
+
+	routes := cfg.ResolvedRoutes()
+	assert.True(t, routes[0].Proxies())
+	assert.False(t, routes[1].Proxies())
+	assert.Empty(t, routes[1].Pool, "no default pool for redirects")
+	assert.Equal(t, 301, routes[1].Redirect.ParsedStatus())
+	assert.Equal(t, 200, routes[2].Respond.ParsedStatus())
+	assert.Equal(t, "max-age=3600", routes[2].Respond.Headers["cache-control"])
+}
+
+func TestLoad_InvalidActionRoute_ReturnsError(t *testing.T) {
+	for name, route := range map[string]string{
+		"both actions":    "redirect: {to: /x}\n    respond: {body: x}",
+		"with pool":       "pool: default\n    redirect: {to: /x}",
+		"with mirror":     "respond: {body: x}\n    mirror: {pool: default, percent: 5}",
+		"redirect status": "redirect: {to: /x, status: 200}",
+		"bad regex":       "redirect: {to: /x, regex: '('}",
+		"respond status":  "respond: {status: 99}",
+		"body and file":   "respond: {body: x, body_file: /tmp/x}",
+		"with cache":      "respond: {body: x}\n    cache: {enabled: true}",
+		"with coalesce":   "respond: {body: x}\n    coalesce: {enabled: true}",
+		"with hedge":      "respond: {body: x}\n    hedge: {enabled: true}",
+	} {
+		t.Run(name, func(t *testing.T) {
+			yaml := "backends:\n  - url: \"http://app:8080\"\nroutes:\n  - path_prefix: /x\n    " + route + "\n"
+			_, _, err := config.Load(writeTempYAML(t, yaml))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoad_Compression(t *testing.T) {
+	f := writeTempYAML(t, "backends:\n  - url: \"http://app:8080\"\ncompression:\n  enabled: true\n  encodings: [br, gzip]\n")
+	cfg, _, err := config.Load(f)
+	require.NoError(t, err)
+	assert.True(t, cfg.Compression.Enabled)
+	assert.Equal(t, []string{"br", "gzip"}, cfg.Compression.Encodings)
+	assert.Equal(t, 1024, cfg.Compression.MinSize)
+
+	f = writeTempYAML(t, "backends:\n  - url: \"http://app:8080\"\ncompression:\n  encodings: [deflate]\n")
+	_, _, err = config.Load(f)
+	assert.Error(t, err)
+}
+
+func TestLoad_Cache(t *testing.T) {
+	yaml := `
+backends:
+  - url: "http://app:8080"
+cache:
+  max_size: 1048576
+routes:
+  - path_prefix: /api
+    cache:
+      enabled: true
+      key_headers: [X-Tenant]
+      key_claims: [sub]
+`
+	cfg, _, err := config.Load(writeTempYAML(t, yaml))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1<<20), cfg.Cache.ParsedMaxSize())
+	assert.Equal(t, int64(1<<20), cfg.Cache.ParsedMaxEntrySize(), "defaults to 1 MiB")
+	rc := cfg.Routes[0].Cache
+	assert.True(t, rc.Enabled)
+	assert.Equal(t, []string{"X-Tenant"}, rc.KeyHeaders)
+	assert.Equal(t, []string{"sub"}, rc.KeyClaims)
+
+	assert.Equal(t, int64(64<<20), config.CacheCfg{}.ParsedMaxSize())
+}
+
+func TestLoad_Coalesce(t *testing.T) {
+	yaml := `
+backends:
+  - url: "http://app:8080"
+routes:
+  - path_prefix: /api
+    coalesce:
+      enabled: true
+      key_headers: [Accept-Language]
+      max_waiters: 50
+      allow_authorization: true
+`
+	cfg, _, err := config.Load(writeTempYAML(t, yaml))
+	require.NoError(t, err)
+	c := cfg.Routes[0].Coalesce
+	assert.True(t, c.Enabled)
+	assert.Equal(t, []string{"Accept-Language"}, c.KeyHeaders)
+	assert.Equal(t, 50, c.ParsedMaxWaiters())
+	assert.Equal(t, int64(1<<20), c.ParsedMaxBodyBytes(), "defaults to 1 MiB")
+	assert.True(t, c.AllowAuthorization)
+}
+
+func TestLoad_Hedge(t *testing.T) {
+	yaml := `
+backends:
+  - url: "http://app:8080"
+routes:
+  - path_prefix: /fixed
+    hedge:
+      enabled: true
+      delay: 50ms
+      budget_percent: 5
+  - path_prefix: /p99
+    hedge:
+      enabled: true
+      delay: p99
+`
+	cfg, _, err := config.Load(writeTempYAML(t, yaml))
+	require.NoError(t, err)
+	fixed, pct := cfg.Routes[0].Hedge.ParsedDelay()
+	assert.Equal(t, 50*time.Millisecond, fixed)
+	assert.Zero(t, pct)
+	assert.Equal(t, 5.0, cfg.Routes[0].Hedge.ParsedBudgetPercent())
+	_, pct = cfg.Routes[1].Hedge.ParsedDelay()
+	assert.Equal(t, 99.0, pct)
+	assert.Equal(t, 10.0, cfg.Routes[1].Hedge.ParsedBudgetPercent())
+	assert.Equal(t, 10*time.Millisecond, cfg.Routes[1].Hedge.ParsedMinDelay())
+
+	for name, hedge := range map[string]string{
+		"bad delay":      "{enabled: true, delay: soon}",
+		"bad percentile": "{enabled: true, delay: p100}",
+		"zero delay":     "{enabled: true, delay: 0s}",
+		"budget":         "{enabled: true, budget_percent: 150}",
+	} {
+		t.Run(name, func(t *testing.T) {
+			yaml := "backends:\n  - url: \"http://app:8080\"\nroutes:\n  - path_prefix: /x\n    hedge: " + hedge + "\n"
+			_, _, err := config.Load(writeTempYAML(t, yaml))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoad_Upgrade(t *testing.T) {
+	yaml := `
+backends:
+  - url: "http://app:8080"
+routes:
+  - path_prefix: /ws
+    upgrade:
+      protocols: [websocket]
+      idle_timeout: 30s
+      max_lifetime: 1h
+  - path_prefix: /
+`
+	cfg, _, err := config.Load(writeTempYAML(t, yaml))
+	require.NoError(t, err)
+	up := cfg.Routes[0].Upgrade
+	assert.Equal(t, []string{"websocket"}, up.Protocols)
+	assert.Equal(t, 30*time.Second, up.ParsedIdleTimeout())
+	assert.Equal(t, time.Hour, up.ParsedMaxLifetime())
+	assert.Empty(t, cfg.Routes[1].Upgrade.Protocols)
+	assert.Equal(t, 10*time.Minute, cfg.Routes[1].Upgrade.ParsedIdleTimeout())
+	assert.Zero(t, cfg.Routes[1].Upgrade.ParsedMaxLifetime())
+
+	for name, route := range map[string]string{
+		"bad idle":     "upgrade: {protocols: [websocket], idle_timeout: forever}",
+		"bad lifetime": "upgrade: {protocols: [websocket], max_lifetime: -1s}",
+		"action route": "upgrade: {protocols: [websocket]}\n    respond: {body: x}",
+	} {
+		t.Run(name, func(t *testing.T) {
+			yaml := "backends:\n  - url: \"http://app:8080\"\nroutes:\n  - path_prefix: /x\n    " + route + "\n"
+			_, _, err := config.Load(writeTempYAML(t, yaml))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoad_ServerListeners(t *testing.T) {
+	yaml := `
+listen_addr: ":8080"
+backends:
+  - url: "http://app:8080"
+server:
+  h2c: true
+  tls:
+    cert_file: /etc/flux/cert.pem
+    key_file: /etc/flux/key.pem
+    listen_addr: ":8443"
+  http2:
+    max_concurrent_streams: 500
+    max_receive_buffer_per_stream: 262144
+  http3:
+    enabled: true
+`
+	cfg, _, err := config.Load(writeTempYAML(t, yaml))
+	require.NoError(t, err)
+	s := cfg.Server
+	assert.True(t, s.H2C)
+	assert.True(t, s.TLS.Enabled())
+	assert.Equal(t, ":8443", cfg.TLSListenAddr())
+	assert.Equal(t, ":8443", cfg.HTTP3ListenAddr(), "HTTP/3 defaults to the TLS port")
+	assert.Equal(t, 500, s.HTTP2.MaxConcurrentStreams)
+	assert.Equal(t, 262144, s.HTTP2.MaxReceiveBufferPerStream)
+	assert.Zero(t, s.HTTP2.MaxReceiveBufferPerConnection)
+	assert.Equal(t, 24*time.Hour, s.HTTP3.ParsedAltSvcAge())
+
+	plain, _, err := config.Load(writeTempYAML(t, "listen_addr: \":8080\"\nbackends:\n  - url: \"http://app:8080\"\n"))
+	require.NoError(t, err)
+	assert.False(t, plain.Server.TLS.Enabled())
+	assert.Equal(t, ":8080", plain.TLSListenAddr())
+
+	for name, server := range map[string]string{
+		"cert without key":    "tls: {cert_file: c.pem}",
+		"tls addr no cert":    "tls: {listen_addr: \":8443\"}",
+		"http3 without tls":   "http3: {enabled: true}",
+		"bad alt-svc age":     "tls: {cert_file: c.pem, key_file: k.pem}\n  http3: {enabled: true, alt_svc_max_age: soon}",
+		"negative streams":    "http2: {max_concurrent_streams: -1}",
+		"window over 31 bits": "http2: {max_receive_buffer_per_connection: 4294967296}",
+	} {
+		t.Run(name, func(t *testing.T) {
+			yaml := "backends:\n  - url: \"http://app:8080\"\nserver:\n  " + server + "\n"
+			_, _, err := config.Load(writeTempYAML(t, yaml))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoad_TCPListeners(t *testing.T) {
+	yaml := `
+strategy: least_connections
+pools:
+  - name: pg
+    backends:
+      - url: "tcp://replica-1:5432"
+        max_conns: 100
+      - url: "tcp://replica-2:5432"
+tcp:
+  - listen_addr: ":5432"
+    pool: pg
+    idle_timeout: 30m
+    max_conns: 500
+`
+	cfg, _, err := config.Load(writeTempYAML(t, yaml))
+	require.NoError(t, err)
+	require.Len(t, cfg.TCP, 1)
+	l := cfg.TCP[0]
+	assert.Equal(t, ":5432", l.Name, "unnamed listeners are named after their address")
+	assert.Equal(t, 30*time.Minute, l.ParsedIdleTimeout())
+	assert.Equal(t, 5*time.Second, l.ParsedConnectTimeout())
+	assert.Equal(t, 500, l.MaxConns)
+	pools := cfg.ResolvedPools()
+	require.Len(t, pools, 1)
+	assert.True(t, pools[0].IsTCP())
+	assert.Equal(t, "tcp", pools[0].HealthCheck.Type, "tcp pools default to connect checks")
+	assert.Empty(t, cfg.ResolvedRoutes(), "a tcp-only config has no HTTP routes")
+
+	pg := "pools:\n  - name: pg\n    backends: [{url: \"tcp://db:5432\"}]\n"
+	web := "  - name: web\n    backends: [{url: \"http://web:80\"}]\n"
+	for name, yaml := range map[string]string{
+		"no listen_addr":   pg + "tcp:\n  - pool: pg\n",
+		"unknown pool":     pg + "tcp:\n  - listen_addr: \":5432\"\n    pool: nope\n",
+		"http pool":        pg + web + "tcp:\n  - listen_addr: \":5432\"\n    pool: web\n",
+		"duplicate name":   pg + "tcp:\n  - {listen_addr: \":5432\", pool: pg}\n  - {name: \":5432\", listen_addr: \":5433\", pool: pg}\n",
+		"negative conns":   pg + "tcp:\n  - {listen_addr: \":5432\", pool: pg, max_conns: -1}\n",
+		"bad idle timeout": pg + "tcp:\n  - {listen_addr: \":5432\", pool: pg, idle_timeout: later}\n",
+		"missing port":     "pools:\n  - name: pg\n    backends: [{url: \"tcp://db\"}]\ntcp:\n  - {listen_addr: \":5432\", pool: pg}\n",
+		"mixed backends":   "pools:\n  - name: pg\n    backends: [{url: \"tcp://db:5432\"}, {url: \"http://db:80\"}]\ntcp:\n  - {listen_addr: \":5432\", pool: pg}\n",
+		"http health":      "pools:\n  - name: pg\n    health_check: {type: http}\n    backends: [{url: \"tcp://db:5432\"}]\ntcp:\n  - {listen_addr: \":5432\", pool: pg}\n",
+		"route to tcp":     pg + web + "routes:\n  - path_prefix: /\n    pool: pg\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := config.Load(writeTempYAML(t, yaml))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoad_TCPListenerSNI(t *testing.T) {
+	yaml := `
+pools:
+  - name: api
+    backends: [{url: "tcp://api:443"}]
+  - name: web
+    backends: [{url: "tcp://web:443"}]
+tcp:
+  - listen_addr: ":443"
+    hello_timeout: 2s
+    sni:
+      - hosts: [api.example.com, "*.api.example.com"]
+        pool: api
+      - hosts: ["*.example.com"]
+        pool: web
+`
+	cfg, _, err := config.Load(writeTempYAML(t, yaml))
+	require.NoError(t, err)
+	l := cfg.TCP[0]
+	assert.Empty(t, l.Pool, "with sni routes the default pool is optional")
+	assert.Equal(t, 2*time.Second, l.ParsedHelloTimeout())
+	assert.Equal(t, []string{"api", "web"}, l.Pools())
+	assert.Equal(t, 5*time.Second, config.TCPListenerCfg{}.ParsedHelloTimeout())
+
+	pools := "pools:\n  - name: api\n    backends: [{url: \"tcp://api:443\"}]\n  - name: www\n    backends: [{url: \"http://www:80\"}]\n"
+	for name, sni := range map[string]string{
+		"unknown pool":   "- hosts: [a.example.com]\n        pool: nope",
+		"http pool":      "- hosts: [a.example.com]\n        pool: www",
+		"no hosts":       "- pool: api",
+		"bare wildcard":  "- hosts: [\"*\"]\n        pool: api",
+		"inner wildcard": "- hosts: [\"api.*.com\"]\n        pool: api",
+		"duplicate host": "- hosts: [a.example.com, A.example.com.]\n        pool: api",
+	} {
+		t.Run(name, func(t *testing.T) {
+			yaml := pools + "tcp:\n  - listen_addr: \":443\"\n    sni:\n      " + sni + "\n"
+			_, _, err := config.Load(writeTempYAML(t, yaml))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoad_UDPListeners(t *testing.T) {
+	yaml := `
+pools:
+  - name: dns
+    health_check:
+      send_hex: "abcd01000001000000000000076578616d706c6503636f6d0000010001"
+      expect: "example"
+    backends:
+      - url: "udp://ns1:53"
+      - url: "udp://ns2:53"
+  - name: syslog
+    health_check: {type: tcp, port: 601}
+    backends: [{url: "udp://log:514"}]
+udp:
+  - listen_addr: ":53"
+    pool: dns
+    affinity: hash
+  - name: syslog
+    listen_addr: ":514"
+    pool: syslog
+    idle_timeout: 2m
+    max_sessions: 10000
+`
+	cfg, _, err := config.Load(writeTempYAML(t, yaml))
+	require.NoError(t, err)
+	require.Len(t, cfg.UDP, 2)
+	dns, syslog := cfg.UDP[0], cfg.UDP[1]
+	assert.Equal(t, ":53", dns.Name, "unnamed listeners are named after their address")
+	assert.Equal(t, "hash", dns.Affinity)
+	assert.Equal(t, 30*time.Second, dns.ParsedIdleTimeout())
+	assert.Equal(t, 2*time.Minute, syslog.ParsedIdleTimeout())
+	assert.Equal(t, 10000, syslog.MaxSessions)
+
+	pools := cfg.ResolvedPools()
+	require.Len(t, pools, 2)
+	assert.True(t, pools[0].IsUDP())
+	assert.Equal(t, "udp", pools[0].HealthCheck.Type, "udp pools default to udp probes")
+	payload, err := pools[0].HealthCheck.Payload()
+	require.NoError(t, err)
+	assert.Len(t, payload, 29)
+	assert.Equal(t, "tcp", pools[1].HealthCheck.Type)
+	assert.Equal(t, 601, pools[1].HealthCheck.Port)
+	assert.Empty(t, cfg.ResolvedRoutes(), "a udp-only config has no HTTP routes")
+
+	dnsPool := "pools:\n  - name: dns\n    backends: [{url: \"udp://ns:53\"}]\n"
+	web := "  - name: web\n    backends: [{url: \"http://web:80\"}]\n"
+	for name, yaml := range map[string]string{
+		"no listen_addr":   dnsPool + "udp:\n  - pool: dns\n",
+		"http pool":        dnsPool + web + "udp:\n  - listen_addr: \":53\"\n    pool: web\n",
+		"duplicate name":   dnsPool + "udp:\n  - {listen_addr: \":53\", pool: dns}\n  - {name: \":53\", listen_addr: \":54\", pool: dns}\n",
+		"bad affinity":     dnsPool + "udp:\n  - {listen_addr: \":53\", pool: dns, affinity: sticky}\n",
+		"negative limit":   dnsPool + "udp:\n  - {listen_addr: \":53\", pool: dns, max_sessions: -1}\n",
+		"bad idle timeout": dnsPool + "udp:\n  - {listen_addr: \":53\", pool: dns, idle_timeout: later}\n",
+		"missing port":     "pools:\n  - name: dns\n    backends: [{url: \"udp://ns\"}]\nudp:\n  - {listen_addr: \":53\", pool: dns}\n",
+		"mixed backends":   "pools:\n  - name: dns\n    backends: [{url: \"udp://ns:53\"}, {url: \"tcp://ns:53\"}]\nudp:\n  - {listen_addr: \":53\", pool: dns}\n",
+		"http health":      "pools:\n  - name: dns\n    health_check: {type: http}\n    backends: [{url: \"udp://ns:53\"}]\nudp:\n  - {listen_addr: \":53\", pool: dns}\n",
+		"bad send_hex":     "pools:\n  - name: dns\n    health_check: {send_hex: zz}\n    backends: [{url: \"udp://ns:53\"}]\nudp:\n  - {listen_addr: \":53\", pool: dns}\n",
+		"send and hex":     "pools:\n  - name: dns\n    health_check: {send: a, send_hex: \"61\"}\n    backends: [{url: \"udp://ns:53\"}]\nudp:\n  - {listen_addr: \":53\", pool: dns}\n",
+		"bad probe port":   "pools:\n  - name: dns\n    health_check: {port: 70000}\n    backends: [{url: \"udp://ns:53\"}]\nudp:\n  - {listen_addr: \":53\", pool: dns}\n",
+		"udp on http pool": "pools:\n  - name: web\n    health_check: {type: udp}\n    backends: [{url: \"http://web:80\"}]\nroutes:\n  - path_prefix: /\n    pool: web\n",
+		"tcp listener":     dnsPool + "tcp:\n  - {listen_addr: \":53\", pool: dns}\n",
+		"route to udp":     dnsPool + web + "routes:\n  - path_prefix: /\n    pool: dns\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := config.Load(writeTempYAML(t, yaml))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoad_ProxyProtocol(t *testing.T) {
+	yaml := `
+server:
+  proxy_protocol:
+    trusted_cidrs: ["10.0.0.0/8", "192.0.2.10", "2001:db8::/32"]
+pools:
+  - name: web
+    send_proxy_protocol: v2
+    backends: [{url: "http://web:80"}]
+  - name: pg
+    send_proxy_protocol: v1
+    backends: [{url: "tcp://db:5432"}]
+routes:
+  - path_prefix: /
+    pool: web
+tcp:
+  - listen_addr: ":5432"
+    pool: pg
+    proxy_protocol: {trusted_cidrs: ["10.1.0.0/16"], timeout: 2s}
+`
+	cfg, _, err := config.Load(writeTempYAML(t, yaml))
+	require.NoError(t, err)
+	trusted, err := cfg.Server.ProxyProtocol.ParsedTrustedCIDRs()
+	require.NoError(t, err)
+	require.Len(t, trusted, 3)
+	assert.True(t, trusted[0].Contains(net.ParseIP("10.2.3.4")))
+	assert.True(t, trusted[1].Contains(net.ParseIP("192.0.2.10")))
+	assert.False(t, trusted[1].Contains(net.ParseIP("192.0.2.11")), "a single IP trusts only itself")
+	assert.Equal(t, 5*time.Second, cfg.Server.ProxyProtocol.ParsedTimeout())
+	assert.True(t, cfg.TCP[0].ProxyProtocol.Enabled())
+	assert.Equal(t, 2*time.Second, cfg.TCP[0].ProxyProtocol.ParsedTimeout())
+	pools := cfg.ResolvedPools()
+	assert.Equal(t, 2, pools[0].ParsedSendProxyProtocol())
+	assert.Equal(t, 1, pools[1].ParsedSendProxyProtocol())
+
+	web := "backends: [{url: \"http://web:80\"}]\n"
+	for name, yaml := range map[string]string{
+		"bad cidr":     web + "server:\n  proxy_protocol: {trusted_cidrs: [10.0.0.0/33]}\n",
+		"bad ip":       web + "server:\n  proxy_protocol: {trusted_cidrs: [lb.internal]}\n",
+		"bad timeout":  web + "server:\n  proxy_protocol: {trusted_cidrs: [10.0.0.1], timeout: soon}\n",
+		"tcp bad cidr": "pools:\n  - name: pg\n    backends: [{url: \"tcp://db:5432\"}]\ntcp:\n  - {listen_addr: \":5432\", pool: pg, proxy_protocol: {trusted_cidrs: [x]}}\n",
+		"bad version":  web + "pools:\n  - name: app\n    send_proxy_protocol: v3\n    backends: [{url: \"http://app:80\"}]\n",
+		"h2c pool":     web + "pools:\n  - name: app\n    send_proxy_protocol: v2\n    transport: {protocol: h2c}\n    backends: [{url: \"http://app:80\"}]\n",
+		"udp pool":     "pools:\n  - name: dns\n    send_proxy_protocol: v2\n    backends: [{url: \"udp://ns:53\"}]\nudp:\n  - {listen_addr: \":53\", pool: dns}\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := config.Load(writeTempYAML(t, yaml))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoad_UnixSockets(t *testing.T) {
+	yaml := `
+listen_addr: "unix:///run/golb/http.sock"
+server:
+  unix_socket_mode: "0660"
+backends:
+  - url: "unix:///run/app.sock"
+pools:
+  - name: grpc
+    transport: {protocol: h2c}
+    health_check: {type: grpc}
+    backends: [{url: "unix:///run/cart.sock"}, {url: "http://cart:9000"}]
+routes:
+  - path_prefix: /
+`
+	cfg, _, err := config.Load(writeTempYAML(t, yaml))
+	require.NoError(t, err)
+	path, ok := config.UnixSocketPath(cfg.ListenAddr)
+	assert.True(t, ok)
+	assert.Equal(t, "/run/golb/http.sock", path)
+	assert.Equal(t, os.FileMode(0o660), cfg.Server.ParsedUnixSocketMode())
+	_, ok = config.UnixSocketPath(":8080")
+	assert.False(t, ok)
+	assert.Zero(t, config.Default().Server.ParsedUnixSocketMode(), "the umask decides by default")
+
+	web := "backends: [{url: \"http://web:80\"}]\n"
+	for name, yaml := range map[string]string{
+		"relative backend":  "backends: [{url: \"unix://app.sock\"}]\n",
+		"backend host":      "backends: [{url: \"unix://host/run/app.sock\"}]\n",
+		"h2 backend":        web + "pools:\n  - name: app\n    transport: {protocol: h2}\n    backends: [{url: \"unix:///run/app.sock\"}]\n",
+		"probe port":        web + "pools:\n  - name: app\n    health_check: {type: tcp, port: 8081}\n    backends: [{url: \"unix:///run/app.sock\"}]\n",
+		"relative listener": web + "listen_addr: \"unix://http.sock\"\n",
+		"bad mode":          web + "listen_addr: \"unix:///run/http.sock\"\nserver: {unix_socket_mode: \"rw\"}\n",
+		"mode too wide":     web + "listen_addr: \"unix:///run/http.sock\"\nserver: {unix_socket_mode: \"1777\"}\n",
+		"mode without unix": web + "server: {unix_socket_mode: \"0660\"}\n",
+		"http3 on socket":   web + "listen_addr: \"unix:///run/http.sock\"\nserver: {tls: {cert_file: c.pem, key_file: k.pem}, http3: {enabled: true}}\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := config.Load(writeTempYAML(t, yaml))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoad_Discovery(t *testing.T) {
+	yaml := `
+pools:
+  - name: api
+    strategy: weighted_round_robin
+    discovery:
+      type: dns
+      max_conns: 50
+      dns: {name: api.internal, port: 8080, server: "10.0.0.53:53"}
+  - name: pg
+    discovery:
+      type: dns
+      scheme: tcp
+      dns: {name: _pg._tcp.db.internal, record: srv, interval: 10s, timeout: 2s}
+  - name: web
+    discovery:
+      type: file
+      file: {files: ["/etc/golb/targets/*.json", "/etc/golb/web.yaml"]}
+  - name: cart
+    discovery:
+      type: consul
+      consul: {service: cart, tags: [v2], datacenter: eu}
+  - name: shop
+    discovery:
+      type: kubernetes
+      kubernetes: {service: shop, namespace: prod, port: http, zone: eu-west-1a}
+routes:
+  - path_prefix: /
+    pool: api
+tcp:
+  - listen_addr: ":5432"
+    pool: pg
+`
+	cfg, _, err := config.Load(writeTempYAML(t, yaml))
+	require.NoError(t, err)
+	pools := cfg.ResolvedPools()
+	require.Len(t, pools, 5)
+	api, pg, web, cart, shop := pools[0], pools[1], pools[2], pools[3], pools[4]
+	assert.True(t, api.Discovery.Enabled())
+	assert.Equal(t, "http", api.Discovery.ParsedScheme())
+	assert.Equal(t, config.DNSRecordIP, api.Discovery.DNS.ParsedRecord())
+	assert.Zero(t, api.Discovery.DNS.ParsedInterval(), "the TTL decides by default")
+	assert.Equal(t, 5*time.Second, api.Discovery.DNS.ParsedTimeout())
+	assert.Equal(t, 50, api.Discovery.MaxConns)
+	assert.True(t, pg.IsTCP())
+	assert.Equal(t, config.DNSRecordSRV, pg.Discovery.DNS.ParsedRecord())
+	assert.Equal(t, 10*time.Second, pg.Discovery.DNS.ParsedInterval())
+	assert.Equal(t, 2*time.Second, pg.Discovery.DNS.ParsedTimeout())
+	assert.Equal(t, []string{"/etc/golb/targets/*.json", "/etc/golb/web.yaml"}, web.Discovery.File.Files)
+	assert.Equal(t, 5*time.Minute, web.Discovery.File.ParsedInterval())
+	assert.Equal(t, "http://127.0.0.1:8500", cart.Discovery.Consul.ParsedAddress())
+	assert.Equal(t, "weight", cart.Discovery.Consul.ParsedWeightMeta())
+	assert.Equal(t, 5*time.Minute, cart.Discovery.Consul.ParsedWait())
+	assert.Equal(t, []string{"v2"}, cart.Discovery.Consul.Tags)
+	assert.Equal(t, config.KubernetesDiscoveryCfg{Service: "shop", Namespace: "prod", Port: "http", Zone: "eu-west-1a"}, shop.Discovery.Kubernetes)
+	assert.False(t, config.Default().ResolvedPools()[0].Discovery.Enabled())
+
+	pool := "pools:\n  - name: api\n    discovery:\n"
+	for name, yaml := range map[string]string{
+		"no discovery or backends": "pools:\n  - name: api\n",
+		"both":                     pool + "      type: dns\n      dns: {name: api, port: 80}\n    backends: [{url: \"http://web:80\"}]\n",
+		"unknown type":             pool + "      type: zookeeper\n",
+		"bad scheme":               pool + "      type: dns\n      scheme: ftp\n      dns: {name: api, port: 80}\n",
+		"negative max_conns":       pool + "      type: dns\n      max_conns: -1\n      dns: {name: api, port: 80}\n",
+		"no name":                  pool + "      type: dns\n      dns: {port: 80}\n",
+		"no port":                  pool + "      type: dns\n      dns: {name: api}\n",
+		"srv with port":            pool + "      type: dns\n      dns: {name: _http._tcp.api, record: srv, port: 80}\n",
+		"bad record":               pool + "      type: dns\n      dns: {name: api, record: mx, port: 80}\n",
+		"bad server":               pool + "      type: dns\n      dns: {name: api, port: 80, server: \"10.0.0.53\"}\n",
+		"bad interval":             pool + "      type: dns\n      dns: {name: api, port: 80, interval: 0s}\n",
+		"bad timeout":              pool + "      type: dns\n      dns: {name: api, port: 80, timeout: soon}\n",
+		"scheme for h2c":           pool + "      type: dns\n      scheme: https\n      dns: {name: api, port: 80}\n    transport: {protocol: h2c}\n",
+		"no files":                 pool + "      type: file\n",
+		"bad pattern":              pool + "      type: file\n      file: {files: [\"targets/[.json\"]}\n",
+		"pattern in dir":           pool + "      type: file\n      file: {files: [\"targets/*/web.json\"]}\n",
+		"bad extension":            pool + "      type: file\n      file: {files: [\"targets/*\"]}\n",
+		"bad file interval":        pool + "      type: file\n      file: {files: [web.json], interval: -1s}\n",
+		"no service":               pool + "      type: consul\n",
+		"bad address":              pool + "      type: consul\n      consul: {service: api, address: \"consul:8500\"}\n",
+		"empty tag":                pool + "      type: consul\n      consul: {service: api, tags: [\"\"]}\n",
+		"wait too long":            pool + "      type: consul\n      consul: {service: api, wait: 1h}\n",
+		"no kubernetes service":    pool + "      type: kubernetes\n",
+		"bad kubernetes port":      pool + "      type: kubernetes\n      kubernetes: {service: api, port: \"70000\"}\n",
+		"context, no kubeconfig":   pool + "      type: kubernetes\n      kubernetes: {service: api, context: kind}\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := config.Load(writeTempYAML(t, yaml))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoad_UpstreamProtocolAndHealthCheck(t *testing.T) {
+	yaml := `
+backends:
+  - url: "http://app:8080"
+health_check:
+  enabled: true
+  path: /ready
+  service: shop
+pools:
+  - name: grpc
+    transport:
+      protocol: h2c
+    health_check:
+      type: grpc
+    backends:
+      - url: "http://cart:9000"
+  - name: secure
+    transport:
+      protocol: h2
+      ca_file: /etc/flux/ca.pem
+    backends:
+      - url: "https://secure:443"
+routes:
+  - path_prefix: /
+`
+	cfg, _, err := config.Load(writeTempYAML(t, yaml))
+	require.NoError(t, err)
+	pools := cfg.ResolvedPools()
+	require.Len(t, pools, 3)
+	assert.Equal(t, config.ProtocolHTTP1, pools[0].Transport.ParsedProtocol())
+	assert.Equal(t, config.PoolHealthCheckCfg{Path: "/ready", Service: "shop"}, pools[0].HealthCheck)
+	assert.Equal(t, config.ProtocolH2C, pools[1].Transport.ParsedProtocol())
+	assert.Equal(t, config.PoolHealthCheckCfg{Type: "grpc", Path: "/ready", Service: "shop"}, pools[1].HealthCheck)
+	assert.Equal(t, config.ProtocolH2, pools[2].Transport.ParsedProtocol())
+	assert.Equal(t, "/etc/flux/ca.pem", pools[2].Transport.CAFile)
+
+	for name, pool := range map[string]string{
+		"unknown protocol": "transport: {protocol: spdy}\n    backends: [{url: \"http://b:80\"}]",
+		"h2 over http":     "transport: {protocol: h2}\n    backends: [{url: \"http://b:80\"}]",
+		"h2c over https":   "transport: {protocol: h2c}\n    backends: [{url: \"https://b:443\"}]",
+		"bad health type":  "health_check: {type: icmp}\n    backends: [{url: \"http://b:80\"}]",
+	} {
+		t.Run(name, func(t *testing.T) {
+			yaml := "pools:\n  - name: p\n    " + pool + "\nroutes:\n  - path_prefix: /\n    pool: p\n"
+			_, _, err := config.Load(writeTempYAML(t, yaml))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoad_ServerAndTransportDefaults(t *testing.T) {
+	f := writeTempYAML(t, "backends:\n  - url: \"http://backend:8080\"\n")
+	cfg, _, err := config.Load(f)
+	require.NoError(t, err)
+
+	assert.Equal(t, 15*time.Second, cfg.Server.ParsedReadTimeout())
+	assert.Equal(t, 30*time.Second, cfg.Server.ParsedWriteTimeout())
+	assert.Equal(t, 120*time.Second, cfg.Server.ParsedIdleTimeout())
+	assert.Equal(t, 10*time.Second, cfg.Server.ParsedShutdownTimeout())
+	assert.Equal(t, 5*time.Second, cfg.Transport.ParsedDialTimeout())
+	assert.Equal(t, 30*time.Second, cfg.Transport.ParsedResponseHeaderTimeout())
+	assert.Equal(t, time.Duration(0), cfg.Transport.ParsedRequestTimeout(), "no overall limit by default")
+	assert.Equal(t, 100, cfg.Transport.ParsedMaxIdleConns())
+	assert.Equal(t, 10, cfg.Transport.ParsedMaxIdleConnsPerHost())
+	assert.Equal(t, 0, cfg.Transport.ParsedMaxConnsPerHost(), "unlimited by default")
+}
+
+func TestLoad_PoolTransportOverridesToZero(t *testing.T) {
+	yaml := `
+transport:
+  max_idle_conns: 50
+  max_conns_per_host: 20
+pools:
+  - name: capped
+    backends:
+      - url: "http://a:8080"
+  - name: unlimited
+    transport:
+      max_idle_conns: 0
+      max_conns_per_host: 0
+    backends:
+      - url: "http://b:8080"
+routes:
+  - path_prefix: /a
+    pool: capped
+  - path_prefix: /b
+    pool: unlimited
+`
+	cfg, _, err := config.Load(writeTempYAML(t, yaml))
+	require.NoError(t, err)
+	pools := cfg.ResolvedPools()
+	require.Len(t, pools, 2)
+
+	assert.Equal(t, 50, pools[0].Transport.ParsedMaxIdleConns())
+	assert.Equal(t, 20, pools[0].Transport.ParsedMaxConnsPerHost())
+	assert.Equal(t, 0, pools[1].Transport.ParsedMaxIdleConns(), "an explicit 0 overrides the top-level value")
+	assert.Equal(t, 0, pools[1].Transport.ParsedMaxConnsPerHost())
+	assert.Equal(t, 10, pools[1].Transport.ParsedMaxIdleConnsPerHost(), "unset fields still inherit")
+}
+
+func TestLoad_InvalidDurations_ReturnsError(t *testing.T) {
+	web := "backends:\n  - url: \"http://app:8080\"\n"
+	for name, yaml := range map[string]string{
+		"route timeout without unit": web + "routes:\n  - path_prefix: /\n    timeout: 30\n",
+		"negative route timeout":     web + "routes:\n  - path_prefix: /\n    timeout: -1s\n",
+		"dial timeout typo":          web + "transport: {dial_timeout: 5sec}\n",
+		"negative request timeout":   web + "transport: {request_timeout: -30s}\n",
+		"pool keep-alive":            "pools:\n  - name: p\n    transport: {keep_alive: often}\n    backends: [{url: \"http://b:80\"}]\nroutes:\n  - path_prefix: /\n    pool: p\n",
+		"read timeout":               web + "server: {read_timeout: 15}\n",
+		"negative shutdown timeout":  web + "server: {shutdown_timeout: -10s}\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := config.Load(writeTempYAML(t, yaml))
+			assert.Error(t, err)
+		})
+	}
+
+	_, _, err := config.Load(writeTempYAML(t, web+"transport: {request_timeout: 0s}\nserver: {write_timeout: 0s}\n"))
+	assert.NoError(t, err, "0s disables a timeout")
+}
+
+func TestLoad_ErrorsFormatAndTemplates(t *testing.T) {
+	yaml := `
+backends:
+  - url: "http://backend:8080"
+errors:
+  format: html
+  templates:
+    "503": /etc/flux/503.html
+`
+	f := writeTempYAML(t, yaml)
+	cfg, _, err := config.Load(f)
+	require.NoError(t, err)
+	assert.Equal(t, "html", cfg.Errors.Format)
+	assert.Equal(t, "/etc/flux/503.html", cfg.Errors.Templates["503"])
+}
+
+func TestTransportCfg_ZeroDisablesTimeout(t *testing.T) {
+	tc := config.TransportCfg{ResponseHeaderTimeout: "0s"}
+	assert.Equal(t, time.Duration(0), tc.ParsedResponseHeaderTimeout())
+}
+
 func TestHealthCheckCfg_ParsedInterval(t *testing.T) {
 	cases := []struct {
 		input    string
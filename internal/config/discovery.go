@@ -0,0 +1,277 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DiscoveryCfg feeds a pool's backends from a service registry instead of a
+// static backends list. Discovered addresses become backend URLs with Scheme;
+// as the set changes, backends that remain keep their health and counters.
+type DiscoveryCfg struct {
+	Type       string                 `mapstructure:"type"`      // "dns", "file", "consul" or "kubernetes"
+	Scheme     string                 `mapstructure:"scheme"`    // of the backend URLs: http (default), https, tcp or udp
+	MaxConns   int                    `mapstructure:"max_conns"` // per discovered backend; 0 means unlimited
+	DNS        DNSDiscoveryCfg        `mapstructure:"dns"`
+	File       FileDiscoveryCfg       `mapstructure:"file"`
+	Consul     ConsulDiscoveryCfg     `mapstructure:"consul"`
+	Kubernetes KubernetesDiscoveryCfg `mapstructure:"kubernetes"`
+}
+
+// Enabled reports whether the pool's backends are discovered.
+func (d DiscoveryCfg) Enabled() bool { return d.Type != "" }
+
+// ParsedScheme returns the scheme of discovered backend URLs, defaulting to
+// http.
+func (d DiscoveryCfg) ParsedScheme() string {
+	if d.Scheme == "" {
+		return "http"
+	}
+	return d.Scheme
+}
+
+// DNS record types for DNSDiscoveryCfg.Record.
+const (
+	DNSRecordIP   = "ip"   // A and AAAA
+	DNSRecordA    = "a"    // A only
+	DNSRecordAAAA = "aaaa" // AAAA only
+	DNSRecordSRV  = "srv"  // SRV, whose targets are resolved to A and AAAA
+)
+
+// DNSDiscoveryCfg resolves Name periodically. Each address found is a
+// backend. SRV records bring their own ports, weights and priorities: the
+// weight becomes the backend's, and lower priorities form failover tiers
+// that serve only when every backend of the tiers before them is down.
+type DNSDiscoveryCfg struct {
+	Name     string `mapstructure:"name"`     // e.g. api.internal or _http._tcp.api.internal
+	Record   string `mapstructure:"record"`   // ip (default), a, aaaa or srv
+	Port     int    `mapstructure:"port"`     // port of A and AAAA addresses
+	Interval string `mapstructure:"interval"` // empty follows the records' TTL
+	Server   string `mapstructure:"server"`   // DNS server host:port; defaults to the first in /etc/resolv.conf
+	Timeout  string `mapstructure:"timeout"`  // per query; default 5s
+}
+
+// ParsedRecord returns the record type to query, defaulting to ip.
+func (d DNSDiscoveryCfg) ParsedRecord() string {
+	if d.Record == "" {
+		return DNSRecordIP
+	}
+	return d.Record
+}
+
+// ParsedInterval returns the fixed re-resolution interval, or 0 to follow
+// the records' TTL.
+func (d DNSDiscoveryCfg) ParsedInterval() time.Duration {
+	return parseDuration(d.Interval, 0)
+}
+
+// ParsedTimeout returns the per-query timeout, defaulting to 5s.
+func (d DNSDiscoveryCfg) ParsedTimeout() time.Duration {
+	return parseDuration(d.Timeout, 5*time.Second)
+}
+
+// FileDiscoveryCfg reads targets from files in the Prometheus file_sd format:
+// lists of target groups, each with host:port targets and the labels, and
+// optionally weight, they share. The files are re-read when they change.
+type FileDiscoveryCfg struct {
+	Files    []string `mapstructure:"files"`    // paths or glob patterns ending in .json, .yaml or .yml
+	Interval string   `mapstructure:"interval"` // also re-read this often, in case events are missed; default 5m
+}
+
+// ParsedInterval returns how often the files are re-read without a change
+// event, defaulting to 5m.
+func (f FileDiscoveryCfg) ParsedInterval() time.Duration {
+	return parseDuration(f.Interval, 5*time.Minute)
+}
+
+// ConsulDiscoveryCfg reads the passing instances of Service from a
+// Consul-compatible health API, with blocking queries that return as soon as
+// the set changes. An instance's weight comes from its WeightMeta metadata,
+// else from its passing weight.
+type ConsulDiscoveryCfg struct {
+	Address    string   `mapstructure:"address"`     // API base URL; default http://127.0.0.1:8500
+	Service    string   `mapstructure:"service"`     // service name
+	Tags       []string `mapstructure:"tags"`        // instances must carry every tag
+	Datacenter string   `mapstructure:"datacenter"`  // default: the agent's own
+	Token      string   `mapstructure:"token"`       // ACL token, sent as X-Consul-Token
+	WeightMeta string   `mapstructure:"weight_meta"` // metadata key holding the weight; default "weight"
+	Wait       string   `mapstructure:"wait"`        // how long a blocking query may wait; default 5m
+}
+
+// ParsedAddress returns the API base URL, defaulting to the local agent.
+func (c ConsulDiscoveryCfg) ParsedAddress() string {
+	if c.Address == "" {
+		return "http://127.0.0.1:8500"
+	}
+	return strings.TrimSuffix(c.Address, "/")
+}
+
+// ParsedWeightMeta returns the metadata key holding instance weights,
+// defaulting to "weight".
+func (c ConsulDiscoveryCfg) ParsedWeightMeta() string {
+	if c.WeightMeta == "" {
+		return "weight"
+	}
+	return c.WeightMeta
+}
+
+// ParsedWait returns how long a blocking query may wait, defaulting to 5m.
+func (c ConsulDiscoveryCfg) ParsedWait() time.Duration {
+	return parseDuration(c.Wait, 5*time.Minute)
+}
+
+// KubernetesDiscoveryCfg watches the EndpointSlices of a Service through the
+// Kubernetes API, with the pod's service account when running in a cluster,
+// or with a kubeconfig file. Ready endpoints serve; terminating ones that
+// still serve are drained, taking requests only when no ready endpoint is
+// left. With Zone set, endpoints hinted for that zone serve first.
+type KubernetesDiscoveryCfg struct {
+	Service    string `mapstructure:"service"`    // Service name
+	Namespace  string `mapstructure:"namespace"`  // default: the gateway's own in a cluster, else the kubeconfig context's, else "default"
+	Port       string `mapstructure:"port"`       // port name or number; may be omitted when the Service has one port
+	Zone       string `mapstructure:"zone"`       // the gateway's zone, for topology-aware routing
+	Kubeconfig string `mapstructure:"kubeconfig"` // kubeconfig file; default: in-cluster service account
+	Context    string `mapstructure:"context"`    // kubeconfig context; default: its current-context
+}
+
+// scheme returns the scheme of the pool's backends: the discovery scheme, or
+// that of its first static backend.
+func (p PoolCfg) scheme() string {
+	if p.Discovery.Enabled() {
+		return p.Discovery.ParsedScheme()
+	}
+	if len(p.Backends) == 0 {
+		return ""
+	}
+	return backendScheme(p.Backends[0].URL)
+}
+
+// validateDiscovery checks a pool's discovery settings.
+func validateDiscovery(p PoolCfg) error {
+	d := p.Discovery
+	if !d.Enabled() {
+		return nil
+	}
+	if len(p.Backends) > 0 {
+		return fmt.Errorf("config: pool %q sets both backends and discovery", p.Name)
+	}
+	switch d.ParsedScheme() {
+	case "http", "https", "tcp", "udp":
+	default:
+		return fmt.Errorf("config: pool %q discovery scheme %q must be http, https, tcp or udp", p.Name, d.Scheme)
+	}
+	if d.MaxConns < 0 {
+		return fmt.Errorf("config: pool %q discovery max_conns must not be negative", p.Name)
+	}
+	switch d.Type {
+	case "dns":
+		return validateDNSDiscovery(p.Name, d.DNS)
+	case "file":
+		return validateFileDiscovery(p.Name, d.File)
+	case "consul":
+		return validateConsulDiscovery(p.Name, d.Consul)
+	case "kubernetes":
+		return validateKubernetesDiscovery(p.Name, d.Kubernetes)
+	default:
+		return fmt.Errorf("config: pool %q discovery type %q must be dns, file, consul or kubernetes", p.Name, d.Type)
+	}
+}
+
+func validateDNSDiscovery(pool string, d DNSDiscoveryCfg) error {
+	if d.Name == "" {
+		return fmt.Errorf("config: pool %q dns discovery needs a name", pool)
+	}
+	switch d.ParsedRecord() {
+	case DNSRecordSRV:
+		if d.Port != 0 {
+			return fmt.Errorf("config: pool %q dns discovery takes ports from srv records, not port", pool)
+		}
+	case DNSRecordIP, DNSRecordA, DNSRecordAAAA:
+		if d.Port <= 0 || d.Port > 65535 {
+			return fmt.Errorf("config: pool %q dns discovery needs a port in 1–65535", pool)
+		}
+	default:
+		return fmt.Errorf("config: pool %q dns discovery record %q must be ip, a, aaaa or srv", pool, d.Record)
+	}
+	if d.Server != "" {
+		if _, port, err := net.SplitHostPort(d.Server); err != nil || port == "" {
+			return fmt.Errorf("config: pool %q dns discovery server %q must be host:port", pool, d.Server)
+		}
+	}
+	for _, f := range []struct{ name, value string }{{"interval", d.Interval}, {"timeout", d.Timeout}} {
+		if f.value == "" {
+			continue
+		}
+		if dur, err := time.ParseDuration(f.value); err != nil || dur <= 0 {
+			return fmt.Errorf("config: pool %q dns discovery %s %q must be a positive duration", pool, f.name, f.value)
+		}
+	}
+	return nil
+}
+
+func validateFileDiscovery(pool string, f FileDiscoveryCfg) error {
+	if len(f.Files) == 0 {
+		return fmt.Errorf("config: pool %q file discovery needs files", pool)
+	}
+	for _, pattern := range f.Files {
+		// Like Prometheus, only the file name may be a pattern, so that the
+		// directories to watch are known.
+		if _, err := filepath.Match(pattern, ""); err != nil || hasMeta(filepath.Dir(pattern)) {
+			return fmt.Errorf("config: pool %q file discovery pattern %q is invalid; only the file name may hold wildcards", pool, pattern)
+		}
+		switch filepath.Ext(pattern) {
+		case ".json", ".yaml", ".yml":
+		default:
+			return fmt.Errorf("config: pool %q file discovery pattern %q must end in .json, .yaml or .yml", pool, pattern)
+		}
+	}
+	if f.Interval != "" {
+		if dur, err := time.ParseDuration(f.Interval); err != nil || dur <= 0 {
+			return fmt.Errorf("config: pool %q file discovery interval %q must be a positive duration", pool, f.Interval)
+		}
+	}
+	return nil
+}
+
+func validateConsulDiscovery(pool string, c ConsulDiscoveryCfg) error {
+	if c.Service == "" {
+		return fmt.Errorf("config: pool %q consul discovery needs a service", pool)
+	}
+	if u, err := url.Parse(c.ParsedAddress()); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("config: pool %q consul discovery address %q must be an http:// or https:// URL", pool, c.Address)
+	}
+	for _, tag := range c.Tags {
+		if tag == "" {
+			return fmt.Errorf("config: pool %q consul discovery has an empty tag", pool)
+		}
+	}
+	if c.Wait != "" {
+		// Consul caps blocking queries at 10 minutes.
+		if dur, err := time.ParseDuration(c.Wait); err != nil || dur <= 0 || dur > 10*time.Minute {
+			return fmt.Errorf("config: pool %q consul discovery wait %q must be a duration between 0 and 10m", pool, c.Wait)
+		}
+	}
+	return nil
+}
+
+func validateKubernetesDiscovery(pool string, k KubernetesDiscoveryCfg) error {
+	if k.Service == "" {
+		return fmt.Errorf("config: pool %q kubernetes discovery needs a service", pool)
+	}
+	if n, err := strconv.Atoi(k.Port); err == nil && (n < 1 || n > 65535) {
+		return fmt.Errorf("config: pool %q kubernetes discovery port %d must be in 1–65535", pool, n)
+	}
+	if k.Context != "" && k.Kubeconfig == "" {
+		return fmt.Errorf("config: pool %q kubernetes discovery context needs a kubeconfig", pool)
+	}
+	return nil
+}
+
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
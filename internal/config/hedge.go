@@ -0,0 +1,73 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HedgeCfg sends a second copy of a slow GET or HEAD request to another
+// backend of the pool. The first response wins; the other attempt is
+// cancelled.
+type HedgeCfg struct {
+	Enabled       bool    `mapstructure:"enabled"`
+	Delay         string  `mapstructure:"delay"`          // duration, or a latency percentile of the pool such as "p95"
+	MinDelay      string  `mapstructure:"min_delay"`      // floor for percentile delays
+	BudgetPercent float64 `mapstructure:"budget_percent"` // hedges allowed as a share of requests, 0–100
+}
+
+// ParsedDelay returns the fixed hedging delay, or the percentile (0–100) of
+// the pool's observed latency to use instead. Delay defaults to "p95".
+func (h HedgeCfg) ParsedDelay() (fixed time.Duration, percentile float64) {
+	d := h.Delay
+	if d == "" {
+		d = "p95"
+	}
+	if p, ok := strings.CutPrefix(d, "p"); ok {
+		if v, err := strconv.ParseFloat(p, 64); err == nil && v > 0 && v < 100 {
+			return 0, v
+		}
+		return 0, 95
+	}
+	return parseDuration(d, 0), 0
+}
+
+// ParsedMinDelay returns MinDelay, defaulting to 10ms.
+func (h HedgeCfg) ParsedMinDelay() time.Duration {
+	return parseDuration(h.MinDelay, 10*time.Millisecond)
+}
+
+// ParsedBudgetPercent returns BudgetPercent, defaulting to 10.
+func (h HedgeCfg) ParsedBudgetPercent() float64 {
+	if h.BudgetPercent <= 0 {
+		return 10
+	}
+	return h.BudgetPercent
+}
+
+// validateHedge checks a route's hedging delay and budget.
+func validateHedge(i int, r RouteCfg) error {
+	h := r.Hedge
+	if !h.Enabled {
+		return nil
+	}
+	if p, ok := strings.CutPrefix(h.Delay, "p"); ok {
+		if v, err := strconv.ParseFloat(p, 64); err != nil || v <= 0 || v >= 100 {
+			return fmt.Errorf("config: route[%d] hedge delay %q must be a duration or a percentile such as p95", i, h.Delay)
+		}
+	} else if h.Delay != "" {
+		if d, err := time.ParseDuration(h.Delay); err != nil || d <= 0 {
+			return fmt.Errorf("config: route[%d] hedge delay %q must be a duration or a percentile such as p95", i, h.Delay)
+		}
+	}
+	if h.MinDelay != "" {
+		if _, err := time.ParseDuration(h.MinDelay); err != nil {
+			return fmt.Errorf("config: route[%d] hedge min_delay: %w", i, err)
+		}
+	}
+	if h.BudgetPercent < 0 || h.BudgetPercent > 100 {
+		return fmt.Errorf("config: route[%d] hedge budget_percent must be in 0–100", i)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// ServerTLSCfg serves the gateway over TLS, with HTTP/2 negotiated through
+// ALPN. With ListenAddr set, TLS is served there in addition to the
+// plaintext listen_addr; otherwise listen_addr itself serves TLS.
+type ServerTLSCfg struct {
+	CertFile   string `mapstructure:"cert_file"`
+	KeyFile    string `mapstructure:"key_file"`
+	ListenAddr string `mapstructure:"listen_addr"`
+}
+
+// Enabled reports whether the gateway serves TLS.
+func (t ServerTLSCfg) Enabled() bool { return t.CertFile != "" }
+
+// HTTP2Cfg tunes HTTP/2 (and HTTP/3) connections from clients. Zero values
+// keep the Go defaults: 250 streams and 1 MiB windows per stream and per
+// connection.
+type HTTP2Cfg struct {
+	MaxConcurrentStreams          int `mapstructure:"max_concurrent_streams"`            // streams a client may open at once on one connection
+	MaxReceiveBufferPerStream     int `mapstructure:"max_receive_buffer_per_stream"`     // stream flow-control window, bytes
+	MaxReceiveBufferPerConnection int `mapstructure:"max_receive_buffer_per_connection"` // connection flow-control window, bytes
+}
+
+// HTTP3Cfg enables an HTTP/3 listener on UDP. It needs TLS; HTTPS responses
+// advertise it to clients with an Alt-Svc header.
+type HTTP3Cfg struct {
+	Enabled    bool   `mapstructure:"enabled"`
+	ListenAddr string `mapstructure:"listen_addr"` // UDP address; defaults to the TLS listener's address
+	AltSvcAge  string `mapstructure:"alt_svc_max_age"`
+}
+
+// ParsedAltSvcAge returns how long clients may remember the Alt-Svc
+// advertisement, defaulting to 24h.
+func (h HTTP3Cfg) ParsedAltSvcAge() time.Duration {
+	return parseDuration(h.AltSvcAge, 24*time.Hour)
+}
+
+// TLSListenAddr returns the address the TLS listener binds.
+func (c Config) TLSListenAddr() string {
+	if c.Server.TLS.ListenAddr != "" {
+		return c.Server.TLS.ListenAddr
+	}
+	return c.ListenAddr
+}
+
+// HTTP3ListenAddr returns the UDP address of the HTTP/3 listener.
+func (c Config) HTTP3ListenAddr() string {
+	if c.Server.HTTP3.ListenAddr != "" {
+		return c.Server.HTTP3.ListenAddr
+	}
+	return c.TLSListenAddr()
+}
+
+// validateServer checks the listener settings.
+func validateServer(s ServerCfg) error {
+	if (s.TLS.CertFile == "") != (s.TLS.KeyFile == "") {
+		return fmt.Errorf("config: server tls needs both cert_file and key_file")
+	}
+	if s.TLS.ListenAddr != "" && !s.TLS.Enabled() {
+		return fmt.Errorf("config: server tls listen_addr needs cert_file and key_file")
+	}
+	if s.HTTP3.Enabled && !s.TLS.Enabled() {
+		return fmt.Errorf("config: server http3 needs tls")
+	}
+	if err := validateProxyProtocol("server", s.ProxyProtocol); err != nil {
+		return err
+	}
+	for _, f := range []struct{ name, value string }{
+		{"read_timeout", s.ReadTimeout},
+		{"read_header_timeout", s.ReadHeaderTimeout},
+		{"write_timeout", s.WriteTimeout},
+		{"idle_timeout", s.IdleTimeout},
+		{"shutdown_timeout", s.ShutdownTimeout},
+	} {
+		if f.value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(f.value); err != nil || d < 0 {
+			return fmt.Errorf("config: server %s %q must be a non-negative duration", f.name, f.value)
+		}
+	}
+	if v := s.HTTP3.AltSvcAge; v != "" {
+		if d, err := time.ParseDuration(v); err != nil || d < 0 {
+			return fmt.Errorf("config: server http3 alt_svc_max_age %q must be a non-negative duration", v)
+		}
+	}
+	for name, v := range map[string]int{
+		"max_concurrent_streams":            s.HTTP2.MaxConcurrentStreams,
+		"max_receive_buffer_per_stream":     s.HTTP2.MaxReceiveBufferPerStream,
+		"max_receive_buffer_per_connection": s.HTTP2.MaxReceiveBufferPerConnection,
+	} {
+		// Flow-control windows are 31-bit in HTTP/2.
+		if v < 0 || v > math.MaxInt32 {
+			return fmt.Errorf("config: server http2 %s must be in 0–%d", name, math.MaxInt32)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,665 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultPool is the name of the pool built from the top-level backends and
+// strategy keys. Routes that do not name a pool use it.
+const DefaultPool = "default"
+
+// PoolCfg is a named group of backends that share a load-balancing strategy
+// and an upstream transport. The backends are listed, or discovered.
+type PoolCfg struct {
+	Name        string             `mapstructure:"name"`
+	Strategy    string             `mapstructure:"strategy"` // defaults to the top-level strategy
+	Backends    []BackendCfg       `mapstructure:"backends"`
+	Discovery   DiscoveryCfg       `mapstructure:"discovery"`    // find backends at runtime instead
+	Transport   TransportCfg       `mapstructure:"transport"`    // overrides the top-level transport field by field
+	HealthCheck PoolHealthCheckCfg `mapstructure:"health_check"` // how the pool's backends are probed
+
+	SendProxyProtocol string `mapstructure:"send_proxy_protocol"` // "v1" or "v2" announces each client to the backend; empty sends none
+}
+
+// PoolHealthCheckCfg sets how a pool's backends are probed when active
+// health checking is enabled. Fields left empty take the top-level
+// health_check values; interval and timeout are always global.
+type PoolHealthCheckCfg struct {
+	Type    string `mapstructure:"type"`     // "http" (default), "grpc" for grpc.health.v1, "tcp" for a connect check or "udp"
+	Path    string `mapstructure:"path"`     // HTTP probe path
+	Service string `mapstructure:"service"`  // gRPC service to check; empty checks the whole server
+	Send    string `mapstructure:"send"`     // UDP probe payload
+	SendHex string `mapstructure:"send_hex"` // UDP probe payload, hex-encoded, for binary protocols
+	Expect  string `mapstructure:"expect"`   // UDP probes: the reply must contain this; empty accepts silence
+	Port    int    `mapstructure:"port"`     // tcp and udp probes: port to check instead of the backend's
+}
+
+// Merge returns h with every field that is set in o overriding h's value.
+func (h PoolHealthCheckCfg) Merge(o PoolHealthCheckCfg) PoolHealthCheckCfg {
+	if o.Type != "" {
+		h.Type = o.Type
+	}
+	if o.Path != "" {
+		h.Path = o.Path
+	}
+	if o.Service != "" {
+		h.Service = o.Service
+	}
+	if o.Send != "" {
+		h.Send = o.Send
+	}
+	if o.SendHex != "" {
+		h.SendHex = o.SendHex
+	}
+	if o.Expect != "" {
+		h.Expect = o.Expect
+	}
+	if o.Port != 0 {
+		h.Port = o.Port
+	}
+	return h
+}
+
+// RouteCfg maps requests whose path starts with PathPrefix to a pool, or
+// splits them between several pools by weight. A route may instead answer
+// requests itself with a redirect or a fixed response. Routes are matched
+// longest prefix first.
+type RouteCfg struct {
+	Name       string           `mapstructure:"name"`
+	PathPrefix string           `mapstructure:"path_prefix"`
+	Pool       string           `mapstructure:"pool"`
+	Split      []SplitTargetCfg `mapstructure:"split"`     // weighted pools; used instead of pool
+	Overrides  []OverrideCfg    `mapstructure:"overrides"` // force a pool by header or cookie
+	HashKey    string           `mapstructure:"hash_key"`  // sticky splits: "ip", "header:<name>" or "cookie:<name>"
+	Canary     CanaryCfg        `mapstructure:"canary"`    // automated progressive rollout of the split
+	Mirror     MirrorCfg        `mapstructure:"mirror"`    // shadow copy of a share of the traffic
+	Redirect   RedirectCfg      `mapstructure:"redirect"`  // answer with a redirect instead of proxying
+	Respond    RespondCfg       `mapstructure:"respond"`   // answer with a fixed response instead of proxying
+	Cache      RouteCacheCfg    `mapstructure:"cache"`     // store responses in the shared cache
+	Coalesce   CoalesceCfg      `mapstructure:"coalesce"`  // share one upstream call among identical concurrent GETs
+	Hedge      HedgeCfg         `mapstructure:"hedge"`     // send slow GETs to a second backend too
+	Upgrade    UpgradeCfg       `mapstructure:"upgrade"`   // protocol upgrades (WebSocket) tunnelled to the backend
+
+	StripPrefix bool         `mapstructure:"strip_prefix"` // remove path_prefix before forwarding
+	AddPrefix   string       `mapstructure:"add_prefix"`   // prepended after stripping and rewriting
+	Rewrite     []RewriteCfg `mapstructure:"rewrite"`      // regex rewrites of path and query; first match wins
+
+	RequestHeaders  HeaderRulesCfg `mapstructure:"request_headers"`  // applied to the upstream request
+	ResponseHeaders HeaderRulesCfg `mapstructure:"response_headers"` // applied to the upstream response
+	Timeout         string         `mapstructure:"timeout"`          // overall request timeout; overrides transport.request_timeout
+}
+
+// SplitTargetCfg is one weighted pool of a traffic split. Weights are usually
+// percentages summing to 100, but only their ratio matters.
+type SplitTargetCfg struct {
+	Pool   string `mapstructure:"pool"`
+	Weight int    `mapstructure:"weight"`
+}
+
+// OverrideCfg forces requests carrying a header or cookie to a given pool,
+// e.g. X-Canary: true → canary. An empty Value matches any value.
+type OverrideCfg struct {
+	Header string `mapstructure:"header"`
+	Cookie string `mapstructure:"cookie"`
+	Value  string `mapstructure:"value"`
+	Pool   string `mapstructure:"pool"`
+}
+
+// RewriteCfg is a regex rewrite of the request path and query. Regex is
+// matched against "path?query" ("path" without a query) and Replace may use
+// $1 or ${name} capture groups; a "?" in the result starts the new query.
+type RewriteCfg struct {
+	Regex   string `mapstructure:"regex"`
+	Replace string `mapstructure:"replace"`
+}
+
+// HeaderRulesCfg rewrites request or response headers. Rules apply in the
+// order remove, rename, set, add; values of set and add may use ${...}
+// placeholders (client_ip, request_id, route, backend, jwt.<claim>,
+// env.<NAME>).
+type HeaderRulesCfg struct {
+	Remove []string          `mapstructure:"remove"`
+	Rename map[string]string `mapstructure:"rename"` // old name → new name
+	Set    map[string]string `mapstructure:"set"`    // replace any existing value
+	Add    map[string]string `mapstructure:"add"`    // append a value
+}
+
+// Empty reports whether there are no rules.
+func (h HeaderRulesCfg) Empty() bool {
+	return len(h.Remove) == 0 && len(h.Rename) == 0 && len(h.Set) == 0 && len(h.Add) == 0
+}
+
+// HeadersCfg holds gateway-wide header settings.
+type HeadersCfg struct {
+	// StripResponse lists headers removed from every upstream response, e.g.
+	// Server and X-Powered-By, which reveal backend software versions.
+	StripResponse []string `mapstructure:"strip_response"`
+}
+
+// RouteCacheCfg enables the shared response cache for a route. The cache key
+// is the host and request URI, extended with the values of KeyHeaders and of
+// the verified JWT's KeyClaims.
+type RouteCacheCfg struct {
+	Enabled    bool     `mapstructure:"enabled"`
+	KeyHeaders []string `mapstructure:"key_headers"` // request headers added to the cache key
+	KeyClaims  []string `mapstructure:"key_claims"`  // JWT claims added to the cache key, e.g. sub
+}
+
+// CoalesceCfg lets concurrent identical GET and HEAD requests share one
+// upstream call. Requests are identical when method, host, path, query and
+// KeyHeaders match.
+type CoalesceCfg struct {
+	Enabled            bool     `mapstructure:"enabled"`
+	KeyHeaders         []string `mapstructure:"key_headers"`         // request headers that must also match
+	MaxWaiters         int      `mapstructure:"max_waiters"`         // requests waiting on one call; more go upstream themselves
+	MaxBodyBytes       int64    `mapstructure:"max_body_bytes"`      // larger responses are not shared
+	AllowAuthorization bool     `mapstructure:"allow_authorization"` // also coalesce requests with an Authorization header
+}
+
+// ParsedMaxWaiters returns MaxWaiters, defaulting to 100.
+func (c CoalesceCfg) ParsedMaxWaiters() int {
+	if c.MaxWaiters <= 0 {
+		return 100
+	}
+	return c.MaxWaiters
+}
+
+// ParsedMaxBodyBytes returns MaxBodyBytes, defaulting to 1 MiB.
+func (c CoalesceCfg) ParsedMaxBodyBytes() int64 {
+	if c.MaxBodyBytes <= 0 {
+		return 1 << 20
+	}
+	return c.MaxBodyBytes
+}
+
+// UpgradeCfg allows HTTP Upgrade requests, such as WebSocket, on a route and
+// limits the resulting tunnels. Upgrade requests for protocols not listed are
+// forwarded as plain requests without the Upgrade header.
+type UpgradeCfg struct {
+	Protocols   []string `mapstructure:"protocols"`    // allowed Upgrade protocols, e.g. websocket; "*" allows any
+	IdleTimeout string   `mapstructure:"idle_timeout"` // close a tunnel with no traffic either way for this long
+	MaxLifetime string   `mapstructure:"max_lifetime"` // close a tunnel after this long regardless; empty means no limit
+}
+
+// ParsedIdleTimeout returns IdleTimeout, defaulting to 10m.
+func (u UpgradeCfg) ParsedIdleTimeout() time.Duration {
+	return parseDuration(u.IdleTimeout, 10*time.Minute)
+}
+
+// ParsedMaxLifetime returns MaxLifetime; 0 means no limit.
+func (u UpgradeCfg) ParsedMaxLifetime() time.Duration {
+	return parseDuration(u.MaxLifetime, 0)
+}
+
+// validateUpgrade checks a route's tunnel limits.
+func validateUpgrade(i int, r RouteCfg) error {
+	for name, v := range map[string]string{"idle_timeout": r.Upgrade.IdleTimeout, "max_lifetime": r.Upgrade.MaxLifetime} {
+		if v == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(v); err != nil || d < 0 {
+			return fmt.Errorf("config: route[%d] upgrade %s %q must be a non-negative duration", i, name, v)
+		}
+	}
+	return nil
+}
+
+// MirrorCfg copies a percentage of a route's requests to a shadow pool.
+// Shadow responses are discarded.
+type MirrorCfg struct {
+	Pool         string  `mapstructure:"pool"`
+	Percent      float64 `mapstructure:"percent"`        // 0–100
+	MaxBodyBytes int64   `mapstructure:"max_body_bytes"` // larger bodies are not mirrored
+	Timeout      string  `mapstructure:"timeout"`        // deadline for each shadow request
+	MaxInflight  int     `mapstructure:"max_inflight"`   // shadow requests in flight before mirroring is skipped
+}
+
+// Enabled reports whether the route mirrors traffic.
+func (m MirrorCfg) Enabled() bool { return m.Pool != "" }
+
+// ParsedMaxBodyBytes returns MaxBodyBytes, defaulting to 1 MiB.
+func (m MirrorCfg) ParsedMaxBodyBytes() int64 {
+	if m.MaxBodyBytes <= 0 {
+		return 1 << 20
+	}
+	return m.MaxBodyBytes
+}
+
+func (m MirrorCfg) ParsedTimeout() time.Duration {
+	return parseDuration(m.Timeout, 5*time.Second)
+}
+
+// ParsedMaxInflight returns MaxInflight, defaulting to 100.
+func (m MirrorCfg) ParsedMaxInflight() int {
+	if m.MaxInflight <= 0 {
+		return 100
+	}
+	return m.MaxInflight
+}
+
+// PrimaryPool returns the pool that defines the route's defaults: its single
+// pool, or the first split target.
+func (r RouteCfg) PrimaryPool() string {
+	if len(r.Split) > 0 {
+		return r.Split[0].Pool
+	}
+	return r.Pool
+}
+
+// ParsedTimeout returns the route's request timeout, or fallback when unset.
+func (r RouteCfg) ParsedTimeout(fallback time.Duration) time.Duration {
+	return parseDuration(r.Timeout, fallback)
+}
+
+// TransportCfg tunes the HTTP client used to reach upstream backends.
+// Durations left empty take their defaults; "0s" disables a timeout.
+type TransportCfg struct {
+	DialTimeout           string `mapstructure:"dial_timeout"`
+	KeepAlive             string `mapstructure:"keep_alive"`
+	TLSHandshakeTimeout   string `mapstructure:"tls_handshake_timeout"`
+	ResponseHeaderTimeout string `mapstructure:"response_header_timeout"`
+	ExpectContinueTimeout string `mapstructure:"expect_continue_timeout"`
+	IdleConnTimeout       string `mapstructure:"idle_conn_timeout"`
+	RequestTimeout        string `mapstructure:"request_timeout"`         // whole request, including the response body
+	MaxIdleConns          *int   `mapstructure:"max_idle_conns"`          // nil when unset, so that a pool can override to 0
+	MaxIdleConnsPerHost   *int   `mapstructure:"max_idle_conns_per_host"` // nil when unset
+	MaxConnsPerHost       *int   `mapstructure:"max_conns_per_host"`      // nil when unset; 0 means unlimited
+	Protocol              string `mapstructure:"protocol"`                // http1 (default), auto, h2 or h2c
+	CAFile                string `mapstructure:"ca_file"`                 // PEM bundle that verifies TLS backends instead of the system roots
+}
+
+// Upstream protocols for TransportCfg.Protocol.
+const (
+	ProtocolHTTP1 = "http1" // HTTP/1.1, over TLS for https backends
+	ProtocolAuto  = "auto"  // HTTP/2 when a TLS backend offers it through ALPN, else HTTP/1.1
+	ProtocolH2    = "h2"    // HTTP/2 over TLS only
+	ProtocolH2C   = "h2c"   // HTTP/2 over cleartext TCP (prior knowledge), e.g. for gRPC
+)
+
+// ParsedProtocol returns Protocol, defaulting to http1.
+func (t TransportCfg) ParsedProtocol() string {
+	if t.Protocol == "" {
+		return ProtocolHTTP1
+	}
+	return t.Protocol
+}
+
+// Merge returns t with every field that is set in o overriding t's value.
+func (t TransportCfg) Merge(o TransportCfg) TransportCfg {
+	pick := func(base, over string) string {
+		if over != "" {
+			return over
+		}
+		return base
+	}
+	pickInt := func(base, over *int) *int {
+		if over != nil {
+			return over
+		}
+		return base
+	}
+	return TransportCfg{
+		DialTimeout:           pick(t.DialTimeout, o.DialTimeout),
+		KeepAlive:             pick(t.KeepAlive, o.KeepAlive),
+		TLSHandshakeTimeout:   pick(t.TLSHandshakeTimeout, o.TLSHandshakeTimeout),
+		ResponseHeaderTimeout: pick(t.ResponseHeaderTimeout, o.ResponseHeaderTimeout),
+		ExpectContinueTimeout: pick(t.ExpectContinueTimeout, o.ExpectContinueTimeout),
+		IdleConnTimeout:       pick(t.IdleConnTimeout, o.IdleConnTimeout),
+		RequestTimeout:        pick(t.RequestTimeout, o.RequestTimeout),
+		MaxIdleConns:          pickInt(t.MaxIdleConns, o.MaxIdleConns),
+		MaxIdleConnsPerHost:   pickInt(t.MaxIdleConnsPerHost, o.MaxIdleConnsPerHost),
+		MaxConnsPerHost:       pickInt(t.MaxConnsPerHost, o.MaxConnsPerHost),
+		Protocol:              pick(t.Protocol, o.Protocol),
+		CAFile:                pick(t.CAFile, o.CAFile),
+	}
+}
+
+// ParsedMaxIdleConns returns MaxIdleConns, defaulting to 100.
+func (t TransportCfg) ParsedMaxIdleConns() int {
+	if t.MaxIdleConns == nil {
+		return 100
+	}
+	return *t.MaxIdleConns
+}
+
+// ParsedMaxIdleConnsPerHost returns MaxIdleConnsPerHost, defaulting to 10.
+func (t TransportCfg) ParsedMaxIdleConnsPerHost() int {
+	if t.MaxIdleConnsPerHost == nil {
+		return 10
+	}
+	return *t.MaxIdleConnsPerHost
+}
+
+// ParsedMaxConnsPerHost returns MaxConnsPerHost, defaulting to 0 (unlimited).
+func (t TransportCfg) ParsedMaxConnsPerHost() int {
+	if t.MaxConnsPerHost == nil {
+		return 0
+	}
+	return *t.MaxConnsPerHost
+}
+
+func (t TransportCfg) ParsedDialTimeout() time.Duration {
+	return parseDuration(t.DialTimeout, 5*time.Second)
+}
+
+func (t TransportCfg) ParsedKeepAlive() time.Duration {
+	return parseDuration(t.KeepAlive, 30*time.Second)
+}
+
+func (t TransportCfg) ParsedTLSHandshakeTimeout() time.Duration {
+	return parseDuration(t.TLSHandshakeTimeout, 10*time.Second)
+}
+
+func (t TransportCfg) ParsedResponseHeaderTimeout() time.Duration {
+	return parseDuration(t.ResponseHeaderTimeout, 30*time.Second)
+}
+
+func (t TransportCfg) ParsedExpectContinueTimeout() time.Duration {
+	return parseDuration(t.ExpectContinueTimeout, time.Second)
+}
+
+func (t TransportCfg) ParsedIdleConnTimeout() time.Duration {
+	return parseDuration(t.IdleConnTimeout, 90*time.Second)
+}
+
+// ParsedRequestTimeout returns the overall request timeout; 0 (the default)
+// means no limit, which suits streaming responses.
+func (t TransportCfg) ParsedRequestTimeout() time.Duration {
+	return parseDuration(t.RequestTimeout, 0)
+}
+
+// ResolvedPools returns every pool the gateway should build: the implicit
+// "default" pool (when top-level backends are set) followed by cfg.Pools.
+// Each pool's strategy, transport and health check are fully resolved
+// against the top-level values.
+func (c Config) ResolvedPools() []PoolCfg {
+	out := make([]PoolCfg, 0, len(c.Pools)+1)
+	if len(c.Backends) > 0 {
+		out = append(out, PoolCfg{
+			Name:      DefaultPool,
+			Strategy:  c.Strategy,
+			Backends:  c.Backends,
+			Transport: c.Transport,
+		})
+	}
+	for _, p := range c.Pools {
+		if p.Strategy == "" {
+			p.Strategy = c.Strategy
+		}
+		p.Transport = c.Transport.Merge(p.Transport)
+		out = append(out, p)
+	}
+	for i := range out {
+		hc := out[i].HealthCheck
+		if out[i].IsTCP() && hc.Type == "" {
+			// TCP backends speak no HTTP; a connect is all that can be checked.
+			hc.Type = "tcp"
+		}
+		if out[i].IsUDP() && hc.Type == "" {
+			hc.Type = "udp"
+		}
+		out[i].HealthCheck = c.HealthCheck.PoolDefaults().Merge(hc)
+	}
+	return out
+}
+
+// ResolvedRoutes returns cfg.Routes, or a single catch-all route to the
+// default pool when none are configured. A config with neither, serving only
+// tcp listeners, has no routes.
+func (c Config) ResolvedRoutes() []RouteCfg {
+	if len(c.Routes) == 0 {
+		if len(c.Backends) == 0 {
+			return nil
+		}
+		return []RouteCfg{{Name: DefaultPool, PathPrefix: "/", Pool: DefaultPool}}
+	}
+	out := make([]RouteCfg, len(c.Routes))
+	for i, r := range c.Routes {
+		if r.Pool == "" && len(r.Split) == 0 && r.Proxies() {
+			r.Pool = DefaultPool
+		}
+		if r.PathPrefix == "" {
+			r.PathPrefix = "/"
+		}
+		if r.Name == "" {
+			r.Name = r.PathPrefix
+		}
+		out[i] = r
+	}
+	return out
+}
+
+// validateProtocol checks that a pool's upstream protocol suits its
+// backends: h2 needs TLS and h2c needs cleartext.
+func validateProtocol(p PoolCfg) error {
+	want := ""
+	switch p.Transport.ParsedProtocol() {
+	case ProtocolHTTP1, ProtocolAuto:
+		return nil
+	case ProtocolH2:
+		want = "https"
+	case ProtocolH2C:
+		want = "http"
+	default:
+		return fmt.Errorf("config: pool %q transport protocol %q must be http1, auto, h2 or h2c", p.Name, p.Transport.Protocol)
+	}
+	if p.Discovery.Enabled() && p.scheme() != want {
+		return fmt.Errorf("config: pool %q discovery must use scheme %s with transport protocol %s", p.Name, want, p.Transport.Protocol)
+	}
+	for _, b := range p.Backends {
+		// Unix socket backends speak cleartext HTTP.
+		if u, err := url.Parse(b.URL); err == nil && u.Scheme != want && (want != "http" || u.Scheme != "unix") {
+			return fmt.Errorf("config: pool %q backend %q must use %s:// with transport protocol %s", p.Name, b.URL, want, p.Transport.Protocol)
+		}
+	}
+	return nil
+}
+
+// validatePools checks pool and route references after unmarshalling.
+func validatePools(cfg *Config) error {
+	if len(cfg.Backends) == 0 && len(cfg.Pools) == 0 {
+		return fmt.Errorf("config: at least one backend must be defined")
+	}
+	names := map[string]bool{}
+	if len(cfg.Backends) > 0 {
+		names[DefaultPool] = true
+	}
+	for i := range cfg.Pools {
+		p := &cfg.Pools[i]
+		if p.Name == "" {
+			return fmt.Errorf("config: pool[%d] has no name", i)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("config: duplicate pool name %q", p.Name)
+		}
+		names[p.Name] = true
+		if len(p.Backends) == 0 && !p.Discovery.Enabled() {
+			return fmt.Errorf("config: pool %q has no backends", p.Name)
+		}
+		if err := normalizeBackends(fmt.Sprintf("pool %q backend", p.Name), p.Backends); err != nil {
+			return err
+		}
+	}
+	for _, p := range cfg.ResolvedPools() {
+		if err := validateDiscovery(p); err != nil {
+			return err
+		}
+		if err := validateProtocol(p); err != nil {
+			return err
+		}
+		switch p.HealthCheck.Type {
+		case "", "http", "grpc", "tcp", "udp":
+		default:
+			return fmt.Errorf("config: pool %q health_check type %q must be http, grpc, tcp or udp", p.Name, p.HealthCheck.Type)
+		}
+		if p.HealthCheck.Port < 0 || p.HealthCheck.Port > 65535 {
+			return fmt.Errorf("config: pool %q health_check port %d is out of range", p.Name, p.HealthCheck.Port)
+		}
+		if err := validateSendProxyProtocol(p); err != nil {
+			return err
+		}
+		if err := validateTransport(p); err != nil {
+			return err
+		}
+	}
+	if len(cfg.Backends) == 0 && len(cfg.Routes) == 0 && len(cfg.TCP) == 0 && len(cfg.UDP) == 0 {
+		return fmt.Errorf("config: routes are required when no top-level backends are defined")
+	}
+	routes := cfg.ResolvedRoutes()
+	routeNames := map[string]int{}
+	for _, r := range routes {
+		routeNames[r.Name]++
+	}
+	for i, r := range routes {
+		if !strings.HasPrefix(r.PathPrefix, "/") {
+			return fmt.Errorf("config: route[%d] path_prefix %q must start with /", i, r.PathPrefix)
+		}
+		if !r.Proxies() {
+			if err := validateAction(i, r); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := validateSplit(i, r, names); err != nil {
+			return err
+		}
+		if err := validateCanary(i, r, routeNames); err != nil {
+			return err
+		}
+		if err := validateHedge(i, r); err != nil {
+			return err
+		}
+		if err := validateUpgrade(i, r); err != nil {
+			return err
+		}
+		if r.Timeout != "" {
+			if d, err := time.ParseDuration(r.Timeout); err != nil || d < 0 {
+				return fmt.Errorf("config: route[%d] timeout %q must be a non-negative duration", i, r.Timeout)
+			}
+		}
+		if r.AddPrefix != "" && !strings.HasPrefix(r.AddPrefix, "/") {
+			return fmt.Errorf("config: route[%d] add_prefix %q must start with /", i, r.AddPrefix)
+		}
+		for _, rw := range r.Rewrite {
+			if _, err := regexp.Compile(rw.Regex); err != nil {
+				return fmt.Errorf("config: route[%d] rewrite: %w", i, err)
+			}
+		}
+		if m := r.Mirror; m.Enabled() {
+			if !names[m.Pool] {
+				return fmt.Errorf("config: route[%d] mirror references unknown pool %q", i, m.Pool)
+			}
+			if m.Percent <= 0 || m.Percent > 100 {
+				return fmt.Errorf("config: route[%d] mirror percent must be in (0, 100]", i)
+			}
+			if v := m.Timeout; v != "" {
+				if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+					return fmt.Errorf("config: route[%d] mirror timeout %q must be a positive duration", i, v)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validateTransport checks the durations of a pool's resolved transport.
+func validateTransport(p PoolCfg) error {
+	t := p.Transport
+	for _, f := range []struct{ name, value string }{
+		{"dial_timeout", t.DialTimeout},
+		{"keep_alive", t.KeepAlive},
+		{"tls_handshake_timeout", t.TLSHandshakeTimeout},
+		{"response_header_timeout", t.ResponseHeaderTimeout},
+		{"expect_continue_timeout", t.ExpectContinueTimeout},
+		{"idle_conn_timeout", t.IdleConnTimeout},
+		{"request_timeout", t.RequestTimeout},
+	} {
+		if f.value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(f.value); err != nil || d < 0 {
+			return fmt.Errorf("config: pool %q transport %s %q must be a non-negative duration", p.Name, f.name, f.value)
+		}
+	}
+	return nil
+}
+
+// validateSplit checks a route's pool references, split weights, overrides
+// and hash key.
+func validateSplit(i int, r RouteCfg, names map[string]bool) error {
+	if len(r.Split) == 0 {
+		if !names[r.Pool] {
+			return fmt.Errorf("config: route[%d] references unknown pool %q", i, r.Pool)
+		}
+		if len(r.Overrides) > 0 || r.HashKey != "" {
+			return fmt.Errorf("config: route[%d] overrides and hash_key require a split", i)
+		}
+		return nil
+	}
+	if r.Pool != "" {
+		return fmt.Errorf("config: route[%d] sets both pool and split", i)
+	}
+	total := 0
+	for _, t := range r.Split {
+		if !names[t.Pool] {
+			return fmt.Errorf("config: route[%d] split references unknown pool %q", i, t.Pool)
+		}
+		if t.Weight < 0 {
+			return fmt.Errorf("config: route[%d] split weight for %q is negative", i, t.Pool)
+		}
+		total += t.Weight
+	}
+	if total == 0 {
+		return fmt.Errorf("config: route[%d] split weights sum to zero", i)
+	}
+	for _, o := range r.Overrides {
+		if (o.Header == "") == (o.Cookie == "") {
+			return fmt.Errorf("config: route[%d] override must set exactly one of header or cookie", i)
+		}
+		if !names[o.Pool] {
+			return fmt.Errorf("config: route[%d] override references unknown pool %q", i, o.Pool)
+		}
+	}
+	switch {
+	case r.HashKey == "", r.HashKey == "ip",
+		strings.HasPrefix(r.HashKey, "header:") && len(r.HashKey) > len("header:"),
+		strings.HasPrefix(r.HashKey, "cookie:") && len(r.HashKey) > len("cookie:"):
+	default:
+		return fmt.Errorf("config: route[%d] hash_key %q must be ip, header:<name> or cookie:<name>", i, r.HashKey)
+	}
+	return nil
+}
+
+// normalizeBackends validates a backend list in place, defaulting weights.
+func normalizeBackends(what string, backends []BackendCfg) error {
+	for i, b := range backends {
+		if b.URL == "" {
+			return fmt.Errorf("config: %s[%d] has empty url", what, i)
+		}
+		if b.Weight <= 0 {
+			backends[i].Weight = 1
+		}
+		if b.MaxConns < 0 {
+			return fmt.Errorf("config: %s[%d] has negative max_conns", what, i)
+		}
+	}
+	return nil
+}
+
+// parseDuration parses s, returning def when s is empty. Load rejects
+// malformed and negative values, which also yield def for configs built in
+// code. An explicit zero is kept so that "0s" can disable a timeout.
+func parseDuration(s string, def time.Duration) time.Duration {
+	if s == "" {
+		return def
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return def
+	}
+	return d
+}
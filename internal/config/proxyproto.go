@@ -0,0 +1,99 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// ProxyProtocolCfg accepts PROXY protocol v1 and v2 headers on a listener
+// from the load balancers in TrustedCIDRs, whose connections must start with
+// one; the client address it carries then replaces theirs. Connections from
+// other sources are served as they are, so their headers are never trusted.
+type ProxyProtocolCfg struct {
+	TrustedCIDRs []string `mapstructure:"trusted_cidrs"` // CIDRs or single IPs; empty disables
+	Timeout      string   `mapstructure:"timeout"`       // to receive the header; default 5s
+}
+
+// Enabled reports whether any source may send a header.
+func (p ProxyProtocolCfg) Enabled() bool { return len(p.TrustedCIDRs) > 0 }
+
+// ParsedTrustedCIDRs returns the trusted networks. A single IP is a network
+// of its own.
+func (p ProxyProtocolCfg) ParsedTrustedCIDRs() ([]*net.IPNet, error) {
+	out := make([]*net.IPNet, 0, len(p.TrustedCIDRs))
+	for _, v := range p.TrustedCIDRs {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted_cidrs entry %q is not an IP or CIDR", v)
+			}
+			bits := 8 * len(ip)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			out = append(out, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("trusted_cidrs entry %q is not an IP or CIDR", v)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// ParsedTimeout returns how long to wait for the header, defaulting to 5s.
+func (p ProxyProtocolCfg) ParsedTimeout() time.Duration {
+	return parseDuration(p.Timeout, 5*time.Second)
+}
+
+// ParsedSendProxyProtocol returns the PROXY protocol version the pool
+// announces clients to its backends with, or 0 for none.
+func (p PoolCfg) ParsedSendProxyProtocol() int {
+	switch p.SendProxyProtocol {
+	case "v1":
+		return 1
+	case "v2":
+		return 2
+	}
+	return 0
+}
+
+// validateProxyProtocol checks what a listener accepts; where names it in
+// errors.
+func validateProxyProtocol(where string, p ProxyProtocolCfg) error {
+	if _, err := p.ParsedTrustedCIDRs(); err != nil {
+		return fmt.Errorf("config: %s proxy_protocol %w", where, err)
+	}
+	if v := p.Timeout; v != "" {
+		if d, err := time.ParseDuration(v); err != nil || d < 0 {
+			return fmt.Errorf("config: %s proxy_protocol timeout %q must be a non-negative duration", where, v)
+		}
+	}
+	return nil
+}
+
+// validateSendProxyProtocol checks what a pool sends. Each upstream
+// connection announces one client, so HTTP pools must not multiplex clients
+// over HTTP/2, and UDP has no connections to announce.
+func validateSendProxyProtocol(p PoolCfg) error {
+	switch p.SendProxyProtocol {
+	case "":
+		return nil
+	case "v1", "v2":
+	default:
+		return fmt.Errorf("config: pool %q send_proxy_protocol %q must be v1 or v2", p.Name, p.SendProxyProtocol)
+	}
+	switch {
+	case p.IsUDP():
+		return fmt.Errorf("config: pool %q has udp backends and cannot send_proxy_protocol", p.Name)
+	case p.IsTCP():
+		return nil
+	case p.Transport.ParsedProtocol() != ProtocolHTTP1:
+		return fmt.Errorf("config: pool %q send_proxy_protocol needs transport protocol http1", p.Name)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TCPListenerCfg is a layer-4 listener: every connection accepted on
+// ListenAddr is spliced byte for byte to a backend picked from Pool, whose
+// backends use tcp://host:port URLs. Listeners are bound at startup; the
+// pool's backends follow hot-reloads.
+//
+// With SNI routes the listener expects TLS: it reads the server name from
+// the ClientHello and picks the pool by hostname, falling back to Pool,
+// without terminating TLS.
+type TCPListenerCfg struct {
+	Name           string        `mapstructure:"name"` // defaults to listen_addr
+	ListenAddr     string        `mapstructure:"listen_addr"`
+	Pool           string        `mapstructure:"pool"`            // with sni, the default pool; optional
+	SNI            []SNIRouteCfg `mapstructure:"sni"`             // route TLS connections by server name
+	HelloTimeout   string        `mapstructure:"hello_timeout"`   // to receive the ClientHello; default 5s
+	IdleTimeout    string        `mapstructure:"idle_timeout"`    // close connections idle in both directions; default 1h
+	ConnectTimeout string        `mapstructure:"connect_timeout"` // to pick and dial a backend; default 5s
+	MaxConns       int           `mapstructure:"max_conns"`       // open client connections; 0 means unlimited
+
+	ProxyProtocol ProxyProtocolCfg `mapstructure:"proxy_protocol"` // headers from trusted load balancers
+}
+
+// SNIRouteCfg sends TLS connections for Hosts to Pool. A host is an exact
+// name or a wildcard "*.example.com", which matches any name below
+// example.com; exact names win over wildcards and longer wildcards over
+// shorter ones.
+type SNIRouteCfg struct {
+	Hosts []string `mapstructure:"hosts"`
+	Pool  string   `mapstructure:"pool"`
+}
+
+// ParsedHelloTimeout returns how long to wait for the ClientHello,
+// defaulting to 5s.
+func (l TCPListenerCfg) ParsedHelloTimeout() time.Duration {
+	return parseDuration(l.HelloTimeout, 5*time.Second)
+}
+
+// Pools returns the names of every pool the listener uses.
+func (l TCPListenerCfg) Pools() []string {
+	var out []string
+	if l.Pool != "" {
+		out = append(out, l.Pool)
+	}
+	for _, r := range l.SNI {
+		out = append(out, r.Pool)
+	}
+	return out
+}
+
+// ParsedIdleTimeout returns the idle timeout, defaulting to 1h. "0s"
+// disables it.
+func (l TCPListenerCfg) ParsedIdleTimeout() time.Duration {
+	return parseDuration(l.IdleTimeout, time.Hour)
+}
+
+// ParsedConnectTimeout returns the connect timeout, defaulting to 5s.
+func (l TCPListenerCfg) ParsedConnectTimeout() time.Duration {
+	return parseDuration(l.ConnectTimeout, 5*time.Second)
+}
+
+// IsTCP reports whether the pool's backends are raw TCP endpoints
+// (tcp://host:port) rather than HTTP servers.
+func (p PoolCfg) IsTCP() bool {
+	return p.scheme() == "tcp"
+}
+
+func backendScheme(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme
+}
+
+// validateTCP checks the tcp listeners and keeps TCP pools and HTTP routes
+// apart.
+func validateTCP(cfg *Config) error {
+	tcpPools := map[string]bool{}
+	for _, p := range cfg.ResolvedPools() {
+		if !p.IsTCP() {
+			continue
+		}
+		tcpPools[p.Name] = true
+		for _, b := range p.Backends {
+			u, err := url.Parse(b.URL)
+			if err != nil || u.Scheme != "tcp" {
+				return fmt.Errorf("config: pool %q mixes tcp:// and other backends", p.Name)
+			}
+			if _, port, err := net.SplitHostPort(u.Host); err != nil || port == "" {
+				return fmt.Errorf("config: pool %q backend %q must be tcp://host:port", p.Name, b.URL)
+			}
+		}
+		if p.HealthCheck.Type != "tcp" {
+			return fmt.Errorf("config: pool %q has tcp backends, so its health_check type must be tcp", p.Name)
+		}
+	}
+
+	names := map[string]bool{}
+	for i := range cfg.TCP {
+		l := &cfg.TCP[i]
+		if l.ListenAddr == "" {
+			return fmt.Errorf("config: tcp[%d] has no listen_addr", i)
+		}
+		if l.Name == "" {
+			l.Name = l.ListenAddr
+		}
+		if names[l.Name] {
+			return fmt.Errorf("config: duplicate tcp listener name %q", l.Name)
+		}
+		names[l.Name] = true
+		if (l.Pool != "" || len(l.SNI) == 0) && !tcpPools[l.Pool] {
+			return fmt.Errorf("config: tcp listener %q needs a pool of tcp:// backends, got %q", l.Name, l.Pool)
+		}
+		if err := validateSNI(l, tcpPools); err != nil {
+			return err
+		}
+		if l.MaxConns < 0 {
+			return fmt.Errorf("config: tcp listener %q max_conns must not be negative", l.Name)
+		}
+		if err := validateProxyProtocol(fmt.Sprintf("tcp listener %q", l.Name), l.ProxyProtocol); err != nil {
+			return err
+		}
+		for name, v := range map[string]string{"idle_timeout": l.IdleTimeout, "connect_timeout": l.ConnectTimeout, "hello_timeout": l.HelloTimeout} {
+			if v == "" {
+				continue
+			}
+			if d, err := time.ParseDuration(v); err != nil || d < 0 {
+				return fmt.Errorf("config: tcp listener %q %s %q must be a non-negative duration", l.Name, name, v)
+			}
+		}
+	}
+
+	for i, r := range cfg.Routes {
+		for _, name := range routePools(r) {
+			if tcpPools[name] {
+				return fmt.Errorf("config: route[%d] cannot send HTTP to tcp pool %q", i, name)
+			}
+		}
+	}
+	return nil
+}
+
+// routePools returns every pool a route may send requests to.
+func routePools(r RouteCfg) []string {
+	out := []string{r.Pool, r.Mirror.Pool}
+	for _, t := range r.Split {
+		out = append(out, t.Pool)
+	}
+	for _, o := range r.Overrides {
+		out = append(out, o.Pool)
+	}
+	return out
+}
+
+// validateSNI checks a listener's SNI routes: known tcp pools and well-formed
+// host patterns, each used once.
+func validateSNI(l *TCPListenerCfg, tcpPools map[string]bool) error {
+	seen := map[string]bool{}
+	for i, r := range l.SNI {
+		if !tcpPools[r.Pool] {
+			return fmt.Errorf("config: tcp listener %q sni[%d] needs a pool of tcp:// backends, got %q", l.Name, i, r.Pool)
+		}
+		if len(r.Hosts) == 0 {
+			return fmt.Errorf("config: tcp listener %q sni[%d] has no hosts", l.Name, i)
+		}
+		for _, h := range r.Hosts {
+			name := strings.ToLower(strings.TrimSuffix(h, "."))
+			if name == "" || strings.Contains(strings.TrimPrefix(name, "*."), "*") {
+				return fmt.Errorf("config: tcp listener %q sni host %q must be a name or *.domain", l.Name, h)
+			}
+			if seen[name] {
+				return fmt.Errorf("config: tcp listener %q sni host %q is listed twice", l.Name, h)
+			}
+			seen[name] = true
+		}
+	}
+	return nil
+}
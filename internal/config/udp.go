@@ -0,0 +1,118 @@
+package config
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// UDPListenerCfg is a layer-4 UDP listener. Datagrams from a client address
+// are sent to the backend its session was given, picked from Pool (backends
+// use udp://host:port URLs), and replies go back to that client. A session
+// ends after IdleTimeout without datagrams either way.
+type UDPListenerCfg struct {
+	Name        string `mapstructure:"name"` // defaults to listen_addr
+	ListenAddr  string `mapstructure:"listen_addr"`
+	Pool        string `mapstructure:"pool"`
+	IdleTimeout string `mapstructure:"idle_timeout"` // default 30s
+	Affinity    string `mapstructure:"affinity"`     // "" uses the pool's strategy; "hash" pins client IPs to backends
+	MaxSessions int    `mapstructure:"max_sessions"` // 0 means unlimited; datagrams that would open more are dropped
+}
+
+// ParsedIdleTimeout returns the session idle timeout, defaulting to 30s.
+func (l UDPListenerCfg) ParsedIdleTimeout() time.Duration {
+	d := parseDuration(l.IdleTimeout, 30*time.Second)
+	if d == 0 {
+		// Sessions are the only way replies find their client.
+		return 30 * time.Second
+	}
+	return d
+}
+
+// IsUDP reports whether the pool's backends are UDP endpoints
+// (udp://host:port).
+func (p PoolCfg) IsUDP() bool {
+	return p.scheme() == "udp"
+}
+
+// Payload returns the datagram UDP probes send: Send, or SendHex decoded.
+func (h PoolHealthCheckCfg) Payload() ([]byte, error) {
+	if h.SendHex != "" {
+		return hex.DecodeString(h.SendHex)
+	}
+	return []byte(h.Send), nil
+}
+
+// validateUDP checks the udp listeners and keeps UDP pools to them.
+func validateUDP(cfg *Config) error {
+	udpPools := map[string]bool{}
+	for _, p := range cfg.ResolvedPools() {
+		if !p.IsUDP() {
+			if p.HealthCheck.Type == "udp" {
+				return fmt.Errorf("config: pool %q health_check type udp needs udp:// backends", p.Name)
+			}
+			continue
+		}
+		udpPools[p.Name] = true
+		for _, b := range p.Backends {
+			u, err := url.Parse(b.URL)
+			if err != nil || u.Scheme != "udp" {
+				return fmt.Errorf("config: pool %q mixes udp:// and other backends", p.Name)
+			}
+			if _, port, err := net.SplitHostPort(u.Host); err != nil || port == "" {
+				return fmt.Errorf("config: pool %q backend %q must be udp://host:port", p.Name, b.URL)
+			}
+		}
+		switch p.HealthCheck.Type {
+		case "udp", "tcp":
+		default:
+			return fmt.Errorf("config: pool %q has udp backends, so its health_check type must be udp or tcp", p.Name)
+		}
+		if p.HealthCheck.Send != "" && p.HealthCheck.SendHex != "" {
+			return fmt.Errorf("config: pool %q health_check sets both send and send_hex", p.Name)
+		}
+		if _, err := p.HealthCheck.Payload(); err != nil {
+			return fmt.Errorf("config: pool %q health_check send_hex: %w", p.Name, err)
+		}
+	}
+
+	names := map[string]bool{}
+	for i := range cfg.UDP {
+		l := &cfg.UDP[i]
+		if l.ListenAddr == "" {
+			return fmt.Errorf("config: udp[%d] has no listen_addr", i)
+		}
+		if l.Name == "" {
+			l.Name = l.ListenAddr
+		}
+		if names[l.Name] {
+			return fmt.Errorf("config: duplicate udp listener name %q", l.Name)
+		}
+		names[l.Name] = true
+		if !udpPools[l.Pool] {
+			return fmt.Errorf("config: udp listener %q needs a pool of udp:// backends, got %q", l.Name, l.Pool)
+		}
+		if l.Affinity != "" && l.Affinity != "hash" {
+			return fmt.Errorf("config: udp listener %q affinity %q must be empty or hash", l.Name, l.Affinity)
+		}
+		if l.MaxSessions < 0 {
+			return fmt.Errorf("config: udp listener %q max_sessions must not be negative", l.Name)
+		}
+		if v := l.IdleTimeout; v != "" {
+			if d, err := time.ParseDuration(v); err != nil || d < 0 {
+				return fmt.Errorf("config: udp listener %q idle_timeout %q must be a non-negative duration", l.Name, v)
+			}
+		}
+	}
+
+	for i, r := range cfg.Routes {
+		for _, name := range routePools(r) {
+			if udpPools[name] {
+				return fmt.Errorf("config: route[%d] cannot send HTTP to udp pool %q", i, name)
+			}
+		}
+	}
+	return nil
+}
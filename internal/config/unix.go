@@ -0,0 +1,81 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// UnixSocketPath returns the socket path of a unix:///path/to.sock listen
+// address, and false when addr is a TCP address.
+func UnixSocketPath(addr string) (string, bool) {
+	return strings.CutPrefix(addr, "unix://")
+}
+
+// ParsedUnixSocketMode returns the permissions Unix socket listeners are
+// given, or 0 to leave them as the umask makes them.
+func (s ServerCfg) ParsedUnixSocketMode() os.FileMode {
+	m, _ := strconv.ParseUint(s.UnixSocketMode, 8, 32) // validated on load
+	return os.FileMode(m)
+}
+
+// hasUnixBackends reports whether any of the pool's backends is reached over
+// a Unix domain socket.
+func (p PoolCfg) hasUnixBackends() bool {
+	for _, b := range p.Backends {
+		if backendScheme(b.URL) == "unix" {
+			return true
+		}
+	}
+	return false
+}
+
+// validateUnix checks unix:// backends and listen addresses. A Unix socket
+// backend has no host or port, so probes cannot move to another port.
+func validateUnix(cfg *Config) error {
+	for _, p := range cfg.ResolvedPools() {
+		if !p.hasUnixBackends() {
+			continue
+		}
+		for _, b := range p.Backends {
+			u, err := url.Parse(b.URL)
+			if err != nil || u.Scheme != "unix" {
+				continue
+			}
+			if u.Host != "" || !path.IsAbs(u.Path) {
+				return fmt.Errorf("config: pool %q backend %q must be unix:///path/to.sock", p.Name, b.URL)
+			}
+		}
+		if p.HealthCheck.Port != 0 {
+			return fmt.Errorf("config: pool %q has unix backends, so its health_check cannot set a port", p.Name)
+		}
+	}
+
+	s := cfg.Server
+	unix := false
+	for _, addr := range []string{cfg.ListenAddr, s.TLS.ListenAddr} {
+		p, ok := UnixSocketPath(addr)
+		if !ok {
+			continue
+		}
+		if !path.IsAbs(p) {
+			return fmt.Errorf("config: listen_addr %q must be unix:///path/to.sock", addr)
+		}
+		unix = true
+	}
+	if _, ok := UnixSocketPath(cfg.HTTP3ListenAddr()); ok && s.HTTP3.Enabled {
+		return fmt.Errorf("config: server http3 runs over udp and needs its own listen_addr")
+	}
+	if v := s.UnixSocketMode; v != "" {
+		if m, err := strconv.ParseUint(v, 8, 32); err != nil || m > 0o777 {
+			return fmt.Errorf("config: server unix_socket_mode %q must be octal permissions such as 0660", v)
+		}
+		if !unix {
+			return fmt.Errorf("config: server unix_socket_mode needs a unix:// listen_addr")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golb/internal/config"
+)
+
+// minQueryGap spaces blocking queries, so that a catalog answering at once
+// with an unchanged index is not queried in a tight loop.
+const minQueryGap = 250 * time.Millisecond
+
+// Consul discovers backends from the passing instances of a service in a
+// Consul-compatible catalog, through GET /v1/health/service/:service. Each
+// query blocks on the index of the previous answer, so changes arrive as
+// soon as the catalog sees them. An instance becomes a backend at its
+// service address, or its node's when it has none, with its metadata as
+// labels.
+type Consul struct {
+	pool       string
+	endpoint   string
+	tags       []string
+	datacenter string
+	token      string
+	weightMeta string
+	scheme     string
+	wait       time.Duration
+	client     *http.Client
+}
+
+// consulEntry is the part of a /v1/health/service entry that is used.
+type consulEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+		Tags    []string
+		Meta    map[string]string
+		Weights struct {
+			Passing int
+		}
+	}
+}
+
+// NewConsul returns the Consul provider of the named pool.
+func NewConsul(pool string, cfg config.DiscoveryCfg) *Consul {
+	c := cfg.Consul
+	return &Consul{
+		pool:       pool,
+		endpoint:   c.ParsedAddress() + "/v1/health/service/" + url.PathEscape(c.Service),
+		tags:       c.Tags,
+		datacenter: c.Datacenter,
+		token:      c.Token,
+		weightMeta: c.ParsedWeightMeta(),
+		scheme:     cfg.ParsedScheme(),
+		wait:       c.ParsedWait(),
+		client:     &http.Client{},
+	}
+}
+
+// Run queries the catalog until ctx is done, reporting each new target set.
+func (c *Consul) Run(ctx context.Context, update func([]Target)) {
+	var last []Target
+	var index uint64
+	first := true
+	for {
+		start := time.Now()
+		targets, next, err := c.Query(ctx, index)
+		var wait time.Duration
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			slog.Warn("discovery: consul query failed", "pool", c.pool, "error", err)
+			index, wait = 0, retryDelay
+		default:
+			// An index that goes backwards means the catalog was reset;
+			// start over rather than block on an index it will not reach.
+			if next < index {
+				next = 0
+			}
+			index = next
+			if first || !sameTargets(targets, last) {
+				update(targets)
+				last, first = targets, false
+			}
+			wait = minQueryGap - time.Since(start)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Query asks the catalog for the service's passing instances, blocking until
+// the catalog's index passes index when it is not 0. It returns the targets,
+// sorted by URL, and the index of the answer.
+func (c *Consul) Query(ctx context.Context, index uint64) ([]Target, uint64, error) {
+	q := url.Values{"passing": {"true"}}
+	for _, tag := range c.tags {
+		q.Add("tag", tag)
+	}
+	if c.datacenter != "" {
+		q.Set("dc", c.datacenter)
+	}
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", c.wait.String())
+	}
+	// The catalog adds up to wait/16 of jitter to a blocking query.
+	ctx, cancel := context.WithTimeout(ctx, c.wait+c.wait/16+10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.endpoint+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, 0, fmt.Errorf("consul: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var entries []consulEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("consul: %w", err)
+	}
+	next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+
+	var targets []Target
+	for _, e := range entries {
+		// Older catalogs filter by one tag only; check them all here.
+		if !hasTags(e.Service.Tags, c.tags) {
+			continue
+		}
+		addr := e.Service.Address
+		if addr == "" {
+			addr = e.Node.Address
+		}
+		if addr == "" || e.Service.Port <= 0 {
+			continue
+		}
+		targets = append(targets, Target{
+			URL:    c.scheme + "://" + net.JoinHostPort(addr, strconv.Itoa(e.Service.Port)),
+			Weight: c.weight(e),
+			Labels: maps.Clone(e.Service.Meta),
+		})
+	}
+	slices.SortFunc(targets, func(a, b Target) int { return strings.Compare(a.URL, b.URL) })
+	return targets, next, nil
+}
+
+// weight returns the instance's weight from its metadata, else its passing
+// weight.
+func (c *Consul) weight(e consulEntry) int {
+	if v, ok := e.Service.Meta[c.weightMeta]; ok {
+		if w, err := strconv.Atoi(v); err == nil && w > 0 {
+			return w
+		}
+		slog.Warn("discovery: consul instance weight ignored", "pool", c.pool, "meta", c.weightMeta, "value", v)
+	}
+	return max(e.Service.Weights.Passing, 1)
+}
+
+func hasTags(have, want []string) bool {
+	for _, tag := range want {
+		if !slices.Contains(have, tag) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,232 @@
+package discovery_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"golb/internal/config"
+	"golb/internal/discovery"
+)
+
+// ── Catalog stand-in ──────────────────────────────────────────────────────────
+
+// instance is one registered service instance of the stand-in catalog.
+type instance struct {
+	Node    string
+	Address string // of the service; empty falls back to the node's
+	Port    int
+	Tags    []string
+	Meta    map[string]string
+	Weight  int // passing weight
+	DC      string
+	Passing bool
+}
+
+// catalog serves /v1/health/service/web like a Consul agent: it filters by
+// passing, datacenter and the first tag, and holds queries on the current
+// index until the instances change or the wait runs out.
+type catalog struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	index     uint64
+	instances []instance
+	changed   chan struct{} // closed and replaced on every change
+	status    int           // answer with this status when not 0
+	queries   []url.Values
+	tokens    []string
+}
+
+func newCatalog(t *testing.T, instances ...instance) *catalog {
+	t.Helper()
+	c := &catalog{index: 10, instances: instances, changed: make(chan struct{})}
+	c.Server = httptest.NewServer(http.HandlerFunc(c.serve))
+	t.Cleanup(c.Close)
+	return c
+}
+
+// set replaces the instances and advances the index.
+func (c *catalog) set(instances ...instance) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.instances = instances
+	c.index++
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *catalog) fail(status int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status = status
+}
+
+func (c *catalog) recorded() ([]url.Values, []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.queries), slices.Clone(c.tokens)
+}
+
+func (c *catalog) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/health/service/web" {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	c.mu.Lock()
+	c.queries = append(c.queries, q)
+	c.tokens = append(c.tokens, r.Header.Get("X-Consul-Token"))
+	if index, _ := strconv.ParseUint(q.Get("index"), 10, 64); index >= c.index {
+		changed := c.changed
+		c.mu.Unlock()
+		wait, _ := time.ParseDuration(q.Get("wait"))
+		select {
+		case <-changed:
+		case <-time.After(wait):
+		case <-r.Context().Done():
+			return
+		}
+		c.mu.Lock()
+	}
+	defer c.mu.Unlock()
+	if c.status != 0 {
+		http.Error(w, "catalog unavailable", c.status)
+		return
+	}
+
+	dc := q.Get("dc")
+	if dc == "" {
+		dc = "dc1"
+	}
+	out := []map[string]any{}
+	for _, in := range c.instances {
+		if in.DC == "" {
+			in.DC = "dc1"
+		}
+		if in.DC != dc || (q.Get("passing") == "true" && !in.Passing) {
+			continue
+		}
+		if tag := q.Get("tag"); tag != "" && !slices.Contains(in.Tags, tag) {
+			continue
+		}
+		out = append(out, map[string]any{
+			"Node": map[string]any{"Node": "node-" + in.Node, "Address": in.Node, "Datacenter": in.DC},
+			"Service": map[string]any{
+				"ID": "web-" + in.Node, "Service": "web", "Address": in.Address, "Port": in.Port,
+				"Tags": in.Tags, "Meta": in.Meta, "Weights": map[string]int{"Passing": in.Weight, "Warning": 1},
+			},
+			"Checks": []any{},
+		})
+	}
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(c.index, 10))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+func consulCfg(c *catalog, cc config.ConsulDiscoveryCfg) config.DiscoveryCfg {
+	cc.Address = c.URL
+	cc.Service = "web"
+	return config.DiscoveryCfg{Type: "consul", Consul: cc}
+}
+
+// ── Queries ───────────────────────────────────────────────────────────────────
+
+func TestConsul_QueryMapsPassingInstances(t *testing.T) {
+	c := newCatalog(t,
+		instance{Node: "10.0.0.1", Port: 8080, Tags: []string{"web", "v2"}, Meta: map[string]string{"weight": "5", "zone": "a"}, Weight: 1, Passing: true},
+		instance{Node: "10.0.0.2", Address: "10.0.1.2", Port: 8080, Tags: []string{"web", "v2"}, Weight: 3, Passing: true},
+		instance{Node: "10.0.0.3", Port: 8080, Tags: []string{"web", "v2"}, Meta: map[string]string{"weight": "lots"}, Passing: true},
+		instance{Node: "10.0.0.4", Port: 8080, Tags: []string{"web", "v1"}, Weight: 1, Passing: true},
+		instance{Node: "10.0.0.5", Port: 8080, Tags: []string{"web", "v2"}, Weight: 1},
+		instance{Node: "10.9.0.1", Port: 8080, Tags: []string{"web", "v2"}, Weight: 1, Passing: true, DC: "eu"},
+	)
+
+	p := discovery.NewConsul("web", consulCfg(c, config.ConsulDiscoveryCfg{Tags: []string{"web", "v2"}, Token: "secret"}))
+	targets, index, err := p.Query(t.Context(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), index)
+	assert.Equal(t, []discovery.Target{
+		{URL: "http://10.0.0.1:8080", Weight: 5, Labels: map[string]string{"weight": "5", "zone": "a"}},
+		{URL: "http://10.0.0.3:8080", Weight: 1, Labels: map[string]string{"weight": "lots"}},
+		{URL: "http://10.0.1.2:8080", Weight: 3},
+	}, targets, "passing instances with every tag, at the service address or else the node's")
+
+	queries, tokens := c.recorded()
+	assert.Equal(t, []string{"web", "v2"}, queries[0]["tag"])
+	assert.Empty(t, queries[0].Get("index"), "the first query does not block")
+	assert.Equal(t, []string{"secret"}, tokens)
+
+	cfg := consulCfg(c, config.ConsulDiscoveryCfg{Datacenter: "eu", WeightMeta: "zone"})
+	cfg.Scheme = "https"
+	targets, _, err = discovery.NewConsul("web", cfg).Query(t.Context(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://10.9.0.1:8080"}, urls(targets))
+
+	c.fail(http.StatusForbidden)
+	_, _, err = p.Query(t.Context(), 0)
+	assert.ErrorContains(t, err, "403")
+}
+
+// ── Watching ──────────────────────────────────────────────────────────────────
+
+func TestConsul_RunBlocksUntilTheCatalogChanges(t *testing.T) {
+	c := newCatalog(t, instance{Node: "10.0.0.1", Port: 80, Weight: 1, Passing: true})
+	p := discovery.NewConsul("web", consulCfg(c, config.ConsulDiscoveryCfg{Wait: "1m"}))
+
+	updates := make(chan []string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx, func(ts []discovery.Target) { updates <- urls(ts) })
+		close(done)
+	}()
+	defer func() { cancel(); <-done }()
+
+	next := func(msg string) []string {
+		t.Helper()
+		select {
+		case got := <-updates:
+			return got
+		case <-time.After(3 * time.Second):
+			t.Fatal(msg)
+			return nil
+		}
+	}
+	assert.Equal(t, []string{"http://10.0.0.1:80"}, next("no initial update"))
+
+	// The follow-up query waits on the index.
+	require.Eventually(t, func() bool {
+		queries, _ := c.recorded()
+		return len(queries) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	queries, _ := c.recorded()
+	assert.Equal(t, "10", queries[1].Get("index"))
+	assert.Equal(t, "1m0s", queries[1].Get("wait"))
+
+	c.set(instance{Node: "10.0.0.1", Port: 80, Weight: 1, Passing: true}, instance{Node: "10.0.0.2", Port: 80, Weight: 1, Passing: true})
+	assert.Equal(t, []string{"http://10.0.0.1:80", "http://10.0.0.2:80"}, next("no update after a change"))
+
+	// Instances failing their checks leave the set.
+	c.set(instance{Node: "10.0.0.1", Port: 80, Weight: 1}, instance{Node: "10.0.0.2", Port: 80, Weight: 1, Passing: true})
+	assert.Equal(t, []string{"http://10.0.0.2:80"}, next("no update after a failing check"))
+
+	// An unavailable catalog keeps the last set.
+	c.fail(http.StatusInternalServerError)
+	c.set()
+	select {
+	case got := <-updates:
+		t.Fatalf("unexpected update %v", got)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
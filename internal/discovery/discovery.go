@@ -0,0 +1,55 @@
+// Package discovery finds the backends of pools at runtime, from DNS, target
+// files, a service catalog or Kubernetes, and keeps the pools' backend sets
+// in step with them.
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"golb/internal/config"
+)
+
+// Target is one discovered backend.
+type Target struct {
+	URL    string
+	Weight int               // values below 1 count as 1
+	Tier   int               // failover tier; lower tiers serve first
+	Labels map[string]string // metadata the registry attaches, if any
+}
+
+// equal reports whether t and o describe the same backend.
+func (t Target) equal(o Target) bool {
+	return t.URL == o.URL && t.Weight == o.Weight && t.Tier == o.Tier && maps.Equal(t.Labels, o.Labels)
+}
+
+// sameTargets reports whether two target sets are equal, in order.
+func sameTargets(a, b []Target) bool {
+	return slices.EqualFunc(a, b, Target.equal)
+}
+
+// Provider discovers the backends of one pool.
+type Provider interface {
+	// Run reports the pool's complete target set through update when it is
+	// first found and each time it changes, until ctx is done. A failed
+	// lookup is logged and keeps the last set.
+	Run(ctx context.Context, update func([]Target))
+}
+
+// New returns the provider the pool's discovery settings describe.
+func New(pc config.PoolCfg) (Provider, error) {
+	switch pc.Discovery.Type {
+	case "dns":
+		return NewDNS(pc.Name, pc.Discovery), nil
+	case "file":
+		return NewFile(pc.Name, pc.Discovery), nil
+	case "consul":
+		return NewConsul(pc.Name, pc.Discovery), nil
+	case "kubernetes":
+		return NewKubernetes(pc.Name, pc.Discovery), nil
+	default:
+		return nil, fmt.Errorf("discovery: pool %q: unknown type %q", pc.Name, pc.Discovery.Type)
+	}
+}
@@ -0,0 +1,370 @@
+package discovery
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"golb/internal/config"
+)
+
+const (
+	// minTTL and maxTTL bound how often records are re-resolved when their
+	// TTL decides it.
+	minTTL = time.Second
+	maxTTL = 5 * time.Minute
+
+	// negativeTTL is how long a name without records is trusted when the
+	// answer carries no SOA saying otherwise.
+	negativeTTL = 30 * time.Second
+
+	// retryDelay follows a failed lookup when no interval is configured.
+	retryDelay = 5 * time.Second
+)
+
+// DNS discovers backends by resolving a name's A and AAAA records, each
+// address becoming a backend on the configured port, or its SRV records,
+// each target's addresses becoming backends on the record's port, weight
+// and priority tier. Names are re-resolved when their TTL expires, or at a
+// fixed interval.
+//
+// Queries go straight to one DNS server, over UDP and over TCP when the
+// answer is truncated, so that TTLs are known.
+type DNS struct {
+	pool     string
+	name     string
+	record   string
+	scheme   string
+	port     int
+	interval time.Duration
+	timeout  time.Duration
+	server   string
+}
+
+// NewDNS returns the DNS provider of the named pool.
+func NewDNS(pool string, cfg config.DiscoveryCfg) *DNS {
+	d := cfg.DNS
+	server := d.Server
+	if server == "" {
+		server = systemServer()
+	}
+	return &DNS{
+		pool:     pool,
+		name:     d.Name,
+		record:   d.ParsedRecord(),
+		scheme:   cfg.ParsedScheme(),
+		port:     d.Port,
+		interval: d.ParsedInterval(),
+		timeout:  d.ParsedTimeout(),
+		server:   server,
+	}
+}
+
+// Run resolves the name until ctx is done, reporting each new target set.
+func (d *DNS) Run(ctx context.Context, update func([]Target)) {
+	var last []Target
+	first := true
+	for {
+		targets, ttl, err := d.Resolve(ctx)
+		wait := d.interval
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			slog.Warn("discovery: dns lookup failed", "pool", d.pool, "name", d.name, "error", err)
+			if wait == 0 {
+				wait = retryDelay
+			}
+		default:
+			if first || !sameTargets(targets, last) {
+				update(targets)
+				last, first = targets, false
+			}
+			if wait == 0 {
+				wait = min(max(ttl, minTTL), maxTTL)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Resolve looks the name up once and returns its targets, sorted by URL, and
+// the TTL of the answers they came from. A name that does not exist has no
+// targets.
+func (d *DNS) Resolve(ctx context.Context) ([]Target, time.Duration, error) {
+	var targets []Target
+	var ttl time.Duration
+	if d.record == config.DNSRecordSRV {
+		srvs, extra, t, err := d.lookupSRV(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		ttl = t
+		for _, srv := range srvs {
+			addrs, ok := extra[strings.ToLower(srv.Target.String())]
+			if !ok {
+				if addrs, t, err = d.lookupIP(ctx, srv.Target.String(), config.DNSRecordIP); err != nil {
+					return nil, 0, err
+				}
+				ttl = min(ttl, t)
+			}
+			for _, a := range addrs {
+				targets = append(targets, Target{URL: d.url(a, int(srv.Port)), Weight: int(srv.Weight), Tier: int(srv.Priority)})
+			}
+		}
+	} else {
+		addrs, t, err := d.lookupIP(ctx, d.name, d.record)
+		if err != nil {
+			return nil, 0, err
+		}
+		ttl = t
+		for _, a := range addrs {
+			targets = append(targets, Target{URL: d.url(a, d.port), Weight: 1})
+		}
+	}
+	slices.SortFunc(targets, func(a, b Target) int { return strings.Compare(a.URL, b.URL) })
+	return targets, ttl, nil
+}
+
+func (d *DNS) url(a netip.Addr, port int) string {
+	return d.scheme + "://" + net.JoinHostPort(a.String(), strconv.Itoa(port))
+}
+
+// lookupIP returns the addresses of name for the record type, ip meaning
+// both A and AAAA, and the shortest TTL among them.
+func (d *DNS) lookupIP(ctx context.Context, name, record string) ([]netip.Addr, time.Duration, error) {
+	var types []dnsmessage.Type
+	switch record {
+	case config.DNSRecordA:
+		types = []dnsmessage.Type{dnsmessage.TypeA}
+	case config.DNSRecordAAAA:
+		types = []dnsmessage.Type{dnsmessage.TypeAAAA}
+	default:
+		types = []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	}
+	var addrs []netip.Addr
+	ttl := maxTTL
+	for _, qtype := range types {
+		msg, err := d.exchange(ctx, name, qtype)
+		if err != nil {
+			return nil, 0, err
+		}
+		found := false
+		for _, r := range msg.Answers {
+			switch body := r.Body.(type) {
+			case *dnsmessage.AResource:
+				addrs = append(addrs, netip.AddrFrom4(body.A))
+			case *dnsmessage.AAAAResource:
+				addrs = append(addrs, netip.AddrFrom16(body.AAAA))
+			default:
+				continue // CNAMEs leading to the addresses
+			}
+			found = true
+			ttl = min(ttl, time.Duration(r.Header.TTL)*time.Second)
+		}
+		if !found {
+			ttl = min(ttl, negativeCacheTTL(msg))
+		}
+	}
+	return addrs, ttl, nil
+}
+
+// lookupSRV returns the name's SRV records, the addresses of their targets
+// given in the additional section, keyed by lower-case target name, and the
+// shortest TTL among them.
+func (d *DNS) lookupSRV(ctx context.Context) ([]*dnsmessage.SRVResource, map[string][]netip.Addr, time.Duration, error) {
+	msg, err := d.exchange(ctx, d.name, dnsmessage.TypeSRV)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	var srvs []*dnsmessage.SRVResource
+	ttl := maxTTL
+	for _, r := range msg.Answers {
+		if srv, ok := r.Body.(*dnsmessage.SRVResource); ok {
+			srvs = append(srvs, srv)
+			ttl = min(ttl, time.Duration(r.Header.TTL)*time.Second)
+		}
+	}
+	if len(srvs) == 0 {
+		ttl = negativeCacheTTL(msg)
+	}
+	extra := map[string][]netip.Addr{}
+	for _, r := range msg.Additionals {
+		name := strings.ToLower(r.Header.Name.String())
+		switch body := r.Body.(type) {
+		case *dnsmessage.AResource:
+			extra[name] = append(extra[name], netip.AddrFrom4(body.A))
+		case *dnsmessage.AAAAResource:
+			extra[name] = append(extra[name], netip.AddrFrom16(body.AAAA))
+		default:
+			continue
+		}
+		ttl = min(ttl, time.Duration(r.Header.TTL)*time.Second)
+	}
+	return srvs, extra, ttl, nil
+}
+
+// negativeCacheTTL returns how long an answer without records holds, from
+// the SOA in its authority section (RFC 2308).
+func negativeCacheTTL(msg *dnsmessage.Message) time.Duration {
+	for _, r := range msg.Authorities {
+		if soa, ok := r.Body.(*dnsmessage.SOAResource); ok {
+			return time.Duration(min(r.Header.TTL, soa.MinTTL)) * time.Second
+		}
+	}
+	return negativeTTL
+}
+
+// exchange sends one query for name to the server and returns the answer.
+// A name that does not exist is an answer without records, not an error.
+func (d *DNS) exchange(ctx context.Context, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, fmt.Errorf("dns %s: %w", name, err)
+	}
+	id := uint16(rand.Uint32())
+	query, err := buildQuery(id, dnsmessage.Question{Name: n, Type: qtype, Class: dnsmessage.ClassINET})
+	if err != nil {
+		return nil, fmt.Errorf("dns %s: %w", name, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	msg, err := d.roundTrip(ctx, "udp", id, query)
+	if err == nil && msg.Truncated {
+		msg, err = d.roundTrip(ctx, "tcp", id, query)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dns %s %s: %w", name, qtype, err)
+	}
+	switch msg.RCode {
+	case dnsmessage.RCodeSuccess, dnsmessage.RCodeNameError:
+		return msg, nil
+	default:
+		return nil, fmt.Errorf("dns %s %s: server answered %s", name, qtype, msg.RCode)
+	}
+}
+
+// buildQuery returns a recursive query for q, advertising a 4 KiB UDP
+// buffer with EDNS(0). It is prefixed with its length for TCP; UDP skips the
+// first two bytes.
+func buildQuery(id uint16, q dnsmessage.Question) ([]byte, error) {
+	b := dnsmessage.NewBuilder(make([]byte, 2, 512), dnsmessage.Header{ID: id, RecursionDesired: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	if err := b.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(4096, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, err
+	}
+	if err := b.OPTResource(opt, dnsmessage.OPTResource{}); err != nil {
+		return nil, err
+	}
+	query, err := b.Finish()
+	if err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint16(query, uint16(len(query)-2))
+	return query, nil
+}
+
+// roundTrip sends query over network and reads the answer with the same ID.
+func (d *DNS) roundTrip(ctx context.Context, network string, id uint16, query []byte) (*dnsmessage.Message, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, d.server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if network == "tcp" {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		r := bufio.NewReader(conn)
+		var size uint16
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return parseAnswer(buf, id)
+	}
+
+	if _, err := conn.Write(query[2:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Stray datagrams with another ID are not ours; keep waiting.
+		if msg, err := parseAnswer(buf[:n], id); err == nil {
+			return msg, nil
+		}
+	}
+}
+
+var errWrongID = errors.New("answer to another query")
+
+func parseAnswer(buf []byte, id uint16) (*dnsmessage.Message, error) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(buf); err != nil {
+		return nil, err
+	}
+	if msg.ID != id || !msg.Response {
+		return nil, errWrongID
+	}
+	return &msg, nil
+}
+
+// systemServer returns the first nameserver of /etc/resolv.conf, or the
+// local resolver.
+func systemServer() string {
+	if f, err := os.Open("/etc/resolv.conf"); err == nil {
+		defer f.Close()
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			fields := strings.Fields(s.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				return net.JoinHostPort(fields[1], "53")
+			}
+		}
+	}
+	return "127.0.0.1:53"
+}
@@ -0,0 +1,292 @@
+package discovery_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"golb/internal/config"
+	"golb/internal/discovery"
+)
+
+// ── DNS stand-in ──────────────────────────────────────────────────────────────
+
+// dnsServer answers queries over UDP and TCP on one local port from its
+// records, which tests change as they go.
+type dnsServer struct {
+	addr string
+
+	mu       sync.Mutex
+	records  map[string][]dnsmessage.Resource // by lower-case name and type, e.g. "api.test. TypeA"
+	glue     bool                             // SRV answers carry their targets' addresses
+	truncate bool                             // UDP answers are truncated, so clients retry over TCP
+	rcode    dnsmessage.RCode                 // answer every query with this code when not success
+
+	udpQueries, tcpQueries atomic.Int64
+}
+
+func newDNSServer(t *testing.T) *dnsServer {
+	t.Helper()
+	s := &dnsServer{records: map[string][]dnsmessage.Resource{}}
+	var ln net.Listener
+	var pc net.PacketConn
+	for range 10 {
+		var err error
+		if ln, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			continue
+		}
+		if pc, err = net.ListenPacket("udp", ln.Addr().String()); err == nil {
+			break
+		}
+		_ = ln.Close()
+	}
+	require.NotNil(t, pc, "no port free for both udp and tcp")
+	t.Cleanup(func() { _ = ln.Close(); _ = pc.Close() })
+	s.addr = ln.Addr().String()
+
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			s.udpQueries.Add(1)
+			if out := s.answer(buf[:n], true); out != nil {
+				_, _ = pc.WriteTo(out, from)
+			}
+		}
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var size uint16
+				if binary.Read(conn, binary.BigEndian, &size) != nil {
+					return
+				}
+				query := make([]byte, size)
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				s.tcpQueries.Add(1)
+				out := s.answer(query, false)
+				_ = binary.Write(conn, binary.BigEndian, uint16(len(out)))
+				_, _ = conn.Write(out)
+			}()
+		}
+	}()
+	return s
+}
+
+// set replaces the records of name and type.
+func (s *dnsServer) set(name string, qtype dnsmessage.Type, rs ...dnsmessage.Resource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[strings.ToLower(name)+" "+qtype.String()] = rs
+}
+
+// update changes the server's settings while it runs.
+func (s *dnsServer) update(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f()
+}
+
+func (s *dnsServer) answer(query []byte, udp bool) []byte {
+	var q dnsmessage.Message
+	if q.Unpack(query) != nil || len(q.Questions) != 1 {
+		return nil
+	}
+	question := q.Questions[0]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: q.ID, Response: true, Authoritative: true, RCode: s.rcode},
+		Questions: q.Questions,
+	}
+	name := strings.ToLower(question.Name.String())
+	if s.rcode == dnsmessage.RCodeSuccess {
+		resp.Answers = s.records[name+" "+question.Type.String()]
+		known := false
+		for key := range s.records {
+			known = known || strings.HasPrefix(key, name+" ")
+		}
+		if !known {
+			resp.RCode = dnsmessage.RCodeNameError
+		}
+		if len(resp.Answers) == 0 {
+			soa, _ := dnsmessage.NewName("test.")
+			resp.Authorities = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: soa, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET, TTL: 60},
+				Body:   &dnsmessage.SOAResource{NS: soa, MBox: soa, MinTTL: 7},
+			}}
+		}
+		if s.glue {
+			for _, r := range resp.Answers {
+				if srv, ok := r.Body.(*dnsmessage.SRVResource); ok {
+					target := strings.ToLower(srv.Target.String())
+					resp.Additionals = append(resp.Additionals, s.records[target+" TypeA"]...)
+					resp.Additionals = append(resp.Additionals, s.records[target+" TypeAAAA"]...)
+				}
+			}
+		}
+	}
+	if udp && s.truncate {
+		resp.Truncated = true
+		resp.Answers, resp.Authorities, resp.Additionals = nil, nil, nil
+	}
+	out, _ := resp.Pack()
+	return out
+}
+
+func header(name string, qtype dnsmessage.Type, ttl uint32) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: qtype, Class: dnsmessage.ClassINET, TTL: ttl}
+}
+
+func a(name, ip string, ttl uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{Header: header(name, dnsmessage.TypeA, ttl), Body: &dnsmessage.AResource{A: netip.MustParseAddr(ip).As4()}}
+}
+
+func aaaa(name, ip string, ttl uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{Header: header(name, dnsmessage.TypeAAAA, ttl), Body: &dnsmessage.AAAAResource{AAAA: netip.MustParseAddr(ip).As16()}}
+}
+
+func srv(name string, priority, weight, port uint16, target string, ttl uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: header(name, dnsmessage.TypeSRV, ttl),
+		Body:   &dnsmessage.SRVResource{Priority: priority, Weight: weight, Port: port, Target: dnsmessage.MustNewName(target)},
+	}
+}
+
+func dnsCfg(s *dnsServer, d config.DNSDiscoveryCfg) config.DiscoveryCfg {
+	d.Server = s.addr
+	return config.DiscoveryCfg{Type: "dns", DNS: d}
+}
+
+func urls(targets []discovery.Target) []string {
+	out := make([]string, len(targets))
+	for i, t := range targets {
+		out[i] = t.URL
+	}
+	return out
+}
+
+// ── Resolution ────────────────────────────────────────────────────────────────
+
+func TestDNS_ResolvesAAndAAAA(t *testing.T) {
+	s := newDNSServer(t)
+	s.set("api.test.", dnsmessage.TypeA, a("api.test.", "10.0.0.2", 30), a("api.test.", "10.0.0.1", 20))
+	s.set("api.test.", dnsmessage.TypeAAAA, aaaa("api.test.", "2001:db8::1", 40))
+
+	d := discovery.NewDNS("api", dnsCfg(s, config.DNSDiscoveryCfg{Name: "api.test", Port: 8080}))
+	targets, ttl, err := d.Resolve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080", "http://[2001:db8::1]:8080"}, urls(targets))
+	assert.Equal(t, 20*time.Second, ttl, "the shortest TTL decides")
+
+	v4 := discovery.NewDNS("api", dnsCfg(s, config.DNSDiscoveryCfg{Name: "api.test", Port: 8080, Record: "a"}))
+	targets, _, err = v4.Resolve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"}, urls(targets))
+}
+
+func TestDNS_SRVWeightsAndPriorities(t *testing.T) {
+	for _, glue := range []bool{true, false} {
+		s := newDNSServer(t)
+		s.update(func() { s.glue = glue })
+		s.set("_pg._tcp.db.test.", dnsmessage.TypeSRV,
+			srv("_pg._tcp.db.test.", 10, 5, 5432, "a.db.test.", 60),
+			srv("_pg._tcp.db.test.", 10, 0, 5433, "b.db.test.", 60),
+			srv("_pg._tcp.db.test.", 20, 1, 5432, "c.db.test.", 60))
+		s.set("a.db.test.", dnsmessage.TypeA, a("a.db.test.", "10.0.0.1", 60))
+		s.set("b.db.test.", dnsmessage.TypeA, a("b.db.test.", "10.0.0.2", 60))
+		s.set("c.db.test.", dnsmessage.TypeAAAA, aaaa("c.db.test.", "2001:db8::3", 60))
+
+		cfg := dnsCfg(s, config.DNSDiscoveryCfg{Name: "_pg._tcp.db.test", Record: "srv"})
+		cfg.Scheme = "tcp"
+		targets, _, err := discovery.NewDNS("pg", cfg).Resolve(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []discovery.Target{
+			{URL: "tcp://10.0.0.1:5432", Weight: 5, Tier: 10},
+			{URL: "tcp://10.0.0.2:5433", Weight: 0, Tier: 10},
+			{URL: "tcp://[2001:db8::3]:5432", Weight: 1, Tier: 20},
+		}, targets, "glue %v", glue)
+	}
+}
+
+func TestDNS_TruncatedAnswersRetryOverTCP(t *testing.T) {
+	s := newDNSServer(t)
+	s.update(func() { s.truncate = true })
+	s.set("api.test.", dnsmessage.TypeA, a("api.test.", "10.0.0.1", 30))
+
+	d := discovery.NewDNS("api", dnsCfg(s, config.DNSDiscoveryCfg{Name: "api.test", Port: 80, Record: "a"}))
+	targets, _, err := d.Resolve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://10.0.0.1:80"}, urls(targets))
+	assert.Equal(t, int64(1), s.udpQueries.Load())
+	assert.Equal(t, int64(1), s.tcpQueries.Load())
+}
+
+func TestDNS_MissingNameHasNoTargetsButFailuresAreErrors(t *testing.T) {
+	s := newDNSServer(t)
+	d := discovery.NewDNS("api", dnsCfg(s, config.DNSDiscoveryCfg{Name: "gone.test", Port: 80}))
+	targets, ttl, err := d.Resolve(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, targets)
+	assert.Equal(t, 7*time.Second, ttl, "the SOA's negative TTL")
+
+	s.update(func() { s.rcode = dnsmessage.RCodeServerFailure })
+	_, _, err = d.Resolve(context.Background())
+	assert.ErrorContains(t, err, "RCodeServerFailure")
+}
+
+// ── Watching ──────────────────────────────────────────────────────────────────
+
+func TestDNS_RunReportsChanges(t *testing.T) {
+	s := newDNSServer(t)
+	s.set("api.test.", dnsmessage.TypeA, a("api.test.", "10.0.0.1", 1))
+	d := discovery.NewDNS("api", dnsCfg(s, config.DNSDiscoveryCfg{Name: "api.test", Port: 80, Record: "a"}))
+
+	updates := make(chan []string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx, func(ts []discovery.Target) { updates <- urls(ts) })
+		close(done)
+	}()
+	defer func() { cancel(); <-done }()
+
+	assert.Equal(t, []string{"http://10.0.0.1:80"}, <-updates)
+	s.set("api.test.", dnsmessage.TypeA, a("api.test.", "10.0.0.1", 1), a("api.test.", "10.0.0.2", 1))
+	select {
+	case got := <-updates:
+		assert.Equal(t, []string{"http://10.0.0.1:80", "http://10.0.0.2:80"}, got, "re-resolved when the TTL ran out")
+	case <-time.After(3 * time.Second):
+		t.Fatal("no update after the TTL")
+	}
+
+	// A failing server keeps the last set.
+	s.update(func() { s.rcode = dnsmessage.RCodeServerFailure })
+	select {
+	case got := <-updates:
+		t.Fatalf("unexpected update %v", got)
+	case <-time.After(1500 * time.Millisecond):
+	}
+}
@@ -0,0 +1,220 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.yaml.in/yaml/v3"
+
+	"golb/internal/config"
+)
+
+// settleDelay lets a burst of file events, such as an editor's truncate and
+// write, pass before the files are read.
+const settleDelay = 100 * time.Millisecond
+
+// File discovers backends from target files in the Prometheus file_sd
+// format, JSON or YAML by extension:
+//
+//	[{"targets": ["10.0.0.1:8080", "10.0.0.2:8080"], "labels": {"zone": "eu-west-1a"}, "weight": 3}]
+//
+// Each target becomes a backend with the group's labels and weight. The
+// files' directories are watched, and every file matching the patterns is
+// read again when one of them changes, and at the refresh interval. If any
+// file is invalid the whole read is rejected and the last set stays.
+type File struct {
+	pool     string
+	patterns []string
+	scheme   string
+	interval time.Duration
+}
+
+// targetGroup is one entry of a target file.
+type targetGroup struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+	Weight  int               `json:"weight" yaml:"weight"` // extension of file_sd; default 1
+}
+
+// NewFile returns the file provider of the named pool.
+func NewFile(pool string, cfg config.DiscoveryCfg) *File {
+	return &File{
+		pool:     pool,
+		patterns: cfg.File.Files,
+		scheme:   cfg.ParsedScheme(),
+		interval: cfg.File.ParsedInterval(),
+	}
+}
+
+// Run reads the files and reports their targets, then again on each change,
+// until ctx is done.
+func (f *File) Run(ctx context.Context, update func([]Target)) {
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	if watcher, err := fsnotify.NewWatcher(); err != nil {
+		slog.Warn("discovery: target files not watched", "pool", f.pool, "error", err)
+	} else {
+		defer watcher.Close()
+		for _, dir := range f.dirs() {
+			if err := watcher.Add(dir); err != nil {
+				slog.Warn("discovery: target directory not watched", "pool", f.pool, "dir", dir, "error", err)
+			}
+		}
+		events, errs = watcher.Events, watcher.Errors
+	}
+
+	var last []Target
+	first := true
+	load := func() {
+		targets, err := f.Load()
+		if err != nil {
+			slog.Warn("discovery: target files rejected", "pool", f.pool, "error", err)
+			return
+		}
+		if first || !sameTargets(targets, last) {
+			update(targets)
+			last, first = targets, false
+		}
+	}
+	load()
+
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				events = nil
+			} else if f.matches(ev.Name) {
+				settle = time.After(settleDelay)
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+			} else {
+				slog.Warn("discovery: watching target files", "pool", f.pool, "error", err)
+			}
+		case <-settle:
+			settle = nil
+			load()
+		case <-ticker.C:
+			load()
+		}
+	}
+}
+
+// Load reads every file matching the patterns and returns their targets,
+// sorted by URL. No matching file means no targets.
+func (f *File) Load() ([]Target, error) {
+	var files []string
+	for _, pattern := range f.patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			if !slices.Contains(files, m) {
+				files = append(files, m)
+			}
+		}
+	}
+	var targets []Target
+	for _, name := range files {
+		ts, err := f.readFile(name)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, ts...)
+	}
+	slices.SortStableFunc(targets, func(a, b Target) int { return strings.Compare(a.URL, b.URL) })
+	return targets, nil
+}
+
+func (f *File) readFile(name string) ([]Target, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var groups []targetGroup
+	if filepath.Ext(name) == ".json" {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&groups)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err = dec.Decode(&groups); err == io.EOF {
+			err = nil // an empty file has no targets
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	var targets []Target
+	for i, g := range groups {
+		if g.Weight < 0 {
+			return nil, fmt.Errorf("%s: group %d: weight must not be negative", name, i)
+		}
+		for key := range g.Labels {
+			if key == "" {
+				return nil, fmt.Errorf("%s: group %d: empty label name", name, i)
+			}
+		}
+		labels := g.Labels
+		if len(labels) == 0 {
+			labels = nil
+		}
+		for _, t := range g.Targets {
+			host, port, err := net.SplitHostPort(t)
+			if err != nil {
+				return nil, fmt.Errorf("%s: group %d: target %q must be host:port", name, i, t)
+			}
+			if n, err := strconv.Atoi(port); host == "" || err != nil || n < 1 || n > 65535 {
+				return nil, fmt.Errorf("%s: group %d: target %q must be host:port", name, i, t)
+			}
+			targets = append(targets, Target{
+				URL:    f.scheme + "://" + net.JoinHostPort(host, port),
+				Weight: max(g.Weight, 1),
+				Labels: labels,
+			})
+		}
+	}
+	return targets, nil
+}
+
+// dirs returns the directories holding the patterns' files.
+func (f *File) dirs() []string {
+	var dirs []string
+	for _, pattern := range f.patterns {
+		if dir := filepath.Dir(pattern); !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// matches reports whether name is, or was, one of the target files.
+func (f *File) matches(name string) bool {
+	for _, pattern := range f.patterns {
+		if ok, _ := filepath.Match(filepath.Clean(pattern), filepath.Clean(name)); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package discovery_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"golb/internal/config"
+	"golb/internal/discovery"
+)
+
+func fileCfg(patterns ...string) config.DiscoveryCfg {
+	return config.DiscoveryCfg{Type: "file", File: config.FileDiscoveryCfg{Files: patterns}}
+}
+
+func writeFile(t *testing.T, name, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(name, []byte(content), 0o644))
+}
+
+// ── Reading ───────────────────────────────────────────────────────────────────
+
+func TestFile_LoadsJSONAndYAMLTargetGroups(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "web.json"), `[
+		{"targets": ["10.0.0.2:8080", "10.0.0.1:8080"], "labels": {"zone": "a"}, "weight": 3}
+	]`)
+	writeFile(t, filepath.Join(dir, "more.yaml"), `
+- targets: ["[2001:db8::1]:8080"]
+  labels: {zone: b, rack: r7}
+- targets: ["web-3.internal:8080"]
+`)
+	writeFile(t, filepath.Join(dir, "ignored.txt"), "not a target file")
+
+	f := discovery.NewFile("web", fileCfg(filepath.Join(dir, "*.json"), filepath.Join(dir, "*.yaml")))
+	targets, err := f.Load()
+	require.NoError(t, err)
+	assert.Equal(t, []discovery.Target{
+		{URL: "http://10.0.0.1:8080", Weight: 3, Labels: map[string]string{"zone": "a"}},
+		{URL: "http://10.0.0.2:8080", Weight: 3, Labels: map[string]string{"zone": "a"}},
+		{URL: "http://[2001:db8::1]:8080", Weight: 1, Labels: map[string]string{"zone": "b", "rack": "r7"}},
+		{URL: "http://web-3.internal:8080", Weight: 1},
+	}, targets)
+
+	none := discovery.NewFile("web", fileCfg(filepath.Join(dir, "missing", "*.json")))
+	targets, err = none.Load()
+	require.NoError(t, err)
+	assert.Empty(t, targets, "no file means no targets")
+}
+
+func TestFile_InvalidFilesAreRejected(t *testing.T) {
+	for name, content := range map[string]string{
+		"not json":       `[{"targets": ["10.0.0.1:80"]`,
+		"unknown field":  `[{"targets": ["10.0.0.1:80"], "hosts": ["x"]}]`,
+		"no port":        `[{"targets": ["10.0.0.1"]}]`,
+		"bad port":       `[{"targets": ["10.0.0.1:http"]}]`,
+		"no host":        `[{"targets": [":80"]}]`,
+		"negative":       `[{"targets": ["10.0.0.1:80"], "weight": -1}]`,
+		"empty label":    `[{"targets": ["10.0.0.1:80"], "labels": {"": "x"}}]`,
+		"not a list":     `{"targets": ["10.0.0.1:80"]}`,
+		"string targets": `[{"targets": "10.0.0.1:80"}]`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, filepath.Join(dir, "good.json"), `[{"targets": ["10.0.0.9:80"]}]`)
+			writeFile(t, filepath.Join(dir, "bad.json"), content)
+			_, err := discovery.NewFile("web", fileCfg(filepath.Join(dir, "*.json"))).Load()
+			assert.ErrorContains(t, err, "bad.json")
+		})
+	}
+}
+
+// ── Watching ──────────────────────────────────────────────────────────────────
+
+func TestFile_RunFollowsChangesAndIgnoresInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "targets.yml")
+	writeFile(t, name, "- targets: [\"10.0.0.1:80\"]\n")
+
+	cfg := fileCfg(filepath.Join(dir, "*.yml"))
+	cfg.Scheme = "tcp"
+	f := discovery.NewFile("db", cfg)
+	updates := make(chan []string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.Run(ctx, func(ts []discovery.Target) { updates <- urls(ts) })
+		close(done)
+	}()
+	defer func() { cancel(); <-done }()
+
+	next := func(msg string) []string {
+		t.Helper()
+		select {
+		case got := <-updates:
+			return got
+		case <-time.After(3 * time.Second):
+			t.Fatal(msg)
+			return nil
+		}
+	}
+	assert.Equal(t, []string{"tcp://10.0.0.1:80"}, next("no initial update"))
+
+	writeFile(t, name, "- targets: [\"10.0.0.1:80\", \"10.0.0.2:80\"]\n")
+	assert.Equal(t, []string{"tcp://10.0.0.1:80", "tcp://10.0.0.2:80"}, next("no update after a write"))
+
+	// A broken file keeps the live set.
+	writeFile(t, name, "- targets: [\"10.0.0.3\"]\n")
+	select {
+	case got := <-updates:
+		t.Fatalf("unexpected update %v", got)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// So do unrelated files.
+	writeFile(t, filepath.Join(dir, "notes.txt"), "- targets: [\"10.0.0.4:80\"]\n")
+
+	writeFile(t, filepath.Join(dir, "extra.yml"), "- targets: [\"10.0.0.5:80\"]\n")
+	writeFile(t, name, "")
+	assert.Equal(t, []string{"tcp://10.0.0.5:80"}, next("no update after a new file"))
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "extra.yml")))
+	assert.Empty(t, next("no update after a removal"))
+}
@@ -0,0 +1,243 @@
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// serviceAccountDir holds the credentials Kubernetes mounts into every pod.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubeClient makes authenticated requests to the Kubernetes API.
+type kubeClient struct {
+	server    string // base URL, e.g. https://10.96.0.1:443
+	client    *http.Client
+	token     string
+	tokenFile string // read on every request, as projected tokens rotate
+	namespace string // of the pod or the kubeconfig context; may be empty
+}
+
+// kubeStatusError is a response other than 200 OK.
+type kubeStatusError struct {
+	code int
+	msg  string
+}
+
+func (e *kubeStatusError) Error() string {
+	return fmt.Sprintf("kubernetes: %d %s: %s", e.code, http.StatusText(e.code), e.msg)
+}
+
+// newKubeClient returns a client configured from the kubeconfig file at path
+// and the named context, or from the pod's service account when path is
+// empty.
+func newKubeClient(path, contextName string) (*kubeClient, error) {
+	if path == "" {
+		return inClusterClient()
+	}
+	return kubeconfigClient(path, contextName)
+}
+
+func inClusterClient() (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("kubernetes: not running in a cluster; set a kubeconfig")
+	}
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca) {
+		return nil, errors.New("kubernetes: no certificates in the service account's ca.crt")
+	}
+	namespace, _ := os.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+	return &kubeClient{
+		server:    "https://" + net.JoinHostPort(host, port),
+		client:    kubeHTTPClient(&tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}),
+		tokenFile: filepath.Join(serviceAccountDir, "token"),
+		namespace: strings.TrimSpace(string(namespace)),
+	}, nil
+}
+
+// kubeconfig is the part of a kubeconfig file that is used.
+type kubeconfig struct {
+	CurrentContext string              `yaml:"current-context"`
+	Clusters       []kubeconfigCluster `yaml:"clusters"`
+	Users          []kubeconfigUser    `yaml:"users"`
+	Contexts       []kubeconfigContext `yaml:"contexts"`
+}
+
+type kubeconfigCluster struct {
+	Name    string `yaml:"name"`
+	Cluster struct {
+		Server                   string `yaml:"server"`
+		CertificateAuthority     string `yaml:"certificate-authority"`
+		CertificateAuthorityData string `yaml:"certificate-authority-data"`
+		InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+	} `yaml:"cluster"`
+}
+
+type kubeconfigUser struct {
+	Name string `yaml:"name"`
+	User struct {
+		Token                 string    `yaml:"token"`
+		TokenFile             string    `yaml:"tokenFile"`
+		ClientCertificate     string    `yaml:"client-certificate"`
+		ClientCertificateData string    `yaml:"client-certificate-data"`
+		ClientKey             string    `yaml:"client-key"`
+		ClientKeyData         string    `yaml:"client-key-data"`
+		Exec                  yaml.Node `yaml:"exec"`
+		AuthProvider          yaml.Node `yaml:"auth-provider"`
+	} `yaml:"user"`
+}
+
+type kubeconfigContext struct {
+	Name    string `yaml:"name"`
+	Context struct {
+		Cluster   string `yaml:"cluster"`
+		User      string `yaml:"user"`
+		Namespace string `yaml:"namespace"`
+	} `yaml:"context"`
+}
+
+// kubeconfigClient reads the named context of a kubeconfig file, or its
+// current context. Tokens and client certificates are supported; exec and
+// auth-provider credential plugins are not.
+func kubeconfigClient(path, contextName string) (*kubeClient, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes: %w", err)
+	}
+	var kc kubeconfig
+	if err := yaml.Unmarshal(data, &kc); err != nil {
+		return nil, fmt.Errorf("kubernetes: %s: %w", path, err)
+	}
+	if contextName == "" {
+		contextName = kc.CurrentContext
+	}
+	ctxIdx := slices.IndexFunc(kc.Contexts, func(c kubeconfigContext) bool { return c.Name == contextName })
+	if ctxIdx < 0 {
+		return nil, fmt.Errorf("kubernetes: %s: no context %q", path, contextName)
+	}
+	kctx := kc.Contexts[ctxIdx].Context
+	clusterIdx := slices.IndexFunc(kc.Clusters, func(c kubeconfigCluster) bool { return c.Name == kctx.Cluster })
+	if clusterIdx < 0 {
+		return nil, fmt.Errorf("kubernetes: %s: no cluster %q", path, kctx.Cluster)
+	}
+	cluster := kc.Clusters[clusterIdx].Cluster
+	if u, err := url.Parse(cluster.Server); err != nil || u.Host == "" {
+		return nil, fmt.Errorf("kubernetes: %s: cluster %q has no valid server", path, kctx.Cluster)
+	}
+
+	// Relative file references are relative to the kubeconfig file.
+	dir := filepath.Dir(path)
+	load := func(file, b64 string) ([]byte, error) {
+		if b64 != "" {
+			return base64.StdEncoding.DecodeString(b64)
+		}
+		if file == "" {
+			return nil, nil
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		return os.ReadFile(file)
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: cluster.InsecureSkipTLSVerify}
+	ca, err := load(cluster.CertificateAuthority, cluster.CertificateAuthorityData)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes: %s: certificate authority: %w", path, err)
+	}
+	if ca != nil {
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("kubernetes: %s: no certificates in the certificate authority", path)
+		}
+	}
+
+	c := &kubeClient{server: strings.TrimSuffix(cluster.Server, "/"), namespace: kctx.Namespace}
+	if userIdx := slices.IndexFunc(kc.Users, func(u kubeconfigUser) bool { return u.Name == kctx.User }); userIdx >= 0 {
+		user := kc.Users[userIdx].User
+		if !user.Exec.IsZero() || !user.AuthProvider.IsZero() {
+			return nil, fmt.Errorf("kubernetes: %s: user %q uses a credential plugin, which is not supported", path, kctx.User)
+		}
+		c.token = user.Token
+		if user.TokenFile != "" {
+			c.tokenFile = user.TokenFile
+			if !filepath.IsAbs(c.tokenFile) {
+				c.tokenFile = filepath.Join(dir, c.tokenFile)
+			}
+		}
+		certPEM, err := load(user.ClientCertificate, user.ClientCertificateData)
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes: %s: client certificate: %w", path, err)
+		}
+		keyPEM, err := load(user.ClientKey, user.ClientKeyData)
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes: %s: client key: %w", path, err)
+		}
+		if certPEM != nil || keyPEM != nil {
+			cert, err := tls.X509KeyPair(certPEM, keyPEM)
+			if err != nil {
+				return nil, fmt.Errorf("kubernetes: %s: client certificate: %w", path, err)
+			}
+			cfg.Certificates = []tls.Certificate{cert}
+		}
+	}
+	c.client = kubeHTTPClient(cfg)
+	return c, nil
+}
+
+// kubeHTTPClient returns a client without an overall timeout, since watches
+// stay open; the server ends them after their timeoutSeconds.
+func kubeHTTPClient(cfg *tls.Config) *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = cfg
+	return &http.Client{Transport: t}
+}
+
+// get requests path with query q. The caller closes the body of a 200
+// response; other statuses are returned as a *kubeStatusError.
+func (c *kubeClient) get(ctx context.Context, path string, q url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.server+path+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	token := c.token
+	if c.tokenFile != "" {
+		b, err := os.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes: %w", err)
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, &kubeStatusError{code: resp.StatusCode, msg: strings.TrimSpace(string(body))}
+	}
+	return resp, nil
+}
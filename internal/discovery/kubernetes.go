@@ -0,0 +1,331 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golb/internal/config"
+)
+
+// Failover tiers of Kubernetes endpoints.
+const (
+	tierLocal    = 0 // ready, and hinted for the gateway's zone or unhinted
+	tierRemote   = 1 // ready, hinted for other zones
+	tierDraining = 2 // terminating but still serving
+)
+
+// watchTimeout is how long the API server keeps a watch open before the
+// provider resumes it.
+const watchTimeout = 5 * time.Minute
+
+// errWatchExpired means the resource version a watch resumes from is gone,
+// and the slices must be listed again.
+var errWatchExpired = errors.New("kubernetes: watch expired")
+
+// Kubernetes discovers backends from the EndpointSlices of a Service. It
+// lists them, then watches them for changes, so the pool follows pods as
+// they become ready, start terminating and go away.
+//
+// Endpoints map onto failover tiers. Ready endpoints serve. Terminating
+// endpoints that still serve are drained: they stay in the pool, in a tier
+// of their own that only takes requests when no ready endpoint is left, and
+// leave it once they stop serving. With a zone configured and topology hints
+// on every endpoint, as kube-proxy requires, ready endpoints hinted for
+// other zones form a tier behind those hinted for the gateway's zone.
+type Kubernetes struct {
+	pool        string
+	service     string
+	namespace   string
+	port        string
+	zone        string
+	scheme      string
+	kubeconfig  string
+	contextName string
+}
+
+// endpointSlice is the part of a discovery.k8s.io/v1 EndpointSlice that is
+// used.
+type endpointSlice struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Endpoints []struct {
+		Addresses  []string `json:"addresses"`
+		Conditions struct {
+			Ready       *bool `json:"ready"`
+			Serving     *bool `json:"serving"`
+			Terminating *bool `json:"terminating"`
+		} `json:"conditions"`
+		Hints *struct {
+			ForZones []struct {
+				Name string `json:"name"`
+			} `json:"forZones"`
+		} `json:"hints"`
+		NodeName  string `json:"nodeName"`
+		Zone      string `json:"zone"`
+		TargetRef *struct {
+			Kind string `json:"kind"`
+			Name string `json:"name"`
+		} `json:"targetRef"`
+	} `json:"endpoints"`
+	Ports []struct {
+		Name *string `json:"name"`
+		Port *int    `json:"port"`
+	} `json:"ports"`
+}
+
+// NewKubernetes returns the Kubernetes provider of the named pool.
+func NewKubernetes(pool string, cfg config.DiscoveryCfg) *Kubernetes {
+	k := cfg.Kubernetes
+	return &Kubernetes{
+		pool:        pool,
+		service:     k.Service,
+		namespace:   k.Namespace,
+		port:        k.Port,
+		zone:        k.Zone,
+		scheme:      cfg.ParsedScheme(),
+		kubeconfig:  k.Kubeconfig,
+		contextName: k.Context,
+	}
+}
+
+// Run lists and watches the Service's EndpointSlices until ctx is done,
+// reporting each new target set.
+func (k *Kubernetes) Run(ctx context.Context, update func([]Target)) {
+	var last []Target
+	first := true
+	report := func(known map[string]endpointSlice) {
+		targets := k.targets(known)
+		if first || !sameTargets(targets, last) {
+			update(targets)
+			last, first = targets, false
+		}
+	}
+	for {
+		err := k.watch(ctx, report)
+		if ctx.Err() != nil {
+			return
+		}
+		wait := retryDelay
+		if errors.Is(err, errWatchExpired) {
+			wait = 0
+		} else {
+			slog.Warn("discovery: kubernetes watch failed", "pool", k.pool, "service", k.service, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// watch lists the slices and reports them, then follows their changes until
+// the watch fails or ctx is done.
+func (k *Kubernetes) watch(ctx context.Context, report func(map[string]endpointSlice)) error {
+	client, err := newKubeClient(k.kubeconfig, k.contextName)
+	if err != nil {
+		return err
+	}
+	namespace := k.namespace
+	if namespace == "" {
+		namespace = client.namespace
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+	path := "/apis/discovery.k8s.io/v1/namespaces/" + url.PathEscape(namespace) + "/endpointslices"
+	selector := "kubernetes.io/service-name=" + k.service
+
+	resp, err := client.get(ctx, path, url.Values{"labelSelector": {selector}})
+	if err != nil {
+		return err
+	}
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []endpointSlice `json:"items"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("kubernetes: list: %w", err)
+	}
+	known := make(map[string]endpointSlice, len(list.Items))
+	for _, s := range list.Items {
+		known[s.Metadata.Name] = s
+	}
+	report(known)
+
+	version := list.Metadata.ResourceVersion
+	for {
+		resp, err := client.get(ctx, path, url.Values{
+			"labelSelector":       {selector},
+			"watch":               {"true"},
+			"resourceVersion":     {version},
+			"allowWatchBookmarks": {"true"},
+			"timeoutSeconds":      {strconv.Itoa(int(watchTimeout.Seconds()))},
+		})
+		var status *kubeStatusError
+		if errors.As(err, &status) && status.code == http.StatusGone {
+			return errWatchExpired
+		}
+		if err != nil {
+			return err
+		}
+		version, err = k.follow(resp.Body, version, known, report)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// follow applies the events of one watch to the known slices until the
+// server ends it, and returns the resource version to resume from.
+func (k *Kubernetes) follow(body io.Reader, version string, known map[string]endpointSlice, report func(map[string]endpointSlice)) (string, error) {
+	dec := json.NewDecoder(body)
+	for {
+		var event struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := dec.Decode(&event); errors.Is(err, io.EOF) {
+			return version, nil
+		} else if err != nil {
+			return version, fmt.Errorf("kubernetes: watch: %w", err)
+		}
+		if event.Type == "ERROR" {
+			var status struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}
+			_ = json.Unmarshal(event.Object, &status)
+			if status.Code == http.StatusGone {
+				return version, errWatchExpired
+			}
+			return version, fmt.Errorf("kubernetes: watch: %d %s", status.Code, status.Message)
+		}
+		var s endpointSlice
+		if err := json.Unmarshal(event.Object, &s); err != nil {
+			return version, fmt.Errorf("kubernetes: watch: %w", err)
+		}
+		version = s.Metadata.ResourceVersion
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			known[s.Metadata.Name] = s
+		case "DELETED":
+			delete(known, s.Metadata.Name)
+		default: // BOOKMARK only moves the resource version
+			continue
+		}
+		report(known)
+	}
+}
+
+// targets returns the backends of the known slices' serving endpoints,
+// sorted by URL, on their tiers.
+func (k *Kubernetes) targets(known map[string]endpointSlice) []Target {
+	type endpoint struct {
+		target   Target
+		zones    []string // hinted for
+		draining bool
+	}
+	var endpoints []endpoint
+	useHints := k.zone != ""
+	for _, name := range slices.Sorted(maps.Keys(known)) {
+		s := known[name]
+		port, ok := k.slicePort(s)
+		if !ok {
+			slog.Warn("discovery: kubernetes slice has no matching port", "pool", k.pool, "slice", name, "port", k.port)
+			continue
+		}
+		for _, ep := range s.Endpoints {
+			c := ep.Conditions
+			ready := c.Ready == nil || *c.Ready
+			serving := ready
+			if c.Serving != nil {
+				serving = *c.Serving
+			}
+			terminating := c.Terminating != nil && *c.Terminating
+			if len(ep.Addresses) == 0 || !serving || (!terminating && !ready) {
+				continue
+			}
+			labels := map[string]string{}
+			if ep.Zone != "" {
+				labels["zone"] = ep.Zone
+			}
+			if ep.NodeName != "" {
+				labels["node"] = ep.NodeName
+			}
+			if ep.TargetRef != nil && ep.TargetRef.Kind == "Pod" {
+				labels["pod"] = ep.TargetRef.Name
+			}
+			if terminating {
+				labels["state"] = "terminating"
+			}
+			e := endpoint{
+				target: Target{
+					URL:    k.scheme + "://" + net.JoinHostPort(ep.Addresses[0], strconv.Itoa(port)),
+					Weight: 1,
+					Labels: labels,
+				},
+				draining: terminating,
+			}
+			if ep.Hints != nil && len(ep.Hints.ForZones) > 0 {
+				for _, z := range ep.Hints.ForZones {
+					e.zones = append(e.zones, z.Name)
+				}
+			} else if !terminating {
+				useHints = false
+			}
+			endpoints = append(endpoints, e)
+		}
+	}
+
+	targets := make([]Target, 0, len(endpoints))
+	for _, e := range endpoints {
+		switch {
+		case e.draining:
+			e.target.Tier = tierDraining
+		case useHints && !slices.Contains(e.zones, k.zone):
+			e.target.Tier = tierRemote
+		default:
+			e.target.Tier = tierLocal
+		}
+		targets = append(targets, e.target)
+	}
+	slices.SortFunc(targets, func(a, b Target) int { return strings.Compare(a.URL, b.URL) })
+	return targets
+}
+
+// slicePort returns the port of the slice's endpoints that the pool uses.
+func (k *Kubernetes) slicePort(s endpointSlice) (int, bool) {
+	for _, p := range s.Ports {
+		if p.Port == nil {
+			continue
+		}
+		name := ""
+		if p.Name != nil {
+			name = *p.Name
+		}
+		if (k.port == "" && len(s.Ports) == 1) || k.port == name || k.port == strconv.Itoa(*p.Port) {
+			return *p.Port, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,363 @@
+package discovery_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"golb/internal/config"
+	"golb/internal/discovery"
+)
+
+// ── Fake API server ───────────────────────────────────────────────────────────
+
+// apiServer serves the EndpointSlices of the Service "web" in one namespace
+// over TLS, requiring a bearer token: lists, and watches fed by apply.
+type apiServer struct {
+	*httptest.Server
+	namespace string
+
+	mu       sync.Mutex
+	version  int
+	slices   map[string]map[string]any
+	history  [][]byte // event i moved the version to 101+i
+	watchers []chan []byte
+	expire   bool // the next watch answers 410 Gone
+	watches  []string
+	lists    int
+}
+
+func newAPIServer(t *testing.T, namespace string) *apiServer {
+	t.Helper()
+	s := &apiServer{namespace: namespace, version: 100, slices: map[string]map[string]any{}}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// kubeconfig writes a kubeconfig for the server, whose current context is
+// named "test" and has the given namespace.
+func (s *apiServer) kubeconfig(t *testing.T, namespace string) string {
+	t.Helper()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	require.NoError(t, os.WriteFile(path, fmt.Appendf(nil, `apiVersion: v1
+kind: Config
+current-context: test
+clusters:
+  - name: kind
+    cluster:
+      server: %s
+      certificate-authority-data: %s
+users:
+  - name: gateway
+    user:
+      token: s3cret
+contexts:
+  - name: other
+    context: {cluster: kind, user: nobody}
+  - name: test
+    context: {cluster: kind, user: gateway, namespace: %q}
+`, s.URL, base64.StdEncoding.EncodeToString(ca), namespace), 0o600))
+	return path
+}
+
+// apply stores or deletes a slice and sends the event to open watches.
+func (s *apiServer) apply(eventType string, slice map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+	meta := slice["metadata"].(map[string]any)
+	meta["resourceVersion"] = strconv.Itoa(s.version)
+	if eventType == "DELETED" {
+		delete(s.slices, meta["name"].(string))
+	} else {
+		s.slices[meta["name"].(string)] = slice
+	}
+	event, _ := json.Marshal(map[string]any{"type": eventType, "object": slice})
+	s.history = append(s.history, event)
+	for _, w := range s.watchers {
+		w <- event
+	}
+}
+
+func (s *apiServer) expireNextWatch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire = true
+	for _, w := range s.watchers {
+		close(w)
+	}
+	s.watchers = nil
+}
+
+func (s *apiServer) recorded() (lists int, watches []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lists, slices.Clone(s.watches)
+}
+
+func (s *apiServer) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer s3cret" {
+		http.Error(w, `{"kind":"Status","code":401}`, http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Path != "/apis/discovery.k8s.io/v1/namespaces/"+s.namespace+"/endpointslices" {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("labelSelector") != "kubernetes.io/service-name=web" {
+		http.Error(w, "unexpected selector", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	if q.Get("watch") != "true" {
+		s.lists++
+		items := []any{}
+		for _, name := range slices.Sorted(maps.Keys(s.slices)) {
+			items = append(items, s.slices[name])
+		}
+		list := map[string]any{"kind": "EndpointSliceList", "metadata": map[string]any{"resourceVersion": strconv.Itoa(s.version)}, "items": items}
+		s.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(list)
+		return
+	}
+	s.watches = append(s.watches, q.Get("resourceVersion"))
+	if s.expire {
+		s.expire = false
+		s.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintln(w, `{"type":"ERROR","object":{"kind":"Status","code":410,"message":"too old resource version"}}`)
+		return
+	}
+	// Replay what happened since the version the watch resumes from.
+	events := make(chan []byte, 16)
+	from, _ := strconv.Atoi(q.Get("resourceVersion"))
+	for _, event := range s.history[max(from-100, 0):] {
+		events <- event
+	}
+	s.watchers = append(s.watchers, events)
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			_, _ = w.Write(append(event, '\n'))
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// ep describes one endpoint of a slice.
+type ep struct {
+	ip, pod, zone string
+	ready         bool
+	serving       bool
+	terminating   bool
+	hints         []string
+}
+
+func slice(name string, ports map[string]int, eps ...ep) map[string]any {
+	var endpoints []any
+	for _, e := range eps {
+		m := map[string]any{
+			"addresses":  []string{e.ip},
+			"conditions": map[string]any{"ready": e.ready, "serving": e.serving, "terminating": e.terminating},
+			"nodeName":   "node-" + e.zone,
+			"zone":       e.zone,
+			"targetRef":  map[string]any{"kind": "Pod", "name": e.pod, "namespace": "shop"},
+		}
+		if e.hints != nil {
+			var zones []any
+			for _, z := range e.hints {
+				zones = append(zones, map[string]string{"name": z})
+			}
+			m["hints"] = map[string]any{"forZones": zones}
+		}
+		endpoints = append(endpoints, m)
+	}
+	var portList []any
+	for name, port := range ports {
+		portList = append(portList, map[string]any{"name": name, "port": port, "protocol": "TCP"})
+	}
+	return map[string]any{
+		"apiVersion":  "discovery.k8s.io/v1",
+		"kind":        "EndpointSlice",
+		"metadata":    map[string]any{"name": name, "namespace": "shop", "labels": map[string]string{"kubernetes.io/service-name": "web"}},
+		"addressType": "IPv4",
+		"endpoints":   endpoints,
+		"ports":       portList,
+	}
+}
+
+func ready(ip, pod, zone string, hints ...string) ep {
+	return ep{ip: ip, pod: pod, zone: zone, ready: true, serving: true, hints: hints}
+}
+
+// tiers maps each target URL to its tier.
+func tiers(targets []discovery.Target) map[string]int {
+	out := map[string]int{}
+	for _, t := range targets {
+		out[t.URL] = t.Tier
+	}
+	return out
+}
+
+func runProvider(t *testing.T, p discovery.Provider) <-chan []discovery.Target {
+	t.Helper()
+	updates := make(chan []discovery.Target, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx, func(ts []discovery.Target) { updates <- ts })
+		close(done)
+	}()
+	t.Cleanup(func() { cancel(); <-done })
+	return updates
+}
+
+func nextTargets(t *testing.T, updates <-chan []discovery.Target, msg string) []discovery.Target {
+	t.Helper()
+	select {
+	case got := <-updates:
+		return got
+	case <-time.After(3 * time.Second):
+		t.Fatal(msg)
+		return nil
+	}
+}
+
+// ── Endpoint conditions and zones ─────────────────────────────────────────────
+
+func TestKubernetes_ConditionsAndZoneHints(t *testing.T) {
+	api := newAPIServer(t, "shop")
+	api.apply("ADDED", slice("web-a", map[string]int{"http": 8080, "metrics": 9090},
+		ready("10.0.0.1", "web-1", "eu-1a", "eu-1a"),
+		ready("10.0.0.2", "web-2", "eu-1b", "eu-1b"),
+		ep{ip: "10.0.0.3", pod: "web-3", zone: "eu-1a", ready: false, serving: false},                    // starting
+		ep{ip: "10.0.0.4", pod: "web-4", zone: "eu-1a", ready: false, serving: true, terminating: true},  // draining
+		ep{ip: "10.0.0.5", pod: "web-5", zone: "eu-1b", ready: false, serving: false, terminating: true}, // gone
+	))
+
+	cfg := config.DiscoveryCfg{Type: "kubernetes", Kubernetes: config.KubernetesDiscoveryCfg{
+		Service: "web", Port: "http", Zone: "eu-1a", Kubeconfig: api.kubeconfig(t, "shop"),
+	}}
+	updates := runProvider(t, discovery.NewKubernetes("web", cfg))
+	targets := nextTargets(t, updates, "no initial update")
+	assert.Equal(t, map[string]int{
+		"http://10.0.0.1:8080": 0,
+		"http://10.0.0.2:8080": 1,
+		"http://10.0.0.4:8080": 2,
+	}, tiers(targets), "own zone first, then other zones, then draining pods")
+	assert.Equal(t, map[string]string{"zone": "eu-1a", "node": "node-eu-1a", "pod": "web-1"}, targets[0].Labels)
+	assert.Equal(t, "terminating", targets[2].Labels["state"])
+
+	// An unhinted endpoint disables zone routing, as in kube-proxy.
+	api.apply("ADDED", slice("web-b", map[string]int{"http": 8081}, ready("10.0.1.1", "web-6", "eu-1c")))
+	assert.Equal(t, map[string]int{
+		"http://10.0.0.1:8080": 0,
+		"http://10.0.0.2:8080": 0,
+		"http://10.0.0.4:8080": 2,
+		"http://10.0.1.1:8081": 0,
+	}, tiers(nextTargets(t, updates, "no update for a new slice")))
+}
+
+func TestKubernetes_PortSelection(t *testing.T) {
+	api := newAPIServer(t, "default")
+	api.apply("ADDED", slice("web-a", map[string]int{"": 8080}, ready("10.0.0.1", "web-1", "a")))
+	api.apply("ADDED", slice("web-b", map[string]int{"http": 80, "grpc": 9000}, ready("10.0.0.2", "web-2", "a")))
+	kubeconfig := api.kubeconfig(t, "")
+
+	for port, want := range map[string][]string{
+		"":     {"tcp://10.0.0.1:8080"}, // a slice with several ports needs one chosen
+		"9000": {"tcp://10.0.0.2:9000"},
+		"grpc": {"tcp://10.0.0.2:9000"},
+	} {
+		cfg := config.DiscoveryCfg{Type: "kubernetes", Scheme: "tcp", Kubernetes: config.KubernetesDiscoveryCfg{
+			Service: "web", Port: port, Kubeconfig: kubeconfig,
+		}}
+		updates := runProvider(t, discovery.NewKubernetes("web", cfg))
+		assert.Equal(t, want, urls(nextTargets(t, updates, "no update")), "port %q", port)
+	}
+}
+
+// ── Watching ──────────────────────────────────────────────────────────────────
+
+func TestKubernetes_WatchDrainsTerminatingPods(t *testing.T) {
+	api := newAPIServer(t, "shop")
+	api.apply("ADDED", slice("web-a", map[string]int{"http": 8080},
+		ready("10.0.0.1", "web-1", "a"), ready("10.0.0.2", "web-2", "a")))
+
+	cfg := config.DiscoveryCfg{Type: "kubernetes", Kubernetes: config.KubernetesDiscoveryCfg{
+		Service: "web", Namespace: "shop", Kubeconfig: api.kubeconfig(t, "elsewhere"),
+	}}
+	updates := runProvider(t, discovery.NewKubernetes("web", cfg))
+	assert.Equal(t, []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"}, urls(nextTargets(t, updates, "no initial update")))
+
+	// A terminating pod still serving stays in the pool, behind the ready ones.
+	api.apply("MODIFIED", slice("web-a", map[string]int{"http": 8080},
+		ready("10.0.0.1", "web-1", "a"),
+		ep{ip: "10.0.0.2", pod: "web-2", zone: "a", serving: true, terminating: true}))
+	assert.Equal(t, map[string]int{"http://10.0.0.1:8080": 0, "http://10.0.0.2:8080": 2},
+		tiers(nextTargets(t, updates, "no update when the pod started terminating")))
+
+	// Once it stops serving it leaves.
+	api.apply("MODIFIED", slice("web-a", map[string]int{"http": 8080},
+		ready("10.0.0.1", "web-1", "a"),
+		ep{ip: "10.0.0.2", pod: "web-2", zone: "a", terminating: true}))
+	assert.Equal(t, []string{"http://10.0.0.1:8080"}, urls(nextTargets(t, updates, "no update when the pod stopped serving")))
+
+	// An expired watch lists again.
+	api.expireNextWatch()
+	api.apply("ADDED", slice("web-b", map[string]int{"http": 8080}, ready("10.0.1.1", "web-3", "b")))
+	assert.Equal(t, []string{"http://10.0.0.1:8080", "http://10.0.1.1:8080"}, urls(nextTargets(t, updates, "no update after the relist")))
+
+	api.apply("DELETED", slice("web-a", nil))
+	assert.Equal(t, []string{"http://10.0.1.1:8080"}, urls(nextTargets(t, updates, "no update for a deleted slice")))
+
+	lists, watches := api.recorded()
+	assert.GreaterOrEqual(t, lists, 2)
+	assert.Equal(t, "101", watches[0], "the watch resumes from the list's version")
+}
+
+func TestKubernetes_CredentialPluginsAreRejected(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	require.NoError(t, os.WriteFile(path, []byte(`
+current-context: eks
+clusters: [{name: eks, cluster: {server: "https://127.0.0.1:1"}}]
+users: [{name: aws, user: {exec: {command: aws, args: [eks, get-token]}}}]
+contexts: [{name: eks, context: {cluster: eks, user: aws}}]
+`), 0o600))
+	cfg := config.DiscoveryCfg{Type: "kubernetes", Kubernetes: config.KubernetesDiscoveryCfg{Service: "web", Kubeconfig: path}}
+	updates := runProvider(t, discovery.NewKubernetes("web", cfg))
+	select {
+	case got := <-updates:
+		t.Fatalf("unexpected update %v", got)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,168 @@
+package discovery
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"reflect"
+	"sync"
+
+	"golb/internal/config"
+	"golb/internal/proxy"
+	"golb/internal/strategy"
+)
+
+// Manager runs the providers of the active routing table's discovered pools
+// and feeds what they find into the pools. It is safe to call Update while
+// providers are running.
+type Manager struct {
+	onChange func()
+
+	mu      sync.Mutex
+	watches map[string]*watch
+
+	wg sync.WaitGroup
+}
+
+// watch is a running provider and the backends it last produced.
+type watch struct {
+	cfg      config.DiscoveryCfg
+	ctx      context.Context
+	cancel   context.CancelFunc
+	pool     *proxy.Pool
+	backends []*strategy.Backend
+}
+
+// NewManager creates a Manager with no providers; call Update to start them.
+// onChange, when not nil, is called after a pool's backends change, e.g. to
+// hand the new set to the health monitor.
+func NewManager(onChange func()) *Manager {
+	return &Manager{onChange: onChange, watches: map[string]*watch{}}
+}
+
+// Update syncs the providers with cfg and table. It must be called before
+// table is installed in the Gateway, so the table goes live with the
+// backends discovered so far rather than empty pools.
+//
+// Providers whose settings are unchanged keep running and their backends,
+// with their health and counters, move to the new table's pool; new or
+// changed ones start over; those of removed pools are stopped.
+func (m *Manager) Update(cfg config.Config, table *proxy.Table) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	next := map[string]*watch{}
+	for _, pc := range cfg.ResolvedPools() {
+		pool := table.Pool(pc.Name)
+		if !pc.Discovery.Enabled() || pool == nil {
+			continue
+		}
+		if old, ok := m.watches[pc.Name]; ok && reflect.DeepEqual(old.cfg, pc.Discovery) {
+			delete(m.watches, pc.Name)
+			old.pool = pool
+			if err := pool.SetBackends(old.backends); err != nil {
+				slog.Error("discovery: backends not applied", "pool", pc.Name, "error", err)
+			}
+			next[pc.Name] = old
+			continue
+		}
+		provider, err := New(pc)
+		if err != nil {
+			slog.Error("discovery: provider not started", "pool", pc.Name, "error", err)
+			continue
+		}
+		w := &watch{cfg: pc.Discovery, pool: pool}
+		w.ctx, w.cancel = context.WithCancel(context.Background())
+		next[pc.Name] = w
+		m.wg.Add(1)
+		go func(name string) {
+			defer m.wg.Done()
+			provider.Run(w.ctx, func(targets []Target) { m.apply(name, w, targets) })
+		}(pc.Name)
+		slog.Info("discovery: provider started", "pool", pc.Name, "type", pc.Discovery.Type)
+	}
+	for _, old := range m.watches {
+		old.cancel()
+	}
+	m.watches = next
+}
+
+// apply installs the backends for targets in w's pool.
+func (m *Manager) apply(name string, w *watch, targets []Target) {
+	m.mu.Lock()
+	if w.ctx.Err() != nil {
+		m.mu.Unlock()
+		return // stopped by an Update since
+	}
+	backends, added, removed := reconcile(w.backends, targets, w.cfg.MaxConns)
+	if err := w.pool.SetBackends(backends); err != nil {
+		m.mu.Unlock()
+		slog.Error("discovery: backends not applied", "pool", name, "error", err)
+		return
+	}
+	w.backends = backends
+	m.mu.Unlock()
+
+	slog.Info("discovery: backends updated",
+		"pool", name,
+		"backends", len(backends),
+		"added", added,
+		"removed", removed,
+	)
+	if m.onChange != nil {
+		m.onChange()
+	}
+}
+
+// reconcile returns the backends for targets. Backends of current whose URL,
+// weight, tier and labels are unchanged are reused, keeping their health and
+// counters; one whose weight, tier or labels changed is replaced by a
+// backend that inherits its health and shares its active connections.
+func reconcile(current []*strategy.Backend, targets []Target, maxConns int) (out []*strategy.Backend, added, removed int) {
+	byURL := make(map[string]*strategy.Backend, len(current))
+	for _, b := range current {
+		byURL[b.RawURL] = b
+	}
+	seen := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		if seen[t.URL] {
+			continue
+		}
+		seen[t.URL] = true
+		weight := max(t.Weight, 1)
+		old, ok := byURL[t.URL]
+		if ok && old.Weight == weight && old.Tier == t.Tier && maps.Equal(old.Labels, t.Labels) {
+			out = append(out, old)
+			continue
+		}
+		b, err := strategy.NewBackend(t.URL, weight)
+		if err != nil {
+			slog.Warn("discovery: target skipped", "url", t.URL, "error", err)
+			continue
+		}
+		b.Tier, b.MaxConns, b.Labels = t.Tier, maxConns, t.Labels
+		if ok {
+			b.SetHealthy(old.IsHealthy())
+			b.ShareConns(old)
+		} else {
+			added++
+		}
+		out = append(out, b)
+	}
+	for url := range byURL {
+		if !seen[url] {
+			removed++
+		}
+	}
+	return out, added, removed
+}
+
+// Stop stops every provider and waits for them to exit.
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	for _, w := range m.watches {
+		w.cancel()
+	}
+	m.watches = map[string]*watch{}
+	m.mu.Unlock()
+	m.wg.Wait()
+}
@@ -0,0 +1,212 @@
+package discovery_test
+
+import (
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"golb/internal/config"
+	"golb/internal/discovery"
+	"golb/internal/proxy"
+	"golb/internal/strategy"
+)
+
+func discoveredCfg(s *dnsServer) config.Config {
+	d := dnsCfg(s, config.DNSDiscoveryCfg{Name: "api.test", Port: 80, Record: "a", Interval: "20ms"})
+	return config.Config{
+		Strategy: "round_robin",
+		Pools:    []config.PoolCfg{{Name: "api", Discovery: d}},
+		Routes:   []config.RouteCfg{{Name: "api", PathPrefix: "/", Pool: "api"}},
+	}
+}
+
+func backendURLs(bs []*strategy.Backend) []string {
+	out := make([]string, len(bs))
+	for i, b := range bs {
+		out[i] = b.RawURL
+	}
+	return out
+}
+
+// waitBackends waits until the pool holds exactly the backends of want.
+func waitBackends(t *testing.T, pool *proxy.Pool, want ...string) []*strategy.Backend {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(want, backendURLs(pool.Backends()))
+	}, 3*time.Second, 10*time.Millisecond, "want backends %v, have %v", want, backendURLs(pool.Backends()))
+	return pool.Backends()
+}
+
+func TestManager_ReconcilesKeepingStateOfRemainingBackends(t *testing.T) {
+	s := newDNSServer(t)
+	s.set("api.test.", dnsmessage.TypeA, a("api.test.", "10.0.0.1", 60), a("api.test.", "10.0.0.2", 60))
+	cfg := discoveredCfg(s)
+	table, err := proxy.BuildTable(cfg)
+	require.NoError(t, err)
+	pool := table.Pool("api")
+	require.True(t, pool.Discovered())
+
+	var changes atomic.Int64
+	m := discovery.NewManager(func() { changes.Add(1) })
+	defer m.Stop()
+	m.Update(cfg, table)
+
+	first := waitBackends(t, pool, "http://10.0.0.1:80", "http://10.0.0.2:80")
+	assert.Equal(t, int64(1), changes.Load())
+	first[1].SetHealthy(false)
+
+	s.set("api.test.", dnsmessage.TypeA, a("api.test.", "10.0.0.2", 60), a("api.test.", "10.0.0.3", 60))
+	next := waitBackends(t, pool, "http://10.0.0.2:80", "http://10.0.0.3:80")
+	assert.Same(t, first[1], next[0], "a remaining address keeps its backend")
+	assert.False(t, next[0].IsHealthy(), "and its health")
+	assert.True(t, next[1].IsHealthy())
+	assert.Equal(t, int64(2), changes.Load())
+
+	b, err := pool.Picker().Next()
+	require.NoError(t, err)
+	assert.Equal(t, next[1], b, "only the healthy backend serves")
+	pool.Picker().Done(b)
+}
+
+func TestManager_SRVWeightChangeReplacesBackendKeepingHealth(t *testing.T) {
+	s := newDNSServer(t)
+	s.update(func() { s.glue = true })
+	s.set("a.api.test.", dnsmessage.TypeA, a("a.api.test.", "10.0.0.1", 60))
+	s.set("_http._tcp.api.test.", dnsmessage.TypeSRV, srv("_http._tcp.api.test.", 0, 1, 80, "a.api.test.", 60))
+	cfg := discoveredCfg(s)
+	cfg.Pools[0].Discovery.DNS = config.DNSDiscoveryCfg{Name: "_http._tcp.api.test", Record: "srv", Interval: "20ms", Server: s.addr}
+	cfg.Pools[0].Discovery.MaxConns = 7
+	table, err := proxy.BuildTable(cfg)
+	require.NoError(t, err)
+	pool := table.Pool("api")
+
+	m := discovery.NewManager(nil)
+	defer m.Stop()
+	m.Update(cfg, table)
+	first := waitBackends(t, pool, "http://10.0.0.1:80")[0]
+	assert.Equal(t, 7, first.MaxConns)
+	first.SetHealthy(false)
+
+	s.set("_http._tcp.api.test.", dnsmessage.TypeSRV, srv("_http._tcp.api.test.", 0, 5, 80, "a.api.test.", 60))
+	require.Eventually(t, func() bool { return pool.Backends()[0].Weight == 5 }, 3*time.Second, 10*time.Millisecond)
+	b := pool.Backends()[0]
+	assert.NotSame(t, first, b)
+	assert.False(t, b.IsHealthy())
+	assert.Equal(t, 7, b.MaxConns)
+}
+
+func TestManager_ReplacedBackendKeepsCountingInFlightConnections(t *testing.T) {
+	s := newDNSServer(t)
+	s.update(func() { s.glue = true })
+	s.set("a.api.test.", dnsmessage.TypeA, a("a.api.test.", "10.0.0.1", 60))
+	s.set("_http._tcp.api.test.", dnsmessage.TypeSRV, srv("_http._tcp.api.test.", 0, 1, 80, "a.api.test.", 60))
+	cfg := discoveredCfg(s)
+	cfg.Pools[0].Discovery.DNS = config.DNSDiscoveryCfg{Name: "_http._tcp.api.test", Record: "srv", Interval: "20ms", Server: s.addr}
+	cfg.Pools[0].Discovery.MaxConns = 1
+	table, err := proxy.BuildTable(cfg)
+	require.NoError(t, err)
+	pool := table.Pool("api")
+
+	m := discovery.NewManager(nil)
+	defer m.Stop()
+	m.Update(cfg, table)
+	waitBackends(t, pool, "http://10.0.0.1:80")
+	held, err := pool.Picker().Next()
+	require.NoError(t, err)
+
+	s.set("_http._tcp.api.test.", dnsmessage.TypeSRV, srv("_http._tcp.api.test.", 0, 5, 80, "a.api.test.", 60))
+	require.Eventually(t, func() bool { return pool.Backends()[0].Weight == 5 }, 3*time.Second, 10*time.Millisecond)
+	b := pool.Backends()[0]
+	require.NotSame(t, held, b)
+	assert.Equal(t, int64(1), b.ActiveConns(), "the request still running on the old backend counts")
+	_, err = pool.Picker().Next()
+	assert.ErrorIs(t, err, strategy.ErrAllSaturated, "max_conns holds across the replacement")
+
+	pool.Picker().Done(held)
+	assert.Zero(t, b.ActiveConns())
+	got, err := pool.Picker().Next()
+	require.NoError(t, err)
+	assert.Same(t, b, got)
+	pool.Picker().Done(got)
+}
+
+func TestManager_ReloadMovesBackendsToTheNewTable(t *testing.T) {
+	s := newDNSServer(t)
+	s.set("api.test.", dnsmessage.TypeA, a("api.test.", "10.0.0.1", 60))
+	cfg := discoveredCfg(s)
+	table, err := proxy.BuildTable(cfg)
+	require.NoError(t, err)
+
+	m := discovery.NewManager(nil)
+	defer m.Stop()
+	m.Update(cfg, table)
+	first := waitBackends(t, table.Pool("api"), "http://10.0.0.1:80")[0]
+
+	// Unchanged settings: the new table goes live with the same backends.
+	reloaded, err := proxy.BuildTable(cfg)
+	require.NoError(t, err)
+	m.Update(cfg, reloaded)
+	require.Len(t, reloaded.Pool("api").Backends(), 1)
+	assert.Same(t, first, reloaded.Pool("api").Backends()[0])
+
+	// Changed settings start over.
+	cfg.Pools[0].Discovery.DNS.Port = 8080
+	restarted, err := proxy.BuildTable(cfg)
+	require.NoError(t, err)
+	m.Update(cfg, restarted)
+	waitBackends(t, restarted.Pool("api"), "http://10.0.0.1:8080")
+
+	// The replaced table's pool is no longer fed.
+	s.set("api.test.", dnsmessage.TypeA, a("api.test.", "10.0.0.9", 60))
+	waitBackends(t, restarted.Pool("api"), "http://10.0.0.9:8080")
+	assert.Equal(t, []string{"http://10.0.0.1:80"}, backendURLs(reloaded.Pool("api").Backends()))
+}
+
+func TestManager_StopEndsProviders(t *testing.T) {
+	s := newDNSServer(t)
+	s.set("api.test.", dnsmessage.TypeA, a("api.test.", "10.0.0.1", 60))
+	cfg := discoveredCfg(s)
+	table, err := proxy.BuildTable(cfg)
+	require.NoError(t, err)
+	pool := table.Pool("api")
+
+	m := discovery.NewManager(nil)
+	m.Update(cfg, table)
+	waitBackends(t, pool, "http://10.0.0.1:80")
+	m.Stop()
+
+	queries := s.udpQueries.Load()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, queries, s.udpQueries.Load(), "no lookups after Stop")
+}
+
+func TestManager_FilePoolLabelsChangeReplacesBackend(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "web.json")
+	writeFile(t, name, `[{"targets": ["10.0.0.1:80"], "labels": {"zone": "a"}}]`)
+	cfg := config.Config{
+		Strategy: "round_robin",
+		Pools:    []config.PoolCfg{{Name: "web", Discovery: fileCfg(filepath.Join(dir, "*.json"))}},
+		Routes:   []config.RouteCfg{{Name: "web", PathPrefix: "/", Pool: "web"}},
+	}
+	table, err := proxy.BuildTable(cfg)
+	require.NoError(t, err)
+	pool := table.Pool("web")
+
+	m := discovery.NewManager(nil)
+	defer m.Stop()
+	m.Update(cfg, table)
+	first := waitBackends(t, pool, "http://10.0.0.1:80")[0]
+	assert.Equal(t, map[string]string{"zone": "a"}, first.Labels)
+	first.SetHealthy(false)
+
+	writeFile(t, name, `[{"targets": ["10.0.0.1:80"], "labels": {"zone": "b"}}]`)
+	require.Eventually(t, func() bool { return pool.Backends()[0].Labels["zone"] == "b" }, 3*time.Second, 10*time.Millisecond)
+	assert.NotSame(t, first, pool.Backends()[0])
+	assert.False(t, pool.Backends()[0].IsHealthy(), "health carries over")
+}
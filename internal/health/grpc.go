@@ -0,0 +1,143 @@
+package health
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// grpcHealthPath is the method of the standard gRPC health checking protocol.
+const grpcHealthPath = "/grpc.health.v1.Health/Check"
+
+// grpcServing is HealthCheckResponse.ServingStatus SERVING; UNKNOWN (0),
+// NOT_SERVING (2) and SERVICE_UNKNOWN (3) all count as unhealthy.
+const grpcServing = 1
+
+// probeGRPC calls grpc.health.v1.Health/Check on the backend. It is healthy
+// when the call succeeds with grpc-status 0 and the status is SERVING.
+func (m *Monitor) probeGRPC(client *http.Client, t Target) {
+	b := t.Backend
+	body := grpcFrame(healthCheckRequest(t.Check.Service))
+	req, err := http.NewRequest(http.MethodPost, b.URL.Scheme+"://"+b.URL.Host+grpcHealthPath, bytes.NewReader(body))
+	if err != nil {
+		markUnhealthy(b, "error", err)
+		return
+	}
+	setHost(req, b)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		markUnhealthy(b, "error", err)
+		return
+	}
+	defer resp.Body.Close()
+	msg, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		markUnhealthy(b, "error", err)
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		markUnhealthy(b, "status", resp.StatusCode)
+		return
+	}
+	// A failed call may be answered with headers only ("Trailers-Only").
+	code := resp.Trailer.Get("Grpc-Status")
+	if code == "" {
+		code = resp.Header.Get("Grpc-Status")
+	}
+	if code != "0" {
+		markUnhealthy(b, "grpc_status", code, "grpc_message", grpcMessage(resp))
+		return
+	}
+	status, err := servingStatus(msg)
+	switch {
+	case err != nil:
+		markUnhealthy(b, "error", err)
+	case status != grpcServing:
+		markUnhealthy(b, "serving_status", status)
+	default:
+		markHealthy(b)
+	}
+}
+
+func grpcMessage(resp *http.Response) string {
+	if m := resp.Trailer.Get("Grpc-Message"); m != "" {
+		return m
+	}
+	return resp.Header.Get("Grpc-Message")
+}
+
+// healthCheckRequest encodes a HealthCheckRequest message: field 1, the
+// service name, omitted when empty.
+func healthCheckRequest(service string) []byte {
+	if service == "" {
+		return nil
+	}
+	msg := []byte{0x0a} // field 1, length-delimited
+	msg = binary.AppendUvarint(msg, uint64(len(service)))
+	return append(msg, service...)
+}
+
+// grpcFrame prefixes an uncompressed message with the gRPC length header.
+func grpcFrame(msg []byte) []byte {
+	out := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(out[1:], uint32(len(msg)))
+	return append(out, msg...)
+}
+
+var errBadHealthResponse = errors.New("health: malformed grpc.health.v1 response")
+
+// servingStatus decodes field 1 of the HealthCheckResponse in a gRPC frame.
+// Unknown fields are skipped; a missing status is UNKNOWN (0).
+func servingStatus(frame []byte) (uint64, error) {
+	if len(frame) < 5 || frame[0] != 0 {
+		return 0, errBadHealthResponse
+	}
+	n := binary.BigEndian.Uint32(frame[1:5])
+	if uint64(len(frame)-5) < uint64(n) {
+		return 0, errBadHealthResponse
+	}
+	msg := frame[5 : 5+n]
+	var status uint64
+	for len(msg) > 0 {
+		tag, k := binary.Uvarint(msg)
+		if k <= 0 {
+			return 0, errBadHealthResponse
+		}
+		msg = msg[k:]
+		switch tag & 7 {
+		case 0: // varint
+			v, k := binary.Uvarint(msg)
+			if k <= 0 {
+				return 0, errBadHealthResponse
+			}
+			if tag>>3 == 1 {
+				status = v
+			}
+			msg = msg[k:]
+		case 1: // 64-bit
+			if len(msg) < 8 {
+				return 0, errBadHealthResponse
+			}
+			msg = msg[8:]
+		case 2: // length-delimited
+			l, k := binary.Uvarint(msg)
+			if k <= 0 || uint64(len(msg)-k) < l {
+				return 0, errBadHealthResponse
+			}
+			msg = msg[k+int(l):]
+		case 5: // 32-bit
+			if len(msg) < 4 {
+				return 0, errBadHealthResponse
+			}
+			msg = msg[4:]
+		default:
+			return 0, errBadHealthResponse
+		}
+	}
+	return status, nil
+}
@@ -1,16 +1,23 @@
 // Package health implements active health checking for upstream backends.
 // A Monitor runs in the background and periodically probes each backend via
-// an HTTP GET to a configurable path (default "/healthz"). Unhealthy backends
-// are automatically excluded from traffic by the load-balancing strategy.
+// an HTTP GET to a configurable path (default "/healthz"), with the standard
+// grpc.health.v1 Check call for gRPC backends, by opening a TCP connection
+// for layer-4 backends, or with a request datagram for UDP backends.
+// Unhealthy backends are automatically excluded from traffic by the
+// load-balancing strategy.
 //
 // Passive health checks (marking a backend unhealthy after a proxy error) are
 // handled inside internal/proxy — this package only covers active probing.
 package health
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"log/slog"
+	"net"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
@@ -21,29 +28,61 @@ import (
 type Config struct {
 	Interval time.Duration
 	Timeout  time.Duration
-	Path     string // e.g. "/healthz"
+	Path     string // e.g. "/healthz"; targets may override it
+}
+
+// Check describes how one backend is probed.
+type Check struct {
+	GRPC    bool   // call grpc.health.v1.Health/Check instead of an HTTP GET
+	TCP     bool   // only open (and close) a TCP connection
+	UDP     bool   // send Payload as a datagram and wait for a reply
+	Path    string // HTTP probe path; empty uses Config.Path
+	Service string // service name for gRPC probes; empty checks the whole server
+	Payload []byte // UDP probe datagram
+	Expect  []byte // UDP probes: the reply must contain this; empty also accepts silence
+	Port    int    // TCP and UDP probes: port to check instead of the backend's
+}
+
+// Target is a backend to probe, the check to run and the transport to reach
+// it with. A nil Transport uses http.DefaultTransport's settings.
+type Target struct {
+	Backend   *strategy.Backend
+	Check     Check
+	Transport http.RoundTripper
+}
+
+// defaultTransport is http.DefaultTransport, able to dial Unix socket
+// backends too.
+var defaultTransport = func() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = strategy.UnixDialer(t.DialContext)
+	return t
+}()
+
+// Targets returns plain HTTP targets for backends.
+func Targets(backends []*strategy.Backend) []Target {
+	out := make([]Target, len(backends))
+	for i, b := range backends {
+		out[i] = Target{Backend: b}
+	}
+	return out
 }
 
 // Monitor periodically probes all registered backends and updates their health
-// state. It is safe to call UpdateBackends while the monitor is running.
+// state. It is safe to call UpdateTargets while the monitor is running.
 type Monitor struct {
-	cfg    Config
-	client *http.Client
+	cfg Config
 
-	mu       sync.RWMutex
-	backends []*strategy.Backend
+	mu      sync.RWMutex
+	targets []Target
 
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 }
 
 // New creates a Monitor but does not start it; call Start to begin probing.
-func New(backends []*strategy.Backend, cfg Config) *Monitor {
-	return &Monitor{
-		cfg:      cfg,
-		backends: backends,
-		client:   &http.Client{Timeout: cfg.Timeout},
-	}
+func New(targets []Target, cfg Config) *Monitor {
+	return &Monitor{cfg: cfg, targets: targets}
 }
 
 // Start begins the background health-check loop. It runs an immediate check
@@ -80,60 +119,162 @@ func (m *Monitor) Stop() {
 	m.wg.Wait()
 }
 
-// UpdateBackends atomically replaces the backend list. Safe to call while the
+// UpdateTargets atomically replaces the target list. Safe to call while the
 // monitor is running (e.g. on a config hot-reload).
-func (m *Monitor) UpdateBackends(backends []*strategy.Backend) {
+func (m *Monitor) UpdateTargets(targets []Target) {
 	m.mu.Lock()
-	m.backends = backends
+	m.targets = targets
 	m.mu.Unlock()
 }
 
-// probeAll checks every backend concurrently and waits for all to finish.
+// probeAll checks every target concurrently and waits for all to finish.
 func (m *Monitor) probeAll() {
 	m.mu.RLock()
-	backends := m.backends
+	targets := m.targets
 	m.mu.RUnlock()
 
 	var wg sync.WaitGroup
-	for _, b := range backends {
+	for _, t := range targets {
 		wg.Add(1)
-		go func(b *strategy.Backend) {
+		go func(t Target) {
 			defer wg.Done()
-			m.probe(b)
-		}(b)
+			m.probe(t)
+		}(t)
 	}
 	wg.Wait()
 }
 
-// probe sends a single GET request and updates the backend's health flag.
-func (m *Monitor) probe(b *strategy.Backend) {
-	target := b.RawURL + m.cfg.Path
+// probe runs a single check and updates the backend's health flag.
+func (m *Monitor) probe(t Target) {
+	transport := t.Transport
+	if transport == nil {
+		transport = defaultTransport
+	}
+	client := &http.Client{Timeout: m.cfg.Timeout, Transport: transport}
+	if t.Check.GRPC {
+		m.probeGRPC(client, t)
+		return
+	}
+	if t.Check.TCP {
+		m.probeTCP(t.Backend, t.Check.Port)
+		return
+	}
+	if t.Check.UDP {
+		m.probeUDP(t)
+		return
+	}
+	b := t.Backend
+	path := t.Check.Path
+	if path == "" {
+		path = m.cfg.Path
+	}
+	req, err := http.NewRequest(http.MethodGet, b.URL.String()+path, nil)
+	if err != nil {
+		markUnhealthy(b, "error", err)
+		return
+	}
+	setHost(req, b)
 
-	resp, err := m.client.Get(target)
+	resp, err := client.Do(req)
 	if err != nil {
-		if b.IsHealthy() {
-			slog.Warn("health: backend became unhealthy",
-				"backend", b.RawURL,
-				"error", err,
-			)
-		}
-		b.SetHealthy(false)
+		markUnhealthy(b, "error", err)
 		return
 	}
 	resp.Body.Close()
 
 	if resp.StatusCode >= 200 && resp.StatusCode < 400 {
-		if !b.IsHealthy() {
-			slog.Info("health: backend recovered", "backend", b.RawURL)
-		}
-		b.SetHealthy(true)
+		markHealthy(b)
 	} else {
-		if b.IsHealthy() {
-			slog.Warn("health: backend became unhealthy",
-				"backend", b.RawURL,
-				"status", resp.StatusCode,
-			)
-		}
-		b.SetHealthy(false)
+		markUnhealthy(b, "status", resp.StatusCode)
+	}
+}
+
+// setHost gives probes of Unix socket backends the Host header the proxy
+// sends them, in place of the stand-in host of their URL.
+func setHost(req *http.Request, b *strategy.Backend) {
+	if b.IsUnix() {
+		req.Host = "localhost"
+	}
+}
+
+// probeTCP marks b healthy when a connection to it can be opened within the
+// timeout. Unix socket backends are dialled on their socket. Otherwise a
+// non-zero port replaces the backend's; HTTP backends without a port are
+// dialled on their scheme's.
+func (m *Monitor) probeTCP(b *strategy.Backend, port int) {
+	network, addr := "tcp", probeAddr(b, port)
+	if path, ok := strategy.UnixSocket(b.URL.Host); ok {
+		network, addr = "unix", path
+	}
+	conn, err := net.DialTimeout(network, addr, m.cfg.Timeout)
+	if err != nil {
+		markUnhealthy(b, "error", err)
+		return
+	}
+	_ = conn.Close()
+	markHealthy(b)
+}
+
+// probeUDP sends the check's payload to the backend and waits for a reply
+// until the timeout. With an expected reply only a datagram containing it
+// passes. Without one, silence passes too, since many UDP services ignore
+// requests they do not understand; only an ICMP port unreachable, surfaced as
+// a refused read, fails the check.
+func (m *Monitor) probeUDP(t Target) {
+	b := t.Backend
+	conn, err := net.DialTimeout("udp", probeAddr(b, t.Check.Port), m.cfg.Timeout)
+	if err != nil {
+		markUnhealthy(b, "error", err)
+		return
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(m.cfg.Timeout))
+	if _, err := conn.Write(t.Check.Payload); err != nil {
+		markUnhealthy(b, "error", err)
+		return
+	}
+	buf := make([]byte, 64*1024)
+	n, err := conn.Read(buf)
+	var ne net.Error
+	switch {
+	case err != nil && errors.As(err, &ne) && ne.Timeout() && len(t.Check.Expect) == 0:
+		markHealthy(b)
+	case err != nil:
+		markUnhealthy(b, "error", err)
+	case bytes.Contains(buf[:n], t.Check.Expect):
+		markHealthy(b)
+	default:
+		markUnhealthy(b, "reply", string(buf[:n]))
+	}
+}
+
+// probeAddr returns the host:port layer-4 probes dial: the backend's host
+// with port when it is non-zero, else its own port, else its scheme's.
+func probeAddr(b *strategy.Backend, port int) string {
+	switch {
+	case port != 0:
+		return net.JoinHostPort(b.URL.Hostname(), strconv.Itoa(port))
+	case b.URL.Port() != "":
+		return b.URL.Host
+	case b.URL.Scheme == "https":
+		return net.JoinHostPort(b.URL.Hostname(), "443")
+	default:
+		return net.JoinHostPort(b.URL.Hostname(), "80")
+	}
+}
+
+// markHealthy sets b healthy, logging the recovery.
+func markHealthy(b *strategy.Backend) {
+	if !b.IsHealthy() {
+		slog.Info("health: backend recovered", "backend", b.RawURL)
+	}
+	b.SetHealthy(true)
+}
+
+// markUnhealthy sets b unhealthy, logging why with attrs when it was healthy.
+func markUnhealthy(b *strategy.Backend, attrs ...any) {
+	if b.IsHealthy() {
+		slog.Warn("health: backend became unhealthy", append([]any{"backend", b.RawURL}, attrs...)...)
 	}
+	b.SetHealthy(false)
 }
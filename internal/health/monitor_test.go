@@ -0,0 +1,223 @@
+package health_test
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"golb/internal/health"
+	"golb/internal/strategy"
+)
+
+// ── helpers ──────────────────────────────────────────────────────────────────
+
+func backend(t *testing.T, url string) *strategy.Backend {
+	t.Helper()
+	b, err := strategy.NewBackend(url, 1)
+	require.NoError(t, err)
+	return b
+}
+
+// runOnce starts a monitor over targets and waits for its first round of
+// probes to settle b's health.
+func runOnce(t *testing.T, b *strategy.Backend, targets []health.Target, want bool) {
+	t.Helper()
+	b.SetHealthy(!want)
+	m := health.New(targets, health.Config{Interval: 10 * time.Millisecond, Timeout: time.Second, Path: "/healthz"})
+	m.Start()
+	defer m.Stop()
+	assert.Eventually(t, func() bool { return b.IsHealthy() == want }, time.Second, 5*time.Millisecond)
+}
+
+// grpcHealthServer answers grpc.health.v1.Health/Check over h2c with status
+// for the service, or with grpc-status 5 (NOT_FOUND) for other services.
+func grpcHealthServer(t *testing.T, service string, status byte) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/grpc.health.v1.Health/Check", r.URL.Path)
+		assert.Equal(t, "application/grpc", r.Header.Get("Content-Type"))
+		frame, _ := io.ReadAll(r.Body)
+		got := ""
+		if len(frame) > 7 {
+			got = string(frame[7:]) // 5-byte frame header, tag, length
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		if got != service {
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "unknown service")
+			return
+		}
+		w.Header().Set("Trailer", "Grpc-Status")
+		msg := []byte{0x08, status} // field 1, varint
+		out := make([]byte, 5, 5+len(msg))
+		binary.BigEndian.PutUint32(out[1:], uint32(len(msg)))
+		_, _ = w.Write(append(out, msg...))
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func h2c() http.RoundTripper {
+	tr := &http.Transport{Protocols: new(http.Protocols)}
+	tr.Protocols.SetUnencryptedHTTP2(true)
+	return tr
+}
+
+// ── Tests ─────────────────────────────────────────────────────────────────────
+
+func TestMonitor_HTTPProbe(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	b := backend(t, srv.URL)
+	runOnce(t, b, health.Targets([]*strategy.Backend{b}), false)
+	runOnce(t, b, []health.Target{{Backend: b, Check: health.Check{Path: "/ready"}}}, true)
+}
+
+func TestMonitor_GRPCProbe(t *testing.T) {
+	for name, tc := range map[string]struct {
+		served  string // service the server knows
+		status  byte
+		service string // service the probe asks for
+		want    bool
+	}{
+		"serving":         {status: 1, want: true},
+		"serving service": {served: "shop.Cart", status: 1, service: "shop.Cart", want: true},
+		"not serving":     {status: 2, want: false},
+		"unknown service": {status: 1, service: "shop.Other", want: false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := grpcHealthServer(t, tc.served, tc.status)
+			b := backend(t, srv.URL)
+			check := health.Check{GRPC: true, Service: tc.service}
+			runOnce(t, b, []health.Target{{Backend: b, Check: check, Transport: h2c()}}, tc.want)
+		})
+	}
+}
+
+func TestMonitor_GRPCProbeNeedsHTTP2(t *testing.T) {
+	srv := grpcHealthServer(t, "", 1)
+	b := backend(t, srv.URL)
+	// Without an h2c transport the probe cannot reach an h2c-only server.
+	runOnce(t, b, []health.Target{{Backend: b, Check: health.Check{GRPC: true}}}, false)
+}
+
+func TestMonitor_TCPProbe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	b := backend(t, "tcp://"+ln.Addr().String())
+	tcp := []health.Target{{Backend: b, Check: health.Check{TCP: true}}}
+	runOnce(t, b, tcp, true)
+	require.NoError(t, ln.Close())
+	runOnce(t, b, tcp, false)
+}
+
+func TestMonitor_TCPProbePortOverride(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	// The backend's own port is closed; the health port is not.
+	b := backend(t, "udp://127.0.0.1:9")
+	port := ln.Addr().(*net.TCPAddr).Port
+	runOnce(t, b, []health.Target{{Backend: b, Check: health.Check{TCP: true, Port: port}}}, true)
+}
+
+func TestMonitor_UnixSocketProbes(t *testing.T) {
+	path := t.TempDir() + "/app.sock"
+	ln, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "localhost", r.Host, "the stand-in host is not sent")
+	})}
+	go func() { _ = srv.Serve(ln) }()
+
+	b := backend(t, "unix://"+path)
+	runOnce(t, b, health.Targets([]*strategy.Backend{b}), true)
+	tcp := []health.Target{{Backend: b, Check: health.Check{TCP: true}}}
+	runOnce(t, b, tcp, true)
+	require.NoError(t, srv.Close())
+	runOnce(t, b, tcp, false)
+}
+
+func TestMonitor_UDPProbe(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if string(buf[:n]) == "ping" {
+				_, _ = pc.WriteTo([]byte("pong from udp"), addr)
+			}
+		}
+	}()
+	b := backend(t, "udp://"+pc.LocalAddr().String())
+
+	probe := func(check health.Check, want bool) {
+		t.Helper()
+		b.SetHealthy(!want)
+		m := health.New([]health.Target{{Backend: b, Check: check}},
+			health.Config{Interval: 10 * time.Millisecond, Timeout: 100 * time.Millisecond})
+		m.Start()
+		defer m.Stop()
+		assert.Eventually(t, func() bool { return b.IsHealthy() == want }, time.Second, 5*time.Millisecond)
+	}
+	probe(health.Check{UDP: true, Payload: []byte("ping"), Expect: []byte("pong")}, true)
+	probe(health.Check{UDP: true, Payload: []byte("ping"), Expect: []byte("ack")}, false)
+	probe(health.Check{UDP: true, Payload: []byte("hello"), Expect: []byte("pong")}, false) // no reply
+	probe(health.Check{UDP: true, Payload: []byte("hello")}, true)                          // silence passes
+
+	require.NoError(t, pc.Close())
+	probe(health.Check{UDP: true, Payload: []byte("hello")}, false) // port unreachable
+}
+
+func TestMonitor_UpdateTargets(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	b := backend(t, srv.URL)
+	b.SetHealthy(false)
+	m := health.New(nil, health.Config{Interval: 10 * time.Millisecond, Timeout: time.Second})
+	m.Start()
+	defer m.Stop()
+	m.UpdateTargets(health.Targets([]*strategy.Backend{b}))
+	assert.Eventually(t, b.IsHealthy, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,111 @@
+package l4
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"golb/internal/config"
+)
+
+// SNI routes TLS connections by the server name in their ClientHello, which
+// is read but not answered: the backend terminates TLS, and the bytes read
+// here are replayed to it first.
+type SNI struct {
+	exact     map[string]string // host → pool
+	wildcards []sniWildcard     // longest suffix first
+	fallback  string            // pool for unmatched names; empty closes them
+	Timeout   time.Duration     // to receive the ClientHello; 0 means none
+}
+
+type sniWildcard struct {
+	suffix string // ".example.com"
+	pool   string
+}
+
+// NewSNI builds the router of a listener's sni routes, or returns nil when it
+// has none.
+func NewSNI(cfg config.TCPListenerCfg) *SNI {
+	if len(cfg.SNI) == 0 {
+		return nil
+	}
+	s := &SNI{exact: map[string]string{}, fallback: cfg.Pool, Timeout: cfg.ParsedHelloTimeout()}
+	for _, r := range cfg.SNI {
+		for _, h := range r.Hosts {
+			h = normalizeHost(h)
+			if suffix, ok := strings.CutPrefix(h, "*"); ok {
+				s.wildcards = append(s.wildcards, sniWildcard{suffix: suffix, pool: r.Pool})
+			} else {
+				s.exact[h] = r.Pool
+			}
+		}
+	}
+	sort.SliceStable(s.wildcards, func(i, j int) bool {
+		return len(s.wildcards[i].suffix) > len(s.wildcards[j].suffix)
+	})
+	return s
+}
+
+// Route returns the pool for serverName: an exact match, else the longest
+// matching wildcard, else the default pool. An empty result means no pool.
+func (s *SNI) Route(serverName string) string {
+	name := normalizeHost(serverName)
+	if pool, ok := s.exact[name]; ok {
+		return pool
+	}
+	for _, w := range s.wildcards {
+		if strings.HasSuffix(name, w.suffix) && len(name) > len(w.suffix) {
+			return w.pool
+		}
+	}
+	return s.fallback
+}
+
+func normalizeHost(h string) string {
+	return strings.ToLower(strings.TrimSuffix(h, "."))
+}
+
+// errHelloRead stops the handshake once the ClientHello has been parsed.
+var errHelloRead = errors.New("l4: client hello read")
+
+// readClientHello reads the TLS ClientHello from conn and returns the server
+// name it asks for (empty when the client sent none) and every byte read, to
+// be replayed to the backend. crypto/tls does the parsing; the handshake is
+// abandoned as soon as the hello is in.
+func readClientHello(conn net.Conn) (string, []byte, error) {
+	var buf bytes.Buffer
+	var serverName string
+	hello := false
+	err := tls.Server(helloConn{r: io.TeeReader(conn, &buf)}, &tls.Config{
+		GetConfigForClient: func(h *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName, hello = h.ServerName, true
+			return nil, errHelloRead
+		},
+	}).Handshake()
+	if !hello {
+		return "", buf.Bytes(), fmt.Errorf("reading TLS ClientHello: %w", err)
+	}
+	return serverName, buf.Bytes(), nil
+}
+
+// helloConn lets crypto/tls read a ClientHello while keeping it from writing
+// anything back to the client.
+type helloConn struct {
+	net.Conn // nil; only Read and the methods below are used
+	r        io.Reader
+}
+
+func (c helloConn) Read(p []byte) (int, error)         { return c.r.Read(p) }
+func (c helloConn) Write(p []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c helloConn) Close() error                       { return nil }
+func (c helloConn) SetDeadline(t time.Time) error      { return nil }
+func (c helloConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c helloConn) SetWriteDeadline(t time.Time) error { return nil }
+func (c helloConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (c helloConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
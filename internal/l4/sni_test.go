@@ -0,0 +1,149 @@
+package l4_test
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"golb/internal/config"
+	"golb/internal/l4"
+	"golb/internal/strategy"
+)
+
+// ── Helpers ───────────────────────────────────────────────────────────────────
+
+// tlsBackend starts an HTTPS server, which terminates TLS itself, answering
+// with name.
+func tlsBackend(t *testing.T, name string) (*httptest.Server, strategy.Picker) {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, name)
+	}))
+	t.Cleanup(srv.Close)
+	b, err := strategy.NewBackend("tcp://"+srv.Listener.Addr().String(), 1)
+	require.NoError(t, err)
+	return srv, strategy.NewRoundRobin([]*strategy.Backend{b})
+}
+
+// getVia sends an HTTPS request for serverName through the listener at addr
+// and returns the response body.
+func getVia(t *testing.T, addr, serverName string) (string, error) {
+	t.Helper()
+	client := &http.Client{Timeout: 2 * time.Second, Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		},
+		TLSClientConfig: &tls.Config{ServerName: serverName, InsecureSkipVerify: true},
+	}}
+	host := serverName
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	resp, err := client.Get("https://" + host + "/")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+// ── Routing ───────────────────────────────────────────────────────────────────
+
+func TestSNI_Route(t *testing.T) {
+	s := l4.NewSNI(config.TCPListenerCfg{
+		Pool: "default",
+		SNI: []config.SNIRouteCfg{
+			{Hosts: []string{"api.example.com", "API2.example.com."}, Pool: "api"},
+			{Hosts: []string{"*.example.com"}, Pool: "web"},
+			{Hosts: []string{"*.internal.example.com"}, Pool: "internal"},
+		},
+	})
+	for name, want := range map[string]string{
+		"api.example.com":         "api",
+		"API.Example.com":         "api",
+		"api2.example.com":        "api",
+		"www.example.com":         "web",
+		"a.b.example.com":         "web",
+		"db.internal.example.com": "internal",
+		"example.com":             "default",
+		"other.org":               "default",
+		"":                        "default",
+	} {
+		assert.Equal(t, want, s.Route(name), name)
+	}
+	assert.Nil(t, l4.NewSNI(config.TCPListenerCfg{Pool: "p"}), "listeners without sni routes do not peek")
+}
+
+func TestTCP_SNIPassthroughRoutesByServerName(t *testing.T) {
+	apiSrv, api := tlsBackend(t, "api")
+	_, web := tlsBackend(t, "web")
+	_, fallback := tlsBackend(t, "fallback")
+	pickers := map[string]strategy.Picker{"api": api, "web": web, "fallback": fallback}
+
+	l := l4.NewTCP(config.TCPListenerCfg{
+		Name: "tls",
+		Pool: "fallback",
+		SNI: []config.SNIRouteCfg{
+			{Hosts: []string{"api.example.com"}, Pool: "api"},
+			{Hosts: []string{"*.web.example.com"}, Pool: "web"},
+		},
+	}, func(name string) l4.Upstream { return l4.Upstream{Picker: pickers[name]} })
+	addr := startTCP(t, l)
+
+	for serverName, want := range map[string]string{
+		"api.example.com":      "api",
+		"shop.web.example.com": "web",
+		"unknown.example.org":  "fallback",
+		"":                     "fallback", // no SNI at all
+	} {
+		body, err := getVia(t, addr, serverName)
+		require.NoError(t, err, serverName)
+		assert.Equal(t, want, body, serverName)
+	}
+
+	// TLS is terminated by the backend, not the gateway.
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "api.example.com", InsecureSkipVerify: true})
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, apiSrv.Certificate().Raw, conn.ConnectionState().PeerCertificates[0].Raw)
+}
+
+func TestTCP_SNIClosesWhatItCannotRoute(t *testing.T) {
+	_, api := tlsBackend(t, "api")
+	l := l4.NewTCP(config.TCPListenerCfg{
+		Name:         "tls",
+		SNI:          []config.SNIRouteCfg{{Hosts: []string{"api.example.com"}, Pool: "api"}},
+		HelloTimeout: "200ms",
+	}, func(string) l4.Upstream { return l4.Upstream{Picker: api} })
+	addr := startTCP(t, l)
+
+	_, err := getVia(t, addr, "other.example.com")
+	assert.Error(t, err, "no route and no default pool")
+
+	plain, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer plain.Close()
+	_, _ = io.WriteString(plain, "GET / HTTP/1.1\r\nHost: api.example.com\r\n\r\n")
+	_ = plain.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = plain.Read(make([]byte, 1))
+	assert.Error(t, err, "plaintext is not a ClientHello")
+
+	silent, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer silent.Close()
+	_ = silent.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = silent.Read(make([]byte, 1))
+	assert.Error(t, err, "a client that never says hello is closed after hello_timeout")
+
+	assert.Eventually(t, func() bool { return l.Stats().Failed == 3 }, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,476 @@
+// Package l4 proxies raw connections and datagrams (layer 4) for protocols
+// the gateway does not speak, such as Postgres, Redis, DNS or syslog.
+// Backends are chosen by the same pickers as HTTP traffic, so strategies,
+// max_conns, queueing and health checks apply unchanged; a backend's
+// connection slot is held for as long as the client stays connected, or for
+// UDP while its session lasts.
+package l4
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golb/internal/config"
+	"golb/internal/proxyproto"
+	"golb/internal/strategy"
+)
+
+// ErrListenerClosed is returned by Serve after Shutdown.
+var ErrListenerClosed = errors.New("l4: listener closed")
+
+// PoolFunc returns the named pool as currently configured, with a nil
+// Picker when there is no such pool. It is called for every connection, so a
+// hot-reload changes the backends of new connections without a restart.
+type PoolFunc func(name string) Upstream
+
+// Upstream is a pool as the layer-4 listeners use it.
+type Upstream struct {
+	Picker        strategy.Picker
+	ProxyProtocol int // PROXY protocol version announcing clients to backends; 0 for none
+}
+
+// TCP is a layer-4 listener. Every accepted connection is spliced to a
+// backend until either side closes or it has been idle for IdleTimeout.
+type TCP struct {
+	Name           string
+	Addr           string
+	Pool           string // the pool, or with SNI the default pool
+	Pools          PoolFunc
+	SNI            *SNI          // routes TLS connections by server name; nil for none
+	IdleTimeout    time.Duration // 0 means none
+	ConnectTimeout time.Duration // to pick and dial a backend; 0 means none
+	MaxConns       int           // open client connections; 0 means unlimited
+
+	// ProxyTrusted are the sources whose connections start with a PROXY
+	// protocol header, read within ProxyTimeout.
+	ProxyTrusted []*net.IPNet
+	ProxyTimeout time.Duration
+
+	total    atomic.Int64
+	rejected atomic.Int64
+	failed   atomic.Int64
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+
+	mu      sync.Mutex
+	ln      net.Listener
+	conns   map[*session]struct{}
+	closing bool
+	done    chan struct{} // closed when the last connection ends during shutdown
+}
+
+// TCPStats counts a listener's connections.
+type TCPStats struct {
+	Active   int64 // client connections currently open
+	Total    int64 // client connections accepted
+	Rejected int64 // connections refused at MaxConns
+	Failed   int64 // connections closed because no backend could be reached
+	BytesIn  int64 // bytes relayed from clients to backends
+	BytesOut int64 // bytes relayed from backends to clients
+}
+
+// NewTCP builds a listener from cfg that looks its pools up with pools.
+func NewTCP(cfg config.TCPListenerCfg, pools PoolFunc) *TCP {
+	trusted, _ := cfg.ProxyProtocol.ParsedTrustedCIDRs() // validated on load
+	return &TCP{
+		Name:           cfg.Name,
+		Addr:           cfg.ListenAddr,
+		Pool:           cfg.Pool,
+		Pools:          pools,
+		SNI:            NewSNI(cfg),
+		IdleTimeout:    cfg.ParsedIdleTimeout(),
+		ConnectTimeout: cfg.ParsedConnectTimeout(),
+		MaxConns:       cfg.MaxConns,
+		ProxyTrusted:   trusted,
+		ProxyTimeout:   cfg.ProxyProtocol.ParsedTimeout(),
+	}
+}
+
+// Stats returns the listener's connection counters.
+func (t *TCP) Stats() TCPStats {
+	t.mu.Lock()
+	active := int64(len(t.conns))
+	t.mu.Unlock()
+	return TCPStats{
+		Active:   active,
+		Total:    t.total.Load(),
+		Rejected: t.rejected.Load(),
+		Failed:   t.failed.Load(),
+		BytesIn:  t.bytesIn.Load(),
+		BytesOut: t.bytesOut.Load(),
+	}
+}
+
+// ListenAndServe listens on t.Addr and serves connections until Shutdown.
+func (t *TCP) ListenAndServe() error {
+	ln, err := net.Listen("tcp", t.Addr)
+	if err != nil {
+		return err
+	}
+	return t.Serve(proxyproto.NewListener(ln, t.ProxyTrusted, t.ProxyTimeout))
+}
+
+// Serve accepts connections on ln until Shutdown, after which it returns
+// ErrListenerClosed.
+func (t *TCP) Serve(ln net.Listener) error {
+	t.mu.Lock()
+	if t.closing {
+		t.mu.Unlock()
+		_ = ln.Close()
+		return ErrListenerClosed
+	}
+	t.ln = ln
+	t.mu.Unlock()
+
+	var backoff time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if t.isClosing() {
+				return ErrListenerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				// Out of file descriptors and the like: back off as
+				// net/http does rather than spin.
+				backoff = min(max(2*backoff, 5*time.Millisecond), time.Second)
+				time.Sleep(backoff)
+				continue
+			}
+			return err
+		}
+		backoff = 0
+		s := &session{tcp: t, client: conn, start: time.Now()}
+		if !t.track(s) {
+			t.rejected.Add(1)
+			slog.Warn("tcp connection rejected", "listener", t.Name, "client", conn.RemoteAddr().String(), "reason", "max_conns")
+			_ = conn.Close()
+			continue
+		}
+		t.total.Add(1)
+		go s.serve()
+	}
+}
+
+func (t *TCP) isClosing() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closing
+}
+
+// track registers s, or refuses it when the listener is full or closing.
+func (t *TCP) track(s *session) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closing || (t.MaxConns > 0 && len(t.conns) >= t.MaxConns) {
+		return false
+	}
+	if t.conns == nil {
+		t.conns = map[*session]struct{}{}
+	}
+	t.conns[s] = struct{}{}
+	return true
+}
+
+func (t *TCP) untrack(s *session) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, s)
+	if t.closing && len(t.conns) == 0 && t.done != nil {
+		close(t.done)
+		t.done = nil
+	}
+}
+
+// Shutdown stops accepting connections and waits for the open ones to end
+// until ctx is done, when it closes them and returns ctx's error.
+func (t *TCP) Shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	t.closing = true
+	if t.ln != nil {
+		_ = t.ln.Close()
+	}
+	if len(t.conns) == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	done := make(chan struct{})
+	t.done = done
+	t.mu.Unlock()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		t.mu.Lock()
+		for s := range t.conns {
+			s.close("shutdown")
+		}
+		t.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// connect picks a backend and dials it, moving on to the next backend when
+// a dial fails. A backend that cannot be dialled is marked unhealthy, as
+// for HTTP, until the health monitor sees it recover. When the pool asks for
+// it, the connection starts with a PROXY protocol header announcing client.
+// The returned backend's slot must be released with picker.Done.
+func (t *TCP) connect(ctx context.Context, pool string, client net.Conn) (strategy.Picker, *strategy.Backend, net.Conn, error) {
+	up := t.Pools(pool)
+	picker := up.Picker
+	if picker == nil {
+		return nil, nil, nil, fmt.Errorf("pool %q not found", pool)
+	}
+	if t.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.ConnectTimeout)
+		defer cancel()
+	}
+	var dialer net.Dialer
+	dial := proxyproto.DialFunc(dialer.DialContext)
+	if up.ProxyProtocol != 0 {
+		ctx = proxyproto.NewContext(ctx, client.RemoteAddr(), client.LocalAddr())
+		dial = proxyproto.Dialer(dial, up.ProxyProtocol)
+	}
+	var lastErr error
+	for range picker.Backends() {
+		b, err := strategy.NextContext(ctx, picker)
+		if err != nil {
+			if lastErr != nil {
+				return nil, nil, nil, fmt.Errorf("%w (last dial error: %v)", err, lastErr)
+			}
+			return nil, nil, nil, err
+		}
+		conn, err := dial(ctx, "tcp", b.URL.Host)
+		if err == nil {
+			b.IncRequests()
+			return picker, b, conn, nil
+		}
+		picker.Done(b)
+		b.IncErrors()
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+		slog.Warn("tcp backend dial failed, marking unhealthy",
+			"listener", t.Name, "backend", b.RawURL, "error", err)
+		b.SetHealthy(false)
+	}
+	if lastErr == nil {
+		// A discovered pool may have no backends yet.
+		return nil, nil, nil, strategy.ErrNoHealthyBackend
+	}
+	return nil, nil, nil, lastErr
+}
+
+// session is one client connection and, once connected, its backend.
+type session struct {
+	tcp    *TCP
+	client net.Conn
+	start  time.Time
+	last   atomic.Int64 // unix nanoseconds of the last byte either way
+
+	in  atomic.Int64 // client to backend
+	out atomic.Int64 // backend to client
+
+	serverName string // from the ClientHello, with SNI routing
+	hello      []byte // ClientHello bytes read from the client, still to be relayed
+
+	mu       sync.Mutex // guards the fields below, which timers and Shutdown use
+	upstream net.Conn
+	cancel   context.CancelFunc // aborts connecting to a backend
+	reason   string             // why the session closed; empty while open
+	idle     *time.Timer
+}
+
+// serve connects the client to a backend and relays bytes until the session
+// ends.
+func (s *session) serve() {
+	t := s.tcp
+	defer t.untrack(s)
+	defer s.client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.mu.Lock()
+	s.cancel = cancel
+	s.mu.Unlock()
+
+	pool, err := s.route()
+	if err != nil {
+		t.failed.Add(1)
+		slog.Warn("tcp routing failed", "listener", t.Name, "client", s.client.RemoteAddr().String(), "error", err)
+		return
+	}
+	picker, b, upstream, err := t.connect(ctx, pool, s.client)
+	if err != nil {
+		t.failed.Add(1)
+		slog.Warn("tcp connect failed", "listener", t.Name, "client", s.client.RemoteAddr().String(),
+			"pool", pool, "server_name", s.serverName, "error", err)
+		return
+	}
+	defer picker.Done(b)
+
+	if !s.open(upstream) {
+		_ = upstream.Close()
+		return
+	}
+	s.relay()
+
+	slog.Info("tcp connection closed",
+		"listener", t.Name,
+		"client", s.client.RemoteAddr().String(),
+		"server_name", s.serverName,
+		"backend", b.RawURL,
+		"duration_ms", time.Since(s.start).Milliseconds(),
+		"bytes_in", s.in.Load(),
+		"bytes_out", s.out.Load(),
+		"reason", s.closeReason(),
+	)
+}
+
+// route returns the pool for the connection. With SNI it first reads the
+// ClientHello, which must arrive within the hello timeout.
+func (s *session) route() (string, error) {
+	t := s.tcp
+	if t.SNI == nil {
+		return t.Pool, nil
+	}
+	if d := t.SNI.Timeout; d > 0 {
+		_ = s.client.SetReadDeadline(time.Now().Add(d))
+	}
+	name, hello, err := readClientHello(s.client)
+	if err != nil {
+		return "", err
+	}
+	_ = s.client.SetReadDeadline(time.Time{})
+	s.serverName, s.hello = name, hello
+	pool := t.SNI.Route(name)
+	if pool == "" {
+		return "", fmt.Errorf("no sni route for server name %q", name)
+	}
+	return pool, nil
+}
+
+// open attaches the backend connection and starts the idle clock. It fails
+// when the session was closed (by Shutdown) while connecting.
+func (s *session) open(upstream net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reason != "" {
+		return false
+	}
+	s.upstream = upstream
+	s.touch()
+	if d := s.tcp.IdleTimeout; d > 0 {
+		s.idle = time.AfterFunc(d, s.checkIdle)
+	}
+	return true
+}
+
+// relay copies bytes both ways. When one side finishes sending, the other is
+// told so with a half-close, letting protocols that shut down one direction
+// first complete; the session ends once both directions are done.
+func (s *session) relay() {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		src := io.MultiReader(bytes.NewReader(s.hello), s.client)
+		s.copy(s.upstream, src, &s.in, &s.tcp.bytesIn)
+	}()
+	go func() {
+		defer wg.Done()
+		s.copy(s.client, s.upstream, &s.out, &s.tcp.bytesOut)
+	}()
+	wg.Wait()
+}
+
+func (s *session) copy(dst net.Conn, src io.Reader, n, total *atomic.Int64) {
+	_, err := io.Copy(&countingWriter{w: dst, s: s, n: n, total: total}, src)
+	if err != nil {
+		// A reset or a closed connection ends both directions.
+		s.close("closed")
+		return
+	}
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+		return
+	}
+	s.close("closed")
+}
+
+// touch records traffic, which keeps the session from idling out.
+func (s *session) touch() { s.last.Store(time.Now().UnixNano()) }
+
+// checkIdle closes the session if it has carried no traffic for IdleTimeout,
+// and otherwise checks again when it next could have.
+func (s *session) checkIdle() {
+	d := s.tcp.IdleTimeout
+	idle := time.Since(time.Unix(0, s.last.Load()))
+	if idle >= d {
+		s.close("idle_timeout")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reason == "" {
+		s.idle.Reset(d - idle)
+	}
+}
+
+// close ends the session, closing both connections so that the copies stop.
+func (s *session) close(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reason != "" {
+		return
+	}
+	s.reason = reason
+	if s.idle != nil {
+		s.idle.Stop()
+	}
+	if s.cancel != nil {
+		s.cancel()
+	}
+	_ = s.client.Close()
+	if s.upstream != nil {
+		_ = s.upstream.Close()
+	}
+}
+
+// closeReason returns why the session closed: idle_timeout, shutdown or
+// closed when either side hung up.
+func (s *session) closeReason() string {
+	s.close("closed")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reason
+}
+
+// countingWriter counts the bytes relayed in one direction.
+type countingWriter struct {
+	w     io.Writer
+	s     *session
+	n     *atomic.Int64
+	total *atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	if n > 0 {
+		c.n.Add(int64(n))
+		c.total.Add(int64(n))
+		c.s.touch()
+	}
+	return n, err
+}
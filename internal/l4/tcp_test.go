@@ -0,0 +1,273 @@
+package l4_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"golb/internal/config"
+	"golb/internal/l4"
+	"golb/internal/proxyproto"
+	"golb/internal/strategy"
+)
+
+// ── Helpers ───────────────────────────────────────────────────────────────────
+
+// tcpBackend starts a TCP server that greets each connection with name and a
+// newline, then echoes what it reads until the client half-closes.
+func tcpBackend(t *testing.T, name string) *strategy.Backend {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.WriteString(conn, name+"\n")
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	b, err := strategy.NewBackend("tcp://"+ln.Addr().String(), 1)
+	require.NoError(t, err)
+	return b
+}
+
+// deadBackend returns a backend whose address refuses connections.
+func deadBackend(t *testing.T) *strategy.Backend {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+	b, err := strategy.NewBackend("tcp://"+addr, 1)
+	require.NoError(t, err)
+	return b
+}
+
+// startTCP serves l on a free loopback port and returns its address.
+func startTCP(t *testing.T, l *l4.TCP) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = l.Serve(ln) }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = l.Shutdown(ctx)
+	})
+	return ln.Addr().String()
+}
+
+func newTCP(picker strategy.Picker) *l4.TCP {
+	return l4.NewTCP(config.TCPListenerCfg{Name: "test", Pool: "test"}, func(string) l4.Upstream { return l4.Upstream{Picker: picker} })
+}
+
+// greeting dials addr and returns the connection and the backend's name.
+func greeting(t *testing.T, addr string) (*net.TCPConn, *bufio.Reader, string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	r := bufio.NewReader(conn)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	return conn.(*net.TCPConn), r, line[:len(line)-1]
+}
+
+// ── Splicing ──────────────────────────────────────────────────────────────────
+
+func TestTCP_SplicesBothWaysAndHoldsTheBackendSlot(t *testing.T) {
+	b := tcpBackend(t, "pg")
+	l := newTCP(strategy.NewRoundRobin([]*strategy.Backend{b}))
+	conn, r, name := greeting(t, startTCP(t, l))
+	assert.Equal(t, "pg", name)
+	assert.Equal(t, int64(1), b.ActiveConns(), "the slot is held while the client is connected")
+
+	_, err := conn.Write([]byte("SELECT 1;"))
+	require.NoError(t, err)
+	require.NoError(t, conn.CloseWrite())
+	rest, err := io.ReadAll(r)
+	require.NoError(t, err, "a client half-close reaches the backend, which then finishes")
+	assert.Equal(t, "SELECT 1;", string(rest))
+
+	assert.Eventually(t, func() bool { return b.ActiveConns() == 0 }, time.Second, 5*time.Millisecond)
+	st := l.Stats()
+	assert.Equal(t, int64(1), st.Total)
+	assert.Zero(t, st.Active)
+	assert.Equal(t, int64(len("SELECT 1;")), st.BytesIn)
+	assert.Equal(t, int64(len("pg\nSELECT 1;")), st.BytesOut)
+	assert.Equal(t, int64(1), b.TotalRequests())
+}
+
+func TestTCP_UsesTheStrategy(t *testing.T) {
+	a, b := tcpBackend(t, "a"), tcpBackend(t, "b")
+	addr := startTCP(t, newTCP(strategy.NewRoundRobin([]*strategy.Backend{a, b})))
+
+	seen := map[string]int{}
+	for range 4 {
+		conn, _, name := greeting(t, addr)
+		seen[name]++
+		_ = conn.Close()
+	}
+	assert.Equal(t, map[string]int{"a": 2, "b": 2}, seen)
+}
+
+func TestTCP_FailsOverAndMarksDeadBackendUnhealthy(t *testing.T) {
+	dead, live := deadBackend(t), tcpBackend(t, "live")
+	addr := startTCP(t, newTCP(strategy.NewRoundRobin([]*strategy.Backend{dead, live})))
+
+	for range 3 {
+		conn, _, name := greeting(t, addr)
+		assert.Equal(t, "live", name)
+		_ = conn.Close()
+	}
+	assert.False(t, dead.IsHealthy())
+	assert.Equal(t, int64(1), dead.TotalErrors(), "once unhealthy the dead backend is not dialled again")
+	assert.Zero(t, dead.ActiveConns())
+}
+
+func TestTCP_NoBackendClosesTheClient(t *testing.T) {
+	dead := deadBackend(t)
+	l := newTCP(strategy.NewRoundRobin([]*strategy.Backend{dead}))
+	conn, err := net.Dial("tcp", startTCP(t, l))
+	require.NoError(t, err)
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+	assert.Eventually(t, func() bool { return l.Stats().Failed == 1 }, time.Second, 5*time.Millisecond)
+}
+
+func TestTCP_EmptyDiscoveredPoolClosesTheClient(t *testing.T) {
+	picker, err := strategy.NewDynamic("round_robin")
+	require.NoError(t, err)
+	l := newTCP(picker)
+	conn, err := net.Dial("tcp", startTCP(t, l))
+	require.NoError(t, err)
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+	assert.Eventually(t, func() bool { return l.Stats().Failed == 1 }, time.Second, 5*time.Millisecond)
+	assert.Zero(t, l.Stats().Active)
+}
+
+// ── Limits ────────────────────────────────────────────────────────────────────
+
+func TestTCP_IdleTimeoutClosesConnection(t *testing.T) {
+	b := tcpBackend(t, "redis")
+	l := newTCP(strategy.NewRoundRobin([]*strategy.Backend{b}))
+	l.IdleTimeout = 100 * time.Millisecond
+	conn, r, _ := greeting(t, startTCP(t, l))
+
+	// Traffic keeps the connection open past the timeout.
+	for range 3 {
+		time.Sleep(60 * time.Millisecond)
+		_, err := conn.Write([]byte("x"))
+		require.NoError(t, err)
+		_, err = r.ReadByte()
+		require.NoError(t, err)
+	}
+	start := time.Now()
+	_, err := r.ReadByte()
+	assert.Error(t, err, "the idle connection is closed")
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Eventually(t, func() bool { return b.ActiveConns() == 0 }, time.Second, 5*time.Millisecond)
+}
+
+func TestTCP_MaxConnsRejectsExtraConnections(t *testing.T) {
+	b := tcpBackend(t, "db")
+	l := newTCP(strategy.NewRoundRobin([]*strategy.Backend{b}))
+	l.MaxConns = 1
+	addr := startTCP(t, l)
+	first, _, _ := greeting(t, addr)
+
+	second, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer second.Close()
+	_ = second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = second.Read(make([]byte, 1))
+	assert.Error(t, err, "the listener is full")
+	assert.Equal(t, int64(1), l.Stats().Rejected)
+
+	_ = first.Close()
+	assert.Eventually(t, func() bool { return l.Stats().Active == 0 }, time.Second, 5*time.Millisecond)
+	_, _, name := greeting(t, addr)
+	assert.Equal(t, "db", name, "a slot frees up when a connection closes")
+}
+
+func TestTCP_ShutdownDrainsThenCloses(t *testing.T) {
+	b := tcpBackend(t, "db")
+	l := newTCP(strategy.NewRoundRobin([]*strategy.Backend{b}))
+	addr := startTCP(t, l)
+	_, r, _ := greeting(t, addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, l.Shutdown(ctx), context.DeadlineExceeded, "open connections outlive the drain window")
+	_, err := r.ReadByte()
+	assert.Error(t, err, "then they are closed")
+	_, err = net.DialTimeout("tcp", addr, 100*time.Millisecond)
+	assert.Error(t, err, "the listener no longer accepts")
+	assert.Eventually(t, func() bool { return b.ActiveConns() == 0 }, time.Second, 5*time.Millisecond)
+}
+
+// ── PROXY protocol ────────────────────────────────────────────────────────────
+
+func TestTCP_ProxyProtocolCarriesTheClientThrough(t *testing.T) {
+	// The backend greets each connection with the header it starts with.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			header, _ := bufio.NewReader(conn).ReadString('\n')
+			_, _ = io.WriteString(conn, header)
+			_ = conn.Close()
+		}
+	}()
+	b, err := strategy.NewBackend("tcp://"+ln.Addr().String(), 1)
+	require.NoError(t, err)
+
+	picker := strategy.NewRoundRobin([]*strategy.Backend{b})
+	l := l4.NewTCP(config.TCPListenerCfg{Name: "test", Pool: "test"}, func(string) l4.Upstream {
+		return l4.Upstream{Picker: picker, ProxyProtocol: 1}
+	})
+	front, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	go func() { _ = l.Serve(proxyproto.NewListener(front, []*net.IPNet{loopback}, time.Second)) }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = l.Shutdown(ctx)
+	})
+
+	conn, err := net.Dial("tcp", front.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "PROXY TCP4 203.0.113.7 10.0.0.1 4242 5432\r\n")
+	require.NoError(t, err)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	header, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "PROXY TCP4 203.0.113.7 10.0.0.1 4242 5432\r\n", header,
+		"the backend is told of the client and address the load balancer announced")
+}
@@ -0,0 +1,445 @@
+package l4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golb/internal/config"
+	"golb/internal/strategy"
+)
+
+// maxDatagram is the largest UDP payload; buffers this size never truncate.
+const maxDatagram = 64 * 1024
+
+// UDP is a layer-4 datagram listener. The first datagram from a client
+// address opens a session with a backend; later datagrams from that address
+// go to the same backend, and its replies are sent back to the client from
+// the listener's address. A session ends after IdleTimeout without traffic
+// either way, releasing its backend slot.
+type UDP struct {
+	Name        string
+	Addr        string
+	Pool        string
+	Pools       PoolFunc
+	Hash        bool          // pin client IPs to backends by hashing instead of using the pool's strategy
+	IdleTimeout time.Duration // must be positive: sessions are how replies find their client
+	MaxSessions int           // open sessions; 0 means unlimited
+
+	total        atomic.Int64
+	rejected     atomic.Int64
+	failed       atomic.Int64
+	datagramsIn  atomic.Int64
+	datagramsOut atomic.Int64
+	bytesIn      atomic.Int64
+	bytesOut     atomic.Int64
+
+	mu       sync.Mutex
+	conn     net.PacketConn
+	sessions map[string]*udpSession // by client address
+	closing  bool
+	wg       sync.WaitGroup // session relays
+}
+
+// UDPStats counts a listener's sessions and datagrams.
+type UDPStats struct {
+	Active       int64 // sessions currently open
+	Total        int64 // sessions opened
+	Rejected     int64 // datagrams dropped because MaxSessions were open
+	Failed       int64 // datagrams dropped because no backend could be reached
+	DatagramsIn  int64 // datagrams relayed from clients to backends
+	DatagramsOut int64 // datagrams relayed from backends to clients
+	BytesIn      int64
+	BytesOut     int64
+}
+
+// NewUDP builds a listener from cfg that looks its pool up with pools.
+func NewUDP(cfg config.UDPListenerCfg, pools PoolFunc) *UDP {
+	return &UDP{
+		Name:        cfg.Name,
+		Addr:        cfg.ListenAddr,
+		Pool:        cfg.Pool,
+		Pools:       pools,
+		Hash:        cfg.Affinity == "hash",
+		IdleTimeout: cfg.ParsedIdleTimeout(),
+		MaxSessions: cfg.MaxSessions,
+	}
+}
+
+// Stats returns the listener's counters.
+func (u *UDP) Stats() UDPStats {
+	u.mu.Lock()
+	active := int64(len(u.sessions))
+	u.mu.Unlock()
+	return UDPStats{
+		Active:       active,
+		Total:        u.total.Load(),
+		Rejected:     u.rejected.Load(),
+		Failed:       u.failed.Load(),
+		DatagramsIn:  u.datagramsIn.Load(),
+		DatagramsOut: u.datagramsOut.Load(),
+		BytesIn:      u.bytesIn.Load(),
+		BytesOut:     u.bytesOut.Load(),
+	}
+}
+
+// ListenAndServe listens on u.Addr and serves datagrams until Shutdown.
+func (u *UDP) ListenAndServe() error {
+	conn, err := net.ListenPacket("udp", u.Addr)
+	if err != nil {
+		return err
+	}
+	return u.Serve(conn)
+}
+
+// Serve reads datagrams from conn until Shutdown, after which it returns
+// ErrListenerClosed.
+func (u *UDP) Serve(conn net.PacketConn) error {
+	u.mu.Lock()
+	if u.closing {
+		u.mu.Unlock()
+		_ = conn.Close()
+		return ErrListenerClosed
+	}
+	u.conn = conn
+	u.mu.Unlock()
+
+	buf := make([]byte, maxDatagram)
+	var backoff time.Duration
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if u.isClosing() {
+				return ErrListenerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				backoff = min(max(2*backoff, 5*time.Millisecond), time.Second)
+				time.Sleep(backoff)
+				continue
+			}
+			return err
+		}
+		backoff = 0
+		if s := u.session(addr); s != nil {
+			s.forward(buf[:n])
+		}
+	}
+}
+
+func (u *UDP) isClosing() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.closing
+}
+
+// session returns the client's session, opening one when it has none. It
+// returns nil, and the datagram is dropped, when the listener is full or no
+// backend can be reached. Only Serve opens sessions, so two are never opened
+// for one client.
+func (u *UDP) session(client net.Addr) *udpSession {
+	key := client.String()
+	u.mu.Lock()
+	if s, ok := u.sessions[key]; ok {
+		u.mu.Unlock()
+		return s
+	}
+	full := u.MaxSessions > 0 && len(u.sessions) >= u.MaxSessions
+	u.mu.Unlock()
+	if full {
+		// Logged at debug only: one line per datagram of a flood helps no one.
+		u.rejected.Add(1)
+		slog.Debug("udp datagram dropped", "listener", u.Name, "client", key, "reason", "max_sessions")
+		return nil
+	}
+
+	picker, b, upstream, err := u.connect(client)
+	if err != nil {
+		u.failed.Add(1)
+		slog.Warn("udp connect failed", "listener", u.Name, "client", key, "pool", u.Pool, "error", err)
+		return nil
+	}
+	s := &udpSession{udp: u, key: key, client: client, picker: picker, backend: b, upstream: upstream, start: time.Now()}
+	s.touch()
+	u.mu.Lock()
+	if u.closing {
+		u.mu.Unlock()
+		_ = upstream.Close()
+		picker.Done(b)
+		return nil
+	}
+	if u.sessions == nil {
+		u.sessions = map[string]*udpSession{}
+	}
+	u.sessions[key] = s
+	u.wg.Add(1)
+	u.mu.Unlock()
+	u.total.Add(1)
+	s.mu.Lock()
+	s.idle = time.AfterFunc(u.IdleTimeout, s.checkIdle)
+	s.mu.Unlock()
+	go s.relay()
+	return s
+}
+
+// untrack forgets s, unless the client has already been given a new session.
+func (u *UDP) untrack(s *udpSession) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.sessions[s.key] == s {
+		delete(u.sessions, s.key)
+	}
+}
+
+// Shutdown stops reading datagrams and closes every session. UDP has no
+// connections to drain, so it only waits, until ctx is done, for the session
+// relays to finish.
+func (u *UDP) Shutdown(ctx context.Context) error {
+	u.mu.Lock()
+	u.closing = true
+	if u.conn != nil {
+		_ = u.conn.Close()
+	}
+	sessions := make([]*udpSession, 0, len(u.sessions))
+	for _, s := range u.sessions {
+		sessions = append(sessions, s)
+	}
+	u.mu.Unlock()
+	for _, s := range sessions {
+		s.close("shutdown")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		u.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// connect picks a backend for client and opens a socket connected to it,
+// moving on to the next backend when that fails. The returned backend's slot
+// must be released with picker.Done.
+func (u *UDP) connect(client net.Addr) (strategy.Picker, *strategy.Backend, net.Conn, error) {
+	picker := u.Pools(u.Pool).Picker
+	if picker == nil {
+		return nil, nil, nil, fmt.Errorf("pool %q not found", u.Pool)
+	}
+	var lastErr error
+	for range picker.Backends() {
+		b, err := u.pick(picker, client)
+		if err != nil {
+			if lastErr != nil {
+				return nil, nil, nil, fmt.Errorf("%w (last dial error: %v)", err, lastErr)
+			}
+			return nil, nil, nil, err
+		}
+		conn, err := net.Dial("udp", b.URL.Host)
+		if err == nil {
+			b.IncRequests()
+			return picker, b, conn, nil
+		}
+		picker.Done(b)
+		b.IncErrors()
+		lastErr = err
+		slog.Warn("udp backend dial failed, marking unhealthy",
+			"listener", u.Name, "backend", b.RawURL, "error", err)
+		b.SetHealthy(false)
+	}
+	if lastErr == nil {
+		// A discovered pool may have no backends yet.
+		return nil, nil, nil, strategy.ErrNoHealthyBackend
+	}
+	return nil, nil, nil, lastErr
+}
+
+// pick selects a backend with the pool's strategy, or by hashing the
+// client's IP. It never waits: the read loop serves every client.
+func (u *UDP) pick(picker strategy.Picker, client net.Addr) (*strategy.Backend, error) {
+	if !u.Hash {
+		return strategy.TryNext(picker)
+	}
+	return hashPick(picker.Backends(), clientIP(client))
+}
+
+// hashPick ranks the healthy, unblocked backends by weighted rendezvous
+// hashing of key and takes a slot on the first one that has room. A key
+// keeps its backend while that backend is up, and when one goes away only
+// its own keys move.
+func hashPick(backends []*strategy.Backend, key string) (*strategy.Backend, error) {
+	type ranked struct {
+		b     *strategy.Backend
+		score float64
+	}
+	var candidates []ranked
+	for _, b := range backends {
+		if !b.IsHealthy() || b.IsBlocked() {
+			continue
+		}
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(b.RawURL))
+		// Map the hash into (0,1); -w/ln(x) weights the backend's share.
+		x := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+		candidates = append(candidates, ranked{b: b, score: -float64(max(b.Weight, 1)) / math.Log(x)})
+	}
+	if len(candidates) == 0 {
+		return nil, strategy.ErrNoHealthyBackend
+	}
+	for len(candidates) > 0 {
+		best := 0
+		for i, c := range candidates {
+			if c.score > candidates[best].score {
+				best = i
+			}
+		}
+		if b := candidates[best].b; b.TryIncConns() {
+			return b, nil
+		}
+		candidates = append(candidates[:best], candidates[best+1:]...)
+	}
+	return nil, strategy.ErrAllSaturated
+}
+
+// clientIP returns the host part of addr, so that a client keeps its
+// backend across source ports.
+func clientIP(addr net.Addr) string {
+	if ua, ok := addr.(*net.UDPAddr); ok {
+		return ua.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// udpSession is one client address and the backend its datagrams go to.
+type udpSession struct {
+	udp      *UDP
+	key      string
+	client   net.Addr
+	picker   strategy.Picker
+	backend  *strategy.Backend
+	upstream net.Conn
+	start    time.Time
+	last     atomic.Int64 // unix nanoseconds of the last datagram either way
+
+	in  atomic.Int64 // datagrams from the client
+	out atomic.Int64 // datagrams to the client
+
+	mu     sync.Mutex // guards the fields below
+	reason string     // why the session closed; empty while open
+	idle   *time.Timer
+}
+
+// forward sends a client datagram to the backend.
+func (s *udpSession) forward(p []byte) {
+	if _, err := s.upstream.Write(p); err != nil {
+		s.close("closed")
+		return
+	}
+	s.touch()
+	s.in.Add(1)
+	s.udp.datagramsIn.Add(1)
+	s.udp.bytesIn.Add(int64(len(p)))
+}
+
+// relay sends the backend's replies to the client until the session closes.
+// A refused read means the backend answered with ICMP port unreachable: it
+// is marked unhealthy, as a failed TCP dial is, and the client's next
+// datagram opens a session with another backend.
+func (s *udpSession) relay() {
+	u := s.udp
+	defer u.wg.Done()
+	buf := make([]byte, maxDatagram)
+	for {
+		n, err := s.upstream.Read(buf)
+		if err != nil {
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				s.backend.IncErrors()
+				slog.Warn("udp backend refused datagrams, marking unhealthy",
+					"listener", u.Name, "backend", s.backend.RawURL)
+				s.backend.SetHealthy(false)
+				s.close("refused")
+			}
+			break
+		}
+		s.touch()
+		if _, err := u.conn.WriteTo(buf[:n], s.client); err != nil {
+			break
+		}
+		s.out.Add(1)
+		u.datagramsOut.Add(1)
+		u.bytesOut.Add(int64(n))
+	}
+	s.close("closed")
+	s.picker.Done(s.backend)
+
+	slog.Debug("udp session closed",
+		"listener", u.Name,
+		"client", s.key,
+		"backend", s.backend.RawURL,
+		"duration_ms", time.Since(s.start).Milliseconds(),
+		"datagrams_in", s.in.Load(),
+		"datagrams_out", s.out.Load(),
+		"reason", s.closeReason(),
+	)
+}
+
+// touch records traffic, which keeps the session from idling out.
+func (s *udpSession) touch() { s.last.Store(time.Now().UnixNano()) }
+
+// checkIdle closes the session if it has carried no traffic for IdleTimeout,
+// and otherwise checks again when it next could have.
+func (s *udpSession) checkIdle() {
+	d := s.udp.IdleTimeout
+	idle := time.Since(time.Unix(0, s.last.Load()))
+	if idle >= d {
+		s.close("idle_timeout")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reason == "" {
+		s.idle.Reset(d - idle)
+	}
+}
+
+// close ends the session: the client's next datagram opens a new one.
+func (s *udpSession) close(reason string) {
+	s.mu.Lock()
+	if s.reason != "" {
+		s.mu.Unlock()
+		return
+	}
+	s.reason = reason
+	if s.idle != nil {
+		s.idle.Stop()
+	}
+	s.mu.Unlock()
+	_ = s.upstream.Close()
+	s.udp.untrack(s)
+}
+
+// closeReason returns why the session closed: idle_timeout, refused,
+// shutdown or closed.
+func (s *udpSession) closeReason() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reason
+}
@@ -0,0 +1,253 @@
+package l4_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"golb/internal/config"
+	"golb/internal/l4"
+	"golb/internal/strategy"
+)
+
+// ── Helpers ───────────────────────────────────────────────────────────────────
+
+// udpBackend starts a UDP server that answers every datagram with name, a
+// colon and the datagram.
+func udpBackend(t *testing.T, name string) *strategy.Backend {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = pc.Close() })
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = pc.WriteTo(append([]byte(name+":"), buf[:n]...), addr)
+		}
+	}()
+	b, err := strategy.NewBackend("udp://"+pc.LocalAddr().String(), 1)
+	require.NoError(t, err)
+	return b
+}
+
+// deadUDPBackend returns a backend whose port answers with ICMP port
+// unreachable.
+func deadUDPBackend(t *testing.T) *strategy.Backend {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := pc.LocalAddr().String()
+	require.NoError(t, pc.Close())
+	b, err := strategy.NewBackend("udp://"+addr, 1)
+	require.NoError(t, err)
+	return b
+}
+
+// startUDP serves l on a free loopback port and returns its address.
+func startUDP(t *testing.T, l *l4.UDP) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = l.Serve(pc) }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = l.Shutdown(ctx)
+	})
+	return pc.LocalAddr().String()
+}
+
+func newUDP(picker strategy.Picker) *l4.UDP {
+	return l4.NewUDP(config.UDPListenerCfg{Name: "test", Pool: "test"}, func(string) l4.Upstream { return l4.Upstream{Picker: picker} })
+}
+
+// udpClient dials addr from a fresh source port.
+func udpClient(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("udp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// ask sends msg and returns the reply, or an error when none arrives.
+func ask(conn net.Conn, msg string) (string, error) {
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		return "", err
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	return string(buf[:n]), err
+}
+
+// backendOf sends msg and returns the name of the backend that answered.
+func backendOf(t *testing.T, conn net.Conn, msg string) string {
+	t.Helper()
+	reply, err := ask(conn, msg)
+	require.NoError(t, err)
+	name, echoed, _ := strings.Cut(reply, ":")
+	require.Equal(t, msg, echoed)
+	return name
+}
+
+// ── Sessions ──────────────────────────────────────────────────────────────────
+
+func TestUDP_RepliesReachTheRightClient(t *testing.T) {
+	b := udpBackend(t, "dns")
+	l := newUDP(strategy.NewRoundRobin([]*strategy.Backend{b}))
+	addr := startUDP(t, l)
+	one, two := udpClient(t, addr), udpClient(t, addr)
+
+	reply, err := ask(one, "q1")
+	require.NoError(t, err)
+	assert.Equal(t, "dns:q1", reply)
+	reply, err = ask(two, "q2")
+	require.NoError(t, err)
+	assert.Equal(t, "dns:q2", reply)
+	reply, err = ask(one, "q3")
+	require.NoError(t, err)
+	assert.Equal(t, "dns:q3", reply)
+
+	st := l.Stats()
+	assert.Equal(t, int64(2), st.Active, "one session per client address")
+	assert.Equal(t, int64(2), st.Total)
+	assert.Equal(t, int64(3), st.DatagramsIn)
+	assert.Equal(t, int64(3), st.DatagramsOut)
+	assert.Equal(t, int64(6), st.BytesIn)
+	assert.Equal(t, int64(18), st.BytesOut)
+	assert.Equal(t, int64(2), b.ActiveConns(), "each session holds a slot")
+}
+
+func TestUDP_SessionsStickToTheirBackend(t *testing.T) {
+	a, b := udpBackend(t, "a"), udpBackend(t, "b")
+	addr := startUDP(t, newUDP(strategy.NewRoundRobin([]*strategy.Backend{a, b})))
+
+	seen := map[string]int{}
+	for range 4 {
+		conn := udpClient(t, addr)
+		first := backendOf(t, conn, "x")
+		seen[first]++
+		for range 3 {
+			assert.Equal(t, first, backendOf(t, conn, "y"), "a session keeps its backend")
+		}
+	}
+	assert.Equal(t, map[string]int{"a": 2, "b": 2}, seen, "new sessions follow the strategy")
+}
+
+func TestUDP_HashAffinityPinsClientIPs(t *testing.T) {
+	a, b, c := udpBackend(t, "a"), udpBackend(t, "b"), udpBackend(t, "c")
+	l := newUDP(strategy.NewRoundRobin([]*strategy.Backend{a, b, c}))
+	l.Hash = true
+	addr := startUDP(t, l)
+
+	pinned := backendOf(t, udpClient(t, addr), "x")
+	for range 5 {
+		assert.Equal(t, pinned, backendOf(t, udpClient(t, addr), "x"), "every source port of one IP lands on one backend")
+	}
+
+	// The IP moves while its backend is down and returns when it recovers.
+	byName := map[string]*strategy.Backend{"a": a, "b": b, "c": c}
+	byName[pinned].SetHealthy(false)
+	moved := backendOf(t, udpClient(t, addr), "x")
+	assert.NotEqual(t, pinned, moved)
+	byName[pinned].SetHealthy(true)
+	assert.Equal(t, pinned, backendOf(t, udpClient(t, addr), "x"))
+}
+
+func TestUDP_RefusedBackendIsMarkedUnhealthy(t *testing.T) {
+	dead, live := deadUDPBackend(t), udpBackend(t, "live")
+	l := newUDP(strategy.NewRoundRobin([]*strategy.Backend{dead, live}))
+	addr := startUDP(t, l)
+
+	conn := udpClient(t, addr)
+	_, err := ask(conn, "lost")
+	assert.Error(t, err, "the first datagram went to the dead backend")
+	assert.Eventually(t, func() bool { return !dead.IsHealthy() }, time.Second, 5*time.Millisecond)
+	assert.Eventually(t, func() bool { return dead.ActiveConns() == 0 }, time.Second, 5*time.Millisecond)
+
+	assert.Equal(t, "live", backendOf(t, conn, "retry"), "the client's next datagram opens a new session")
+	assert.Equal(t, int64(1), dead.TotalErrors())
+}
+
+func TestUDP_NoBackendDropsTheDatagram(t *testing.T) {
+	b := udpBackend(t, "dns")
+	b.SetHealthy(false)
+	l := newUDP(strategy.NewRoundRobin([]*strategy.Backend{b}))
+	_, err := ask(udpClient(t, startUDP(t, l)), "q")
+	assert.Error(t, err)
+	assert.Equal(t, int64(1), l.Stats().Failed)
+	assert.Zero(t, l.Stats().Active)
+}
+
+func TestUDP_EmptyDiscoveredPoolDropsTheDatagram(t *testing.T) {
+	picker, err := strategy.NewDynamic("round_robin")
+	require.NoError(t, err)
+	l := newUDP(picker)
+	conn := udpClient(t, startUDP(t, l))
+	for range 2 {
+		_, err := ask(conn, "q")
+		assert.Error(t, err)
+	}
+	assert.Equal(t, int64(2), l.Stats().Failed, "each datagram tries again, and none opens a session")
+	assert.Zero(t, l.Stats().Active)
+}
+
+// ── Limits ────────────────────────────────────────────────────────────────────
+
+func TestUDP_IdleTimeoutEndsSessions(t *testing.T) {
+	b := udpBackend(t, "syslog")
+	l := newUDP(strategy.NewRoundRobin([]*strategy.Backend{b}))
+	l.IdleTimeout = 100 * time.Millisecond
+	conn := udpClient(t, startUDP(t, l))
+
+	// Traffic keeps the session open past the timeout.
+	for range 3 {
+		time.Sleep(60 * time.Millisecond)
+		backendOf(t, conn, "x")
+	}
+	assert.Equal(t, int64(1), l.Stats().Total)
+	assert.Eventually(t, func() bool { return l.Stats().Active == 0 }, time.Second, 5*time.Millisecond)
+	assert.Eventually(t, func() bool { return b.ActiveConns() == 0 }, time.Second, 5*time.Millisecond)
+
+	backendOf(t, conn, "again")
+	assert.Equal(t, int64(2), l.Stats().Total, "the next datagram opens a new session")
+}
+
+func TestUDP_MaxSessionsDropsNewClients(t *testing.T) {
+	b := udpBackend(t, "dns")
+	l := newUDP(strategy.NewRoundRobin([]*strategy.Backend{b}))
+	l.MaxSessions = 1
+	addr := startUDP(t, l)
+	first := udpClient(t, addr)
+	backendOf(t, first, "x")
+
+	_, err := ask(udpClient(t, addr), "y")
+	assert.Error(t, err, "the listener is full")
+	assert.Equal(t, int64(1), l.Stats().Rejected)
+	backendOf(t, first, "z")
+}
+
+func TestUDP_ShutdownClosesSessions(t *testing.T) {
+	b := udpBackend(t, "dns")
+	l := newUDP(strategy.NewRoundRobin([]*strategy.Backend{b}))
+	conn := udpClient(t, startUDP(t, l))
+	backendOf(t, conn, "x")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, l.Shutdown(ctx))
+	assert.Zero(t, l.Stats().Active)
+	assert.Zero(t, b.ActiveConns())
+	_, err := ask(conn, "y")
+	assert.Error(t, err)
+}
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"context"
 	"log/slog"
 	"net/http"
 	"strings"
@@ -8,6 +9,17 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// claimsKey is the context key for the verified token's claims.
+type claimsKey struct{}
+
+// Claims returns the claims of the JWT verified by JWTAuth for this request,
+// or nil when the request was not authenticated (auth disabled or excluded
+// path).
+func Claims(ctx context.Context) jwt.MapClaims {
+	c, _ := ctx.Value(claimsKey{}).(jwt.MapClaims)
+	return c
+}
+
 // JWTAuth returns a middleware that enforces Bearer JWT authentication using
 // HMAC-SHA256 (HS256). Tokens must be present in the Authorization header as
 // "Bearer <token>".
@@ -16,6 +28,7 @@ import (
 //   - exclude — exact URL paths that bypass authentication (e.g. "/healthz").
 //
 // Returns 401 Unauthorized when the header is missing or the token is invalid.
+// The verified claims are available to later handlers through Claims.
 //
 // ⚠  In production the secret should come from an environment variable or a
 // secrets manager, not from the config file on disk.
@@ -65,6 +78,9 @@ func JWTAuth(secret string, exclude []string) func(http.Handler) http.Handler {
 				return
 			}
 
+			if claims, ok := token.Claims.(jwt.MapClaims); ok {
+				r = r.WithContext(context.WithValue(r.Context(), claimsKey{}, claims))
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
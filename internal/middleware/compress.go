@@ -0,0 +1,331 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultCompressEncodings is the server's preference order when a client
+// accepts several encodings equally.
+var DefaultCompressEncodings = []string{"zstd", "br", "gzip"}
+
+// DefaultCompressTypes are the media types compressed when none are
+// configured. A "type/*" entry matches every subtype.
+var DefaultCompressTypes = []string{
+	"text/*",
+	"application/json",
+	"application/problem+json",
+	"application/x-ndjson",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// encoder is a pooled compressor for one Content-Encoding.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+var encoderPools = map[string]*sync.Pool{
+	"gzip": {New: func() any {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	}},
+	"br": {New: func() any {
+		// Level 5 keeps brotli close to gzip's speed on dynamic content.
+		return brotli.NewWriterLevel(nil, 5)
+	}},
+	"zstd": {New: func() any {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return w
+	}},
+}
+
+// Compress returns a middleware that compresses responses for clients that
+// accept it.
+//
+//   - encodings    — supported encodings (gzip, br, zstd) in the server's
+//     preference order; nil means DefaultCompressEncodings.
+//   - minSize      — smaller bodies are sent as is.
+//   - contentTypes — media types worth compressing; nil means
+//     DefaultCompressTypes.
+//
+// The encoding is negotiated from Accept-Encoding, honouring q-values.
+// Responses that already have a Content-Encoding, partial content and
+// bodyless responses are left alone. Compressible responses always carry
+// Vary: Accept-Encoding, so shared caches keep the variants apart. Bodies are
+// buffered only up to minSize; a Flush from the handler (e.g. a streamed
+// response) starts compression straight away and flushes the encoder.
+func Compress(encodings []string, minSize int, contentTypes []string) func(http.Handler) http.Handler {
+	if len(encodings) == 0 {
+		encodings = DefaultCompressEncodings
+	}
+	if len(contentTypes) == 0 {
+		contentTypes = DefaultCompressTypes
+	}
+	var supported []string
+	for _, e := range encodings {
+		if encoderPools[e] != nil {
+			supported = append(supported, e)
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cw := &compressWriter{
+				ResponseWriter: w,
+				encoding:       negotiateEncoding(r.Header.Get("Accept-Encoding"), supported),
+				minSize:        minSize,
+				types:          contentTypes,
+				head:           r.Method == http.MethodHead,
+				status:         http.StatusOK,
+			}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// compressWriter decides whether to compress once it has seen the response
+// headers and either minSize bytes of body, a Flush or the end of the
+// response.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string // negotiated encoding; "" when the client accepts none
+	minSize  int
+	types    []string
+	head     bool
+
+	status      int
+	wroteHeader bool // WriteHeader was called by the handler
+	decided     bool // headers have been sent downstream
+	hijacked    bool
+	buf         []byte
+	enc         encoder
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.decided || cw.wroteHeader {
+		return
+	}
+	if code >= 100 && code < 200 {
+		// Informational responses (and 101 upgrades) go straight through.
+		cw.ResponseWriter.WriteHeader(code)
+		if code == http.StatusSwitchingProtocols {
+			cw.decided = true
+		}
+		return
+	}
+	cw.status, cw.wroteHeader = code, true
+	if cw.head || code == http.StatusNoContent || code == http.StatusNotModified ||
+		code == http.StatusPartialContent || !cw.eligible() || cw.encoding == "" {
+		cw.start(false)
+		return
+	}
+	if cl, err := strconv.Atoi(cw.Header().Get("Content-Length")); err == nil {
+		cw.start(cl >= cw.minSize)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided && !cw.wroteHeader {
+		if cw.Header().Get("Content-Type") == "" {
+			// Sniff now, as net/http would, so the type check can run.
+			cw.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) >= cw.minSize {
+			cw.start(true)
+			if err := cw.drain(); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush starts compressing whatever has been buffered, flushes the encoder
+// and then the underlying connection, so streamed responses are not held
+// back until minSize bytes arrive.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if !cw.wroteHeader {
+			cw.WriteHeader(http.StatusOK)
+		}
+		if !cw.decided {
+			cw.start(true)
+		}
+		_ = cw.drain()
+	}
+	if cw.enc != nil {
+		_ = cw.enc.Flush()
+	}
+	_ = http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Hijack hands the connection over, e.g. for a protocol upgrade.
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(cw.ResponseWriter).Hijack()
+	if err == nil {
+		cw.decided, cw.hijacked = true, true
+	}
+	return conn, brw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *compressWriter) Unwrap() http.ResponseWriter { return cw.ResponseWriter }
+
+// eligible reports whether the response may be compressed at all, and marks
+// it as varying by Accept-Encoding if so.
+func (cw *compressWriter) eligible() bool {
+	h := cw.Header()
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" ||
+		hasToken(h.Values("Cache-Control"), "no-transform") {
+		return false
+	}
+	if !matchesType(h.Get("Content-Type"), cw.types) {
+		return false
+	}
+	if !hasToken(h.Values("Vary"), "Accept-Encoding") {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	return true
+}
+
+// start sends the headers downstream, switching them to the compressed
+// representation when compress is true.
+func (cw *compressWriter) start(compress bool) {
+	cw.decided = true
+	if compress {
+		h := cw.Header()
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		// The compressed bytes differ, so a strong validator no longer holds.
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.enc = encoderPools[cw.encoding].Get().(encoder)
+		cw.enc.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+// drain writes out the buffered body.
+func (cw *compressWriter) drain() error {
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	buf := cw.buf
+	cw.buf = nil
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// close finishes the response once the handler has returned. A body that
+// never reached minSize is sent uncompressed.
+func (cw *compressWriter) close() {
+	if cw.hijacked {
+		return
+	}
+	if !cw.decided {
+		if !cw.wroteHeader && len(cw.buf) == 0 {
+			// The handler wrote nothing; let net/http send its default.
+			return
+		}
+		cw.start(false)
+		_ = cw.drain()
+	}
+	if cw.enc != nil {
+		_ = cw.enc.Close()
+		cw.enc.Reset(nil)
+		encoderPools[cw.encoding].Put(cw.enc)
+		cw.enc = nil
+	}
+}
+
+// negotiateEncoding picks the supported encoding with the highest q-value in
+// an Accept-Encoding header, breaking ties by the order of supported.
+func negotiateEncoding(accept string, supported []string) string {
+	if accept == "" {
+		return ""
+	}
+	q := map[string]float64{}
+	wildcard := -1.0
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		weight := 1.0
+		if k, v, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(k) == "q" {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				weight = f
+			}
+		}
+		if name == "*" {
+			wildcard = weight
+		} else {
+			q[name] = weight
+		}
+	}
+	best, bestQ := "", 0.0
+	for _, enc := range supported {
+		w, ok := q[enc]
+		if !ok {
+			w = wildcard
+		}
+		if w > bestQ {
+			best, bestQ = enc, w
+		}
+	}
+	return best
+}
+
+// matchesType reports whether the media type of contentType is in types.
+func matchesType(contentType string, types []string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range types {
+		if t == mt || strings.HasSuffix(t, "/*") && strings.HasPrefix(mt, t[:len(t)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasToken reports whether a comma-separated header contains token.
+func hasToken(values []string, token string) bool {
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t == "*" || strings.EqualFold(t, token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -3,15 +3,19 @@
 package middleware
 
 import (
+	"bufio"
 	"crypto/rand"
 	"encoding/hex"
 	"log/slog"
+	"net"
 	"net/http"
 	"time"
 )
 
 // responseRecorder wraps http.ResponseWriter to capture the status code and
-// number of bytes written by the downstream handler.
+// number of bytes written by the downstream handler. It passes Flush and
+// Hijack through, so streamed responses and protocol upgrades keep working
+// behind the logger.
 type responseRecorder struct {
 	http.ResponseWriter
 	status int
@@ -29,6 +33,21 @@ func (rr *responseRecorder) Write(b []byte) (int, error) {
 	return n, err
 }
 
+func (rr *responseRecorder) Flush() {
+	_ = http.NewResponseController(rr.ResponseWriter).Flush()
+}
+
+func (rr *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rr.ResponseWriter).Hijack()
+	if err == nil {
+		rr.status = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rr *responseRecorder) Unwrap() http.ResponseWriter { return rr.ResponseWriter }
+
 // Logger returns a middleware that emits one structured JSON log line per
 // request, including method, path, status, response size, and latency.
 // It also generates a unique X-Request-Id header that is forwarded upstream
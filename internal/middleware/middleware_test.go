@@ -1,12 +1,19 @@
 package middleware_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/andybalholm/brotli"
 	jwtlib "github.com/golang-jwt/jwt/v5"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -60,6 +67,153 @@ func TestLogger_UniqueRequestIDs(t *testing.T) {
 	assert.Len(t, ids, 50, "every request should receive a unique X-Request-Id")
 }
 
+func TestLogger_PassesFlushAndHijackThrough(t *testing.T) {
+	var flushed, hijacked bool
+	handler := middleware.Logger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, flushed = w.(http.Flusher)
+		_, hijacked = w.(http.Hijacker)
+		w.(http.Flusher).Flush()
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.True(t, flushed)
+	assert.True(t, hijacked)
+	assert.True(t, rec.Flushed)
+}
+
+// ── Compress ─────────────────────────────────────────────────────────────────
+
+func TestCompress_NegotiatesEncoding(t *testing.T) {
+	body := strings.Repeat(`{"hello":"world"},`, 200)
+	handler := middleware.Compress(nil, 1024, nil)(jsonHandler(body))
+
+	for _, tc := range []struct {
+		accept, want string
+	}{
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"gzip, br, zstd", "zstd"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"*", "zstd"},
+		{"*, zstd;q=0", "br"},
+		{"deflate", ""},
+		{"", ""},
+	} {
+		t.Run(tc.accept, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", tc.accept)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.want, rec.Header().Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+			assert.Equal(t, body, decode(t, tc.want, rec.Body.Bytes()))
+		})
+	}
+}
+
+func TestCompress_SkipsIneligibleResponses(t *testing.T) {
+	big := strings.Repeat("a", 4096)
+	cases := map[string]http.HandlerFunc{
+		"too small": jsonHandler("{}"),
+		"image": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write([]byte(big))
+		},
+		"already encoded": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "br")
+			_, _ = w.Write([]byte(big))
+		},
+		"no-transform": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Cache-Control", "public, no-transform")
+			_, _ = w.Write([]byte(big))
+		},
+		"partial content": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Range", "bytes 0-4095/10000")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write([]byte(big))
+		},
+	}
+	for name, h := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			rec := httptest.NewRecorder()
+			middleware.Compress([]string{"gzip"}, 1024, nil)(h).ServeHTTP(rec, req)
+
+			ce := rec.Header().Get("Content-Encoding")
+			assert.NotEqual(t, "gzip", ce)
+			if ce == "" {
+				assert.NotEmpty(t, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestCompress_KnownLengthAndValidators(t *testing.T) {
+	body := strings.Repeat("x", 2048)
+	handler := middleware.Compress([]string{"gzip"}, 1024, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Vary", "Origin")
+		_, _ = w.Write([]byte(body))
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	assert.Empty(t, rec.Header().Get("Content-Length"))
+	assert.Empty(t, rec.Header().Get("Accept-Ranges"))
+	assert.Equal(t, `W/"v1"`, rec.Header().Get("ETag"))
+	assert.Equal(t, []string{"Origin", "Accept-Encoding"}, rec.Header().Values("Vary"))
+	assert.Equal(t, body, decode(t, "gzip", rec.Body.Bytes()))
+}
+
+func TestCompress_StreamsOnFlush(t *testing.T) {
+	events := make(chan string)
+	handler := middleware.Logger(middleware.Compress([]string{"gzip"}, 1024, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for ev := range events {
+			_, _ = io.WriteString(w, "data: "+ev+"\n\n")
+			w.(http.Flusher).Flush()
+		}
+	})))
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	defer close(events)
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	go func() { events <- "one" }()
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+
+	// Each flushed event must be readable before the stream ends.
+	zr, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	buf := make([]byte, len("data: one\n\n"))
+	_, err = io.ReadFull(zr, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "data: one\n\n", string(buf))
+
+	events <- "two"
+	buf = make([]byte, len("data: two\n\n"))
+	_, err = io.ReadFull(zr, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "data: two\n\n", string(buf))
+}
+
 // ── RateLimiter ──────────────────────────────────────────────────────────────
 
 func TestRateLimiter_AllowsBurst(t *testing.T) {
@@ -156,6 +310,21 @@ func TestJWTAuth_ExcludedPath_NoTokenNeeded(t *testing.T) {
 	}
 }
 
+func TestJWTAuth_ExposesClaims(t *testing.T) {
+	var sub any
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sub = middleware.Claims(r.Context())["sub"]
+	})
+	handler := middleware.JWTAuth(testSecret, nil)(inner)
+
+	req := httptest.NewRequest("GET", "/api", nil)
+	req.Header.Set("Authorization", "Bearer "+signedToken(t, testSecret))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "test-user", sub)
+
+	assert.Nil(t, middleware.Claims(httptest.NewRequest("GET", "/", nil).Context()))
+}
+
 // ── helpers ──────────────────────────────────────────────────────────────────
 
 func ok200() http.Handler {
@@ -180,3 +349,32 @@ func signedToken(t *testing.T, secret string) string {
 	require.NoError(t, err)
 	return s
 }
+
+func jsonHandler(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, body)
+	}
+}
+
+// decode undoes a Content-Encoding.
+func decode(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+	var r io.Reader = bytes.NewReader(body)
+	switch encoding {
+	case "gzip":
+		zr, err := gzip.NewReader(r)
+		require.NoError(t, err)
+		r = zr
+	case "br":
+		r = brotli.NewReader(r)
+	case "zstd":
+		zr, err := zstd.NewReader(r)
+		require.NoError(t, err)
+		defer zr.Close()
+		r = zr
+	}
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(out)
+}
@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"golb/internal/config"
+)
+
+// Redirect answers a route's requests with a redirect instead of proxying
+// them.
+type Redirect struct {
+	Status int
+	To     *Template      // target; nil means the request's path and query
+	Regex  *regexp.Regexp // optional; matched against the path, groups feed To
+	HTTPS  bool           // upgrade the target to https://
+}
+
+// NewRedirect compiles cfg.
+func NewRedirect(cfg config.RedirectCfg) (*Redirect, error) {
+	rd := &Redirect{Status: cfg.ParsedStatus(), HTTPS: cfg.HTTPS}
+	if cfg.Regex != "" {
+		re, err := regexp.Compile(cfg.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("regex: %w", err)
+		}
+		rd.Regex = re
+	}
+	if cfg.To != "" {
+		t, err := parseTemplate(cfg.To, rd.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("to: %w", err)
+		}
+		rd.To = t
+	}
+	return rd, nil
+}
+
+// target returns the Location for r, or false when the redirect's regex does
+// not match the path.
+func (rd *Redirect) target(r *http.Request, rt *Route) (string, bool) {
+	v := templateVars{req: r, route: rt}
+	if rd.Regex != nil {
+		v.captures = rd.Regex.FindStringSubmatch(r.URL.Path)
+		if v.captures == nil {
+			return "", false
+		}
+	}
+	loc := r.URL.RequestURI()
+	if rd.To != nil {
+		loc = rd.To.expand(v)
+	}
+	if !rd.HTTPS {
+		return loc, true
+	}
+	u, err := url.Parse(loc)
+	if err != nil {
+		return loc, true
+	}
+	if u.Host == "" {
+		// The client's port served plain HTTP; let https use its default.
+		u.Host = r.Host
+		if host, _, err := net.SplitHostPort(r.Host); err == nil {
+			u.Host = host
+		}
+	}
+	u.Scheme = "https"
+	return u.String(), true
+}
+
+// Response is a fixed response a route answers with instead of proxying.
+type Response struct {
+	Status  int
+	Headers []HeaderValue
+	Body    []byte
+}
+
+// NewResponse compiles cfg, reading BodyFile if set. Without a configured
+// Content-Type, one is taken from the file extension or sniffed from the
+// body.
+func NewResponse(cfg config.RespondCfg) (*Response, error) {
+	resp := &Response{Status: cfg.ParsedStatus(), Body: []byte(cfg.Body)}
+	if cfg.BodyFile != "" {
+		body, err := os.ReadFile(cfg.BodyFile)
+		if err != nil {
+			return nil, fmt.Errorf("body_file: %w", err)
+		}
+		resp.Body = body
+	}
+	hasType := false
+	for _, name := range sortedKeys(cfg.Headers) {
+		t, err := ParseTemplate(cfg.Headers[name])
+		if err != nil {
+			return nil, fmt.Errorf("header %q: %w", name, err)
+		}
+		name = http.CanonicalHeaderKey(name)
+		hasType = hasType || name == "Content-Type"
+		resp.Headers = append(resp.Headers, HeaderValue{Name: name, Value: t})
+	}
+	if !hasType && len(resp.Body) > 0 {
+		ctype := mime.TypeByExtension(filepath.Ext(cfg.BodyFile))
+		if ctype == "" {
+			ctype = http.DetectContentType(resp.Body)
+		}
+		t, _ := ParseTemplate(ctype)
+		resp.Headers = append(resp.Headers, HeaderValue{Name: "Content-Type", Value: t})
+	}
+	return resp, nil
+}
+
+// write sends the response. HEAD requests and bodyless statuses get headers
+// only.
+func (resp *Response) write(w http.ResponseWriter, r *http.Request, rt *Route) {
+	v := templateVars{req: r, route: rt}
+	h := w.Header()
+	for _, hv := range resp.Headers {
+		h.Set(hv.Name, hv.Value.expand(v))
+	}
+	rt.ResponseHeaders.apply(h, v)
+	bodyless := resp.Status == http.StatusNoContent || resp.Status == http.StatusNotModified
+	if !bodyless {
+		h.Set("Content-Length", strconv.Itoa(len(resp.Body)))
+	}
+	w.WriteHeader(resp.Status)
+	if !bodyless && r.Method != http.MethodHead {
+		_, _ = w.Write(resp.Body)
+	}
+}
+
+// serveAction answers r for a route that redirects or responds itself.
+func (gw *Gateway) serveAction(w http.ResponseWriter, r *http.Request, rt *Route) {
+	if rt.Respond != nil {
+		rt.Respond.write(w, r, rt)
+		return
+	}
+	loc, ok := rt.Redirect.target(r, rt)
+	if !ok {
+		gw.errorPages().Write(w, r, http.StatusNotFound, "no route matches this path")
+		return
+	}
+	rt.ResponseHeaders.apply(w.Header(), templateVars{req: r, route: rt})
+	http.Redirect(w, r, loc, rt.Redirect.Status)
+}
@@ -0,0 +1,253 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golb/internal/config"
+)
+
+// representationHeaders always take part in the coalescing key: they change
+// the response a backend sends for the same URL.
+var representationHeaders = []string{"Accept-Encoding", "If-None-Match", "If-Modified-Since"}
+
+// Coalescer lets concurrent identical GET and HEAD requests on a route share
+// one upstream call. The first request (the leader) goes upstream as usual;
+// requests with the same key that arrive while it is in flight wait and get
+// a copy of its response. Waiters go upstream on their own when the
+// response is too large to share, private to the leader's client (it sets a
+// cookie or is Cache-Control private or no-store), when the leader's client
+// went away before the response completed, or when MaxWaiters are already
+// waiting.
+type Coalescer struct {
+	KeyHeaders         []string // request headers that must also match, canonicalised
+	MaxWaiters         int      // requests waiting on one call
+	MaxBodyBytes       int64    // larger responses are not shared
+	AllowAuthorization bool     // coalesce requests carrying Authorization
+
+	mu    sync.Mutex
+	calls map[string]*call
+
+	leaders  atomic.Int64
+	shared   atomic.Int64
+	bypassed atomic.Int64
+}
+
+// CoalesceStats counts how a route's coalescing requests were served.
+type CoalesceStats struct {
+	Leaders  int64 // requests that made the upstream call
+	Shared   int64 // requests answered with a leader's response
+	Bypassed int64 // waiters that went upstream themselves: response too large or private, leader aborted or too many waiters
+}
+
+// call is one in-flight upstream request and, once done is closed, its
+// response.
+type call struct {
+	done    chan struct{}
+	waiters int
+
+	ok     bool // the response is complete and may be shared
+	status int
+	header http.Header
+	body   []byte
+}
+
+// NewCoalescer builds a Coalescer from a route's config, or returns nil when
+// the route does not coalesce.
+func NewCoalescer(cfg config.CoalesceCfg) *Coalescer {
+	if !cfg.Enabled {
+		return nil
+	}
+	c := &Coalescer{
+		MaxWaiters:         cfg.ParsedMaxWaiters(),
+		MaxBodyBytes:       cfg.ParsedMaxBodyBytes(),
+		AllowAuthorization: cfg.AllowAuthorization,
+		calls:              map[string]*call{},
+	}
+	for _, h := range cfg.KeyHeaders {
+		c.KeyHeaders = append(c.KeyHeaders, http.CanonicalHeaderKey(h))
+	}
+	return c
+}
+
+// Stats returns the coalescer's counters.
+func (c *Coalescer) Stats() CoalesceStats {
+	return CoalesceStats{Leaders: c.leaders.Load(), Shared: c.shared.Load(), Bypassed: c.bypassed.Load()}
+}
+
+// eligible reports whether r may share an upstream call. Credentials keep a
+// request to itself unless allowed, or, for cookies, part of the key.
+func (c *Coalescer) eligible(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if r.ContentLength > 0 || r.Header.Get("Upgrade") != "" || r.Header.Get("Range") != "" {
+		return false
+	}
+	if r.Header.Get("Cookie") != "" && !slices.Contains(c.KeyHeaders, "Cookie") {
+		return false
+	}
+	return c.AllowAuthorization || r.Header.Get("Authorization") == ""
+}
+
+// shareable reports whether a response with header h may be handed to other
+// clients than the one it was made for.
+func shareable(h http.Header) bool {
+	if len(h.Values("Set-Cookie")) > 0 {
+		return false
+	}
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			d, _, _ = strings.Cut(strings.TrimSpace(d), "=")
+			if strings.EqualFold(d, "private") || strings.EqualFold(d, "no-store") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// key identifies requests that may share a response.
+func (c *Coalescer) key(r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteString(" ")
+	b.WriteString(strings.ToLower(r.Host))
+	b.WriteString(r.URL.RequestURI())
+	for _, names := range [][]string{representationHeaders, c.KeyHeaders} {
+		for _, name := range names {
+			b.WriteString("\x00")
+			b.WriteString(name)
+			b.WriteString("=")
+			b.WriteString(strings.Join(r.Header.Values(name), ","))
+		}
+	}
+	return b.String()
+}
+
+// serve answers r through next, sharing the upstream call with identical
+// concurrent requests.
+func (c *Coalescer) serve(w http.ResponseWriter, r *http.Request, next func(http.ResponseWriter, *http.Request)) {
+	if !c.eligible(r) {
+		next(w, r)
+		return
+	}
+	key := c.key(r)
+
+	c.mu.Lock()
+	if cl := c.calls[key]; cl != nil {
+		if cl.waiters >= c.MaxWaiters {
+			c.mu.Unlock()
+			c.bypassed.Add(1)
+			next(w, r)
+			return
+		}
+		cl.waiters++
+		c.mu.Unlock()
+		select {
+		case <-cl.done:
+		case <-r.Context().Done():
+			return
+		}
+		if !cl.ok {
+			c.bypassed.Add(1)
+			next(w, r)
+			return
+		}
+		c.shared.Add(1)
+		cl.write(w, r)
+		return
+	}
+	cl := &call{done: make(chan struct{})}
+	c.calls[key] = cl
+	c.mu.Unlock()
+	c.leaders.Add(1)
+
+	tw := &teeWriter{client: w, header: http.Header{}, limit: c.MaxBodyBytes}
+	finished := false
+	// Deferred so that waiters are released even when the proxy aborts the
+	// response with a panic.
+	defer func() {
+		c.mu.Lock()
+		delete(c.calls, key)
+		c.mu.Unlock()
+		cl.ok = finished && !tw.overflow && r.Context().Err() == nil && shareable(tw.header)
+		cl.status, cl.header, cl.body = tw.status, tw.header, tw.body.Bytes()
+		close(cl.done)
+	}()
+	next(tw, r)
+	tw.finish()
+	finished = true
+}
+
+// write replays the shared response to one waiter.
+func (cl *call) write(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	for k, vs := range cl.header {
+		h[k] = append([]string(nil), vs...)
+	}
+	w.WriteHeader(cl.status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(cl.body)
+	}
+}
+
+// teeWriter relays the leader's response to its client and keeps a copy for
+// the waiters, up to limit bytes. It has its own header map so that headers
+// set for the leader's client by earlier handlers (X-Request-Id) are not
+// handed to the waiters.
+type teeWriter struct {
+	client http.ResponseWriter
+	header http.Header
+	limit  int64
+
+	status      int
+	wroteHeader bool
+	overflow    bool
+	body        bytes.Buffer
+}
+
+func (tw *teeWriter) Header() http.Header { return tw.header }
+
+func (tw *teeWriter) WriteHeader(code int) {
+	if tw.wroteHeader || code < 200 {
+		return
+	}
+	tw.status, tw.wroteHeader = code, true
+	h := tw.client.Header()
+	for k, vs := range tw.header {
+		h[k] = vs
+	}
+	tw.client.WriteHeader(code)
+}
+
+func (tw *teeWriter) Write(p []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	if !tw.overflow {
+		if int64(tw.body.Len()+len(p)) > tw.limit {
+			tw.overflow = true
+			tw.body = bytes.Buffer{}
+		} else {
+			tw.body.Write(p)
+		}
+	}
+	return tw.client.Write(p)
+}
+
+// Flush keeps streamed responses streaming to the leader.
+func (tw *teeWriter) Flush() {
+	_ = http.NewResponseController(tw.client).Flush()
+}
+
+// finish sends the header of a response that had no body.
+func (tw *teeWriter) finish() {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"golb/internal/config"
+)
+
+// ErrorPages renders the responses the gateway generates itself (404 for an
+// unmatched route, 502/503/504 for upstream failures). Every format carries
+// the request ID set by the Logger middleware so that a client report can be
+// matched to the gateway's logs. gRPC calls get a grpc-status instead,
+// whatever the configured format.
+type ErrorPages struct {
+	format    string
+	templates map[int]*template.Template
+}
+
+// errorInfo is the data available to HTML error templates.
+type errorInfo struct {
+	Status     int
+	StatusText string
+	Detail     string
+	Path       string
+	RequestID  string
+}
+
+// defaultHTML is used for statuses without a configured template.
+var defaultHTML = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html><head><title>{{.Status}} {{.StatusText}}</title></head>
+<body><h1>{{.Status}} {{.StatusText}}</h1><p>{{.Detail}}</p>
+<p><small>Request ID: {{.RequestID}}</small></p></body></html>
+`))
+
+// NewErrorPages validates cfg and loads any HTML templates it names.
+func NewErrorPages(cfg config.ErrorsCfg) (*ErrorPages, error) {
+	p := &ErrorPages{format: cfg.Format, templates: map[int]*template.Template{}}
+	switch p.format {
+	case "":
+		p.format = "text"
+	case "text", "json", "problem", "html":
+	default:
+		return nil, fmt.Errorf("proxy: unknown error format %q", cfg.Format)
+	}
+	for code, path := range cfg.Templates {
+		status, err := strconv.Atoi(code)
+		if err != nil || status < 400 || status > 599 {
+			return nil, fmt.Errorf("proxy: error template key %q is not a 4xx/5xx status", code)
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: error template for %d: %w", status, err)
+		}
+		tmpl, err := template.New(code).Parse(string(raw))
+		if err != nil {
+			return nil, fmt.Errorf("proxy: error template for %d: %w", status, err)
+		}
+		p.templates[status] = tmpl
+	}
+	return p, nil
+}
+
+// Write sends an error response with the given status. detail is a short,
+// client-safe explanation; internal error values are logged, never sent.
+func (p *ErrorPages) Write(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	if isGRPC(r) {
+		code := grpcCode(status)
+		if status == http.StatusGatewayTimeout {
+			// The gateway's own timeouts are deadlines, not outages.
+			code = grpcDeadlineExceeded
+		}
+		writeGRPCError(w, code, detail)
+		return
+	}
+	info := errorInfo{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Detail:     detail,
+		Path:       r.URL.Path,
+		RequestID:  r.Header.Get("X-Request-Id"),
+	}
+
+	var (
+		body        []byte
+		contentType string
+	)
+	switch p.format {
+	case "json":
+		contentType = "application/json"
+		body, _ = json.Marshal(map[string]any{
+			"error":      errorCode(status),
+			"status":     status,
+			"message":    detail,
+			"request_id": info.RequestID,
+		})
+	case "problem":
+		// RFC 9457 problem details; request_id is an extension member.
+		contentType = "application/problem+json"
+		body, _ = json.Marshal(map[string]any{
+			"type":       "about:blank",
+			"title":      info.StatusText,
+			"status":     status,
+			"detail":     detail,
+			"instance":   info.Path,
+			"request_id": info.RequestID,
+		})
+	case "html":
+		contentType = "text/html; charset=utf-8"
+		tmpl := p.templates[status]
+		if tmpl == nil {
+			tmpl = defaultHTML
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, info); err != nil {
+			slog.Error("error template failed", "status", status, "error", err)
+			buf.Reset()
+			_ = defaultHTML.Execute(&buf, info)
+		}
+		body = buf.Bytes()
+	default:
+		contentType = "text/plain; charset=utf-8"
+		body = []byte(detail + "\n")
+	}
+	if p.format != "text" && p.format != "html" {
+		body = append(body, '\n')
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// errorCode turns a status into a stable snake_case identifier, e.g.
+// 503 → "service_unavailable".
+func errorCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gRPC status codes used by the gateway.
+const (
+	grpcUnknown          = 2
+	grpcDeadlineExceeded = 4
+	grpcPermissionDenied = 7
+	grpcUnimplemented    = 12
+	grpcInternal         = 13
+	grpcUnavailable      = 14
+	grpcUnauthenticated  = 16
+)
+
+// isGRPC reports whether r is a gRPC call. gRPC-Web is not: its clients read
+// errors from the HTTP status.
+func isGRPC(r *http.Request) bool {
+	return isGRPCContentType(r.Header.Get("Content-Type"))
+}
+
+func isGRPCContentType(ct string) bool {
+	rest, ok := strings.CutPrefix(ct, "application/grpc")
+	return ok && (rest == "" || rest[0] == '+' || rest[0] == ';')
+}
+
+// grpcCode maps an HTTP status to a gRPC code as gRPC clients do for
+// responses that are not gRPC (doc/http-grpc-status-mapping.md).
+func grpcCode(status int) int {
+	switch status {
+	case http.StatusBadRequest:
+		return grpcInternal
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusNotFound:
+		return grpcUnimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return grpcUnavailable
+	}
+	return grpcUnknown
+}
+
+// writeGRPCError answers a gRPC call with a Trailers-Only response: status
+// 200 and the grpc-status and grpc-message in the only header block.
+func writeGRPCError(w http.ResponseWriter, code int, message string) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/grpc")
+	h.Set("Grpc-Status", strconv.Itoa(code))
+	h.Set("Grpc-Message", grpcEscape(message))
+	w.WriteHeader(http.StatusOK)
+}
+
+// grpcStatusFromHTTP turns an upstream response that is not gRPC, such as an
+// HTML 503 from another proxy, into a Trailers-Only gRPC error. The original
+// body is closed, which releases the backend's connection slot.
+func grpcStatusFromHTTP(resp *http.Response) {
+	_ = resp.Body.Close()
+	message := fmt.Sprintf("upstream answered %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	if resp.StatusCode == http.StatusOK {
+		message = "upstream answered with content type " + strconv.Quote(resp.Header.Get("Content-Type"))
+	}
+	h := http.Header{}
+	h.Set("Content-Type", "application/grpc")
+	h.Set("Grpc-Status", strconv.Itoa(grpcCode(resp.StatusCode)))
+	h.Set("Grpc-Message", grpcEscape(message))
+	resp.StatusCode, resp.Status = http.StatusOK, "200 OK"
+	resp.Header, resp.Trailer = h, nil
+	resp.Body, resp.ContentLength = http.NoBody, 0
+}
+
+// grpcEscape percent-encodes a grpc-message value: bytes outside printable
+// ASCII, and '%' itself.
+func grpcEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
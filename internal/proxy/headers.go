@@ -0,0 +1,277 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golb/internal/config"
+	"golb/internal/middleware"
+	"golb/internal/strategy"
+)
+
+// HeaderRules rewrites the headers of a request or response. Rules apply in
+// a fixed order: remove, rename, set, add.
+type HeaderRules struct {
+	Remove []string
+	Rename [][2]string // from, to
+	Set    []HeaderValue
+	Add    []HeaderValue
+}
+
+// HeaderValue is a header name with a templated value.
+type HeaderValue struct {
+	Name  string
+	Value *Template
+}
+
+// NewHeaderRules compiles cfg. Header names are canonicalised, and map-based
+// rules are ordered by name so their effect never depends on map order.
+func NewHeaderRules(cfg config.HeaderRulesCfg) (*HeaderRules, error) {
+	if cfg.Empty() {
+		return nil, nil
+	}
+	hr := &HeaderRules{}
+	for _, name := range cfg.Remove {
+		hr.Remove = append(hr.Remove, http.CanonicalHeaderKey(name))
+	}
+	for _, from := range sortedKeys(cfg.Rename) {
+		hr.Rename = append(hr.Rename, [2]string{http.CanonicalHeaderKey(from), http.CanonicalHeaderKey(cfg.Rename[from])})
+	}
+	compile := func(m map[string]string) ([]HeaderValue, error) {
+		var out []HeaderValue
+		for _, name := range sortedKeys(m) {
+			t, err := ParseTemplate(m[name])
+			if err != nil {
+				return nil, fmt.Errorf("header %q: %w", name, err)
+			}
+			out = append(out, HeaderValue{Name: http.CanonicalHeaderKey(name), Value: t})
+		}
+		return out, nil
+	}
+	var err error
+	if hr.Set, err = compile(cfg.Set); err != nil {
+		return nil, err
+	}
+	if hr.Add, err = compile(cfg.Add); err != nil {
+		return nil, err
+	}
+	return hr, nil
+}
+
+// apply rewrites h. A nil *HeaderRules is a no-op.
+func (hr *HeaderRules) apply(h http.Header, v templateVars) {
+	if hr == nil {
+		return
+	}
+	for _, name := range hr.Remove {
+		h.Del(name)
+	}
+	for _, rn := range hr.Rename {
+		if vals, ok := h[rn[0]]; ok {
+			h.Del(rn[0])
+			h[rn[1]] = vals
+		}
+	}
+	for _, hv := range hr.Set {
+		h.Set(hv.Name, hv.Value.expand(v))
+	}
+	for _, hv := range hr.Add {
+		h.Add(hv.Name, hv.Value.expand(v))
+	}
+}
+
+// templateVars is what a Template can reference.
+type templateVars struct {
+	req      *http.Request // the client request, for client IP, request ID and claims
+	route    *Route
+	backend  *strategy.Backend
+	captures []string // regex groups of a redirect; index 0 is the whole match
+}
+
+// Template is a header value with ${...} placeholders:
+//
+//	${client_ip}   client address without port
+//	${request_id}  X-Request-Id assigned by the logger
+//	${route}       route name
+//	${backend}     URL of the selected backend
+//	${host}        Host the client asked for, without port
+//	${path}        request path
+//	${query}       raw query string, without "?"
+//	${jwt.<claim>} claim of the verified JWT (empty without one)
+//	${env.<NAME>}  environment variable, read when the config is loaded
+//
+// Redirect targets can also use the groups of the redirect's regex as ${1}
+// or ${name}. "$$" is a literal "$".
+type Template struct {
+	parts []templatePart
+}
+
+type templatePart struct {
+	lit string // literal text, or the argument of a jwt variable
+	fn  func(templateVars, string) string
+}
+
+var templateFuncs = map[string]func(templateVars, string) string{
+	"client_ip": func(v templateVars, _ string) string {
+		host, _, err := net.SplitHostPort(v.req.RemoteAddr)
+		if err != nil {
+			return v.req.RemoteAddr
+		}
+		return host
+	},
+	"request_id": func(v templateVars, _ string) string { return v.req.Header.Get("X-Request-Id") },
+	"route": func(v templateVars, _ string) string {
+		if v.route == nil {
+			return ""
+		}
+		return v.route.Name
+	},
+	"backend": func(v templateVars, _ string) string {
+		if v.backend == nil {
+			return ""
+		}
+		return v.backend.RawURL
+	},
+	"host": func(v templateVars, _ string) string {
+		host, _, err := net.SplitHostPort(v.req.Host)
+		if err != nil {
+			return v.req.Host
+		}
+		return host
+	},
+	"path":  func(v templateVars, _ string) string { return v.req.URL.Path },
+	"query": func(v templateVars, _ string) string { return v.req.URL.RawQuery },
+}
+
+// ParseTemplate compiles s.
+func ParseTemplate(s string) (*Template, error) {
+	return parseTemplate(s, nil)
+}
+
+// parseTemplate compiles s; when re is set, its capture groups may be
+// referenced by number or name and take precedence over other variables.
+func parseTemplate(s string, re *regexp.Regexp) (*Template, error) {
+	t := &Template{}
+	var lit strings.Builder
+	flush := func() {
+		if lit.Len() > 0 {
+			t.parts = append(t.parts, templatePart{lit: lit.String()})
+			lit.Reset()
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' {
+			lit.WriteByte(s[i])
+			continue
+		}
+		if strings.HasPrefix(s[i:], "$$") {
+			lit.WriteByte('$')
+			i++
+			continue
+		}
+		if !strings.HasPrefix(s[i:], "${") {
+			lit.WriteByte('$')
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated ${ in %q", s)
+		}
+		name := s[i+2 : i+end]
+		i += end
+		if idx, ok := captureIndex(re, name); ok {
+			flush()
+			t.parts = append(t.parts, templatePart{lit: strconv.Itoa(idx), fn: capture})
+			continue
+		}
+		switch {
+		case strings.HasPrefix(name, "env.") && len(name) > len("env."):
+			lit.WriteString(os.Getenv(strings.TrimPrefix(name, "env.")))
+		case strings.HasPrefix(name, "jwt.") && len(name) > len("jwt."):
+			flush()
+			t.parts = append(t.parts, templatePart{lit: strings.TrimPrefix(name, "jwt."), fn: jwtClaim})
+		case templateFuncs[name] != nil:
+			flush()
+			t.parts = append(t.parts, templatePart{fn: templateFuncs[name]})
+		default:
+			return nil, fmt.Errorf("unknown variable ${%s}", name)
+		}
+	}
+	flush()
+	return t, nil
+}
+
+// expand renders the template.
+func (t *Template) expand(v templateVars) string {
+	if len(t.parts) == 1 && t.parts[0].fn == nil {
+		return t.parts[0].lit
+	}
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.fn == nil {
+			b.WriteString(p.lit)
+		} else {
+			b.WriteString(p.fn(v, p.lit))
+		}
+	}
+	return b.String()
+}
+
+// captureIndex resolves a ${1} or ${name} reference to a group of re.
+func captureIndex(re *regexp.Regexp, name string) (int, bool) {
+	if re == nil {
+		return 0, false
+	}
+	if n, err := strconv.Atoi(name); err == nil {
+		return n, n >= 0 && n <= re.NumSubexp()
+	}
+	if idx := re.SubexpIndex(name); idx > 0 {
+		return idx, true
+	}
+	return 0, false
+}
+
+func capture(v templateVars, idx string) string {
+	i, _ := strconv.Atoi(idx)
+	if i >= len(v.captures) {
+		return ""
+	}
+	return v.captures[i]
+}
+
+func jwtClaim(v templateVars, claim string) string {
+	val, ok := middleware.Claims(v.req.Context())[claim]
+	if !ok {
+		return ""
+	}
+	switch c := val.(type) {
+	case string:
+		return c
+	case float64:
+		return strconv.FormatFloat(c, 'f', -1, 64)
+	default:
+		return fmt.Sprint(c)
+	}
+}
+
+// stripHeaders removes the named headers from h.
+func stripHeaders(h http.Header, names []string) {
+	for _, name := range names {
+		h.Del(name)
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golb/internal/config"
+	"golb/internal/strategy"
+)
+
+// hedgeBurst is the most hedges a budget can save up while traffic is fast.
+const hedgeBurst = 10
+
+// hedgeMinSamples is the number of responses a pool must have seen before a
+// percentile delay is trusted; until then requests are not hedged.
+const hedgeMinSamples = 20
+
+// Hedge sends a second copy of a slow request to a different backend of the
+// same pool. Whichever attempt answers first is used and the other is
+// cancelled. Both attempts take and release their backend's connection slot
+// through the pool's picker, and the hedge never waits in the request queue.
+//
+// A budget caps the extra load: every eligible request earns BudgetRatio of
+// a hedge, and a hedge is only sent when a whole one has been earned. During
+// a slowdown, when most requests would be hedged, the budget runs dry and at
+// most BudgetRatio extra requests reach the backends.
+type Hedge struct {
+	Delay       time.Duration // fixed delay before hedging; 0 when Percentile is used
+	Percentile  float64       // hedge after this percentile (0–100) of the pool's latency
+	MinDelay    time.Duration // floor for percentile delays
+	BudgetRatio float64       // hedges per eligible request, 0–1
+
+	mu     sync.Mutex
+	tokens float64
+
+	sent      atomic.Int64
+	won       atomic.Int64
+	throttled atomic.Int64
+}
+
+// HedgeStats counts a route's hedged requests.
+type HedgeStats struct {
+	Sent      int64 // second attempts sent
+	Won       int64 // second attempts that answered first
+	Throttled int64 // hedges skipped because the budget was spent or no other backend was free
+}
+
+// NewHedge builds a Hedge from a route's config, or returns nil when the
+// route does not hedge.
+func NewHedge(cfg config.HedgeCfg) *Hedge {
+	if !cfg.Enabled {
+		return nil
+	}
+	h := &Hedge{MinDelay: cfg.ParsedMinDelay(), BudgetRatio: cfg.ParsedBudgetPercent() / 100}
+	h.Delay, h.Percentile = cfg.ParsedDelay()
+	return h
+}
+
+// Stats returns the hedge's counters.
+func (h *Hedge) Stats() HedgeStats {
+	return HedgeStats{Sent: h.sent.Load(), Won: h.won.Load(), Throttled: h.throttled.Load()}
+}
+
+// eligible reports whether req may be sent twice: only safe, bodiless
+// requests are.
+func (h *Hedge) eligible(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	return (req.Body == nil || req.Body == http.NoBody) && req.Header.Get("Upgrade") == ""
+}
+
+// delay returns how long to wait for the first attempt before hedging, or
+// false when the pool has too little latency data for a percentile delay.
+func (h *Hedge) delay(p *Pool) (time.Duration, bool) {
+	if h.Percentile == 0 {
+		return h.Delay, true
+	}
+	var s strategy.LatencySnapshot
+	for _, b := range p.Backends() {
+		s = s.Add(b.Latency())
+	}
+	if s.Count() < hedgeMinSamples {
+		return 0, false
+	}
+	return max(s.Quantile(h.Percentile/100), h.MinDelay), true
+}
+
+// earn credits the budget for one eligible request.
+func (h *Hedge) earn() {
+	h.mu.Lock()
+	h.tokens = min(h.tokens+h.BudgetRatio, hedgeBurst)
+	h.mu.Unlock()
+}
+
+// spend takes one hedge from the budget, reporting false when it is empty.
+func (h *Hedge) spend() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.tokens < 1 {
+		return false
+	}
+	h.tokens--
+	return true
+}
+
+// attempt is the outcome of one copy of a hedged request.
+type attempt struct {
+	resp  *http.Response
+	err   error
+	index int // 0 for the first attempt, 1 for the hedge
+}
+
+// roundTrip forwards req through p, hedging it to a second backend if the
+// first has not answered within the delay.
+func (h *Hedge) roundTrip(p *Pool, req *http.Request) (*http.Response, error) {
+	h.earn()
+	delay, ok := h.delay(p)
+	if !ok {
+		return p.RoundTrip(req)
+	}
+	first, err := strategy.NextContext(req.Context(), p.picker)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(chan attempt, 2)
+	var cancels []context.CancelFunc
+	launch := func(b *strategy.Backend) {
+		// Each attempt gets its own context, so the loser can be cancelled,
+		// and its own copy of the headers, which send rewrites.
+		ctx, cancel := context.WithCancel(req.Context())
+		out := req.Clone(ctx)
+		index := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			resp, err := p.send(out, b)
+			results <- attempt{resp: resp, err: err, index: index}
+		}()
+	}
+	launch(first)
+	pending := 1
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if !h.spend() {
+				h.throttled.Add(1)
+				continue
+			}
+			second, ok := p.pickOther(first)
+			if !ok {
+				h.throttled.Add(1)
+				continue
+			}
+			slog.Debug("hedging request",
+				"method", req.Method,
+				"path", req.URL.Path,
+				"pool", p.Name,
+				"backend", second.RawURL,
+				"after", delay,
+			)
+			h.sent.Add(1)
+			launch(second)
+			pending++
+		case a := <-results:
+			pending--
+			if a.err != nil {
+				cancels[a.index]()
+				if pending == 0 {
+					return nil, a.err
+				}
+				// Wait for the other attempt; count this one like a failed
+				// shadow request and leave its health to the monitor.
+				recordLoser(a)
+				continue
+			}
+			if a.index == 1 {
+				h.won.Add(1)
+			}
+			for i, cancel := range cancels {
+				if i != a.index {
+					cancel()
+				}
+			}
+			if pending > 0 {
+				go drainLosers(results, pending)
+			}
+			// The winner's context lives until its body is closed.
+			a.resp.Body = releaseOnClose(a.resp.Body, cancels[a.index])
+			return a.resp, nil
+		}
+	}
+}
+
+// drainLosers collects the n cancelled attempts still in flight after the
+// winner answered. A loser that answered anyway has its body closed, which
+// releases its backend's slot.
+func drainLosers(results chan attempt, n int) {
+	for range n {
+		recordLoser(<-results)
+	}
+}
+
+// recordLoser counts a losing attempt against its backend.
+func recordLoser(a attempt) {
+	if a.err == nil {
+		if b := backendFromCtx(a.resp.Request.Context()); b != nil {
+			b.IncRequests()
+		}
+		a.resp.Body.Close()
+		return
+	}
+	var be *backendError
+	if errors.As(a.err, &be) {
+		be.backend.IncRequests()
+		if !errors.Is(be.err, context.Canceled) {
+			be.backend.IncErrors()
+		}
+	}
+}
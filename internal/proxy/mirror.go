@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// MirrorHeader marks requests sent to a shadow pool so the shadow service
+// (and its logs) can tell them apart from real traffic.
+const MirrorHeader = "X-Mirrored-Request"
+
+// Mirror copies a sample of a route's requests to a shadow pool. Mirroring is
+// fire-and-forget: the copy is sent from its own goroutine with its own
+// timeout, the shadow response is discarded, and shadow failures never reach
+// the error handler, so they cannot affect the client or the health of
+// primary backends.
+type Mirror struct {
+	Pool         *Pool
+	Percent      float64       // share of requests to mirror, 0–100
+	MaxBodyBytes int64         // larger request bodies are not mirrored
+	Timeout      time.Duration // deadline for each shadow request
+	MaxInflight  int64         // shadow requests allowed in flight; 0 means unlimited
+
+	inflight atomic.Int64
+	sent     atomic.Int64
+	failed   atomic.Int64
+	dropped  atomic.Int64
+}
+
+// MirrorStats counts a mirror's shadow requests.
+type MirrorStats struct {
+	Sent    int64 // shadow requests that got a response
+	Failed  int64 // shadow requests that errored or timed out
+	Dropped int64 // sampled requests not mirrored: body too large or too many in flight
+}
+
+// Stats returns the mirror's counters.
+func (m *Mirror) Stats() MirrorStats {
+	return MirrorStats{Sent: m.sent.Load(), Failed: m.failed.Load(), Dropped: m.dropped.Load()}
+}
+
+// sample reports whether this request should be mirrored.
+func (m *Mirror) sample() bool {
+	return m.Percent >= 100 || rand.Float64()*100 < m.Percent
+}
+
+// capture buffers r's body so it can be sent twice, and returns the copy for
+// the shadow request. Bodies over MaxBodyBytes are left to stream to the
+// primary untouched and ok is false.
+func (m *Mirror) capture(r *http.Request) (body []byte, ok bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	if r.ContentLength > m.MaxBodyBytes {
+		return nil, false
+	}
+	buf, err := io.ReadAll(io.LimitReader(r.Body, m.MaxBodyBytes+1))
+	if int64(len(buf)) > m.MaxBodyBytes || err != nil {
+		// Put back what was read; the primary still gets the whole body.
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+		return nil, false
+	}
+	r.Body = readCloser{bytes.NewReader(buf), r.Body}
+	return buf, true
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// send forwards shadow, a private copy of the client request that has been
+// through the director, with body to the shadow pool. Shadow backends count
+// the request like any other, but their health is left to the active
+// monitor.
+func (m *Mirror) send(shadow *http.Request, body []byte) {
+	defer m.inflight.Add(-1)
+
+	// shadow's context keeps the client request's values, such as JWT claims
+	// for header rules, but not its cancellation: the copy may outlive the
+	// primary exchange.
+	ctx, cancel := context.WithTimeout(shadow.Context(), m.Timeout)
+	defer cancel()
+
+	out := shadow.WithContext(ctx)
+	out.Header.Set(MirrorHeader, "true")
+	out.Body = http.NoBody
+	if body != nil {
+		out.Body = io.NopCloser(bytes.NewReader(body))
+		out.ContentLength = int64(len(body))
+	}
+	out.TransferEncoding = nil
+	out.RequestURI = ""
+
+	resp, err := m.Pool.RoundTrip(out)
+	if err != nil {
+		m.failed.Add(1)
+		var be *backendError
+		if errors.As(err, &be) {
+			be.backend.IncRequests()
+			be.backend.IncErrors()
+		}
+		slog.Debug("mirror request failed",
+			"pool", m.Pool.Name,
+			"method", out.Method,
+			"path", out.URL.Path,
+			"error", err,
+		)
+		return
+	}
+	if b := backendFromCtx(resp.Request.Context()); b != nil {
+		b.IncRequests()
+		if resp.StatusCode >= 500 {
+			b.IncServerErrors()
+		}
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	m.sent.Add(1)
+}
+
+// start launches the shadow request unless too many are already in flight.
+func (m *Mirror) start(shadow *http.Request, body []byte) {
+	if n := m.inflight.Add(1); m.MaxInflight > 0 && n > m.MaxInflight {
+		m.inflight.Add(-1)
+		m.dropped.Add(1)
+		return
+	}
+	go m.send(shadow, body)
+}
@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"golb/internal/config"
+	"golb/internal/health"
+	"golb/internal/proxyproto"
+	"golb/internal/strategy"
+)
+
+// Pool is a named group of backends behind one Picker, reached through its own
+// upstream transport. Pools are immutable, hot-reload builds new ones, except
+// that a discovered pool's backends follow its service registry.
+type Pool struct {
+	Name      string
+	picker    strategy.Picker
+	dynamic   *strategy.Dynamic // backends of a discovered pool; nil for static ones
+	transport http.RoundTripper
+	health    health.Check // how the active monitor probes the backends
+
+	proxyProtocol int // PROXY protocol version announcing clients to backends; 0 for none
+}
+
+// NewPool creates a Pool. A nil transport selects one built from the default
+// transport settings.
+func NewPool(name string, p strategy.Picker, transport http.RoundTripper) *Pool {
+	if transport == nil {
+		transport, _ = NewTransport(config.Default().Transport)
+	}
+	return &Pool{Name: name, picker: p, transport: transport}
+}
+
+// Picker returns the pool's Picker.
+func (p *Pool) Picker() strategy.Picker { return p.picker }
+
+// Backends returns the pool's backends.
+func (p *Pool) Backends() []*strategy.Backend { return p.picker.Backends() }
+
+// Discovered reports whether the pool's backends come from service discovery.
+func (p *Pool) Discovered() bool { return p.dynamic != nil }
+
+// SetBackends replaces the backends of a discovered pool.
+func (p *Pool) SetBackends(backends []*strategy.Backend) error {
+	if p.dynamic == nil {
+		return fmt.Errorf("proxy: pool %q has static backends", p.Name)
+	}
+	return p.dynamic.SetBackends(backends)
+}
+
+// ProxyProtocol returns the PROXY protocol version the pool announces clients
+// to its backends with, or 0 for none.
+func (p *Pool) ProxyProtocol() int { return p.proxyProtocol }
+
+// NewTransport builds an upstream http.Transport from cfg. The overall
+// request timeout is not a transport setting; it is applied per route.
+//
+// The protocol decides how backends are spoken to: HTTP/1.1 (the default),
+// HTTP/2 where TLS backends offer it (auto), HTTP/2 over TLS only (h2), or
+// HTTP/2 over cleartext with prior knowledge (h2c), which is what most gRPC
+// servers inside a cluster expect. HTTP/2 connections are pinged after
+// keep_alive without traffic so that dead ones are noticed. Unix socket
+// backends are dialled over their socket.
+func NewTransport(cfg config.TransportCfg) (*http.Transport, error) {
+	dialer := &net.Dialer{
+		Timeout:   cfg.ParsedDialTimeout(),
+		KeepAlive: cfg.ParsedKeepAlive(),
+	}
+	t := &http.Transport{
+		Proxy:                 nil, // never route upstream traffic through an env-configured proxy
+		DialContext:           strategy.UnixDialer(dialer.DialContext),
+		TLSHandshakeTimeout:   cfg.ParsedTLSHandshakeTimeout(),
+		ResponseHeaderTimeout: cfg.ParsedResponseHeaderTimeout(),
+		ExpectContinueTimeout: cfg.ParsedExpectContinueTimeout(),
+		IdleConnTimeout:       cfg.ParsedIdleConnTimeout(),
+		MaxIdleConns:          cfg.ParsedMaxIdleConns(),
+		MaxIdleConnsPerHost:   cfg.ParsedMaxIdleConnsPerHost(),
+		MaxConnsPerHost:       cfg.ParsedMaxConnsPerHost(),
+	}
+
+	var protocols http.Protocols
+	switch cfg.ParsedProtocol() {
+	case config.ProtocolHTTP1:
+		protocols.SetHTTP1(true)
+	case config.ProtocolAuto:
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+	case config.ProtocolH2:
+		protocols.SetHTTP2(true)
+	case config.ProtocolH2C:
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		return nil, fmt.Errorf("unknown transport protocol %q", cfg.Protocol)
+	}
+	t.Protocols = &protocols
+	if protocols.HTTP2() || protocols.UnencryptedHTTP2() {
+		t.HTTP2 = &http.HTTP2Config{SendPingTimeout: cfg.ParsedKeepAlive()}
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("transport ca_file: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("transport ca_file %s: no PEM certificates found", cfg.CAFile)
+		}
+		t.TLSClientConfig = &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	}
+	return t, nil
+}
+
+// sendProxyProtocol makes t start every upstream connection with a PROXY
+// protocol header announcing the client of the request it was dialled for.
+// A connection then belongs to that client, so none are reused: each request
+// pays for its own dial and TLS handshake.
+func sendProxyProtocol(t *http.Transport, version int) {
+	t.DialContext = proxyproto.Dialer(t.DialContext, version)
+	t.DisableKeepAlives = true
+}
+
+// RoundTrip picks a backend for req, forwards it, and keeps the backend's
+// connection slot until the response body is closed.
+func (p *Pool) RoundTrip(req *http.Request) (*http.Response, error) {
+	b, err := strategy.NextContext(req.Context(), p.picker)
+	if err != nil {
+		return nil, err
+	}
+	return p.send(req, b)
+}
+
+// send forwards req to b, whose connection slot the caller has taken with
+// the picker. The slot is released on error or when the response body is
+// closed.
+func (p *Pool) send(req *http.Request, b *strategy.Backend) (*http.Response, error) {
+	// Attach the selected backend to the request context so downstream hooks
+	// can retrieve it without sharing mutable state across goroutines.
+	ctx := context.WithValue(req.Context(), ctxKey{}, b)
+	if p.proxyProtocol != 0 {
+		local, _ := req.Context().Value(http.LocalAddrContextKey).(net.Addr)
+		ctx = proxyproto.NewContext(ctx, proxyproto.ParseAddr(req.RemoteAddr), local)
+	}
+	out := req.WithContext(ctx)
+	rt := routeFromCtx(req.Context())
+	out.URL = targetURL(req.URL, b, rt)
+	out.Host = b.URL.Host
+	if b.IsUnix() {
+		// The stand-in host means nothing to the backend.
+		out.Host = "localhost"
+	}
+	if rt != nil {
+		rt.RequestHeaders.apply(out.Header, templateVars{req: req, route: rt, backend: b})
+	}
+
+	slog.Debug("proxying request",
+		"method", out.Method,
+		"path", out.URL.Path,
+		"pool", p.Name,
+		"backend", b.RawURL,
+	)
+
+	start := time.Now()
+	resp, err := p.transport.RoundTrip(out)
+	if err != nil {
+		p.picker.Done(b)
+		return nil, &backendError{backend: b, err: err}
+	}
+	b.ObserveLatency(time.Since(start))
+	resp.Body = releaseOnClose(resp.Body, func() { p.picker.Done(b) })
+	return resp, nil
+}
+
+// pickOther takes a slot on a backend other than not, without waiting. It
+// reports false when no other backend is available.
+func (p *Pool) pickOther(not *strategy.Backend) (*strategy.Backend, bool) {
+	for range p.picker.Backends() {
+		b, err := strategy.TryNext(p.picker)
+		if err != nil {
+			return nil, false
+		}
+		if b != not {
+			return b, true
+		}
+		p.picker.Done(b)
+	}
+	return nil, false
+}
+
+// closeIdle drops the pool's idle upstream connections, if its transport
+// supports it. Called on pools retired by a hot-reload.
+func (p *Pool) closeIdle() {
+	if t, ok := p.transport.(interface{ CloseIdleConnections() }); ok {
+		t.CloseIdleConnections()
+	}
+}
@@ -1,22 +1,44 @@
 // Package proxy is the core request-forwarding layer of GOLB.
 //
 // Gateway wraps net/http/httputil.ReverseProxy and adds:
-//   - Dynamic backend selection via a pluggable strategy.Picker.
+//   - Path-prefix routing to named backend pools (see Table), with optional
+//     weighted, sticky traffic splits across pools (see Splitter).
+//   - Routes that answer on their own with a redirect or a fixed response.
+//   - Per-route response caching in a shared RFC 9111 cache (see cache.Store).
+//   - Coalescing of identical concurrent GETs into one upstream call.
+//   - Hedged requests: a slow GET is also sent to a second backend and the
+//     first response wins, within a budget on the extra load.
+//   - WebSocket and other Upgrade tunnels on routes that allow them, with
+//     idle and lifetime limits and per-tunnel byte counts.
+//   - Fire-and-forget mirroring of a share of requests to a shadow pool.
+//   - Per-route request/response header rules with templated values, and
+//     global stripping of response headers.
+//   - Backend base paths, prefix stripping/adding and regex path rewrites,
+//     with redirects and cookie paths mapped back to the public prefix.
+//   - Dynamic backend selection via a pluggable strategy.Picker per pool.
 //   - Standard proxy header injection (X-Forwarded-For, X-Real-IP, …).
-//   - Active connection tracking (IncConns/DecConns on Backend).
+//   - Active connection tracking: a backend's connection slot is held until
+//     the response body has been fully relayed to the client.
+//   - Per-pool upstream transports and per-route request timeouts; upstream
+//     timeouts are answered with 504 Gateway Timeout.
 //   - Passive health checks: a backend is marked unhealthy on any dial or
 //     protocol error, and the active health monitor re-enables it later.
-//   - Atomic picker swap for zero-downtime config hot-reloads.
+//   - Atomic routing-table swap for zero-downtime config hot-reloads.
 package proxy
 
 import (
 	"context"
+	"errors"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"sync"
-	"time"
 
+	"golb/internal/cache"
+	"golb/internal/config"
 	"golb/internal/strategy"
 )
 
@@ -24,67 +46,170 @@ import (
 // backend, preventing accidental collisions with other packages.
 type ctxKey struct{}
 
+// routeKey is the context key for the matched *Route.
+type routeKey struct{}
+
+// poolKey is the context key for the *Pool chosen for the request.
+type poolKey struct{}
+
+// publicURLKey is the context key for the scheme and host the client used,
+// needed to rewrite absolute redirects from a backend.
+type publicURLKey struct{}
+
 // Gateway is the central http.Handler. It is safe for concurrent use.
 type Gateway struct {
-	mu     sync.RWMutex
-	picker strategy.Picker
-	rp     *httputil.ReverseProxy
+	mu    sync.RWMutex
+	table *Table
+	pages *ErrorPages
+	cache *cache.Store
+	rp    *httputil.ReverseProxy
 }
 
-// New creates a Gateway using the given Picker. The returned Gateway is ready
-// to be wrapped in middleware and passed to http.Server.
+// New creates a Gateway that sends every request to a single pool using the
+// given Picker and the default transport settings. The returned Gateway is
+// ready to be wrapped in middleware and passed to http.Server.
 func New(p strategy.Picker) *Gateway {
-	gw := &Gateway{picker: p}
+	return NewWithTable(singlePoolTable(p))
+}
+
+// NewWithTable creates a Gateway serving the given routing table.
+func NewWithTable(t *Table) *Gateway {
+	gw := &Gateway{table: t, pages: &ErrorPages{format: "text"}}
 	gw.rp = &httputil.ReverseProxy{
 		Director:       gw.director,
 		ModifyResponse: gw.modifyResponse,
 		ErrorHandler:   gw.errorHandler,
-		Transport: &http.Transport{
-			MaxIdleConns:        100,
-			MaxIdleConnsPerHost: 10,
-			IdleConnTimeout:     90 * time.Second,
-		},
+		Transport:      routeTransport{},
 	}
 	return gw
 }
 
-// UpdatePicker atomically swaps the active Picker. In-flight requests using
-// the old picker complete normally; new requests use the new picker immediately.
+// UpdateTable atomically swaps the routing table. In-flight requests finish
+// on the pools they started with, and still hold their backends' connection
+// slots in t, whose backends also keep their health; idle upstream
+// connections of the old table are closed.
+func (gw *Gateway) UpdateTable(t *Table) {
+	gw.mu.Lock()
+	old := gw.table
+	t.inherit(old)
+	gw.table = t
+	gw.mu.Unlock()
+	old.closeIdle()
+}
+
+// UpdatePicker replaces the routing table with a single catch-all route served
+// by p. In-flight requests using the old picker complete normally; new
+// requests use the new picker immediately.
 func (gw *Gateway) UpdatePicker(p strategy.Picker) {
+	gw.UpdateTable(singlePoolTable(p))
+}
+
+// SetErrorPages swaps the renderer used for gateway-generated error responses.
+func (gw *Gateway) SetErrorPages(p *ErrorPages) {
 	gw.mu.Lock()
-	gw.picker = p
+	gw.pages = p
 	gw.mu.Unlock()
 }
 
-// ServeHTTP satisfies http.Handler.
-func (gw *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	gw.rp.ServeHTTP(w, r)
+func (gw *Gateway) errorPages() *ErrorPages {
+	gw.mu.RLock()
+	defer gw.mu.RUnlock()
+	return gw.pages
 }
 
-// director rewrites the incoming request to target a backend chosen by the
-// current Picker. The chosen Backend is stored in the request context so that
-// modifyResponse and errorHandler can call Done on it.
-func (gw *Gateway) director(req *http.Request) {
+// SetCache sets the response cache used by routes with caching enabled.
+// Without one, those routes are proxied uncached.
+func (gw *Gateway) SetCache(c *cache.Store) {
+	gw.mu.Lock()
+	gw.cache = c
+	gw.mu.Unlock()
+}
+
+// Cache returns the response cache, or nil if none is set.
+func (gw *Gateway) Cache() *cache.Store {
+	gw.mu.RLock()
+	defer gw.mu.RUnlock()
+	return gw.cache
+}
+
+// Table returns the active routing table.
+func (gw *Gateway) Table() *Table {
 	gw.mu.RLock()
-	picker := gw.picker
-	gw.mu.RUnlock()
-
-	b, err := picker.Next()
-	if err != nil {
-		slog.Error("no healthy backend available", "error", err)
-		// Point at an unreachable address so ReverseProxy triggers its
-		// ErrorHandler via a dial error rather than panicking.
-		req.URL.Scheme = "http"
-		req.URL.Host = "0.0.0.0:0"
+	defer gw.mu.RUnlock()
+	return gw.table
+}
+
+// ServeHTTP satisfies http.Handler. It matches the request to a route,
+// answers it directly for redirect and respond routes, serves it from the
+// cache when the route caches and a usable response is stored, and otherwise
+// forwards it, sharing the upstream call with identical concurrent requests
+// on coalescing routes. Upgrade requests are tunnelled when the route allows
+// the protocol and forwarded as plain requests otherwise.
+func (gw *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt := gw.Table().Match(r.URL.Path)
+	if rt == nil {
+		gw.errorPages().Write(w, r, http.StatusNotFound, "no route matches this path")
+		return
+	}
+	if rt.Redirect != nil || rt.Respond != nil {
+		gw.serveAction(w, r, rt)
 		return
 	}
+	if r.Header.Get("Upgrade") != "" {
+		if rt.Upgrade.allows(r) {
+			gw.serveUpgrade(w, r, rt)
+			return
+		}
+		stripUpgrade(r)
+	}
+	forward := func(w http.ResponseWriter, r *http.Request) { gw.forward(w, r, rt) }
+	if co := rt.Coalesce; co != nil {
+		// Coalescing sits behind the cache, so concurrent misses share one
+		// upstream call too.
+		upstream := forward
+		forward = func(w http.ResponseWriter, r *http.Request) { co.serve(w, r, upstream) }
+	}
+	if c := gw.Cache(); c != nil && rt.Cache != nil {
+		c.Serve(w, r, rt.Cache, http.HandlerFunc(forward))
+		return
+	}
+	forward(w, r)
+}
 
-	originalHost := req.Host
+// forward starts a shadow copy if the route mirrors traffic, applies the
+// route's request timeout and hands off to the ReverseProxy. Upgrade requests
+// get no timeout: it would cut the tunnel, whose limits are the route's own.
+func (gw *Gateway) forward(w http.ResponseWriter, r *http.Request, rt *Route) {
+	if m := rt.Mirror; m != nil && r.Header.Get("Upgrade") == "" && m.sample() {
+		if body, ok := m.capture(r); ok {
+			shadow := r.Clone(context.WithValue(context.WithoutCancel(r.Context()), routeKey{}, rt))
+			gw.director(shadow)
+			m.start(shadow, body)
+		} else {
+			m.dropped.Add(1)
+		}
+	}
 
-	req.URL.Scheme = b.URL.Scheme
-	req.URL.Host = b.URL.Host
-	req.Host = b.URL.Host
+	ctx := context.WithValue(r.Context(), routeKey{}, rt)
+	ctx = context.WithValue(ctx, poolKey{}, rt.pool(r))
+	ctx = context.WithValue(ctx, publicURLKey{}, &url.URL{Scheme: requestScheme(r), Host: r.Host})
+	if rt.Timeout > 0 && r.Header.Get("Upgrade") == "" {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rt.Timeout)
+		defer cancel()
+	}
+	gw.rp.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func singlePoolTable(p strategy.Picker) *Table {
+	pool := NewPool(config.DefaultPool, p, nil)
+	return NewTable([]*Route{{Name: config.DefaultPool, PathPrefix: "/", Pool: pool}})
+}
 
+// director prepares the outgoing request. Backend selection happens later, in
+// Pool.RoundTrip, so that a request waiting for a connection slot never
+// dials anything.
+func (gw *Gateway) director(req *http.Request) {
 	// Strip hop-by-hop headers that must not be forwarded upstream.
 	req.Header.Del("Te")
 	req.Header.Del("Trailers")
@@ -97,65 +222,200 @@ func (gw *Gateway) director(req *http.Request) {
 		req.Header.Set("X-Forwarded-For", req.RemoteAddr)
 	}
 	req.Header.Set("X-Real-IP", req.RemoteAddr)
-	req.Header.Set("X-Forwarded-Host", originalHost)
+	req.Header.Set("X-Forwarded-Host", req.Host)
 	req.Header.Set("X-Forwarded-Proto", requestScheme(req))
-
-	slog.Debug("proxying request",
-		"method", req.Method,
-		"path", req.URL.Path,
-		"backend", b.RawURL,
-	)
-
-	// Attach the selected backend to the request context so downstream hooks
-	// can retrieve it without sharing mutable state across goroutines.
-	newReq := req.WithContext(context.WithValue(req.Context(), ctxKey{}, b))
-	*req = *newReq
 }
 
-// modifyResponse is called on every successful upstream response.
-// It releases the active-connection count for the selected backend.
+// modifyResponse is called on every successful upstream response. 5xx
+// responses are counted separately so pools can be compared by error rate.
+// A gRPC call answered with something other than gRPC gets the matching
+// grpc-status instead.
+// It also strips the globally configured headers, maps redirect and cookie
+// paths back to the public prefix and applies the route's response header
+// rules.
 func (gw *Gateway) modifyResponse(resp *http.Response) error {
-	if b := backendFromCtx(resp.Request.Context()); b != nil {
-		gw.mu.RLock()
-		picker := gw.picker
-		gw.mu.RUnlock()
-		picker.Done(b)
+	ctx := resp.Request.Context()
+	b := backendFromCtx(ctx)
+	if b != nil {
 		b.IncRequests()
+		if resp.StatusCode >= 500 {
+			b.IncServerErrors()
+		}
+	}
+	if isGRPC(resp.Request) && resp.StatusCode != http.StatusSwitchingProtocols && !isGRPCContentType(resp.Header.Get("Content-Type")) {
+		grpcStatusFromHTTP(resp)
+	}
+
+	stripHeaders(resp.Header, gw.Table().stripResponse)
+	if rt := routeFromCtx(ctx); rt != nil {
+		if b != nil {
+			public, _ := ctx.Value(publicURLKey{}).(*url.URL)
+			rewriteResponsePaths(resp, rt, b, public)
+		}
+		rt.ResponseHeaders.apply(resp.Header, templateVars{req: resp.Request, route: rt, backend: b})
+	}
+	if t, ok := ctx.Value(tunnelKey{}).(*tunnel); ok && resp.StatusCode == http.StatusSwitchingProtocols {
+		t.backend = b
 	}
 	return nil
 }
 
-// errorHandler is called when ReverseProxy cannot reach the backend (dial
-// error, timeout, etc.). It performs a passive health check by marking the
-// backend unhealthy so the strategy stops sending traffic to it until the
-// active monitor revives it.
+// errorHandler is called when ReverseProxy cannot obtain a response. It maps
+// the failure to a status code:
+//
+//   - 503 Service Unavailable — no healthy backend, or no free connection slot
+//     (queue full or queue timeout).
+//   - 504 Gateway Timeout — dial, response-header or route timeout.
+//   - 502 Bad Gateway — connection refused/reset or a protocol error.
+//
+// When a backend was reached, it also performs a passive health check by
+// marking the backend unhealthy so the strategy stops sending traffic to it
+// until the active monitor revives it. A backend that accepted the connection
+// but was too slow to answer is only counted as an error: one slow endpoint
+// should not take the whole backend out of rotation.
 func (gw *Gateway) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
-	if b := backendFromCtx(r.Context()); b != nil {
-		gw.mu.RLock()
-		picker := gw.picker
-		gw.mu.RUnlock()
-		picker.Done(b)
+	pages := gw.errorPages()
 
-		// Passive health check — mark unhealthy immediately.
-		// The health.Monitor will clear this flag once the backend recovers.
-		b.SetHealthy(false)
-		b.IncRequests()
-		b.IncErrors()
+	var be *backendError
+	if !errors.As(err, &be) {
+		status, detail := http.StatusBadGateway, "bad gateway"
+		switch {
+		case errors.Is(err, strategy.ErrNoHealthyBackend):
+			status, detail = http.StatusServiceUnavailable, "no healthy backend available"
+		case errors.Is(err, strategy.ErrQueueFull), errors.Is(err, strategy.ErrQueueTimeout),
+			errors.Is(err, strategy.ErrAllSaturated):
+			status, detail = http.StatusServiceUnavailable, "all backends are at capacity"
+		case isTimeout(err):
+			// The route timeout expired while waiting in the queue.
+			status, detail = http.StatusGatewayTimeout, "timed out waiting for a backend"
+		}
+		slog.Warn("no backend for request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"error", err,
+		)
+		pages.Write(w, r, status, detail)
+		return
+	}
 
-		slog.Error("backend error — marked unhealthy",
+	b := be.backend
+	b.IncRequests()
+
+	// A client that hung up says nothing about the backend's health.
+	if errors.Is(be.err, context.Canceled) && r.Context().Err() != nil {
+		slog.Info("client cancelled request",
 			"backend", b.RawURL,
 			"method", r.Method,
 			"path", r.URL.Path,
-			"error", err,
+		)
+		pages.Write(w, r, http.StatusBadGateway, "request cancelled")
+		return
+	}
+	b.IncErrors()
+
+	if isTimeout(be.err) && !isDialError(be.err) {
+		slog.Error("backend timeout",
+			"backend", b.RawURL,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"error", be.err,
 		)
 	} else {
-		slog.Error("backend error",
+		// Passive health check — mark unhealthy immediately.
+		// The health.Monitor will clear this flag once the backend recovers.
+		b.SetHealthy(false)
+
+		slog.Error("backend error — marked unhealthy",
+			"backend", b.RawURL,
 			"method", r.Method,
 			"path", r.URL.Path,
-			"error", err,
+			"error", be.err,
 		)
 	}
-	http.Error(w, "bad gateway", http.StatusBadGateway)
+
+	if isTimeout(be.err) {
+		pages.Write(w, r, http.StatusGatewayTimeout, "upstream timed out")
+		return
+	}
+	pages.Write(w, r, http.StatusBadGateway, "upstream connection failed")
+}
+
+// routeTransport is the ReverseProxy transport: it forwards each request
+// through the pool chosen for it in ServeHTTP, hedging it on routes that
+// hedge.
+type routeTransport struct{}
+
+func (routeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	pool, _ := req.Context().Value(poolKey{}).(*Pool)
+	if pool == nil {
+		return nil, errors.New("proxy: request has no pool")
+	}
+	if rt := routeFromCtx(req.Context()); rt != nil && rt.Hedge != nil && rt.Hedge.eligible(req) {
+		return rt.Hedge.roundTrip(pool, req)
+	}
+	return pool.RoundTrip(req)
+}
+
+// backendError carries the backend that failed so errorHandler can apply
+// passive health checks to it.
+type backendError struct {
+	backend *strategy.Backend
+	err     error
+}
+
+func (e *backendError) Error() string { return e.err.Error() }
+func (e *backendError) Unwrap() error { return e.err }
+
+// releaseOnClose wraps body so that release runs exactly once when it is
+// closed. Upgrade responses (101) carry an io.ReadWriteCloser body that
+// ReverseProxy type-asserts on, so that capability is preserved.
+func releaseOnClose(body io.ReadCloser, release func()) io.ReadCloser {
+	rb := &releaseBody{ReadCloser: body, release: release}
+	if rwc, ok := body.(io.ReadWriteCloser); ok {
+		return &releaseRWBody{releaseBody: rb, w: rwc}
+	}
+	return rb
+}
+
+type releaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (rb *releaseBody) Close() error {
+	err := rb.ReadCloser.Close()
+	rb.once.Do(rb.release)
+	return err
+}
+
+type releaseRWBody struct {
+	*releaseBody
+	w io.Writer
+}
+
+func (rb *releaseRWBody) Write(p []byte) (int, error) { return rb.w.Write(p) }
+
+// isTimeout reports whether err is a deadline or I/O timeout: a dial timeout,
+// the transport's response-header timeout, or the route's request timeout.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// isDialError reports whether err happened while connecting to the backend.
+func isDialError(err error) bool {
+	var oe *net.OpError
+	return errors.As(err, &oe) && oe.Op == "dial"
+}
+
+func routeFromCtx(ctx context.Context) *Route {
+	rt, _ := ctx.Value(routeKey{}).(*Route)
+	return rt
 }
 
 func backendFromCtx(ctx context.Context) *strategy.Backend {
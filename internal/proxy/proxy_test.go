@@ -1,15 +1,30 @@
 package proxy_test
 
 import (
+	"bufio"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"golb/internal/cache"
+	"golb/internal/config"
+	"golb/internal/middleware"
 	"golb/internal/proxy"
 	"golb/internal/strategy"
 )
@@ -76,7 +91,7 @@ func TestGateway_InjectsProxyHeaders(t *testing.T) {
 	assert.Equal(t, "http", receivedHeaders.Get("X-Forwarded-Proto"))
 }
 
-func TestGateway_NoHealthyBackend_Returns502(t *testing.T) {
+func TestGateway_NoHealthyBackend_Returns503(t *testing.T) {
 	b, err := strategy.NewBackend("http://127.0.0.1:1", 1)
 	require.NoError(t, err)
 	b.SetHealthy(false) // explicitly mark unhealthy
@@ -90,7 +105,8 @@ func TestGateway_NoHealthyBackend_Returns502(t *testing.T) {
 	require.NoError(t, err)
 	resp.Body.Close()
 
-	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode,
+		"no healthy backend is a capacity problem, not a bad gateway")
 }
 
 func TestGateway_PassiveHealthCheck_MarksUnhealthy(t *testing.T) {
@@ -168,7 +184,1648 @@ func TestGateway_ForwardsStatusCodes(t *testing.T) {
 	}
 }
 
-// ── helpers ──────────────────────────────────────────────────────────────────
+func TestGateway_HoldsConnSlotUntilBodyDone(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = w.Write([]byte("done"))
+	}))
+	defer backend.Close()
+
+	gw, b := singleBackendGateway(t, backend.URL)
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), b.ActiveConns(), "slot must be held while the body streams")
+
+	close(release)
+	_, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Eventually(t, func() bool { return b.ActiveConns() == 0 },
+		time.Second, 5*time.Millisecond, "slot must be released once the body is done")
+}
+
+func TestGateway_QueueTimeout_Returns503(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer backend.Close()
+
+	b, err := strategy.NewBackend(backend.URL, 1)
+	require.NoError(t, err)
+	b.MaxConns = 1
+	q := strategy.NewQueue(strategy.NewRoundRobin([]*strategy.Backend{b}), 5, 50*time.Millisecond)
+	srv := httptest.NewServer(proxy.New(q))
+	defer srv.Close()
+	defer close(release) // unblock the slow request before the servers close
+
+	// Occupy the only slot.
+	go func() {
+		if resp, err := http.Get(srv.URL + "/slow"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	require.Eventually(t, func() bool { return b.ActiveConns() == 1 }, time.Second, 5*time.Millisecond)
+
+	resp, err := http.Get(srv.URL + "/queued")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.True(t, b.IsHealthy(), "queue timeout must not mark the backend unhealthy")
+	assert.Equal(t, int64(1), q.Stats().Timeouts)
+}
+
+func TestGateway_QueuedRequestServedWhenSlotFrees(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	defer backend.Close()
+
+	b, err := strategy.NewBackend(backend.URL, 1)
+	require.NoError(t, err)
+	b.MaxConns = 1
+	q := strategy.NewQueue(strategy.NewRoundRobin([]*strategy.Backend{b}), 5, 2*time.Second)
+	srv := httptest.NewServer(proxy.New(q))
+	defer srv.Close()
+
+	go func() {
+		if resp, err := http.Get(srv.URL + "/slow"); err == nil {
+			_, _ = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+	}()
+	require.Eventually(t, func() bool { return b.ActiveConns() == 1 }, time.Second, 5*time.Millisecond)
+
+	got := make(chan string, 1)
+	go func() { got <- doGet(t, srv.URL+"/queued") }()
+	require.Eventually(t, func() bool { return q.Stats().Depth == 1 }, time.Second, 5*time.Millisecond)
+
+	close(release)
+	assert.Equal(t, "/queued", <-got)
+}
+
+func TestGateway_ReloadKeepsInFlightRequestsAndHealth(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	defer backend.Close()
+
+	cfg := config.Default()
+	cfg.Backends = []config.BackendCfg{{URL: backend.URL, Weight: 1, MaxConns: 1}}
+	cfg.Queue.MaxSize = 0
+	build := func() *proxy.Table {
+		table, err := proxy.BuildTable(cfg)
+		require.NoError(t, err)
+		return table
+	}
+	gw := proxy.NewWithTable(build())
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	slow := make(chan struct{})
+	go func() {
+		defer close(slow)
+		if resp, err := http.Get(srv.URL + "/slow"); err == nil {
+			_, _ = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+	}()
+	old := gw.Table().Backends()[0]
+	require.Eventually(t, func() bool { return old.ActiveConns() == 1 }, time.Second, 5*time.Millisecond)
+
+	gw.UpdateTable(build())
+	b := gw.Table().Backends()[0]
+	require.NotSame(t, old, b)
+	assert.Equal(t, int64(1), b.ActiveConns(), "the request still running counts in the new table")
+	resp, err := http.Get(srv.URL + "/next")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "max_conns holds across the reload")
+
+	close(release)
+	<-slow
+	assert.Eventually(t, func() bool { return b.ActiveConns() == 0 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "/next", doGet(t, srv.URL+"/next"))
+
+	b.SetHealthy(false)
+	gw.UpdateTable(build())
+	assert.False(t, gw.Table().Backends()[0].IsHealthy(), "health survives the reload")
+}
+
+func TestGateway_RoutesByLongestPrefix(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("api"))
+	}))
+	defer api.Close()
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("web"))
+	}))
+	defer web.Close()
+
+	apiPool := newPool(t, "api", api.URL, nil)
+	webPool := newPool(t, "web", web.URL, nil)
+	gw := proxy.NewWithTable(proxy.NewTable([]*proxy.Route{
+		{Name: "web", PathPrefix: "/", Pool: webPool},
+		{Name: "api", PathPrefix: "/api", Pool: apiPool},
+	}))
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	assert.Equal(t, "api", doGet(t, srv.URL+"/api"))
+	assert.Equal(t, "api", doGet(t, srv.URL+"/api/users"))
+	assert.Equal(t, "web", doGet(t, srv.URL+"/apix"), "prefix matching is segment-aware")
+	assert.Equal(t, "web", doGet(t, srv.URL+"/index.html"))
+}
+
+func TestGateway_NoMatchingRoute_Returns404(t *testing.T) {
+	pool := newPool(t, "api", "http://127.0.0.1:1", nil)
+	gw := proxy.NewWithTable(proxy.NewTable([]*proxy.Route{
+		{Name: "api", PathPrefix: "/api", Pool: pool},
+	}))
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/other")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestGateway_ResponseHeaderTimeout_Returns504(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer backend.Close()
+
+	transport, err := proxy.NewTransport(config.TransportCfg{ResponseHeaderTimeout: "50ms"})
+	require.NoError(t, err)
+	pool := newPool(t, "slow", backend.URL, transport)
+	gw := proxy.NewWithTable(proxy.NewTable([]*proxy.Route{{Name: "slow", PathPrefix: "/", Pool: pool}}))
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+	defer close(release)
+
+	resp, err := http.Get(srv.URL + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	b := pool.Backends()[0]
+	assert.True(t, b.IsHealthy(), "a slow answer must not take the backend out of rotation")
+	assert.Equal(t, int64(1), b.TotalErrors())
+}
+
+func TestGateway_RouteTimeout_Returns504(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer backend.Close()
+
+	pool := newPool(t, "slow", backend.URL, nil)
+	gw := proxy.NewWithTable(proxy.NewTable([]*proxy.Route{
+		{Name: "slow", PathPrefix: "/", Pool: pool, Timeout: 50 * time.Millisecond},
+	}))
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+	defer close(release)
+
+	start := time.Now()
+	resp, err := http.Get(srv.URL + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestBuildTable_FromConfig(t *testing.T) {
+	cfg := config.Default()
+	cfg.Pools = []config.PoolCfg{{
+		Name:     "api",
+		Backends: []config.BackendCfg{{URL: "http://api:8080", Weight: 1}},
+	}}
+	cfg.Routes = []config.RouteCfg{
+		{Name: "api", PathPrefix: "/api", Pool: "api", Timeout: "3s"},
+		{Name: "rest", PathPrefix: "/", Pool: config.DefaultPool},
+	}
+
+	table, err := proxy.BuildTable(cfg)
+	require.NoError(t, err)
+
+	require.Len(t, table.Pools(), 2)
+	assert.Equal(t, "api", table.Pools()[0].Name)
+	assert.Equal(t, config.DefaultPool, table.Pools()[1].Name)
+	assert.Len(t, table.Backends(), 2)
+
+	rt := table.Match("/api/v1")
+	require.NotNil(t, rt)
+	assert.Equal(t, "api", rt.Pool.Name)
+	assert.Equal(t, 3*time.Second, rt.Timeout)
+}
+
+// ── Traffic splitting ────────────────────────────────────────────────────────
+
+func TestSplitter_DistributesByWeight(t *testing.T) {
+	stable := newPool(t, "stable", "http://stable:80", nil)
+	canary := newPool(t, "canary", "http://canary:80", nil)
+	s := proxy.NewSplitter([]*proxy.SplitTarget{
+		{Pool: stable, Weight: 80},
+		{Pool: canary, Weight: 20},
+	}, nil, "")
+
+	counts := map[string]int{}
+	for i := 0; i < 5000; i++ {
+		counts[s.Pick(httptest.NewRequest(http.MethodGet, "/", nil)).Name]++
+	}
+	assert.InDelta(t, 1000, counts["canary"], 200)
+	assert.Equal(t, int64(counts["stable"]), s.Targets()[0].Picks())
+}
+
+func TestSplitter_OverrideForcesPool(t *testing.T) {
+	stable := newPool(t, "stable", "http://stable:80", nil)
+	canary := newPool(t, "canary", "http://canary:80", nil)
+	s := proxy.NewSplitter([]*proxy.SplitTarget{
+		{Pool: stable, Weight: 100},
+		{Pool: canary, Weight: 0},
+	}, []proxy.Override{
+		{Header: "X-Canary", Value: "true", Pool: canary},
+		{Cookie: "beta", Pool: canary},
+	}, "")
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.Equal(t, "stable", s.Pick(r).Name)
+
+	r.Header.Set("X-Canary", "true")
+	assert.Equal(t, "canary", s.Pick(r).Name)
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Canary", "false")
+	assert.Equal(t, "stable", s.Pick(r).Name, "value must match")
+
+	r.AddCookie(&http.Cookie{Name: "beta", Value: "anything"})
+	assert.Equal(t, "canary", s.Pick(r).Name, "empty value matches any cookie value")
+	assert.Zero(t, s.Targets()[1].Picks(), "overrides are not counted as split picks")
+}
+
+func TestSplitter_StickyHashKey(t *testing.T) {
+	stable := newPool(t, "stable", "http://stable:80", nil)
+	canary := newPool(t, "canary", "http://canary:80", nil)
+	s := proxy.NewSplitter([]*proxy.SplitTarget{
+		{Pool: stable, Weight: 50},
+		{Pool: canary, Weight: 50},
+	}, nil, "header:X-User")
+
+	first := map[string]string{}
+	for i := 0; i < 50; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-User", user)
+		first[user] = s.Pick(r).Name
+		for j := 0; j < 3; j++ {
+			assert.Equal(t, first[user], s.Pick(r).Name, "same key, same pool")
+		}
+	}
+
+	// Growing the canary (listed last) only moves users towards it.
+	s.SetWeights(map[string]int{"stable": 10, "canary": 90})
+	assert.Equal(t, map[string]int{"stable": 10, "canary": 90}, s.Weights())
+	for user, pool := range first {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-User", user)
+		if pool == "canary" {
+			assert.Equal(t, "canary", s.Pick(r).Name, user)
+		}
+	}
+}
+
+func TestGateway_SplitRouteSendsToChosenPool(t *testing.T) {
+	stableSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("stable"))
+	}))
+	defer stableSrv.Close()
+	canarySrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("canary"))
+	}))
+	defer canarySrv.Close()
+
+	cfg := config.Default()
+	cfg.Backends = nil
+	cfg.Pools = []config.PoolCfg{
+		{Name: "stable", Backends: []config.BackendCfg{{URL: stableSrv.URL, Weight: 1}}},
+		{Name: "canary", Backends: []config.BackendCfg{{URL: canarySrv.URL, Weight: 1}}},
+	}
+	cfg.Routes = []config.RouteCfg{{
+		PathPrefix: "/",
+		Split:      []config.SplitTargetCfg{{Pool: "stable", Weight: 100}, {Pool: "canary", Weight: 0}},
+		Overrides:  []config.OverrideCfg{{Header: "X-Canary", Value: "true", Pool: "canary"}},
+	}}
+	table, err := proxy.BuildTable(cfg)
+	require.NoError(t, err)
+	require.Len(t, table.Pools(), 2)
+
+	srv := httptest.NewServer(proxy.NewWithTable(table))
+	defer srv.Close()
+
+	assert.Equal(t, "stable", doGet(t, srv.URL+"/"))
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/", nil)
+	req.Header.Set("X-Canary", "true")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	canary := table.Pools()[0].Backends()[0]
+	assert.Equal(t, int64(1), canary.TotalRequests())
+	assert.Equal(t, int64(1), canary.TotalServerErrors(), "5xx responses are counted per backend")
+}
+
+// ── Mirroring ────────────────────────────────────────────────────────────────
+
+func TestGateway_MirrorSendsMarkedCopy(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(append([]byte("primary:"), body...))
+	}))
+	defer primary.Close()
+
+	type shadowReq struct {
+		marker, body string
+	}
+	got := make(chan shadowReq, 1)
+	release := make(chan struct{})
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- shadowReq{r.Header.Get(proxy.MirrorHeader), string(body)}
+		<-release // a slow shadow must not hold up the client
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer shadow.Close()
+	defer close(release)
+
+	primaryPool := newPool(t, "primary", primary.URL, nil)
+	shadowPool := newPool(t, "shadow", shadow.URL, nil)
+	mirror := &proxy.Mirror{Pool: shadowPool, Percent: 100, MaxBodyBytes: 1024, Timeout: 5 * time.Second}
+	gw := proxy.NewWithTable(proxy.NewTable([]*proxy.Route{
+		{Name: "api", PathPrefix: "/", Pool: primaryPool, Mirror: mirror},
+	}))
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/orders", "text/plain", strings.NewReader("hello"))
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "primary:hello", string(body))
+
+	select {
+	case r := <-got:
+		assert.Equal(t, "true", r.marker)
+		assert.Equal(t, "hello", r.body)
+	case <-time.After(2 * time.Second):
+		t.Fatal("shadow request not received")
+	}
+}
+
+func TestGateway_MirrorKeepsRequestValues(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer primary.Close()
+	got := make(chan string, 1)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Get("X-User-Id")
+	}))
+	defer shadow.Close()
+
+	rules, err := proxy.NewHeaderRules(config.HeaderRulesCfg{Set: map[string]string{"X-User-Id": "${jwt.sub}"}})
+	require.NoError(t, err)
+	mirror := &proxy.Mirror{Pool: newPool(t, "shadow", shadow.URL, nil), Percent: 100, MaxBodyBytes: 1024, Timeout: 5 * time.Second}
+	gw := proxy.NewWithTable(proxy.NewTable([]*proxy.Route{
+		{Name: "api", PathPrefix: "/", Pool: newPool(t, "primary", primary.URL, nil), Mirror: mirror, RequestHeaders: rules},
+	}))
+	const secret = "mirror-test-secret"
+	srv := httptest.NewServer(middleware.JWTAuth(secret, nil)(gw))
+	defer srv.Close()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "user-42"}).SignedString([]byte(secret))
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// The client is gone by now; the shadow must still be sent, with the claim.
+	select {
+	case sub := <-got:
+		assert.Equal(t, "user-42", sub)
+	case <-time.After(2 * time.Second):
+		t.Fatal("shadow request not received")
+	}
+}
+
+func TestGateway_MirrorFailureLeavesPrimaryAlone(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer primary.Close()
+
+	b, err := strategy.NewBackend(primary.URL, 1)
+	require.NoError(t, err)
+	primaryPool := proxy.NewPool("primary", strategy.NewRoundRobin([]*strategy.Backend{b}), nil)
+	shadowPool := newPool(t, "shadow", "http://127.0.0.1:1", nil)
+	mirror := &proxy.Mirror{Pool: shadowPool, Percent: 100, MaxBodyBytes: 1024, Timeout: time.Second}
+	gw := proxy.NewWithTable(proxy.NewTable([]*proxy.Route{
+		{Name: "api", PathPrefix: "/", Pool: primaryPool, Mirror: mirror},
+	}))
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	assert.Equal(t, "ok", doGet(t, srv.URL+"/"))
+	require.Eventually(t, func() bool { return mirror.Stats().Failed == 1 }, 2*time.Second, 5*time.Millisecond)
+
+	assert.True(t, b.IsHealthy())
+	assert.Equal(t, int64(1), b.TotalRequests())
+	assert.Zero(t, b.TotalErrors())
+	assert.True(t, shadowPool.Backends()[0].IsHealthy(), "shadow health is left to the active monitor")
+}
+
+func TestGateway_MirrorSkipsOversizedBody(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write([]byte(strconv.Itoa(len(body))))
+	}))
+	defer primary.Close()
+
+	shadowHits := make(chan struct{}, 1)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		shadowHits <- struct{}{}
+	}))
+	defer shadow.Close()
+
+	mirror := &proxy.Mirror{Pool: newPool(t, "shadow", shadow.URL, nil), Percent: 100, MaxBodyBytes: 8, Timeout: time.Second}
+	gw := proxy.NewWithTable(proxy.NewTable([]*proxy.Route{
+		{Name: "api", PathPrefix: "/", Pool: newPool(t, "primary", primary.URL, nil), Mirror: mirror},
+	}))
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	// An unknown length forces the gateway to read past the limit.
+	body := io.MultiReader(strings.NewReader("0123456789"), strings.NewReader("abcdef"))
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/", body)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	assert.Equal(t, "16", string(got), "primary still receives the whole body")
+	assert.Equal(t, int64(1), mirror.Stats().Dropped)
+	select {
+	case <-shadowHits:
+		t.Fatal("oversized body must not be mirrored")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// ── Header rules ─────────────────────────────────────────────────────────────
+
+func TestGateway_HeaderRules(t *testing.T) {
+	t.Setenv("FLUX_TEST_REGION", "eu-west")
+
+	var got http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Header().Set("Server", "nginx/1.2.3")
+		w.Header().Set("X-Powered-By", "PHP/5")
+		w.Header().Set("X-Internal-Trace", "abc")
+		w.Header().Set("X-Upstream-Version", "2")
+	}))
+	defer backend.Close()
+
+	cfg := config.Default()
+	cfg.Backends = []config.BackendCfg{{URL: backend.URL, Weight: 1}}
+	cfg.Headers.StripResponse = []string{"server", "X-Powered-By"}
+	cfg.Routes = []config.RouteCfg{{
+		Name:       "api",
+		PathPrefix: "/",
+		RequestHeaders: config.HeaderRulesCfg{
+			Remove: []string{"cookie"},
+			Rename: map[string]string{"x-legacy-user": "X-User"},
+			Set: map[string]string{
+				"x-client-ip": "${client_ip}",
+				"X-Route":     "route=${route} backend=${backend}",
+				"X-Region":    "${env.FLUX_TEST_REGION}",
+				"X-Subject":   "${jwt.sub}",
+				"X-Price":     "$$5",
+			},
+			Add: map[string]string{"X-Request-Trace": "${request_id}"},
+		},
+		ResponseHeaders: config.HeaderRulesCfg{
+			Remove: []string{"X-Internal-Trace"},
+			Rename: map[string]string{"X-Upstream-Version": "X-Version"},
+			Set:    map[string]string{"X-Served-By": "${backend}"},
+		},
+	}}
+	table, err := proxy.BuildTable(cfg)
+	require.NoError(t, err)
+	handler := middleware.Logger(proxy.NewWithTable(table))
+
+	req := httptest.NewRequest(http.MethodGet, "/x", nil)
+	req.RemoteAddr = "203.0.113.7:5555"
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("X-Legacy-User", "alice")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	assert.Empty(t, got.Get("Cookie"))
+	assert.Empty(t, got.Get("X-Legacy-User"))
+	assert.Equal(t, "alice", got.Get("X-User"))
+	assert.Equal(t, "203.0.113.7", got.Get("X-Client-Ip"))
+	assert.Equal(t, "route=api backend="+backend.URL, got.Get("X-Route"))
+	assert.Equal(t, "eu-west", got.Get("X-Region"))
+	assert.Empty(t, got.Get("X-Subject"), "no JWT, no claim")
+	assert.Equal(t, "$5", got.Get("X-Price"))
+	assert.Equal(t, rec.Header().Get("X-Request-Id"), got.Get("X-Request-Trace"))
+
+	assert.Empty(t, rec.Header().Get("Server"))
+	assert.Empty(t, rec.Header().Get("X-Powered-By"))
+	assert.Empty(t, rec.Header().Get("X-Internal-Trace"))
+	assert.Equal(t, "2", rec.Header().Get("X-Version"))
+	assert.Equal(t, backend.URL, rec.Header().Get("X-Served-By"))
+}
+
+func TestGateway_HeaderRulesJWTClaim(t *testing.T) {
+	var subject string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject = r.Header.Get("X-User-Id")
+	}))
+	defer backend.Close()
+
+	rules, err := proxy.NewHeaderRules(config.HeaderRulesCfg{Set: map[string]string{"X-User-Id": "${jwt.sub}"}})
+	require.NoError(t, err)
+	gw := proxy.NewWithTable(proxy.NewTable([]*proxy.Route{
+		{Name: "api", PathPrefix: "/", Pool: newPool(t, "api", backend.URL, nil), RequestHeaders: rules},
+	}))
+	const secret = "header-rules-test-secret"
+	handler := middleware.JWTAuth(secret, nil)(gw)
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "user-42"}).SignedString([]byte(secret))
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "user-42", subject)
+}
+
+func TestNewHeaderRules_RejectsUnknownVariable(t *testing.T) {
+	_, err := proxy.NewHeaderRules(config.HeaderRulesCfg{Set: map[string]string{"X-A": "${nope}"}})
+	assert.Error(t, err)
+	_, err = proxy.NewHeaderRules(config.HeaderRulesCfg{Add: map[string]string{"X-A": "${route"}})
+	assert.Error(t, err)
+}
+
+// ── Path rewriting ───────────────────────────────────────────────────────────
+
+func TestGateway_JoinsBackendBasePath(t *testing.T) {
+	var gotPath, gotQuery string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotQuery = r.URL.Path, r.URL.RawQuery
+	}))
+	defer backend.Close()
+
+	gw := proxy.NewWithTable(proxy.NewTable([]*proxy.Route{
+		{Name: "api", PathPrefix: "/", Pool: newPool(t, "api", backend.URL+"/v2/?tenant=a", nil)},
+	}))
+	gw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1?x=1", nil))
+	assert.Equal(t, "/v2/users/1", gotPath)
+	assert.Equal(t, "tenant=a&x=1", gotQuery)
+}
+
+func TestGateway_PathRewrite(t *testing.T) {
+	var gotURI string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURI = r.URL.RequestURI()
+	}))
+	defer backend.Close()
+
+	cases := []struct {
+		name string
+		rc   config.RouteCfg
+		path string
+		want string
+	}{
+		{"strip prefix", config.RouteCfg{PathPrefix: "/users", StripPrefix: true}, "/users/42", "/base/42"},
+		{"strip whole path", config.RouteCfg{PathPrefix: "/users", StripPrefix: true}, "/users", "/base/"},
+		{"add prefix", config.RouteCfg{PathPrefix: "/", AddPrefix: "/internal"}, "/a/b", "/base/internal/a/b"},
+		{"strip and add", config.RouteCfg{PathPrefix: "/users/", StripPrefix: true, AddPrefix: "/people"}, "/users/7?q=1", "/base/people/7?q=1"},
+		{
+			"regex over path and query",
+			config.RouteCfg{PathPrefix: "/", Rewrite: []config.RewriteCfg{
+				{Regex: `^/nomatch`, Replace: "/never"},
+				{Regex: `^/items/(?P<id>\d+)\?page=(\d+)$`, Replace: "/catalog/item?id=${id}&p=$2"},
+			}},
+			"/items/9?page=3",
+			"/base/catalog/item?id=9&p=3",
+		},
+		{
+			"first matching rule wins",
+			config.RouteCfg{PathPrefix: "/", Rewrite: []config.RewriteCfg{
+				{Regex: `^/old/(.*)$`, Replace: "/new/$1"},
+				{Regex: `^/old/`, Replace: "/other/"},
+			}},
+			"/old/x",
+			"/base/new/x",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rw, err := proxy.NewPathRewrite(tc.rc)
+			require.NoError(t, err)
+			gw := proxy.NewWithTable(proxy.NewTable([]*proxy.Route{{
+				Name:       "r",
+				PathPrefix: tc.rc.PathPrefix,
+				Pool:       newPool(t, "p", backend.URL+"/base", nil),
+				Rewrite:    rw,
+			}}))
+			rec := httptest.NewRecorder()
+			gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			require.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, tc.want, gotURI)
+		})
+	}
+}
+
+func TestGateway_PathRewriteMapsRedirectsAndCookies(t *testing.T) {
+	var backendHost string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/app/login":
+			w.Header().Add("Set-Cookie", "session=abc; Path=/app; HttpOnly")
+			w.Header().Add("Set-Cookie", "pref=1; Path=/app/settings; Secure")
+			w.Header().Add("Set-Cookie", "other=1; path=/elsewhere")
+			http.Redirect(w, r, "/app/home?welcome=1", http.StatusFound)
+		case "/app/out":
+			http.Redirect(w, r, "http://"+backendHost+"/app/bye", http.StatusFound)
+		case "/app/external":
+			http.Redirect(w, r, "https://example.com/app/x", http.StatusFound)
+		}
+	}))
+	defer backend.Close()
+	backendHost = strings.TrimPrefix(backend.URL, "http://")
+
+	rw, err := proxy.NewPathRewrite(config.RouteCfg{PathPrefix: "/users", StripPrefix: true})
+	require.NoError(t, err)
+	gw := proxy.NewWithTable(proxy.NewTable([]*proxy.Route{{
+		Name:       "users",
+		PathPrefix: "/users",
+		Pool:       newPool(t, "users", backend.URL+"/app", nil),
+		Rewrite:    rw,
+	}}))
+
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://gw.example/users/login", nil))
+	assert.Equal(t, "/users/home?welcome=1", rec.Header().Get("Location"))
+	assert.Equal(t, []string{
+		"session=abc; Path=/users; HttpOnly",
+		"pref=1; Path=/users/settings; Secure",
+		"other=1; path=/elsewhere",
+	}, rec.Header().Values("Set-Cookie"))
+
+	rec = httptest.NewRecorder()
+	gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://gw.example/users/out", nil))
+	assert.Equal(t, "http://gw.example/users/bye", rec.Header().Get("Location"))
+
+	rec = httptest.NewRecorder()
+	gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://gw.example/users/external", nil))
+	assert.Equal(t, "https://example.com/app/x", rec.Header().Get("Location"), "other hosts untouched")
+}
+
+func TestNewPathRewrite_RejectsBadRegex(t *testing.T) {
+	_, err := proxy.NewPathRewrite(config.RouteCfg{Rewrite: []config.RewriteCfg{{Regex: "(", Replace: "/"}}})
+	assert.Error(t, err)
+}
+
+// ── Redirect and respond routes ──────────────────────────────────────────────
+
+func TestGateway_RedirectRoute(t *testing.T) {
+	cases := []struct {
+		name   string
+		cfg    config.RedirectCfg
+		url    string
+		status int
+		want   string
+	}{
+		{
+			"captured segments",
+			config.RedirectCfg{Regex: `^/blog/(\d+)/(?P<slug>[^/]+)$`, To: "/posts/${slug}?id=${1}&${query}", Status: 301},
+			"http://gw.example/blog/42/hello?ref=x",
+			http.StatusMovedPermanently,
+			"/posts/hello?id=42&ref=x",
+		},
+		{
+			"https upgrade keeps the request URI",
+			config.RedirectCfg{HTTPS: true, Status: 308},
+			"http://gw.example:8080/blog/1?a=b",
+			http.StatusPermanentRedirect,
+			"https://gw.example/blog/1?a=b",
+		},
+		{
+			"absolute target",
+			config.RedirectCfg{To: "https://new.example${path}"},
+			"http://gw.example/blog/7",
+			http.StatusFound,
+			"https://new.example/blog/7",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rd, err := proxy.NewRedirect(tc.cfg)
+			require.NoError(t, err)
+			gw := proxy.NewWithTable(proxy.NewTable([]*proxy.Route{{Name: "blog", PathPrefix: "/blog", Redirect: rd}}))
+			rec := httptest.NewRecorder()
+			gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.url, nil))
+			assert.Equal(t, tc.status, rec.Code)
+			assert.Equal(t, tc.want, rec.Header().Get("Location"))
+		})
+	}
+}
+
+func TestGateway_RedirectRegexMismatch_Returns404(t *testing.T) {
+	rd, err := proxy.NewRedirect(config.RedirectCfg{Regex: `^/old/(\d+)$`, To: "/new/$1"})
+	require.NoError(t, err)
+	gw := proxy.NewWithTable(proxy.NewTable([]*proxy.Route{{Name: "old", PathPrefix: "/old", Redirect: rd}}))
+
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/old/abc", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestNewRedirect_RejectsUnknownCapture(t *testing.T) {
+	_, err := proxy.NewRedirect(config.RedirectCfg{Regex: `^/(\d+)$`, To: "/x/$${2}"})
+	require.NoError(t, err, "$$ is a literal dollar")
+	_, err = proxy.NewRedirect(config.RedirectCfg{Regex: `^/(\d+)$`, To: "/x/${2}"})
+	assert.Error(t, err)
+	_, err = proxy.NewRedirect(config.RedirectCfg{To: "/x/${1}"})
+	assert.Error(t, err, "no regex, no groups")
+}
+
+func TestGateway_RespondRoute(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "maintenance.html")
+	require.NoError(t, os.WriteFile(file, []byte("<h1>Back soon</h1>"), 0o600))
+
+	robots, err := proxy.NewResponse(config.RespondCfg{Body: "User-agent: *\nDisallow: /\n"})
+	require.NoError(t, err)
+	gone, err := proxy.NewResponse(config.RespondCfg{
+		Status:  410,
+		Headers: map[string]string{"content-type": "application/json", "X-Route": "${route}"},
+		Body:    `{"error":"retired"}`,
+	})
+	require.NoError(t, err)
+	maint, err := proxy.NewResponse(config.RespondCfg{Status: 503, BodyFile: file})
+	require.NoError(t, err)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("backend reached for %s", r.URL.Path)
+	}))
+	defer backend.Close()
+	gw := proxy.NewWithTable(proxy.NewTable([]*proxy.Route{
+		{Name: "app", PathPrefix: "/", Pool: newPool(t, "app", backend.URL, nil)},
+		{Name: "robots", PathPrefix: "/robots.txt", Respond: robots},
+		{Name: "v1", PathPrefix: "/api/v1", Respond: gone},
+		{Name: "shop", PathPrefix: "/shop", Respond: maint},
+	}))
+	handler := middleware.Logger(gw)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/robots.txt", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "User-agent: *\nDisallow: /\n", rec.Body.String())
+	assert.NotEmpty(t, rec.Header().Get("X-Request-Id"), "middleware still runs")
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/users", nil))
+	assert.Equal(t, http.StatusGone, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "v1", rec.Header().Get("X-Route"))
+	assert.JSONEq(t, `{"error":"retired"}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/shop/cart", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "18", rec.Header().Get("Content-Length"))
+	assert.Empty(t, rec.Body.String(), "HEAD gets no body")
+}
+
+func TestBuildTable_ActionRoutesHaveNoPool(t *testing.T) {
+	cfg := config.Default()
+	cfg.Backends = []config.BackendCfg{{URL: "http://app:8080", Weight: 1}}
+	cfg.Routes = []config.RouteCfg{
+		{PathPrefix: "/"},
+		{PathPrefix: "/old", Redirect: config.RedirectCfg{To: "/new"}},
+		{PathPrefix: "/robots.txt", Respond: config.RespondCfg{Body: "x"}},
+	}
+	table, err := proxy.BuildTable(cfg)
+	require.NoError(t, err)
+	assert.Len(t, table.Pools(), 1)
+	assert.NotNil(t, table.Match("/old/x").Redirect)
+	assert.Nil(t, table.Match("/old/x").Pool)
+
+	cfg.Routes[2].Respond = config.RespondCfg{BodyFile: filepath.Join(t.TempDir(), "missing")}
+	_, err = proxy.BuildTable(cfg)
+	assert.Error(t, err)
+}
+
+// ── Response caching ─────────────────────────────────────────────────────────
+
+func TestGateway_CachesRouteResponses(t *testing.T) {
+	var hits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		w.Header().Set("Cache-Control", "max-age=5, stale-while-revalidate=60")
+		if n == 1 {
+			w.Header().Set("Age", "10") // stored already stale
+		}
+		fmt.Fprintf(w, "v%d", n)
+	}))
+	defer backend.Close()
+
+	pool := newPool(t, "api", backend.URL, nil)
+	gw := proxy.NewWithTable(proxy.NewTable([]*proxy.Route{
+		{Name: "cached", PathPrefix: "/cached", Pool: pool, Cache: cache.PolicyFrom(config.RouteCacheCfg{Enabled: true})},
+		{Name: "plain", PathPrefix: "/", Pool: pool},
+	}))
+	gw.SetCache(cache.New(1<<20, 1<<20))
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/cached/x")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "MISS", resp.Header.Get(cache.Header))
+
+	resp, err = http.Get(srv.URL + "/cached/x")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "STALE", resp.Header.Get(cache.Header))
+	assert.Equal(t, "v1", string(body))
+
+	// The background refresh outlives the client request and goes through
+	// the route's pool.
+	require.Eventually(t, func() bool { return doGet(t, srv.URL+"/cached/x") == "v2" },
+		time.Second, 10*time.Millisecond)
+	assert.EqualValues(t, 2, hits.Load())
+	assert.Zero(t, pool.Backends()[0].ActiveConns(), "cached responses release no connection slot")
+
+	resp, err = http.Get(srv.URL + "/plain")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Empty(t, resp.Header.Get(cache.Header), "routes without cache are not touched")
+}
+
+// ── Request coalescing ───────────────────────────────────────────────────────
+
+// coalescingServer serves a single coalescing route backed by a backend that
+// holds every request until release is closed.
+func coalescingServer(t *testing.T, cfg config.CoalesceCfg, body string) (url string, rt *proxy.Route, hits *atomic.Int64, release chan struct{}) {
+	t.Helper()
+	hits = &atomic.Int64{}
+	release = make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		<-release
+		w.Header().Set("X-Tenant-Seen", r.Header.Get("X-Tenant"))
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(backend.Close)
+
+	cfg.Enabled = true
+	rt = &proxy.Route{Name: "api", PathPrefix: "/", Pool: newPool(t, "api", backend.URL, nil), Coalesce: proxy.NewCoalescer(cfg)}
+	srv := httptest.NewServer(proxy.NewWithTable(proxy.NewTable([]*proxy.Route{rt})))
+	t.Cleanup(srv.Close)
+	return srv.URL, rt, hits, release
+}
+
+// concurrentGets starts n requests to url once the first one has reached the
+// backend, then releases the backend and returns the bodies.
+func concurrentGets(t *testing.T, url string, n int, hits *atomic.Int64, release chan struct{}, headers ...string) []string {
+	t.Helper()
+	bodies := make([]string, n)
+	var wg sync.WaitGroup
+	get := func(i int) {
+		defer wg.Done()
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		for j := 0; j+1 < len(headers); j += 2 {
+			req.Header.Set(headers[j], headers[j+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		bodies[i] = string(b)
+	}
+	wg.Add(n)
+	go get(0)
+	require.Eventually(t, func() bool { return hits.Load() >= 1 }, time.Second, time.Millisecond)
+	for i := 1; i < n; i++ {
+		go get(i)
+	}
+	time.Sleep(100 * time.Millisecond) // let the followers join the call
+	close(release)
+	wg.Wait()
+	return bodies
+}
+
+func TestGateway_CoalescesIdenticalRequests(t *testing.T) {
+	url, rt, hits, release := coalescingServer(t, config.CoalesceCfg{}, "shared")
+
+	bodies := concurrentGets(t, url+"/items?page=1", 5, hits, release)
+	assert.EqualValues(t, 1, hits.Load(), "one upstream call for five identical requests")
+	for _, b := range bodies {
+		assert.Equal(t, "shared", b)
+	}
+	st := rt.Coalesce.Stats()
+	assert.EqualValues(t, 1, st.Leaders)
+	assert.EqualValues(t, 4, st.Shared)
+
+	doGet(t, url+"/items?page=1")
+	assert.EqualValues(t, 2, hits.Load(), "finished calls are not reused")
+}
+
+func TestGateway_CoalesceKeyHeaders(t *testing.T) {
+	url, _, hits, release := coalescingServer(t, config.CoalesceCfg{KeyHeaders: []string{"x-tenant"}}, "ok")
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for _, tenant := range []string{"acme", "globex"} {
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, url+"/items", nil)
+			req.Header.Set("X-Tenant", tenant)
+			resp, err := http.DefaultClient.Do(req)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tenant, resp.Header.Get("X-Tenant-Seen"))
+				resp.Body.Close()
+			}
+		}()
+	}
+	require.Eventually(t, func() bool { return hits.Load() == 2 }, time.Second, time.Millisecond,
+		"different key header values make separate calls")
+	close(release)
+	wg.Wait()
+}
+
+func TestGateway_CoalesceSkipsAuthorizedRequests(t *testing.T) {
+	url, _, hits, release := coalescingServer(t, config.CoalesceCfg{}, "ok")
+	concurrentGets(t, url+"/me", 3, hits, release, "Authorization", "Bearer x")
+	assert.EqualValues(t, 3, hits.Load())
+
+	url, _, hits, release = coalescingServer(t, config.CoalesceCfg{AllowAuthorization: true}, "ok")
+	concurrentGets(t, url+"/me", 3, hits, release, "Authorization", "Bearer x")
+	assert.EqualValues(t, 1, hits.Load())
+}
+
+func TestGateway_CoalesceKeepsPrivateResponsesPrivate(t *testing.T) {
+	for name, header := range map[string][2]string{
+		"set-cookie": {"Set-Cookie", "session=%d"},
+		"private":    {"Cache-Control", "private, max-age=60"},
+		"no-store":   {"Cache-Control", "no-store"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var hits atomic.Int64
+			release := make(chan struct{})
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := hits.Add(1)
+				<-release
+				w.Header().Set(header[0], strings.ReplaceAll(header[1], "%d", strconv.FormatInt(n, 10)))
+				_, _ = fmt.Fprintf(w, "for request %d", n)
+			}))
+			t.Cleanup(backend.Close)
+			rt := &proxy.Route{Name: "api", PathPrefix: "/", Pool: newPool(t, "api", backend.URL, nil), Coalesce: proxy.NewCoalescer(config.CoalesceCfg{Enabled: true})}
+			srv := httptest.NewServer(proxy.NewWithTable(proxy.NewTable([]*proxy.Route{rt})))
+			t.Cleanup(srv.Close)
+
+			bodies := concurrentGets(t, srv.URL+"/me", 3, &hits, release)
+			assert.EqualValues(t, 3, hits.Load(), "waiters make their own calls")
+			assert.ElementsMatch(t, []string{"for request 1", "for request 2", "for request 3"}, bodies,
+				"no waiter receives the leader's response")
+			assert.EqualValues(t, 2, rt.Coalesce.Stats().Bypassed)
+		})
+	}
+}
+
+func TestGateway_CoalesceSkipsRequestsWithCookies(t *testing.T) {
+	url, _, hits, release := coalescingServer(t, config.CoalesceCfg{}, "ok")
+	concurrentGets(t, url+"/me", 3, hits, release, "Cookie", "session=abc")
+	assert.EqualValues(t, 3, hits.Load())
+
+	url, _, hits, release = coalescingServer(t, config.CoalesceCfg{KeyHeaders: []string{"cookie"}}, "ok")
+	concurrentGets(t, url+"/me", 3, hits, release, "Cookie", "session=abc")
+	assert.EqualValues(t, 1, hits.Load(), "requests with the same cookies share a call")
+}
+
+func TestGateway_CoalesceLimits(t *testing.T) {
+	large := strings.Repeat("x", 64)
+	url, rt, hits, release := coalescingServer(t, config.CoalesceCfg{MaxBodyBytes: 32}, large)
+	bodies := concurrentGets(t, url+"/big", 3, hits, release)
+	assert.EqualValues(t, 3, hits.Load(), "responses over max_body_bytes are not shared")
+	for _, b := range bodies {
+		assert.Equal(t, large, b)
+	}
+	assert.EqualValues(t, 2, rt.Coalesce.Stats().Bypassed)
+
+	url, rt, hits, release = coalescingServer(t, config.CoalesceCfg{MaxWaiters: 1}, "ok")
+	concurrentGets(t, url+"/busy", 4, hits, release)
+	assert.EqualValues(t, 3, hits.Load(), "requests beyond max_waiters go upstream themselves")
+	assert.EqualValues(t, 1, rt.Coalesce.Stats().Shared)
+}
+
+func TestNewCoalescer_DisabledIsNil(t *testing.T) {
+	assert.Nil(t, proxy.NewCoalescer(config.CoalesceCfg{}))
+	c := proxy.NewCoalescer(config.CoalesceCfg{Enabled: true})
+	require.NotNil(t, c)
+	assert.Equal(t, 100, c.MaxWaiters)
+	assert.Equal(t, int64(1<<20), c.MaxBodyBytes)
+}
+
+// ── Hedged requests ──────────────────────────────────────────────────────────
+
+// hedgingServer serves one hedging route over a pool of the given backend
+// handlers, in round-robin order.
+func hedgingServer(t *testing.T, cfg config.HedgeCfg, handlers ...http.HandlerFunc) (url string, rt *proxy.Route, backends []*strategy.Backend) {
+	t.Helper()
+	for _, h := range handlers {
+		srv := httptest.NewServer(h)
+		t.Cleanup(srv.Close)
+		b, err := strategy.NewBackend(srv.URL, 1)
+		require.NoError(t, err)
+		backends = append(backends, b)
+	}
+	cfg.Enabled = true
+	pool := proxy.NewPool("api", strategy.NewRoundRobin(backends), nil)
+	rt = &proxy.Route{Name: "api", PathPrefix: "/", Pool: pool, Hedge: proxy.NewHedge(cfg)}
+	srv := httptest.NewServer(proxy.NewWithTable(proxy.NewTable([]*proxy.Route{rt})))
+	t.Cleanup(srv.Close)
+	return srv.URL, rt, backends
+}
+
+func TestGateway_HedgeFirstResponseWinsAndLoserIsCancelled(t *testing.T) {
+	var calls atomic.Int64
+	cancelled := make(chan struct{})
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) == 1 {
+				<-r.Context().Done() // the first attempt hangs until cancelled
+				close(cancelled)
+				return
+			}
+			_, _ = io.WriteString(w, name)
+		}
+	}
+	url, rt, backends := hedgingServer(t, config.HedgeCfg{Delay: "20ms", BudgetPercent: 100},
+		handler("a"), handler("b"))
+
+	start := time.Now()
+	body := doGet(t, url+"/")
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Contains(t, []string{"a", "b"}, body)
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the losing attempt was not cancelled")
+	}
+	st := rt.Hedge.Stats()
+	assert.EqualValues(t, 1, st.Sent)
+	assert.EqualValues(t, 1, st.Won)
+	require.Eventually(t, func() bool {
+		return backends[0].ActiveConns() == 0 && backends[1].ActiveConns() == 0
+	}, time.Second, 5*time.Millisecond, "both attempts release their connection slot")
+	assert.EqualValues(t, 2, calls.Load())
+}
+
+func TestGateway_HedgeNotSentForFastOrUnsafeRequests(t *testing.T) {
+	var calls atomic.Int64
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.Method == http.MethodPost {
+			time.Sleep(50 * time.Millisecond)
+		}
+		_, _ = io.WriteString(w, "ok")
+	}
+	url, rt, _ := hedgingServer(t, config.HedgeCfg{Delay: "10ms", BudgetPercent: 100}, ok, ok)
+
+	doGet(t, url+"/")
+	resp, err := http.Post(url+"/", "text/plain", strings.NewReader("x"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.EqualValues(t, 2, calls.Load())
+	assert.Zero(t, rt.Hedge.Stats().Sent, "fast GETs and POSTs are never hedged")
+}
+
+func TestGateway_HedgeBudgetCapsExtraLoad(t *testing.T) {
+	var calls atomic.Int64
+	slow := func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		time.Sleep(40 * time.Millisecond)
+		_, _ = io.WriteString(w, "slow")
+	}
+	url, rt, _ := hedgingServer(t, config.HedgeCfg{Delay: "5ms", BudgetPercent: 50}, slow, slow)
+
+	for range 4 {
+		assert.Equal(t, "slow", doGet(t, url+"/"))
+	}
+	st := rt.Hedge.Stats()
+	assert.EqualValues(t, 2, st.Sent, "a 50% budget allows one hedge per two requests")
+	assert.EqualValues(t, 2, st.Throttled)
+	require.Eventually(t, func() bool { return calls.Load() == 6 }, time.Second, 5*time.Millisecond)
+}
+
+func TestGateway_HedgePercentileNeedsLatencyData(t *testing.T) {
+	slow := func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	url, rt, _ := hedgingServer(t, config.HedgeCfg{Delay: "p90", BudgetPercent: 100}, slow, slow)
+	doGet(t, url+"/")
+	st := rt.Hedge.Stats()
+	assert.Zero(t, st.Sent+st.Throttled, "no hedging until the pool has latency samples")
+}
+
+func TestNewHedge(t *testing.T) {
+	assert.Nil(t, proxy.NewHedge(config.HedgeCfg{}))
+	h := proxy.NewHedge(config.HedgeCfg{Enabled: true})
+	require.NotNil(t, h)
+	assert.Equal(t, 95.0, h.Percentile)
+	assert.Equal(t, 10*time.Millisecond, h.MinDelay)
+	assert.InDelta(t, 0.1, h.BudgetRatio, 1e-9)
+
+	h = proxy.NewHedge(config.HedgeCfg{Enabled: true, Delay: "150ms"})
+	assert.Equal(t, 150*time.Millisecond, h.Delay)
+	assert.Zero(t, h.Percentile)
+}
+
+// ── Upgrade tunnels ──────────────────────────────────────────────────────────
+
+// echoUpgradeBackend switches to a "websocket" echo tunnel on Upgrade
+// requests and answers anything else with the Upgrade header it saw.
+func echoUpgradeBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			_, _ = io.WriteString(w, "plain upgrade="+r.Header.Get("Upgrade"))
+			return
+		}
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		_, _ = io.Copy(conn, brw)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// upgradingServer serves one route to backendURL through the Logger
+// middleware, with a short server write timeout that tunnels must outlive.
+func upgradingServer(t *testing.T, backendURL string, up *proxy.Upgrade) (addr string, rt *proxy.Route, b *strategy.Backend) {
+	t.Helper()
+	b, err := strategy.NewBackend(backendURL, 1)
+	require.NoError(t, err)
+	pool := proxy.NewPool("ws", strategy.NewRoundRobin([]*strategy.Backend{b}), nil)
+	rt = &proxy.Route{Name: "ws", PathPrefix: "/", Pool: pool, Upgrade: up, Timeout: 50 * time.Millisecond}
+	srv := httptest.NewUnstartedServer(middleware.Logger(proxy.NewWithTable(proxy.NewTable([]*proxy.Route{rt}))))
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv.Listener.Addr().String(), rt, b
+}
+
+// dialUpgrade sends a websocket Upgrade request over a raw connection and
+// reads the response head.
+func dialUpgrade(t *testing.T, addr string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	_, err = io.WriteString(conn, "GET /chat HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+	require.NoError(t, err)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	return conn, br, resp
+}
+
+func echo(t *testing.T, conn net.Conn, br *bufio.Reader, msg string) {
+	t.Helper()
+	_, err := io.WriteString(conn, msg)
+	require.NoError(t, err)
+	got := make([]byte, len(msg))
+	_, err = io.ReadFull(br, got)
+	require.NoError(t, err)
+	assert.Equal(t, msg, string(got))
+}
+
+func TestGateway_UpgradeTunnelHoldsConnectionUntilClosed(t *testing.T) {
+	addr, rt, b := upgradingServer(t, echoUpgradeBackend(t).URL, &proxy.Upgrade{Protocols: []string{"websocket"}})
+	conn, br, resp := dialUpgrade(t, addr)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	echo(t, conn, br, "ping")
+	assert.EqualValues(t, 1, b.ActiveConns(), "the backend slot is held while the tunnel is open")
+	assert.EqualValues(t, 1, rt.Upgrade.Stats().Active)
+
+	// Neither the server's write timeout nor the route timeout ends a tunnel.
+	time.Sleep(100 * time.Millisecond)
+	echo(t, conn, br, "pong")
+
+	conn.Close()
+	assert.Eventually(t, func() bool { return b.ActiveConns() == 0 }, time.Second, 5*time.Millisecond)
+	assert.Eventually(t, func() bool { return rt.Upgrade.Stats().Active == 0 }, time.Second, 5*time.Millisecond)
+	st := rt.Upgrade.Stats()
+	assert.EqualValues(t, 1, st.Total)
+	assert.EqualValues(t, 8, st.BytesIn)
+	assert.EqualValues(t, 8, st.BytesOut)
+}
+
+func TestGateway_UpgradeLimitsCloseTunnel(t *testing.T) {
+	for name, up := range map[string]*proxy.Upgrade{
+		"idle":     {Protocols: []string{"websocket"}, IdleTimeout: 50 * time.Millisecond},
+		"lifetime": {Protocols: []string{"*"}, MaxLifetime: 150 * time.Millisecond},
+	} {
+		t.Run(name, func(t *testing.T) {
+			addr, _, b := upgradingServer(t, echoUpgradeBackend(t).URL, up)
+			conn, br, resp := dialUpgrade(t, addr)
+			require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+			if up.MaxLifetime > 0 {
+				// Traffic does not extend a tunnel's lifetime.
+				for range 3 {
+					echo(t, conn, br, "x")
+					time.Sleep(20 * time.Millisecond)
+				}
+			}
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err := br.ReadByte()
+			assert.ErrorIs(t, err, io.EOF, "the gateway closes the tunnel")
+			assert.Eventually(t, func() bool { return b.ActiveConns() == 0 }, time.Second, 5*time.Millisecond)
+		})
+	}
+}
+
+func TestGateway_UpgradeNotAllowedIsForwardedPlain(t *testing.T) {
+	for name, up := range map[string]*proxy.Upgrade{
+		"no upgrades":    nil,
+		"other protocol": {Protocols: []string{"h2c"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			addr, _, _ := upgradingServer(t, echoUpgradeBackend(t).URL, up)
+			_, br, resp := dialUpgrade(t, addr)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			body := make([]byte, resp.ContentLength)
+			_, err := io.ReadFull(br, body)
+			require.NoError(t, err)
+			assert.Equal(t, "plain upgrade=", string(body))
+		})
+	}
+}
+
+func TestNewUpgrade(t *testing.T) {
+	assert.Nil(t, proxy.NewUpgrade(config.UpgradeCfg{}))
+	up := proxy.NewUpgrade(config.UpgradeCfg{Protocols: []string{"WebSocket"}, MaxLifetime: "1h"})
+	require.NotNil(t, up)
+	assert.Equal(t, []string{"websocket"}, up.Protocols)
+	assert.Equal(t, 10*time.Minute, up.IdleTimeout)
+	assert.Equal(t, time.Hour, up.MaxLifetime)
+}
+
+// ── gRPC and HTTP/2 upstreams ────────────────────────────────────────────────
+
+// h2cServer starts a server that speaks HTTP/2 over cleartext as well as
+// HTTP/1.1, as gRPC servers inside a cluster usually do.
+func h2cServer(t *testing.T, h http.Handler) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(h)
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetHTTP1(true)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// h2cClient talks HTTP/2 over cleartext with prior knowledge.
+func h2cClient() *http.Client {
+	tr := &http.Transport{Protocols: new(http.Protocols)}
+	tr.Protocols.SetUnencryptedHTTP2(true)
+	return &http.Client{Transport: tr}
+}
+
+func grpcRequest(t *testing.T, url string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader("\x00\x00\x00\x00\x00"))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	return req
+}
+
+func h2cTransport(t *testing.T) http.RoundTripper {
+	t.Helper()
+	tr, err := proxy.NewTransport(config.TransportCfg{Protocol: config.ProtocolH2C})
+	require.NoError(t, err)
+	return tr
+}
+
+func TestGateway_H2CUpstreamPassesTrailers(t *testing.T) {
+	backend := h2cServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "HTTP/2.0", r.Proto)
+		assert.Equal(t, "trailers", r.Header.Get("Te"))
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		_, _ = io.WriteString(w, "\x00\x00\x00\x00\x02hi")
+		w.(http.Flusher).Flush()
+		w.Header().Set("Grpc-Status", "0")
+		// An unannounced trailer, as gRPC servers send for metadata.
+		w.Header().Set(http.TrailerPrefix+"X-Cost", "7")
+	}))
+	pool := newPool(t, "grpc", backend.URL, h2cTransport(t))
+	gw := proxy.NewWithTable(proxy.NewTable([]*proxy.Route{{Name: "grpc", PathPrefix: "/", Pool: pool}}))
+	srv := h2cServer(t, middleware.Logger(gw))
+
+	resp, err := h2cClient().Do(grpcRequest(t, srv.URL+"/echo.Echo/Say"))
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "\x00\x00\x00\x00\x02hi", string(body))
+	assert.Equal(t, "0", resp.Trailer.Get("Grpc-Status"))
+	assert.Equal(t, "7", resp.Trailer.Get("X-Cost"))
+	assert.EqualValues(t, 0, pool.Backends()[0].ActiveConns())
+}
+
+func TestGateway_TLSHTTP2Upstream(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Proto)
+	}))
+	backend.EnableHTTP2 = true
+	backend.StartTLS()
+	defer backend.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caFile, cert, 0o600))
+
+	for protocol, want := range map[string]string{
+		config.ProtocolH2:    "HTTP/2.0",
+		config.ProtocolAuto:  "HTTP/2.0",
+		config.ProtocolHTTP1: "HTTP/1.1",
+	} {
+		t.Run(protocol, func(t *testing.T) {
+			tr, err := proxy.NewTransport(config.TransportCfg{Protocol: protocol, CAFile: caFile})
+			require.NoError(t, err)
+			gw := proxy.NewWithTable(proxy.NewTable([]*proxy.Route{{Name: "tls", PathPrefix: "/", Pool: newPool(t, "tls", backend.URL, tr)}}))
+			srv := httptest.NewServer(gw)
+			defer srv.Close()
+			assert.Equal(t, want, doGet(t, srv.URL+"/"))
+		})
+	}
+}
+
+func TestNewTransport_Errors(t *testing.T) {
+	_, err := proxy.NewTransport(config.TransportCfg{Protocol: "spdy"})
+	assert.Error(t, err)
+	_, err = proxy.NewTransport(config.TransportCfg{CAFile: filepath.Join(t.TempDir(), "missing.pem")})
+	assert.Error(t, err)
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	require.NoError(t, os.WriteFile(empty, []byte("not a certificate"), 0o600))
+	_, err = proxy.NewTransport(config.TransportCfg{CAFile: empty})
+	assert.Error(t, err)
+}
+
+func TestGateway_GRPCErrorsGetGRPCStatus(t *testing.T) {
+	html503 := h2cServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = io.WriteString(w, "<h1>down for maintenance</h1>")
+	}))
+	hang := make(chan struct{})
+	slow := h2cServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-hang }))
+	defer close(hang)
+
+	maintenance := newPool(t, "maintenance", html503.URL, h2cTransport(t))
+	gw := proxy.NewWithTable(proxy.NewTable([]*proxy.Route{
+		{Name: "maintenance", PathPrefix: "/maintenance", Pool: maintenance},
+		{Name: "down", PathPrefix: "/down", Pool: newPool(t, "down", "http://127.0.0.1:1", h2cTransport(t))},
+		{Name: "slow", PathPrefix: "/slow", Pool: newPool(t, "slow", slow.URL, h2cTransport(t)), Timeout: 50 * time.Millisecond},
+	}))
+	pages, err := proxy.NewErrorPages(config.ErrorsCfg{Format: "html"})
+	require.NoError(t, err)
+	gw.SetErrorPages(pages)
+	srv := h2cServer(t, gw)
+
+	for path, want := range map[string]string{
+		"/maintenance/svc.S/M": "14", // upstream answered with HTML
+		"/down/svc.S/M":        "14", // connection refused
+		"/slow/svc.S/M":        "4",  // route timeout
+		"/nope/svc.S/M":        "12", // no route
+	} {
+		t.Run(path, func(t *testing.T) {
+			resp, err := h2cClient().Do(grpcRequest(t, srv.URL+path))
+			require.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, "application/grpc", resp.Header.Get("Content-Type"))
+			assert.Equal(t, want, resp.Header.Get("Grpc-Status"))
+			assert.NotEmpty(t, resp.Header.Get("Grpc-Message"))
+			assert.Empty(t, body)
+		})
+	}
+	assert.EqualValues(t, 0, maintenance.Backends()[0].ActiveConns(), "the replaced body is still released")
+
+	// Plain HTTP clients keep the configured error pages.
+	resp, err := http.Get(srv.URL + "/nope")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/html")
+}
+
+// ── Unix socket upstreams ────────────────────────────────────────────────────
+
+// unixServer serves h over HTTP/1.1 and h2c on a Unix socket and returns
+// its unix:// URL.
+func unixServer(t *testing.T, h http.Handler) string {
+	t.Helper()
+	path := t.TempDir() + "/app.sock"
+	ln, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	srv := &http.Server{Handler: h, Protocols: new(http.Protocols)}
+	srv.Protocols.SetHTTP1(true)
+	srv.Protocols.SetUnencryptedHTTP2(true)
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(func() { _ = srv.Close() })
+	return "unix://" + path
+}
+
+func TestGateway_UnixSocketUpstream(t *testing.T) {
+	backendURL := unixServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s %s", r.Proto, r.Host, r.URL.Path)
+	}))
+
+	gw, b := singleBackendGateway(t, backendURL)
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+	assert.Equal(t, "HTTP/1.1 localhost /api", doGet(t, srv.URL+"/api"))
+	assert.EqualValues(t, 0, b.ActiveConns())
+
+	// h2c works over the socket too, as gRPC servers behind sidecars use it.
+	pool := newPool(t, "grpc", backendURL, h2cTransport(t))
+	h2 := httptest.NewServer(proxy.NewWithTable(proxy.NewTable([]*proxy.Route{{Name: "grpc", PathPrefix: "/", Pool: pool}})))
+	defer h2.Close()
+	assert.Equal(t, "HTTP/2.0 localhost /api", doGet(t, h2.URL+"/api"))
+}
+
+// ── Error responses ──────────────────────────────────────────────────────────
+
+func TestErrorPages_JSONIncludesRequestID(t *testing.T) {
+	pages, err := proxy.NewErrorPages(config.ErrorsCfg{Format: "json"})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api", nil)
+	req.Header.Set("X-Request-Id", "abc123")
+	pages.Write(rec, req, http.StatusServiceUnavailable, "no healthy backend available")
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "service_unavailable", body["error"])
+	assert.Equal(t, "abc123", body["request_id"])
+	assert.Equal(t, float64(503), body["status"])
+}
+
+func TestErrorPages_ProblemJSON(t *testing.T) {
+	pages, err := proxy.NewErrorPages(config.ErrorsCfg{Format: "problem"})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/orders/7", nil)
+	req.Header.Set("X-Request-Id", "req-9")
+	pages.Write(rec, req, http.StatusGatewayTimeout, "upstream timed out")
+
+	assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "Gateway Timeout", body["title"])
+	assert.Equal(t, "/orders/7", body["instance"])
+	assert.Equal(t, "req-9", body["request_id"])
+}
+
+func TestErrorPages_HTMLTemplatePerStatus(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "502.html")
+	require.NoError(t, os.WriteFile(path, []byte(`<p>oops {{.Status}} id={{.RequestID}}</p>`), 0o644))
+
+	pages, err := proxy.NewErrorPages(config.ErrorsCfg{
+		Format:    "html",
+		Templates: map[string]string{"502": path},
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-Id", "r1")
+
+	rec := httptest.NewRecorder()
+	pages.Write(rec, req, http.StatusBadGateway, "upstream connection failed")
+	assert.Equal(t, "<p>oops 502 id=r1</p>", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	pages.Write(rec, req, http.StatusServiceUnavailable, "busy")
+	assert.Contains(t, rec.Body.String(), "503 Service Unavailable", "statuses without a template use the built-in page")
+	assert.Contains(t, rec.Body.String(), "r1")
+}
+
+func TestNewErrorPages_RejectsBadConfig(t *testing.T) {
+	_, err := proxy.NewErrorPages(config.ErrorsCfg{Format: "yaml"})
+	assert.Error(t, err)
+
+	_, err = proxy.NewErrorPages(config.ErrorsCfg{Format: "html", Templates: map[string]string{"200": "x"}})
+	assert.Error(t, err, "only 4xx/5xx statuses may have templates")
+
+	_, err = proxy.NewErrorPages(config.ErrorsCfg{Format: "html", Templates: map[string]string{"502": "/nonexistent.html"}})
+	assert.Error(t, err)
+}
+
+func TestGateway_ErrorStatusesUseConfiguredFormat(t *testing.T) {
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	deadURL := dead.URL
+	dead.Close()
+
+	gw, _ := singleBackendGateway(t, deadURL)
+	pages, err := proxy.NewErrorPages(config.ErrorsCfg{Format: "json"})
+	require.NoError(t, err)
+	gw.SetErrorPages(pages)
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	// First request: connection refused → 502, backend marked unhealthy.
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/", nil)
+	req.Header.Set("X-Request-Id", "first")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, "first", body["request_id"])
+
+	// Second request: nothing healthy left → 503.
+	resp, err = http.Get(srv.URL + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+// ── helpers ──────────────────────────────────────────────────────────────────
+
+func newPool(t *testing.T, name, backendURL string, transport http.RoundTripper) *proxy.Pool {
+	t.Helper()
+	b, err := strategy.NewBackend(backendURL, 1)
+	require.NoError(t, err)
+	return proxy.NewPool(name, strategy.NewRoundRobin([]*strategy.Backend{b}), transport)
+}
 
 func doGet(t *testing.T, url string) string {
 	t.Helper()
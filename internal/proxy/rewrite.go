@@ -0,0 +1,217 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golb/internal/config"
+	"golb/internal/strategy"
+)
+
+// PathRewrite changes the path and query of requests on their way upstream.
+// Steps run in order: strip the route prefix, apply the first matching regex
+// rule, add a prefix. The backend's own base path is joined on afterwards.
+type PathRewrite struct {
+	StripPrefix bool
+	AddPrefix   string
+	Rules       []RewriteRule
+}
+
+// RewriteRule rewrites the path and query together. Regex is matched against
+// "path?query" ("path" when there is no query); Replace may reference capture
+// groups as $1 or ${name}, and a "?" in the result starts the new query.
+type RewriteRule struct {
+	Regex   *regexp.Regexp
+	Replace string
+}
+
+// NewPathRewrite compiles the rewrite settings of rc, or returns nil when the
+// route has none.
+func NewPathRewrite(rc config.RouteCfg) (*PathRewrite, error) {
+	if !rc.StripPrefix && rc.AddPrefix == "" && len(rc.Rewrite) == 0 {
+		return nil, nil
+	}
+	pr := &PathRewrite{StripPrefix: rc.StripPrefix, AddPrefix: rc.AddPrefix}
+	for _, r := range rc.Rewrite {
+		re, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("rewrite %q: %w", r.Regex, err)
+		}
+		pr.Rules = append(pr.Rules, RewriteRule{Regex: re, Replace: r.Replace})
+	}
+	return pr, nil
+}
+
+// apply rewrites u, a request URL matched by a route with the given prefix.
+// A nil *PathRewrite is a no-op.
+func (pr *PathRewrite) apply(u *url.URL, prefix string) {
+	if pr == nil {
+		return
+	}
+	path, query := u.Path, u.RawQuery
+	if pr.StripPrefix {
+		path = stripPathPrefix(path, prefix)
+	}
+	for _, rule := range pr.Rules {
+		subject := path
+		if query != "" {
+			subject += "?" + query
+		}
+		if m := rule.Regex.FindStringSubmatchIndex(subject); m != nil {
+			out := string(rule.Regex.ExpandString(nil, rule.Replace, subject, m))
+			path, query, _ = strings.Cut(out, "?")
+			break
+		}
+	}
+	if pr.AddPrefix != "" {
+		path = joinPaths(pr.AddPrefix, path)
+	}
+	if path != u.Path {
+		u.Path, u.RawPath = path, ""
+	}
+	u.RawQuery = query
+}
+
+// upstreamPrefix is the path prefix the backend sees in place of the public
+// one: the backend's base path plus add_prefix.
+func (pr *PathRewrite) upstreamPrefix(b *strategy.Backend) string {
+	p := strings.TrimSuffix(b.URL.Path, "/")
+	if pr != nil && pr.AddPrefix != "" {
+		p = joinPaths(p, pr.AddPrefix)
+	}
+	return strings.TrimSuffix(p, "/")
+}
+
+// publicPrefix is the path prefix clients used in place of the upstream one.
+func (pr *PathRewrite) publicPrefix(routePrefix string) string {
+	if pr != nil && pr.StripPrefix {
+		return strings.TrimSuffix(routePrefix, "/")
+	}
+	return ""
+}
+
+// targetURL builds the upstream URL for a request to b: the route's rewrite
+// applied to req's path and query, joined onto the backend's base path and
+// query.
+func targetURL(req *url.URL, b *strategy.Backend, rt *Route) *url.URL {
+	target := *req
+	if rt != nil {
+		rt.Rewrite.apply(&target, rt.PathPrefix)
+	}
+	target.Scheme = b.URL.Scheme
+	target.Host = b.URL.Host
+	target.Path, target.RawPath = joinURLPath(b.URL, &target)
+	switch {
+	case b.URL.RawQuery == "":
+	case target.RawQuery == "":
+		target.RawQuery = b.URL.RawQuery
+	default:
+		target.RawQuery = b.URL.RawQuery + "&" + target.RawQuery
+	}
+	return &target
+}
+
+// rewriteResponsePaths maps Location and Set-Cookie paths that point at the
+// upstream prefix back to the public prefix, so redirects and cookies work
+// through the gateway. Absolute Location URLs on the backend's host are also
+// pointed back at the public host. Regex rewrites cannot be reversed and are
+// not undone.
+func rewriteResponsePaths(resp *http.Response, rt *Route, b *strategy.Backend, public *url.URL) {
+	upstream := rt.Rewrite.upstreamPrefix(b)
+	prefix := rt.Rewrite.publicPrefix(rt.PathPrefix)
+	if upstream == prefix {
+		return
+	}
+	toPublic := func(p string) string {
+		rest := p
+		if upstream != "" {
+			if p != upstream && !strings.HasPrefix(p, upstream+"/") {
+				return p // outside the upstream prefix; leave it alone
+			}
+			rest = p[len(upstream):]
+		}
+		if rest == "" && prefix != "" {
+			return prefix
+		}
+		return joinPaths(prefix, rest)
+	}
+
+	if loc := resp.Header.Get("Location"); loc != "" {
+		if u, err := url.Parse(loc); err == nil && strings.HasPrefix(u.Path, "/") {
+			switch {
+			case u.Host == "":
+				u.Path, u.RawPath = toPublic(u.Path), ""
+				resp.Header.Set("Location", u.String())
+			case u.Host == b.URL.Host && public != nil:
+				u.Scheme, u.Host = public.Scheme, public.Host
+				u.Path, u.RawPath = toPublic(u.Path), ""
+				resp.Header.Set("Location", u.String())
+			}
+		}
+	}
+
+	cookies := resp.Header["Set-Cookie"]
+	for i, c := range cookies {
+		cookies[i] = rewriteCookiePath(c, toPublic)
+	}
+}
+
+// rewriteCookiePath rewrites the Path attribute of a Set-Cookie value in
+// place, leaving every other attribute byte for byte as the backend sent it.
+func rewriteCookiePath(cookie string, toPublic func(string) string) string {
+	attrs := strings.Split(cookie, ";")
+	for i, a := range attrs[1:] {
+		name, val, ok := strings.Cut(strings.TrimSpace(a), "=")
+		if ok && strings.EqualFold(name, "path") && strings.HasPrefix(val, "/") {
+			p := toPublic(val)
+			if val == "/" && len(p) > 1 {
+				// Path=/ scopes the cookie to the whole app, i.e. the public
+				// prefix itself rather than prefix + "/".
+				p = strings.TrimSuffix(p, "/")
+			}
+			attrs[i+1] = " " + name + "=" + p
+		}
+	}
+	return strings.Join(attrs, ";")
+}
+
+// stripPathPrefix removes a route prefix from path, keeping a leading slash.
+func stripPathPrefix(path, prefix string) string {
+	rest := strings.TrimPrefix(path, strings.TrimSuffix(prefix, "/"))
+	if !strings.HasPrefix(rest, "/") {
+		rest = "/" + rest
+	}
+	return rest
+}
+
+// joinPaths joins a and b with exactly one slash between them, the way
+// httputil.NewSingleHostReverseProxy joins a target's base path.
+func joinPaths(a, b string) string {
+	switch {
+	case b == "":
+		if a == "" {
+			return "/"
+		}
+		return a
+	case strings.HasSuffix(a, "/") && strings.HasPrefix(b, "/"):
+		return a + b[1:]
+	case !strings.HasSuffix(a, "/") && !strings.HasPrefix(b, "/"):
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// joinURLPath joins the paths of base and u, keeping u's escaping when it has
+// a RawPath. An empty base path leaves u's path untouched.
+func joinURLPath(base, u *url.URL) (path, rawpath string) {
+	if base.Path == "" || base.Path == "/" {
+		return u.Path, u.RawPath
+	}
+	if base.RawPath == "" && u.RawPath == "" {
+		return joinPaths(base.Path, u.Path), ""
+	}
+	return joinPaths(base.Path, u.Path), joinPaths(base.EscapedPath(), u.EscapedPath())
+}
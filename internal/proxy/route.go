@@ -0,0 +1,400 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"golb/internal/cache"
+	"golb/internal/config"
+	"golb/internal/health"
+	"golb/internal/strategy"
+)
+
+// Route sends requests whose path starts with PathPrefix to Pool, or, when
+// Split is set, to the pool chosen by the splitter. Routes with Redirect or
+// Respond set answer requests themselves and have no pool.
+type Route struct {
+	Name       string
+	PathPrefix string
+	Pool       *Pool         // the route's pool; the first split target for split routes; nil for redirect/respond
+	Split      *Splitter     // optional weighted split across pools
+	Mirror     *Mirror       // optional shadow copy of a share of the traffic
+	Cache      *cache.Policy // optional response caching; nil means none
+	Coalesce   *Coalescer    // optional sharing of identical concurrent requests
+	Hedge      *Hedge        // optional second attempt for slow requests
+	Upgrade    *Upgrade      // allowed protocol upgrades; nil means none
+	Timeout    time.Duration // overall request timeout; 0 means none
+
+	Redirect *Redirect // answer with a redirect instead of proxying
+	Respond  *Response // answer with a fixed response instead of proxying
+
+	Rewrite         *PathRewrite // upstream path/query rewriting; nil means none
+	RequestHeaders  *HeaderRules // applied to the upstream request; nil means none
+	ResponseHeaders *HeaderRules // applied to the upstream response; nil means none
+}
+
+// pool returns the pool that should serve r.
+func (rt *Route) pool(r *http.Request) *Pool {
+	if rt.Split != nil {
+		return rt.Split.Pick(r)
+	}
+	return rt.Pool
+}
+
+// pools returns every pool the route can send traffic to, shadow pools
+// included.
+func (rt *Route) pools() []*Pool {
+	if rt.Pool == nil {
+		return nil
+	}
+	out := []*Pool{rt.Pool}
+	if rt.Split != nil {
+		for _, t := range rt.Split.targets {
+			out = append(out, t.Pool)
+		}
+		for _, o := range rt.Split.overrides {
+			out = append(out, o.Pool)
+		}
+	}
+	if rt.Mirror != nil {
+		out = append(out, rt.Mirror.Pool)
+	}
+	return out
+}
+
+// matches reports whether path falls under the route's prefix. Matching is
+// segment-aware: "/api" matches "/api" and "/api/x" but not "/apix".
+func (rt *Route) matches(path string) bool {
+	if !strings.HasPrefix(path, rt.PathPrefix) {
+		return false
+	}
+	return len(path) == len(rt.PathPrefix) ||
+		strings.HasSuffix(rt.PathPrefix, "/") ||
+		path[len(rt.PathPrefix)] == '/'
+}
+
+// Table is an immutable routing table. The Gateway swaps whole tables on
+// hot-reload, so a request always sees one consistent set of routes and pools.
+type Table struct {
+	routes []*Route // longest prefix first
+	pools  []*Pool  // every distinct pool referenced by routes or tcp listeners, sorted by name
+
+	stripResponse []string // headers removed from every upstream response
+}
+
+// NewTable builds a Table from routes, ordering them for longest-prefix match.
+func NewTable(routes []*Route) *Table {
+	sorted := append([]*Route(nil), routes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].PathPrefix) > len(sorted[j].PathPrefix)
+	})
+
+	seen := map[*Pool]bool{}
+	var pools []*Pool
+	for _, rt := range sorted {
+		for _, p := range rt.pools() {
+			if !seen[p] {
+				seen[p] = true
+				pools = append(pools, p)
+			}
+		}
+	}
+	sort.Slice(pools, func(i, j int) bool { return pools[i].Name < pools[j].Name })
+
+	return &Table{routes: sorted, pools: pools}
+}
+
+// Match returns the route for path, or nil when no route matches.
+func (t *Table) Match(path string) *Route {
+	for _, rt := range t.routes {
+		if rt.matches(path) {
+			return rt
+		}
+	}
+	return nil
+}
+
+// SetStripResponseHeaders sets the headers removed from every upstream
+// response. It must be called before the table is installed in a Gateway.
+func (t *Table) SetStripResponseHeaders(names []string) {
+	t.stripResponse = nil
+	for _, name := range names {
+		t.stripResponse = append(t.stripResponse, http.CanonicalHeaderKey(name))
+	}
+}
+
+// Routes returns the routes in match order.
+func (t *Table) Routes() []*Route { return t.routes }
+
+// Pools returns the table's pools sorted by name.
+func (t *Table) Pools() []*Pool { return t.pools }
+
+// Pool returns the pool called name, or nil.
+func (t *Table) Pool(name string) *Pool {
+	for _, p := range t.pools {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// addPool adds a pool no route references, such as a tcp listener's, so that
+// it is health checked and reported like the others.
+func (t *Table) addPool(p *Pool) {
+	if t.Pool(p.Name) != nil {
+		return
+	}
+	t.pools = append(t.pools, p)
+	sort.Slice(t.pools, func(i, j int) bool { return t.pools[i].Name < t.pools[j].Name })
+}
+
+// Backends returns the backends of every pool, e.g. for the health monitor.
+func (t *Table) Backends() []*strategy.Backend {
+	var out []*strategy.Backend
+	for _, p := range t.pools {
+		out = append(out, p.Backends()...)
+	}
+	return out
+}
+
+// HealthTargets returns the backends of every pool together with the pool's
+// check and transport, for the health monitor.
+func (t *Table) HealthTargets() []health.Target {
+	var out []health.Target
+	for _, p := range t.pools {
+		for _, b := range p.Backends() {
+			out = append(out, health.Target{Backend: b, Check: p.health, Transport: p.transport})
+		}
+	}
+	return out
+}
+
+// inherit carries the state of old's backends over to the backends of t's
+// static pools with the same pool name and URL: their health, and their
+// active connections, so that requests still running on old count towards
+// max_conns. Discovered pools get their backends from discovery instead.
+func (t *Table) inherit(old *Table) {
+	for _, p := range t.pools {
+		prev := old.Pool(p.Name)
+		if p.Discovered() || prev == nil {
+			continue
+		}
+		byURL := make(map[string]*strategy.Backend, len(prev.Backends()))
+		for _, b := range prev.Backends() {
+			byURL[b.RawURL] = b
+		}
+		for _, b := range p.Backends() {
+			if o, ok := byURL[b.RawURL]; ok && o != b {
+				b.SetHealthy(o.IsHealthy())
+				b.ShareConns(o)
+			}
+		}
+	}
+}
+
+// closeIdle drops idle upstream connections held by the table's pools.
+func (t *Table) closeIdle() {
+	for _, p := range t.pools {
+		p.closeIdle()
+	}
+}
+
+// BuildTable constructs the runtime routing table described by cfg: one Pool
+// per resolved pool (with its own transport and queue) and one Route per
+// resolved route.
+func BuildTable(cfg config.Config) (*Table, error) {
+	pools := map[string]*Pool{}
+	for _, pc := range cfg.ResolvedPools() {
+		picker, dynamic, err := buildPicker(pc, cfg.Queue)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: pool %q: %w", pc.Name, err)
+		}
+		transport, err := NewTransport(pc.Transport)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: pool %q: %w", pc.Name, err)
+		}
+		version := pc.ParsedSendProxyProtocol()
+		if version != 0 {
+			sendProxyProtocol(transport, version)
+		}
+		payload, err := pc.HealthCheck.Payload()
+		if err != nil {
+			return nil, fmt.Errorf("proxy: pool %q: %w", pc.Name, err)
+		}
+		pool := NewPool(pc.Name, picker, transport)
+		pool.dynamic = dynamic
+		pool.proxyProtocol = version
+		pool.health = health.Check{
+			GRPC:    pc.HealthCheck.Type == "grpc",
+			TCP:     pc.HealthCheck.Type == "tcp",
+			UDP:     pc.HealthCheck.Type == "udp",
+			Path:    pc.HealthCheck.Path,
+			Service: pc.HealthCheck.Service,
+			Payload: payload,
+			Expect:  []byte(pc.HealthCheck.Expect),
+			Port:    pc.HealthCheck.Port,
+		}
+		pools[pc.Name] = pool
+	}
+
+	lookup := func(rc config.RouteCfg, name string) (*Pool, error) {
+		pool, ok := pools[name]
+		if !ok {
+			return nil, fmt.Errorf("proxy: route %q references unknown pool %q", rc.Name, name)
+		}
+		return pool, nil
+	}
+
+	var routes []*Route
+	for _, rc := range cfg.ResolvedRoutes() {
+		if !rc.Proxies() {
+			rt, err := buildActionRoute(rc)
+			if err != nil {
+				return nil, fmt.Errorf("proxy: route %q: %w", rc.Name, err)
+			}
+			routes = append(routes, rt)
+			continue
+		}
+		pool, err := lookup(rc, rc.PrimaryPool())
+		if err != nil {
+			return nil, err
+		}
+		rt := &Route{
+			Name:       rc.Name,
+			PathPrefix: rc.PathPrefix,
+			Pool:       pool,
+			Cache:      cache.PolicyFrom(rc.Cache),
+			Coalesce:   NewCoalescer(rc.Coalesce),
+			Hedge:      NewHedge(rc.Hedge),
+			Upgrade:    NewUpgrade(rc.Upgrade),
+			Timeout:    rc.ParsedTimeout(poolTransport(cfg, rc.PrimaryPool()).ParsedRequestTimeout()),
+		}
+		if len(rc.Split) > 0 {
+			if rt.Split, err = buildSplitter(rc, lookup); err != nil {
+				return nil, err
+			}
+		}
+		if rt.Rewrite, err = NewPathRewrite(rc); err != nil {
+			return nil, fmt.Errorf("proxy: route %q: %w", rc.Name, err)
+		}
+		if rt.RequestHeaders, err = NewHeaderRules(rc.RequestHeaders); err != nil {
+			return nil, fmt.Errorf("proxy: route %q request_headers: %w", rc.Name, err)
+		}
+		if rt.ResponseHeaders, err = NewHeaderRules(rc.ResponseHeaders); err != nil {
+			return nil, fmt.Errorf("proxy: route %q response_headers: %w", rc.Name, err)
+		}
+		if mc := rc.Mirror; mc.Enabled() {
+			shadow, err := lookup(rc, mc.Pool)
+			if err != nil {
+				return nil, err
+			}
+			rt.Mirror = &Mirror{
+				Pool:         shadow,
+				Percent:      mc.Percent,
+				MaxBodyBytes: mc.ParsedMaxBodyBytes(),
+				Timeout:      mc.ParsedTimeout(),
+				MaxInflight:  int64(mc.ParsedMaxInflight()),
+			}
+		}
+		routes = append(routes, rt)
+	}
+	t := NewTable(routes)
+	for _, lc := range cfg.TCP {
+		for _, name := range lc.Pools() {
+			pool, ok := pools[name]
+			if !ok {
+				return nil, fmt.Errorf("proxy: tcp listener %q references unknown pool %q", lc.Name, name)
+			}
+			t.addPool(pool)
+		}
+	}
+	for _, lc := range cfg.UDP {
+		pool, ok := pools[lc.Pool]
+		if !ok {
+			return nil, fmt.Errorf("proxy: udp listener %q references unknown pool %q", lc.Name, lc.Pool)
+		}
+		t.addPool(pool)
+	}
+	t.SetStripResponseHeaders(cfg.Headers.StripResponse)
+	return t, nil
+}
+
+// buildActionRoute builds a route that redirects or responds instead of
+// proxying.
+func buildActionRoute(rc config.RouteCfg) (*Route, error) {
+	rt := &Route{Name: rc.Name, PathPrefix: rc.PathPrefix}
+	var err error
+	if rc.Redirect.Enabled() {
+		if rt.Redirect, err = NewRedirect(rc.Redirect); err != nil {
+			return nil, fmt.Errorf("redirect %w", err)
+		}
+	} else if rt.Respond, err = NewResponse(rc.Respond); err != nil {
+		return nil, fmt.Errorf("respond %w", err)
+	}
+	if rt.ResponseHeaders, err = NewHeaderRules(rc.ResponseHeaders); err != nil {
+		return nil, fmt.Errorf("response_headers: %w", err)
+	}
+	return rt, nil
+}
+
+// buildSplitter builds the Splitter for a route with split targets.
+func buildSplitter(rc config.RouteCfg, lookup func(config.RouteCfg, string) (*Pool, error)) (*Splitter, error) {
+	var targets []*SplitTarget
+	for _, sc := range rc.Split {
+		pool, err := lookup(rc, sc.Pool)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, &SplitTarget{Pool: pool, Weight: sc.Weight})
+	}
+	var overrides []Override
+	for _, oc := range rc.Overrides {
+		pool, err := lookup(rc, oc.Pool)
+		if err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, Override{Header: oc.Header, Cookie: oc.Cookie, Value: oc.Value, Pool: pool})
+	}
+	return NewSplitter(targets, overrides, rc.HashKey), nil
+}
+
+// buildPicker builds a pool's backends and Picker, wrapped in a wait queue
+// when queueing is enabled. A discovered pool starts out empty; its Dynamic
+// picker is returned too, for discovery to fill.
+func buildPicker(pc config.PoolCfg, qc config.QueueCfg) (strategy.Picker, *strategy.Dynamic, error) {
+	var picker strategy.Picker
+	var dynamic *strategy.Dynamic
+	if pc.Discovery.Enabled() {
+		d, err := strategy.NewDynamic(pc.Strategy)
+		if err != nil {
+			return nil, nil, err
+		}
+		picker, dynamic = d, d
+	} else {
+		backends, err := strategy.NewBackends(pc.Backends)
+		if err != nil {
+			return nil, nil, err
+		}
+		if picker, err = strategy.New(pc.Strategy, backends); err != nil {
+			return nil, nil, err
+		}
+	}
+	if qc.MaxSize > 0 {
+		picker = strategy.NewQueue(picker, qc.MaxSize, qc.ParsedTimeout())
+	}
+	return picker, dynamic, nil
+}
+
+// poolTransport returns the resolved transport settings of the named pool.
+func poolTransport(cfg config.Config, name string) config.TransportCfg {
+	for _, pc := range cfg.ResolvedPools() {
+		if pc.Name == name {
+			return pc.Transport
+		}
+	}
+	return cfg.Transport
+}
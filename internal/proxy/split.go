@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// SplitTarget is one weighted pool of a Splitter.
+type SplitTarget struct {
+	Pool   *Pool
+	Weight int
+
+	picks atomic.Int64
+}
+
+// Picks returns how many requests the splitter has sent to the target.
+func (t *SplitTarget) Picks() int64 { return t.picks.Load() }
+
+// Override forces requests that carry a header or cookie to Pool. An empty
+// Value matches any value.
+type Override struct {
+	Header string
+	Cookie string
+	Value  string
+	Pool   *Pool
+}
+
+func (o Override) matches(r *http.Request) bool {
+	var v string
+	if o.Header != "" {
+		vs := r.Header.Values(o.Header)
+		if len(vs) == 0 {
+			return false
+		}
+		v = vs[0]
+	} else {
+		c, err := r.Cookie(o.Cookie)
+		if err != nil {
+			return false
+		}
+		v = c.Value
+	}
+	return o.Value == "" || strings.EqualFold(v, o.Value)
+}
+
+// Splitter distributes a route's requests between pools by weight.
+//
+// Overrides are checked first. Otherwise, when a hash key is configured the
+// pool is derived from a hash of the key, so a given user keeps hitting the
+// same pool for as long as the weights stay put; without a hash key each
+// request is placed at random. Targets are laid out on the hash ring in
+// configuration order, so listing the canary last means raising its weight
+// only moves users from stable to canary, never back.
+//
+// Weights can be changed at runtime with SetWeights; the new set is swapped
+// in atomically.
+type Splitter struct {
+	targets   []*SplitTarget
+	overrides []Override
+	hashKey   string
+
+	weights atomic.Pointer[[]int]
+}
+
+// NewSplitter creates a Splitter. hashKey is "", "ip", "header:<name>" or
+// "cookie:<name>".
+func NewSplitter(targets []*SplitTarget, overrides []Override, hashKey string) *Splitter {
+	s := &Splitter{targets: targets, overrides: overrides, hashKey: hashKey}
+	w := make([]int, len(targets))
+	for i, t := range targets {
+		w[i] = t.Weight
+	}
+	s.weights.Store(&w)
+	return s
+}
+
+// Targets returns the split targets in configuration order.
+func (s *Splitter) Targets() []*SplitTarget { return s.targets }
+
+// Weights returns the current weight of each pool, keyed by pool name.
+func (s *Splitter) Weights() map[string]int {
+	w := *s.weights.Load()
+	out := make(map[string]int, len(w))
+	for i, t := range s.targets {
+		out[t.Pool.Name] = w[i]
+	}
+	return out
+}
+
+// SetWeights atomically replaces the weights of the named pools. Pools not in
+// weights keep their current weight; unknown names are ignored.
+func (s *Splitter) SetWeights(weights map[string]int) {
+	for {
+		old := s.weights.Load()
+		w := append([]int(nil), (*old)...)
+		for i, t := range s.targets {
+			if v, ok := weights[t.Pool.Name]; ok && v >= 0 {
+				w[i] = v
+			}
+		}
+		if s.weights.CompareAndSwap(old, &w) {
+			return
+		}
+	}
+}
+
+// Pick returns the pool that should serve r.
+func (s *Splitter) Pick(r *http.Request) *Pool {
+	for _, o := range s.overrides {
+		if o.matches(r) {
+			return o.Pool
+		}
+	}
+
+	w := *s.weights.Load()
+	total := 0
+	for _, v := range w {
+		total += v
+	}
+	if total <= 0 {
+		t := s.targets[0]
+		t.picks.Add(1)
+		return t.Pool
+	}
+
+	var n int
+	if key, ok := s.key(r); ok {
+		// Map the hash onto a fixed 0–9999 scale first so a user's position
+		// does not depend on the current total weight.
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		n = int(h.Sum32()%10000) * total / 10000
+	} else {
+		n = rand.IntN(total)
+	}
+	for i, v := range w {
+		if n < v {
+			t := s.targets[i]
+			t.picks.Add(1)
+			return t.Pool
+		}
+		n -= v
+	}
+	t := s.targets[len(s.targets)-1]
+	t.picks.Add(1)
+	return t.Pool
+}
+
+// key extracts the sticky hash key from r.
+func (s *Splitter) key(r *http.Request) (string, bool) {
+	switch {
+	case s.hashKey == "ip":
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		return host, host != ""
+	case strings.HasPrefix(s.hashKey, "header:"):
+		v := r.Header.Get(strings.TrimPrefix(s.hashKey, "header:"))
+		return v, v != ""
+	case strings.HasPrefix(s.hashKey, "cookie:"):
+		c, err := r.Cookie(strings.TrimPrefix(s.hashKey, "cookie:"))
+		if err != nil || c.Value == "" {
+			return "", false
+		}
+		return c.Value, true
+	}
+	return "", false
+}
@@ -0,0 +1,264 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golb/internal/config"
+	"golb/internal/strategy"
+)
+
+// Upgrade allows HTTP Upgrade requests (WebSocket and the like) on a route
+// and limits the tunnels they open. Once the backend answers 101 Switching
+// Protocols the client connection is hijacked and bytes are copied both ways
+// until either side closes, the tunnel has been idle for IdleTimeout or it
+// has been open for MaxLifetime. The backend's connection slot is held for
+// the whole life of the tunnel.
+type Upgrade struct {
+	Protocols   []string      // allowed protocols, lower case; "*" allows any
+	IdleTimeout time.Duration // 0 means none
+	MaxLifetime time.Duration // 0 means none
+
+	active   atomic.Int64
+	total    atomic.Int64
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+}
+
+// UpgradeStats counts a route's tunnels.
+type UpgradeStats struct {
+	Active   int64 // tunnels currently open
+	Total    int64 // tunnels opened
+	BytesIn  int64 // bytes relayed from clients to backends
+	BytesOut int64 // bytes relayed from backends to clients
+}
+
+// NewUpgrade builds an Upgrade from a route's config, or returns nil when the
+// route allows no upgrades.
+func NewUpgrade(cfg config.UpgradeCfg) *Upgrade {
+	if len(cfg.Protocols) == 0 {
+		return nil
+	}
+	u := &Upgrade{IdleTimeout: cfg.ParsedIdleTimeout(), MaxLifetime: cfg.ParsedMaxLifetime()}
+	for _, p := range cfg.Protocols {
+		u.Protocols = append(u.Protocols, strings.ToLower(p))
+	}
+	return u
+}
+
+// Stats returns the route's tunnel counters.
+func (u *Upgrade) Stats() UpgradeStats {
+	return UpgradeStats{
+		Active:   u.active.Load(),
+		Total:    u.total.Load(),
+		BytesIn:  u.bytesIn.Load(),
+		BytesOut: u.bytesOut.Load(),
+	}
+}
+
+// allows reports whether every protocol r asks to upgrade to is allowed. A
+// nil Upgrade allows none.
+func (u *Upgrade) allows(r *http.Request) bool {
+	if u == nil {
+		return false
+	}
+	for _, v := range r.Header.Values("Upgrade") {
+		for _, p := range strings.Split(v, ",") {
+			// Drop any version: "websocket/13" matches "websocket".
+			p, _, _ = strings.Cut(strings.ToLower(strings.TrimSpace(p)), "/")
+			if p != "" && !u.allowsProtocol(p) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (u *Upgrade) allowsProtocol(p string) bool {
+	for _, allowed := range u.Protocols {
+		if allowed == "*" || allowed == p {
+			return true
+		}
+	}
+	return false
+}
+
+// tunnelKey is the context key for the *tunnel of an upgrade request, so that
+// modifyResponse can record which backend accepted it.
+type tunnelKey struct{}
+
+// serveUpgrade forwards an allowed upgrade request and, if the backend
+// switched protocols, logs the tunnel once it has closed.
+func (gw *Gateway) serveUpgrade(w http.ResponseWriter, r *http.Request, rt *Route) {
+	t := &tunnel{up: rt.Upgrade}
+	uw := &upgradeWriter{ResponseWriter: w, t: t}
+	gw.forward(uw, r.WithContext(context.WithValue(r.Context(), tunnelKey{}, t)), rt)
+	if !t.opened() {
+		return
+	}
+	rt.Upgrade.active.Add(-1)
+	backend := ""
+	if t.backend != nil {
+		backend = t.backend.RawURL
+	}
+	slog.Info("tunnel closed",
+		"request_id", r.Header.Get("X-Request-Id"),
+		"route", rt.Name,
+		"backend", backend,
+		"protocol", r.Header.Get("Upgrade"),
+		"duration_ms", time.Since(t.start).Milliseconds(),
+		"bytes_in", t.in.Load(),
+		"bytes_out", t.out.Load(),
+		"reason", t.closeReason(),
+	)
+}
+
+// stripUpgrade turns an upgrade request the route does not allow into a plain
+// request: without the Upgrade header the backend answers it as one, as RFC
+// 9110 §7.8 lets a server ignore an upgrade it does not support.
+func stripUpgrade(r *http.Request) {
+	r.Header.Del("Upgrade")
+}
+
+// tunnel is one upgraded connection between a client and a backend.
+type tunnel struct {
+	up      *Upgrade
+	backend *strategy.Backend // set by modifyResponse on 101
+
+	conn  net.Conn // the hijacked client connection; nil until then
+	start time.Time
+	last  atomic.Int64 // unix nanoseconds of the last byte either way
+
+	in  atomic.Int64 // client to backend
+	out atomic.Int64 // backend to client
+
+	mu       sync.Mutex // guards the fields below, which the timers use
+	reason   string     // why the tunnel closed; empty while open
+	idle     *time.Timer
+	lifetime *time.Timer
+}
+
+// open starts the tunnel's clocks on the hijacked client connection.
+func (t *tunnel) open(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.conn, t.start = conn, time.Now()
+	t.last.Store(t.start.UnixNano())
+	t.up.active.Add(1)
+	t.up.total.Add(1)
+	if d := t.up.IdleTimeout; d > 0 {
+		t.idle = time.AfterFunc(d, t.checkIdle)
+	}
+	if d := t.up.MaxLifetime; d > 0 {
+		t.lifetime = time.AfterFunc(d, func() { t.close("max_lifetime") })
+	}
+}
+
+func (t *tunnel) opened() bool { return t.conn != nil }
+
+// touch records traffic, which keeps the tunnel from idling out.
+func (t *tunnel) touch() { t.last.Store(time.Now().UnixNano()) }
+
+// checkIdle closes the tunnel if it has carried no traffic for IdleTimeout,
+// and otherwise checks again when it next could have.
+func (t *tunnel) checkIdle() {
+	idle := time.Since(time.Unix(0, t.last.Load()))
+	if idle >= t.up.IdleTimeout {
+		t.close("idle_timeout")
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.reason == "" {
+		t.idle.Reset(t.up.IdleTimeout - idle)
+	}
+}
+
+// close ends the tunnel. Closing the client connection makes the copy loops
+// fail, after which the ReverseProxy closes the backend connection and so
+// releases the backend's slot.
+func (t *tunnel) close(reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.reason != "" {
+		return
+	}
+	t.reason = reason
+	if t.idle != nil {
+		t.idle.Stop()
+	}
+	if t.lifetime != nil {
+		t.lifetime.Stop()
+	}
+	_ = t.conn.Close()
+}
+
+// closeReason returns why the tunnel closed: idle_timeout, max_lifetime or
+// closed when either side hung up.
+func (t *tunnel) closeReason() string {
+	t.close("closed")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.reason
+}
+
+// upgradeWriter hands the ReverseProxy a client connection that counts bytes
+// and enforces the tunnel's limits when it hijacks.
+type upgradeWriter struct {
+	http.ResponseWriter
+	t *tunnel
+}
+
+// Hijack takes over the client connection for the tunnel.
+func (uw *upgradeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(uw.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	// The server's read and write timeouts are meant for requests, not for
+	// a tunnel that may stay open for hours.
+	_ = conn.SetDeadline(time.Time{})
+	uw.t.open(conn)
+	return &tunnelConn{Conn: conn, t: uw.t}, brw, nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (uw *upgradeWriter) Unwrap() http.ResponseWriter { return uw.ResponseWriter }
+
+// tunnelConn is the client side of a tunnel.
+type tunnelConn struct {
+	net.Conn
+	t *tunnel
+}
+
+func (c *tunnelConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.t.in.Add(int64(n))
+		c.t.up.bytesIn.Add(int64(n))
+		c.t.touch()
+	}
+	return n, err
+}
+
+func (c *tunnelConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.t.out.Add(int64(n))
+		c.t.up.bytesOut.Add(int64(n))
+		c.t.touch()
+	}
+	return n, err
+}
+
+func (c *tunnelConn) Close() error {
+	c.t.close("closed")
+	return nil
+}
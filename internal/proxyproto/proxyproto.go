@@ -0,0 +1,327 @@
+// Package proxyproto reads and writes PROXY protocol headers (versions 1 and
+// 2, as specified by HAProxy), which carry a connection's original client
+// and destination addresses across layer-4 load balancers.
+//
+// Listeners accept a header only from trusted sources, whose connections
+// must start with one; RemoteAddr and LocalAddr then report the addresses
+// it carries. Connections from other sources are served as they are.
+// Upstream dialers write a header at the start of each connection.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// signature starts every version 2 header.
+var signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// v1MaxLen is the longest version 1 header, CRLF included.
+const v1MaxLen = 107
+
+// ErrNoHeader is returned by the reads of a trusted connection that did not
+// start with a PROXY protocol header.
+var ErrNoHeader = errors.New("proxyproto: connection has no PROXY protocol header")
+
+// Listener accepts connections from ln, reading a PROXY protocol header from
+// those whose source address is in Trusted.
+type Listener struct {
+	net.Listener
+	Trusted []*net.IPNet
+	Timeout time.Duration // to receive the header; 0 means none
+}
+
+// NewListener wraps ln. With no trusted networks it returns ln unchanged.
+func NewListener(ln net.Listener, trusted []*net.IPNet, timeout time.Duration) net.Listener {
+	if len(trusted) == 0 {
+		return ln
+	}
+	return &Listener{Listener: ln, Trusted: trusted, Timeout: timeout}
+}
+
+// Accept returns the next connection. The header of a trusted connection is
+// read on its first use, not here, so that one slow client does not hold up
+// the others.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusts(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &Conn{Conn: conn, r: bufio.NewReader(conn), timeout: l.Timeout}, nil
+}
+
+func (l *Listener) trusts(addr net.Addr) bool {
+	ta, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range l.Trusted {
+		if n.Contains(ta.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection that starts with a PROXY protocol header. The header
+// is read by the first call to Read, RemoteAddr or LocalAddr.
+type Conn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+
+	once     sync.Once
+	src, dst net.Addr // from the header; nil for LOCAL and UNKNOWN
+	err      error
+}
+
+// Read reads data following the header.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(p)
+}
+
+// RemoteAddr returns the client address the header carries, or the peer's
+// address when it carries none.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address the header carries, or the
+// connection's own when it carries none.
+func (c *Conn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.dst != nil {
+		return c.dst
+	}
+	return c.Conn.LocalAddr()
+}
+
+// CloseWrite half-closes the underlying TCP connection, as the layer-4
+// relays do.
+func (c *Conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+func (c *Conn) readHeader() {
+	if c.timeout > 0 {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+	}
+	c.src, c.dst, c.err = ReadHeader(c.r)
+	if c.err != nil {
+		slog.Warn("proxy protocol header rejected", "peer", c.Conn.RemoteAddr().String(), "error", c.err)
+	}
+}
+
+// ReadHeader reads a version 1 or 2 header from r and returns the source and
+// destination addresses it carries. Both are nil for a LOCAL (v2) or UNKNOWN
+// (v1) header, and for address families other than TCP over IPv4 or IPv6.
+func ReadHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	start, err := r.Peek(len(signature))
+	switch {
+	case err == nil && bytes.Equal(start, signature):
+		return readV2(r)
+	case bytes.HasPrefix(start, []byte("PROXY ")):
+		return readV1(r)
+	case err != nil && !errors.Is(err, io.EOF):
+		return nil, nil, err
+	default:
+		return nil, nil, ErrNoHeader
+	}
+}
+
+// readV1 parses "PROXY TCP4 src dst sport dport\r\n".
+func readV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for len(line) < v1MaxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			break
+		}
+	}
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, nil, fmt.Errorf("proxyproto: v1 header longer than %d bytes", v1MaxLen)
+	}
+	fields := strings.Split(text, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("proxyproto: malformed v1 header %q", text)
+	}
+	src, err := v1Addr(fields[1], fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := v1Addr(fields[1], fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func v1Addr(family, host, port string) (*net.TCPAddr, error) {
+	ip, err := netip.ParseAddr(host)
+	if err != nil || ip.Zone() != "" || ip.Is4() != (family == "TCP4") {
+		return nil, fmt.Errorf("proxyproto: bad %s address %q", family, host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("proxyproto: bad port %q", port)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip.Unmap(), uint16(p))), nil
+}
+
+// readV2 parses the binary header: signature, version and command, family,
+// address length, addresses and any TLVs, which are skipped.
+func readV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, nil, err
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("proxyproto: unsupported version %d", fixed[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, err
+	}
+	switch cmd := fixed[12] & 0xf; cmd {
+	case 0: // LOCAL: the proxy's own connection, e.g. a health check
+		return nil, nil, nil
+	case 1: // PROXY
+	default:
+		return nil, nil, fmt.Errorf("proxyproto: unsupported command %d", cmd)
+	}
+
+	var size int
+	switch fixed[13] {
+	case 0x11: // TCP over IPv4
+		size = net.IPv4len
+	case 0x21: // TCP over IPv6
+		size = net.IPv6len
+	default:
+		return nil, nil, nil
+	}
+	if len(payload) < 2*size+4 {
+		return nil, nil, fmt.Errorf("proxyproto: v2 address block too short")
+	}
+	src := &net.TCPAddr{IP: net.IP(payload[:size]), Port: int(binary.BigEndian.Uint16(payload[2*size:]))}
+	dst := &net.TCPAddr{IP: net.IP(payload[size : 2*size]), Port: int(binary.BigEndian.Uint16(payload[2*size+2:]))}
+	return src, dst, nil
+}
+
+// Header returns the version 1 or 2 header announcing a connection from src
+// to dst. Without TCP addresses for both it announces none: UNKNOWN in
+// version 1 and LOCAL in version 2.
+func Header(version int, src, dst net.Addr) []byte {
+	s, _ := src.(*net.TCPAddr)
+	d, _ := dst.(*net.TCPAddr)
+	if version == 1 {
+		return headerV1(s, d)
+	}
+	return headerV2(s, d)
+}
+
+func headerV1(src, dst *net.TCPAddr) []byte {
+	if src == nil || dst == nil {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	family := "TCP4"
+	sip, dip := src.AddrPort().Addr().Unmap(), dst.AddrPort().Addr().Unmap()
+	if !sip.Is4() || !dip.Is4() {
+		// Mixed families are announced as IPv6, with IPv4-mapped addresses.
+		family, sip, dip = "TCP6", netip.AddrFrom16(sip.As16()), netip.AddrFrom16(dip.As16())
+	}
+	return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", family, sip, dip, src.Port, dst.Port)
+}
+
+func headerV2(src, dst *net.TCPAddr) []byte {
+	out := append([]byte{}, signature...)
+	if src == nil || dst == nil {
+		return append(out, 0x20, 0x00, 0, 0) // LOCAL, unspecified family
+	}
+	family := byte(0x11)
+	sip, dip := src.IP.To4(), dst.IP.To4()
+	if sip == nil || dip == nil {
+		family, sip, dip = 0x21, src.IP.To16(), dst.IP.To16()
+	}
+	out = append(out, 0x21, family)
+	out = binary.BigEndian.AppendUint16(out, uint16(2*len(sip)+4))
+	out = append(out, sip...)
+	out = append(out, dip...)
+	out = binary.BigEndian.AppendUint16(out, uint16(src.Port))
+	return binary.BigEndian.AppendUint16(out, uint16(dst.Port))
+}
+
+type ctxKey struct{}
+
+type addrs struct{ src, dst net.Addr }
+
+// NewContext returns ctx carrying the client and destination addresses that
+// a Dialer announces on the connections it opens for ctx.
+func NewContext(ctx context.Context, src, dst net.Addr) context.Context {
+	return context.WithValue(ctx, ctxKey{}, addrs{src: src, dst: dst})
+}
+
+// DialFunc is the signature of net.Dialer.DialContext.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Dialer returns a dial function that writes a version header to every
+// connection dial opens, announcing the addresses of the dial's context.
+// Connections dialled without them, such as health probes, announce none.
+func Dialer(dial DialFunc, version int) DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		a, _ := ctx.Value(ctxKey{}).(addrs)
+		if _, err := conn.Write(Header(version, a.src, a.dst)); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// ParseAddr parses a host:port address such as http.Request.RemoteAddr,
+// returning nil when it is not an IP address and port.
+func ParseAddr(hostport string) net.Addr {
+	ap, err := netip.ParseAddrPort(hostport)
+	if err != nil {
+		return nil
+	}
+	return net.TCPAddrFromAddrPort(ap)
+}
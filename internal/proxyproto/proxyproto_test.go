@@ -0,0 +1,188 @@
+package proxyproto_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"golb/internal/proxyproto"
+)
+
+func tcpAddr(t *testing.T, s string) *net.TCPAddr {
+	t.Helper()
+	a, err := net.ResolveTCPAddr("tcp", s)
+	require.NoError(t, err)
+	return a
+}
+
+func read(t *testing.T, header string) (net.Addr, net.Addr, error) {
+	t.Helper()
+	return proxyproto.ReadHeader(bufio.NewReader(strings.NewReader(header)))
+}
+
+// ── Headers ───────────────────────────────────────────────────────────────────
+
+func TestHeader_RoundTrips(t *testing.T) {
+	for name, tc := range map[string]struct{ src, dst string }{
+		"ipv4":  {"203.0.113.7:4242", "10.0.0.1:443"},
+		"ipv6":  {"[2001:db8::7]:4242", "[2001:db8::1]:443"},
+		"mixed": {"203.0.113.7:4242", "[2001:db8::1]:443"},
+	} {
+		for _, version := range []int{1, 2} {
+			t.Run(name, func(t *testing.T) {
+				src, dst := tcpAddr(t, tc.src), tcpAddr(t, tc.dst)
+				r := bufio.NewReader(io.MultiReader(bytes.NewReader(proxyproto.Header(version, src, dst)), strings.NewReader("payload")))
+				gotSrc, gotDst, err := proxyproto.ReadHeader(r)
+				require.NoError(t, err)
+				assert.Equal(t, src.Port, gotSrc.(*net.TCPAddr).Port)
+				assert.True(t, src.IP.Equal(gotSrc.(*net.TCPAddr).IP), "v%d src %s", version, gotSrc)
+				assert.True(t, dst.IP.Equal(gotDst.(*net.TCPAddr).IP), "v%d dst %s", version, gotDst)
+				rest, _ := io.ReadAll(r)
+				assert.Equal(t, "payload", string(rest), "data after the header is left to read")
+			})
+		}
+	}
+}
+
+func TestHeader_V1Text(t *testing.T) {
+	src, dst := tcpAddr(t, "203.0.113.7:4242"), tcpAddr(t, "10.0.0.1:443")
+	assert.Equal(t, "PROXY TCP4 203.0.113.7 10.0.0.1 4242 443\r\n", string(proxyproto.Header(1, src, dst)))
+	assert.Equal(t, "PROXY TCP6 ::ffff:203.0.113.7 2001:db8::1 4242 443\r\n",
+		string(proxyproto.Header(1, src, tcpAddr(t, "[2001:db8::1]:443"))))
+	assert.Equal(t, "PROXY UNKNOWN\r\n", string(proxyproto.Header(1, nil, nil)))
+}
+
+func TestHeader_WithoutAddressesAnnouncesNone(t *testing.T) {
+	for _, version := range []int{1, 2} {
+		src, dst, err := proxyproto.ReadHeader(bufio.NewReader(bytes.NewReader(proxyproto.Header(version, nil, nil))))
+		require.NoError(t, err)
+		assert.Nil(t, src, "UNKNOWN and LOCAL carry no addresses")
+		assert.Nil(t, dst)
+	}
+}
+
+func TestReadHeader_Rejects(t *testing.T) {
+	for name, header := range map[string]string{
+		"no header":      "GET / HTTP/1.1\r\n\r\n",
+		"empty":          "",
+		"bad family":     "PROXY UDP4 1.2.3.4 5.6.7.8 1 2\r\n",
+		"family mixup":   "PROXY TCP4 2001:db8::1 5.6.7.8 1 2\r\n",
+		"bad port":       "PROXY TCP4 1.2.3.4 5.6.7.8 1 70000\r\n",
+		"missing fields": "PROXY TCP4 1.2.3.4 5.6.7.8 1\r\n",
+		"unterminated":   "PROXY TCP4 1.2.3.4 5.6.7.8 1 2" + strings.Repeat(" ", 100),
+		"v2 version":     "\r\n\r\n\x00\r\nQUIT\n\x11\x11\x00\x00",
+		"v2 short":       "\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x04\x01\x02\x03\x04",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := read(t, header)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// ── Listener ──────────────────────────────────────────────────────────────────
+
+// accept serves one connection from ln and reports its addresses and data.
+func accept(t *testing.T, ln net.Listener) <-chan []string {
+	t.Helper()
+	out := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			out <- []string{err.Error()}
+			return
+		}
+		defer conn.Close()
+		data, err := io.ReadAll(conn)
+		if err != nil {
+			data = []byte(err.Error())
+		}
+		out <- []string{conn.RemoteAddr().String(), conn.LocalAddr().String(), string(data)}
+	}()
+	return out
+}
+
+func listen(t *testing.T, trusted string) net.Listener {
+	t.Helper()
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, n, err := net.ParseCIDR(trusted)
+	require.NoError(t, err)
+	ln := proxyproto.NewListener(inner, []*net.IPNet{n}, time.Second)
+	t.Cleanup(func() { _ = ln.Close() })
+	return ln
+}
+
+func send(t *testing.T, addr, data string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	_, err = io.WriteString(conn, data)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+}
+
+func TestListener_TrustedSourcesAnnounceClients(t *testing.T) {
+	ln := listen(t, "127.0.0.0/8")
+	got := accept(t, ln)
+	send(t, ln.Addr().String(), "PROXY TCP4 203.0.113.7 10.0.0.1 4242 443\r\nhello")
+	assert.Equal(t, []string{"203.0.113.7:4242", "10.0.0.1:443", "hello"}, <-got)
+}
+
+func TestListener_TrustedSourcesMustSendAHeader(t *testing.T) {
+	ln := listen(t, "127.0.0.0/8")
+	got := accept(t, ln)
+	send(t, ln.Addr().String(), "GET / HTTP/1.1\r\n\r\n")
+	res := <-got
+	assert.True(t, strings.HasPrefix(res[0], "127.0.0.1:"), "the peer's own address remains")
+	assert.Equal(t, proxyproto.ErrNoHeader.Error(), res[2])
+}
+
+func TestListener_UntrustedSourcesAreServedAsIs(t *testing.T) {
+	ln := listen(t, "192.0.2.0/24")
+	got := accept(t, ln)
+	send(t, ln.Addr().String(), "PROXY TCP4 203.0.113.7 10.0.0.1 4242 443\r\nhello")
+	res := <-got
+	assert.True(t, strings.HasPrefix(res[0], "127.0.0.1:"), "an untrusted header is not believed")
+	assert.Equal(t, "PROXY TCP4 203.0.113.7 10.0.0.1 4242 443\r\nhello", res[2])
+}
+
+func TestListener_NoTrustedSourcesLeavesTheListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer inner.Close()
+	assert.Same(t, inner, proxyproto.NewListener(inner, nil, time.Second))
+}
+
+// ── Dialer ────────────────────────────────────────────────────────────────────
+
+func TestDialer_WritesTheContextsAddresses(t *testing.T) {
+	ln := listen(t, "127.0.0.0/8")
+	var d net.Dialer
+	dial := proxyproto.Dialer(d.DialContext, 2)
+
+	got := accept(t, ln)
+	ctx := proxyproto.NewContext(context.Background(), tcpAddr(t, "203.0.113.7:4242"), tcpAddr(t, "10.0.0.1:443"))
+	conn, err := dial(ctx, "tcp", ln.Addr().String())
+	require.NoError(t, err)
+	_, _ = io.WriteString(conn, "hello")
+	require.NoError(t, conn.Close())
+	assert.Equal(t, []string{"203.0.113.7:4242", "10.0.0.1:443", "hello"}, <-got)
+
+	// Without addresses, e.g. for health probes, the header announces none.
+	got = accept(t, ln)
+	conn, err = dial(context.Background(), "tcp", ln.Addr().String())
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	res := <-got
+	assert.True(t, strings.HasPrefix(res[0], "127.0.0.1:"))
+	assert.Empty(t, res[2])
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/url"
 	"sync/atomic"
+	"time"
 
 	"golb/internal/config"
 )
@@ -12,27 +13,42 @@ import (
 // Mutable state (health, active connections) uses atomics for lock-free
 // concurrent access from many goroutines simultaneously.
 type Backend struct {
-	URL    *url.URL
-	RawURL string
-	Weight int
+	URL      *url.URL
+	RawURL   string
+	Weight   int
+	MaxConns int               // 0 means unlimited
+	Tier     int               // failover tier of discovered backends; see Dynamic
+	Labels   map[string]string // metadata of discovered backends; nil for static ones
 
 	healthy       atomic.Bool
 	blocked       atomic.Bool
-	activeConns   atomic.Int64
+	activeConns   *atomic.Int64 // shared with a replaced backend; see ShareConns
 	totalRequests atomic.Int64
 	totalErrors   atomic.Int64
+	serverErrors  atomic.Int64
+	latency       latencyHistogram
 }
 
 // NewBackend parses rawURL and returns a healthy Backend ready for use.
+//
+// A unix:///path/to.sock backend is reached over that Unix domain socket.
+// Its URL is then a plain HTTP URL whose host stands for the socket (see
+// UnixHost), so that it is proxied and probed like any other, while RawURL
+// keeps the socket URL for logs and metrics.
 func NewBackend(rawURL string, weight int) (*Backend, error) {
 	u, err := url.Parse(rawURL)
 	if err != nil {
 		return nil, fmt.Errorf("strategy: invalid backend URL %q: %w", rawURL, err)
 	}
+	if u.Scheme == "unix" {
+		u = unixURL(u)
+	}
 	b := &Backend{
 		URL:    u,
 		RawURL: rawURL,
 		Weight: weight,
+
+		activeConns: new(atomic.Int64),
 	}
 	b.healthy.Store(true) // backends are assumed healthy at startup
 	return b, nil
@@ -46,6 +62,7 @@ func NewBackends(cfgs []config.BackendCfg) ([]*Backend, error) {
 		if err != nil {
 			return nil, err
 		}
+		b.MaxConns = c.MaxConns
 		backends = append(backends, b)
 	}
 	return backends, nil
@@ -63,6 +80,45 @@ func (b *Backend) TotalRequests() int64 { return b.totalRequests.Load() }
 func (b *Backend) IncErrors()           { b.totalErrors.Add(1) }
 func (b *Backend) TotalErrors() int64   { return b.totalErrors.Load() }
 
+// ShareConns makes b count active connections together with old, which b
+// replaces after a discovery update or a reload: requests still running on
+// old keep holding their slots, so MaxConns and least-connections see them,
+// and release them through old.DecConns. It must be called before b takes
+// requests.
+func (b *Backend) ShareConns(old *Backend) { b.activeConns = old.activeConns }
+
+// IncServerErrors counts a 5xx response returned by the backend. Unlike
+// TotalErrors, which counts failures to get a response at all, these are
+// answers the backend chose to send.
+func (b *Backend) IncServerErrors()         { b.serverErrors.Add(1) }
+func (b *Backend) TotalServerErrors() int64 { return b.serverErrors.Load() }
+
+// ObserveLatency records how long the backend took to return response headers.
+func (b *Backend) ObserveLatency(d time.Duration) { b.latency.observe(d) }
+
+// Latency returns a snapshot of the backend's response-latency histogram.
+func (b *Backend) Latency() LatencySnapshot { return b.latency.snapshot() }
+
+// TryIncConns increments the active connection count unless the backend is
+// already at MaxConns, and reports whether a connection slot was acquired.
+// The compare-and-swap loop keeps the cap exact under concurrent pickers.
+func (b *Backend) TryIncConns() bool {
+	for {
+		n := b.activeConns.Load()
+		if b.MaxConns > 0 && n >= int64(b.MaxConns) {
+			return false
+		}
+		if b.activeConns.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// IsSaturated reports whether the backend has reached its MaxConns limit.
+func (b *Backend) IsSaturated() bool {
+	return b.MaxConns > 0 && b.ActiveConns() >= int64(b.MaxConns)
+}
+
 // healthySubset returns only the healthy, non-blocked backends from the given slice.
 func healthySubset(all []*Backend) []*Backend {
 	out := make([]*Backend, 0, len(all))
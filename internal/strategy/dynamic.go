@@ -0,0 +1,76 @@
+package strategy
+
+import (
+	"errors"
+	"sort"
+	"sync/atomic"
+)
+
+// Dynamic is a Picker whose backends change at runtime, as service discovery
+// finds and loses them. Each set is served by fresh pickers of one strategy,
+// one per Backend.Tier: lower tiers are tried first, and a tier serves only
+// when every tier before it is unhealthy or saturated. A Dynamic without
+// backends has no healthy backend.
+type Dynamic struct {
+	strategy string
+	set      atomic.Pointer[dynamicSet]
+}
+
+type dynamicSet struct {
+	backends []*Backend
+	tiers    []Picker // by ascending Tier
+}
+
+// NewDynamic returns an empty Dynamic that picks with the named strategy.
+func NewDynamic(strategy string) (*Dynamic, error) {
+	if _, err := newPicker(strategy, nil); err != nil {
+		return nil, err
+	}
+	d := &Dynamic{strategy: strategy}
+	d.set.Store(&dynamicSet{})
+	return d, nil
+}
+
+// SetBackends replaces the backends. Requests already holding a backend of
+// the old set release it as usual.
+func (d *Dynamic) SetBackends(backends []*Backend) error {
+	byTier := map[int][]*Backend{}
+	var tiers []int
+	for _, b := range backends {
+		if _, ok := byTier[b.Tier]; !ok {
+			tiers = append(tiers, b.Tier)
+		}
+		byTier[b.Tier] = append(byTier[b.Tier], b)
+	}
+	sort.Ints(tiers)
+	set := &dynamicSet{backends: backends}
+	for _, t := range tiers {
+		p, err := newPicker(d.strategy, byTier[t])
+		if err != nil {
+			return err
+		}
+		set.tiers = append(set.tiers, p)
+	}
+	d.set.Store(set)
+	return nil
+}
+
+func (d *Dynamic) Next() (*Backend, error) {
+	err := ErrNoHealthyBackend
+	for _, p := range d.set.Load().tiers {
+		b, perr := p.Next()
+		if perr == nil {
+			return b, nil
+		}
+		if errors.Is(perr, ErrAllSaturated) {
+			err = perr
+		}
+	}
+	return nil, err
+}
+
+// Done releases b's connection slot. Every strategy's Done does only that,
+// so backends picked from a set since replaced are released correctly.
+func (d *Dynamic) Done(b *Backend) { b.DecConns() }
+
+func (d *Dynamic) Backends() []*Backend { return d.set.Load().backends }
@@ -0,0 +1,122 @@
+package strategy
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of the per-backend response-latency
+// histogram. Observations above the last bound land in an overflow bucket.
+var LatencyBuckets = []time.Duration{
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	75 * time.Millisecond,
+	100 * time.Millisecond,
+	150 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	750 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// latencyHistogram is a fixed-bucket histogram updated with atomics only.
+type latencyHistogram struct {
+	counts [16 + 1]atomic.Int64 // len(LatencyBuckets) + overflow
+	sum    atomic.Int64         // nanoseconds
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := 0
+	for i < len(LatencyBuckets) && d > LatencyBuckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+func (h *latencyHistogram) snapshot() LatencySnapshot {
+	s := LatencySnapshot{Counts: make([]int64, len(h.counts)), Sum: time.Duration(h.sum.Load())}
+	for i := range h.counts {
+		s.Counts[i] = h.counts[i].Load()
+	}
+	return s
+}
+
+// LatencySnapshot is a point-in-time copy of a latency histogram. Counts has
+// one entry per LatencyBuckets bound plus a final overflow bucket; entries are
+// per bucket, not cumulative.
+type LatencySnapshot struct {
+	Counts []int64
+	Sum    time.Duration
+}
+
+// Count returns the number of observations.
+func (s LatencySnapshot) Count() int64 {
+	var n int64
+	for _, c := range s.Counts {
+		n += c
+	}
+	return n
+}
+
+// Add returns the bucket-wise sum of s and o, e.g. to aggregate a pool.
+func (s LatencySnapshot) Add(o LatencySnapshot) LatencySnapshot {
+	out := LatencySnapshot{Counts: make([]int64, len(LatencyBuckets)+1), Sum: s.Sum + o.Sum}
+	for i := range out.Counts {
+		out.Counts[i] = at(s.Counts, i) + at(o.Counts, i)
+	}
+	return out
+}
+
+// Sub returns the observations in s that are not in the earlier snapshot o.
+func (s LatencySnapshot) Sub(o LatencySnapshot) LatencySnapshot {
+	out := LatencySnapshot{Counts: make([]int64, len(LatencyBuckets)+1), Sum: s.Sum - o.Sum}
+	for i := range out.Counts {
+		out.Counts[i] = at(s.Counts, i) - at(o.Counts, i)
+	}
+	return out
+}
+
+// Quantile estimates the q-quantile (0 < q ≤ 1) by linear interpolation
+// within the bucket that contains it. It returns 0 when there are no
+// observations, and the last bound for quantiles in the overflow bucket.
+func (s LatencySnapshot) Quantile(q float64) time.Duration {
+	total := s.Count()
+	if total == 0 {
+		return 0
+	}
+	rank := q * float64(total)
+	var seen float64
+	for i, c := range s.Counts {
+		if c == 0 {
+			continue
+		}
+		if seen+float64(c) >= rank {
+			if i == len(LatencyBuckets) {
+				return LatencyBuckets[len(LatencyBuckets)-1]
+			}
+			var lower time.Duration
+			if i > 0 {
+				lower = LatencyBuckets[i-1]
+			}
+			frac := (rank - seen) / float64(c)
+			return lower + time.Duration(frac*float64(LatencyBuckets[i]-lower))
+		}
+		seen += float64(c)
+	}
+	return LatencyBuckets[len(LatencyBuckets)-1]
+}
+
+func at(counts []int64, i int) int64 {
+	if i < len(counts) {
+		return counts[i]
+	}
+	return 0
+}
@@ -5,7 +5,8 @@ import "sync"
 // LeastConnections routes each new request to the healthy backend that
 // currently has the fewest active connections. Ties are broken by the order
 // backends appear in the list (first one wins). Active connection counts are
-// tracked with the atomic counter on each Backend.
+// tracked with the atomic counter on each Backend; backends at MaxConns are
+// never selected.
 type LeastConnections struct {
 	mu       sync.RWMutex
 	backends []*Backend
@@ -19,20 +20,39 @@ func (l *LeastConnections) Next() (*Backend, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
-	var best *Backend
-	for _, b := range l.backends {
-		if !b.IsHealthy() {
-			continue
+	var skip map[*Backend]bool // backends that lost a race for their last slot
+	for {
+		var best *Backend
+		healthy := 0
+		for _, b := range l.backends {
+			if !b.IsHealthy() {
+				continue
+			}
+			healthy++
+			if skip[b] || b.IsSaturated() {
+				continue
+			}
+			if best == nil || b.ActiveConns() < best.ActiveConns() {
+				best = b
+			}
 		}
-		if best == nil || b.ActiveConns() < best.ActiveConns() {
-			best = b
+		if healthy == 0 {
+			return nil, ErrNoHealthyBackend
 		}
+		if best == nil {
+			return nil, ErrAllSaturated
+		}
+		// Another goroutine may have taken the last slot since the scan.
+		if best.TryIncConns() {
+			return best, nil
+		}
+		if skip == nil {
+			skip = make(map[*Backend]bool)
+		}
+		skip[best] = true
 	}
-	if best == nil {
-		return nil, ErrNoHealthyBackend
-	}
-	best.IncConns()
-	return best, nil
 }
 
 func (l *LeastConnections) Done(b *Backend) { b.DecConns() }
+
+func (l *LeastConnections) Backends() []*Backend { return l.backends }
@@ -3,6 +3,7 @@
 package strategy
 
 import (
+	"context"
 	"errors"
 	"fmt"
 )
@@ -10,12 +11,41 @@ import (
 // ErrNoHealthyBackend is returned when every backend is marked unhealthy.
 var ErrNoHealthyBackend = errors.New("strategy: no healthy backend available")
 
+// ErrAllSaturated is returned when healthy backends exist but every one of
+// them is already serving its MaxConns connections.
+var ErrAllSaturated = errors.New("strategy: all backends at max connections")
+
 // Picker selects the next backend for an incoming request.
 // Done must be called exactly once after the request to backend b completes
-// (success or failure) — used by LeastConnections to track active connections.
+// (success or failure) — used to release the connection slot taken by Next.
 type Picker interface {
 	Next() (*Backend, error)
 	Done(b *Backend)
+	Backends() []*Backend
+}
+
+// ContextPicker is implemented by pickers whose selection may block, such as
+// Queue. The context bounds the wait.
+type ContextPicker interface {
+	Picker
+	NextContext(ctx context.Context) (*Backend, error)
+}
+
+// NextContext selects a backend from p, waiting on ctx when p supports it.
+func NextContext(ctx context.Context, p Picker) (*Backend, error) {
+	if cp, ok := p.(ContextPicker); ok {
+		return cp.NextContext(ctx)
+	}
+	return p.Next()
+}
+
+// TryNext selects a backend from p without ever waiting: a Queue only hands
+// out a backend when one is free and no request is queued ahead.
+func TryNext(p Picker) (*Backend, error) {
+	if tp, ok := p.(interface{ TryNext() (*Backend, error) }); ok {
+		return tp.TryNext()
+	}
+	return p.Next()
 }
 
 // New constructs the Picker named by strategy from the given backends.
@@ -24,6 +54,10 @@ func New(strategy string, backends []*Backend) (Picker, error) {
 	if len(backends) == 0 {
 		return nil, fmt.Errorf("strategy: at least one backend required")
 	}
+	return newPicker(strategy, backends)
+}
+
+func newPicker(strategy string, backends []*Backend) (Picker, error) {
 	switch strategy {
 	case "round_robin", "":
 		return NewRoundRobin(backends), nil
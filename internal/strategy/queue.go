@@ -0,0 +1,194 @@
+package strategy
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrQueueFull is returned when every backend is saturated and the wait
+// queue already holds its maximum number of requests.
+var ErrQueueFull = errors.New("strategy: request queue is full")
+
+// ErrQueueTimeout is returned when a queued request did not get a connection
+// slot within the queue timeout.
+var ErrQueueTimeout = errors.New("strategy: timed out waiting in request queue")
+
+// queueRecheck is how often the request at the head of the line asks the
+// picker again. Done wakes it at once; this catches slots that appear without
+// a Done: a backend recovering, or a reload or discovery adding backends.
+const queueRecheck = 100 * time.Millisecond
+
+// Queue wraps a Picker with a bounded FIFO wait queue, in the spirit of
+// HAProxy's maxconn / timeout queue. When the inner picker reports
+// ErrAllSaturated, the caller waits in line until a slot frees up, the
+// timeout elapses, or its context is cancelled. Requests arriving while others
+// are already waiting join the back of the line rather than jumping it.
+type Queue struct {
+	picker  Picker
+	maxSize int
+	timeout time.Duration
+
+	mu      sync.Mutex
+	waiters list.List // of chan struct{}, front = longest waiting
+
+	queued    atomic.Int64
+	timeouts  atomic.Int64
+	rejected  atomic.Int64
+	waitNanos atomic.Int64
+}
+
+// QueueStats is a point-in-time snapshot of a Queue's counters.
+type QueueStats struct {
+	Depth    int           // requests currently waiting
+	MaxSize  int           // configured queue bound
+	Queued   int64         // total requests that had to wait
+	Timeouts int64         // total requests that gave up after the timeout
+	Rejected int64         // total requests refused because the queue was full
+	WaitTime time.Duration // cumulative time spent waiting
+}
+
+// NewQueue wraps p with a wait queue holding at most maxSize requests, each
+// for at most timeout.
+func NewQueue(p Picker, maxSize int, timeout time.Duration) *Queue {
+	return &Queue{picker: p, maxSize: maxSize, timeout: timeout}
+}
+
+// Next is NextContext without a caller deadline; only the queue timeout applies.
+func (q *Queue) Next() (*Backend, error) {
+	return q.NextContext(context.Background())
+}
+
+// NextContext returns a backend from the inner picker, queueing while every
+// backend is saturated.
+func (q *Queue) NextContext(ctx context.Context) (*Backend, error) {
+	q.mu.Lock()
+	if q.waiters.Len() == 0 {
+		b, err := q.picker.Next()
+		if !errors.Is(err, ErrAllSaturated) {
+			q.mu.Unlock()
+			return b, err
+		}
+	}
+	if q.waiters.Len() >= q.maxSize {
+		q.mu.Unlock()
+		q.rejected.Add(1)
+		return nil, ErrQueueFull
+	}
+	wake := make(chan struct{}, 1)
+	el := q.waiters.PushBack(wake)
+	q.mu.Unlock()
+
+	q.queued.Add(1)
+	start := time.Now()
+	defer func() { q.waitNanos.Add(int64(time.Since(start))) }()
+
+	timer := time.NewTimer(q.timeout)
+	defer timer.Stop()
+	recheck := time.NewTicker(queueRecheck)
+	defer recheck.Stop()
+
+	for {
+		select {
+		case <-wake:
+			q.mu.Lock()
+			b, err := q.picker.Next()
+			if errors.Is(err, ErrAllSaturated) {
+				// The freed slot went elsewhere (e.g. a hot-reloaded picker
+				// sharing the backend); keep our place at the head of the line.
+				el = q.waiters.PushFront(wake)
+				q.mu.Unlock()
+				continue
+			}
+			q.mu.Unlock()
+			return b, err
+		case <-recheck.C:
+			q.mu.Lock()
+			if q.waiters.Front() != el {
+				q.mu.Unlock()
+				continue
+			}
+			b, err := q.picker.Next()
+			if errors.Is(err, ErrAllSaturated) {
+				q.mu.Unlock()
+				continue
+			}
+			q.waiters.Remove(el)
+			if err == nil {
+				// More capacity may have appeared; let the next in line try.
+				q.wakeLocked()
+			}
+			q.mu.Unlock()
+			return b, err
+		case <-timer.C:
+			q.leave(el, wake)
+			q.timeouts.Add(1)
+			return nil, ErrQueueTimeout
+		case <-ctx.Done():
+			q.leave(el, wake)
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// TryNext returns a backend from the inner picker if one is free and nobody
+// is waiting, and ErrAllSaturated otherwise. It never queues.
+func (q *Queue) TryNext() (*Backend, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.waiters.Len() > 0 {
+		return nil, ErrAllSaturated
+	}
+	return q.picker.Next()
+}
+
+// Done releases b in the inner picker and hands the freed slot to the
+// longest-waiting request, if any.
+func (q *Queue) Done(b *Backend) {
+	q.picker.Done(b)
+	q.mu.Lock()
+	q.wakeLocked()
+	q.mu.Unlock()
+}
+
+func (q *Queue) Backends() []*Backend { return q.picker.Backends() }
+
+// Stats returns a snapshot of the queue's depth and counters.
+func (q *Queue) Stats() QueueStats {
+	q.mu.Lock()
+	depth := q.waiters.Len()
+	q.mu.Unlock()
+	return QueueStats{
+		Depth:    depth,
+		MaxSize:  q.maxSize,
+		Queued:   q.queued.Load(),
+		Timeouts: q.timeouts.Load(),
+		Rejected: q.rejected.Load(),
+		WaitTime: time.Duration(q.waitNanos.Load()),
+	}
+}
+
+// leave removes an abandoned waiter from the line. If Done already signalled
+// it, the wake-up is passed on so the freed slot is not lost.
+func (q *Queue) leave(el *list.Element, wake chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.waiters.Remove(el) // no-op when Done already popped it
+	select {
+	case <-wake:
+		q.wakeLocked()
+	default:
+	}
+}
+
+func (q *Queue) wakeLocked() {
+	front := q.waiters.Front()
+	if front == nil {
+		return
+	}
+	q.waiters.Remove(front)
+	front.Value.(chan struct{}) <- struct{}{}
+}
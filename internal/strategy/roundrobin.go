@@ -4,7 +4,8 @@ import "sync/atomic"
 
 // RoundRobin distributes requests evenly across all healthy backends using
 // a lock-free atomic counter. The counter monotonically increases; modulo
-// arithmetic selects the backend.
+// arithmetic selects the backend. Saturated backends (at MaxConns) are
+// skipped in favour of the next one in the rotation.
 type RoundRobin struct {
 	backends []*Backend
 	counter  atomic.Uint64
@@ -20,9 +21,15 @@ func (r *RoundRobin) Next() (*Backend, error) {
 		return nil, ErrNoHealthyBackend
 	}
 	idx := r.counter.Add(1) - 1
-	b := healthy[idx%uint64(len(healthy))]
-	b.IncConns()
-	return b, nil
+	for i := range healthy {
+		b := healthy[(idx+uint64(i))%uint64(len(healthy))]
+		if b.TryIncConns() {
+			return b, nil
+		}
+	}
+	return nil, ErrAllSaturated
 }
 
 func (r *RoundRobin) Done(b *Backend) { b.DecConns() }
+
+func (r *RoundRobin) Backends() []*Backend { return r.backends }
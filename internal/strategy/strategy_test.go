@@ -1,8 +1,10 @@
 package strategy_test
 
 import (
+	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -148,6 +150,373 @@ func TestLeastConnections_Done_DecrementsCounter(t *testing.T) {
 	assert.Equal(t, int64(0), picked.ActiveConns(), "Done() should decrement counter")
 }
 
+// ── MaxConns ─────────────────────────────────────────────────────────────────
+
+func TestMaxConns_PickersSkipSaturated(t *testing.T) {
+	for _, name := range []string{"round_robin", "weighted_round_robin", "least_connections"} {
+		t.Run(name, func(t *testing.T) {
+			b1 := makeBackend(t, "http://b1:80", 1)
+			b2 := makeBackend(t, "http://b2:80", 1)
+			b1.MaxConns = 1
+
+			p, err := strategy.New(name, []*strategy.Backend{b1, b2})
+			require.NoError(t, err)
+
+			// Hold b1's only slot, then check that b1 is never picked again.
+			for {
+				b, err := p.Next()
+				require.NoError(t, err)
+				if b == b1 {
+					break
+				}
+				p.Done(b)
+			}
+			for i := 0; i < 10; i++ {
+				b, err := p.Next()
+				require.NoError(t, err)
+				assert.Equal(t, b2, b, "saturated backend must be skipped")
+				p.Done(b)
+			}
+			assert.Equal(t, int64(1), b1.ActiveConns())
+		})
+	}
+}
+
+func TestMaxConns_AllSaturated_ReturnsError(t *testing.T) {
+	b1 := makeBackend(t, "http://b1:80", 1)
+	b1.MaxConns = 1
+
+	rr := strategy.NewRoundRobin([]*strategy.Backend{b1})
+	_, err := rr.Next()
+	require.NoError(t, err)
+
+	_, err = rr.Next()
+	assert.ErrorIs(t, err, strategy.ErrAllSaturated)
+}
+
+func TestTryIncConns_RespectsCap(t *testing.T) {
+	b := makeBackend(t, "http://b1:80", 1)
+	b.MaxConns = 2
+
+	assert.True(t, b.TryIncConns())
+	assert.True(t, b.TryIncConns())
+	assert.False(t, b.TryIncConns(), "third slot must be refused")
+	assert.True(t, b.IsSaturated())
+
+	b.DecConns()
+	assert.False(t, b.IsSaturated())
+}
+
+// ── Queue ────────────────────────────────────────────────────────────────────
+
+func TestQueue_WaitsForFreedSlot(t *testing.T) {
+	b := makeBackend(t, "http://b1:80", 1)
+	b.MaxConns = 1
+	q := strategy.NewQueue(strategy.NewRoundRobin([]*strategy.Backend{b}), 10, time.Second)
+
+	first, err := q.Next()
+	require.NoError(t, err)
+
+	got := make(chan error, 1)
+	go func() {
+		_, err := q.Next()
+		got <- err
+	}()
+
+	require.Eventually(t, func() bool { return q.Stats().Depth == 1 },
+		time.Second, 5*time.Millisecond, "second request should be queued")
+
+	q.Done(first)
+	require.NoError(t, <-got, "queued request must get the freed slot")
+	assert.Equal(t, 0, q.Stats().Depth)
+	assert.Equal(t, int64(1), q.Stats().Queued)
+}
+
+func TestQueue_ServesWaiterWhenABackendRecovers(t *testing.T) {
+	busy, down := makeBackend(t, "http://b1:80", 1), makeBackend(t, "http://b2:80", 1)
+	busy.MaxConns = 1
+	down.SetHealthy(false)
+	q := strategy.NewQueue(strategy.NewRoundRobin([]*strategy.Backend{busy, down}), 10, 5*time.Second)
+
+	_, err := q.Next()
+	require.NoError(t, err)
+
+	got := make(chan *strategy.Backend, 1)
+	go func() {
+		b, _ := q.Next()
+		got <- b
+	}()
+	require.Eventually(t, func() bool { return q.Stats().Depth == 1 }, time.Second, 5*time.Millisecond)
+
+	down.SetHealthy(true)
+	select {
+	case b := <-got:
+		assert.Same(t, down, b, "the recovered backend serves the waiter without a Done")
+	case <-time.After(time.Second):
+		t.Fatal("waiter still parked after its backend recovered")
+	}
+	assert.Equal(t, 0, q.Stats().Depth)
+}
+
+func TestQueue_ServesWaitersWhenBackendsAreAdded(t *testing.T) {
+	first := makeBackend(t, "http://b1:80", 1)
+	first.MaxConns = 1
+	d, err := strategy.NewDynamic("round_robin")
+	require.NoError(t, err)
+	require.NoError(t, d.SetBackends([]*strategy.Backend{first}))
+	q := strategy.NewQueue(d, 10, 5*time.Second)
+
+	_, err = q.Next()
+	require.NoError(t, err)
+
+	got := make(chan error, 2)
+	for range 2 {
+		go func() {
+			_, err := q.Next()
+			got <- err
+		}()
+	}
+	require.Eventually(t, func() bool { return q.Stats().Depth == 2 }, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, d.SetBackends([]*strategy.Backend{first, makeBackend(t, "http://b2:80", 1), makeBackend(t, "http://b3:80", 1)}))
+	for range 2 {
+		select {
+		case err := <-got:
+			assert.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("waiters still parked after backends were added")
+		}
+	}
+}
+
+func TestQueue_FIFOOrder(t *testing.T) {
+	b := makeBackend(t, "http://b1:80", 1)
+	b.MaxConns = 1
+	q := strategy.NewQueue(strategy.NewRoundRobin([]*strategy.Backend{b}), 10, time.Second)
+
+	held, err := q.Next()
+	require.NoError(t, err)
+
+	order := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			got, err := q.Next()
+			if err == nil {
+				order <- i
+				q.Done(got)
+			}
+		}(i)
+		require.Eventually(t, func() bool { return q.Stats().Depth == i+1 },
+			time.Second, time.Millisecond)
+	}
+
+	q.Done(held)
+	for want := 0; want < 3; want++ {
+		assert.Equal(t, want, <-order, "waiters must be served in arrival order")
+	}
+}
+
+func TestQueue_TimesOut(t *testing.T) {
+	b := makeBackend(t, "http://b1:80", 1)
+	b.MaxConns = 1
+	q := strategy.NewQueue(strategy.NewRoundRobin([]*strategy.Backend{b}), 10, 20*time.Millisecond)
+
+	_, err := q.Next()
+	require.NoError(t, err)
+
+	_, err = q.Next()
+	assert.ErrorIs(t, err, strategy.ErrQueueTimeout)
+	assert.Equal(t, int64(1), q.Stats().Timeouts)
+	assert.Equal(t, 0, q.Stats().Depth, "timed-out request must leave the queue")
+}
+
+func TestQueue_FullRejectsImmediately(t *testing.T) {
+	b := makeBackend(t, "http://b1:80", 1)
+	b.MaxConns = 1
+	q := strategy.NewQueue(strategy.NewRoundRobin([]*strategy.Backend{b}), 1, time.Second)
+
+	_, err := q.Next()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _, _ = q.NextContext(ctx) }()
+	require.Eventually(t, func() bool { return q.Stats().Depth == 1 },
+		time.Second, 5*time.Millisecond)
+
+	_, err = q.Next()
+	assert.ErrorIs(t, err, strategy.ErrQueueFull)
+	assert.Equal(t, int64(1), q.Stats().Rejected)
+}
+
+func TestQueue_ContextCancelLeavesQueue(t *testing.T) {
+	b := makeBackend(t, "http://b1:80", 1)
+	b.MaxConns = 1
+	q := strategy.NewQueue(strategy.NewRoundRobin([]*strategy.Backend{b}), 10, time.Second)
+
+	_, err := q.Next()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = q.NextContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 0, q.Stats().Depth)
+}
+
+func TestQueue_TryNextNeverWaits(t *testing.T) {
+	b := makeBackend(t, "http://b1:80", 1)
+	b.MaxConns = 1
+	q := strategy.NewQueue(strategy.NewRoundRobin([]*strategy.Backend{b}), 10, time.Second)
+
+	got, err := strategy.TryNext(q)
+	require.NoError(t, err)
+	assert.Same(t, b, got)
+
+	start := time.Now()
+	_, err = strategy.TryNext(q)
+	assert.ErrorIs(t, err, strategy.ErrAllSaturated)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+	assert.Zero(t, q.Stats().Queued, "TryNext must not join the queue")
+
+	q.Done(b)
+	_, err = strategy.TryNext(q)
+	assert.NoError(t, err)
+}
+
+// ── Latency ───────────────────────────────────────────────────────────────────
+
+func TestLatency_Quantile(t *testing.T) {
+	b := makeBackend(t, "http://b1:80", 1)
+	assert.Zero(t, b.Latency().Quantile(0.99), "no observations")
+
+	for i := 0; i < 99; i++ {
+		b.ObserveLatency(20 * time.Millisecond)
+	}
+	b.ObserveLatency(3 * time.Second)
+
+	snap := b.Latency()
+	assert.Equal(t, int64(100), snap.Count())
+	p50 := snap.Quantile(0.5)
+	assert.True(t, p50 > 10*time.Millisecond && p50 <= 25*time.Millisecond, "p50 = %s", p50)
+	assert.LessOrEqual(t, snap.Quantile(0.99), 25*time.Millisecond)
+	assert.Greater(t, snap.Quantile(1), 2500*time.Millisecond)
+}
+
+func TestLatency_SubIsolatesWindow(t *testing.T) {
+	b := makeBackend(t, "http://b1:80", 1)
+	b.ObserveLatency(time.Second)
+	base := b.Latency()
+	b.ObserveLatency(time.Millisecond)
+
+	window := b.Latency().Sub(base)
+	assert.Equal(t, int64(1), window.Count())
+	assert.LessOrEqual(t, window.Quantile(0.99), time.Millisecond)
+}
+
+// ── Unix sockets ──────────────────────────────────────────────────────────────
+
+func TestNewBackend_UnixSocket(t *testing.T) {
+	b := makeBackend(t, "unix:///run/app.sock", 1)
+	assert.True(t, b.IsUnix())
+	assert.Equal(t, "unix:///run/app.sock", b.RawURL, "logs and metrics keep the socket URL")
+	assert.Equal(t, "http", b.URL.Scheme)
+	path, ok := strategy.UnixSocket(b.URL.Host)
+	assert.True(t, ok)
+	assert.Equal(t, "/run/app.sock", path)
+
+	other := makeBackend(t, "unix:///run/other.sock", 1)
+	assert.NotEqual(t, b.URL.Host, other.URL.Host, "each socket has its own host")
+	assert.False(t, makeBackend(t, "http://app:8080", 1).IsUnix())
+}
+
+func TestUnixSocket_AcceptsAPortAndRejectsOtherHosts(t *testing.T) {
+	path, ok := strategy.UnixSocket(strategy.UnixHost("/tmp/a.sock") + ":80")
+	assert.True(t, ok, "transports dial host:port")
+	assert.Equal(t, "/tmp/a.sock", path)
+
+	for _, host := range []string{"app:80", "localhost", "zz.unix.invalid"} {
+		_, ok := strategy.UnixSocket(host)
+		assert.False(t, ok, host)
+	}
+}
+
+// ── Dynamic ───────────────────────────────────────────────────────────────────
+
+func TestDynamic_EmptyHasNoHealthyBackend(t *testing.T) {
+	d, err := strategy.NewDynamic("round_robin")
+	require.NoError(t, err)
+	assert.Empty(t, d.Backends())
+	_, err = d.Next()
+	assert.ErrorIs(t, err, strategy.ErrNoHealthyBackend)
+}
+
+func TestDynamic_LowerTiersServeFirst(t *testing.T) {
+	primary := makeBackend(t, "http://primary:80", 1)
+	spare := makeBackend(t, "http://spare:80", 1)
+	spare.Tier = 10
+	primary.MaxConns = 1
+
+	d, err := strategy.NewDynamic("least_connections")
+	require.NoError(t, err)
+	require.NoError(t, d.SetBackends([]*strategy.Backend{spare, primary}))
+
+	b, err := d.Next()
+	require.NoError(t, err)
+	assert.Equal(t, primary, b)
+
+	// The primary is saturated, then unhealthy: the spare tier serves.
+	b2, err := d.Next()
+	require.NoError(t, err)
+	assert.Equal(t, spare, b2)
+	d.Done(b2)
+	d.Done(b)
+	primary.SetHealthy(false)
+	b, err = d.Next()
+	require.NoError(t, err)
+	assert.Equal(t, spare, b)
+	d.Done(b)
+
+	spare.SetHealthy(false)
+	_, err = d.Next()
+	assert.ErrorIs(t, err, strategy.ErrNoHealthyBackend)
+}
+
+func TestDynamic_SaturatedTiersReportSaturation(t *testing.T) {
+	b1 := makeBackend(t, "http://b1:80", 1)
+	b1.MaxConns = 1
+	d, err := strategy.NewDynamic("round_robin")
+	require.NoError(t, err)
+	require.NoError(t, d.SetBackends([]*strategy.Backend{b1}))
+
+	_, err = d.Next()
+	require.NoError(t, err)
+	_, err = d.Next()
+	assert.ErrorIs(t, err, strategy.ErrAllSaturated)
+}
+
+func TestDynamic_SetBackendsSwapsTheSet(t *testing.T) {
+	old := makeBackend(t, "http://old:80", 1)
+	d, err := strategy.NewDynamic("weighted_round_robin")
+	require.NoError(t, err)
+	require.NoError(t, d.SetBackends([]*strategy.Backend{old}))
+	held, err := d.Next()
+	require.NoError(t, err)
+
+	replacement := makeBackend(t, "http://new:80", 1)
+	require.NoError(t, d.SetBackends([]*strategy.Backend{replacement}))
+	assert.Equal(t, []*strategy.Backend{replacement}, d.Backends())
+	assert.Equal(t, map[string]int{"http://new:80": 10}, countDistribution(t, d, 10))
+
+	d.Done(held)
+	assert.Equal(t, int64(0), old.ActiveConns(), "backends of a replaced set are released")
+}
+
+func TestDynamic_UnknownStrategy_ReturnsError(t *testing.T) {
+	_, err := strategy.NewDynamic("magic_balancer")
+	assert.Error(t, err)
+}
+
 // ── Factory ───────────────────────────────────────────────────────────────────
 
 func TestPickerFactory_ValidStrategies(t *testing.T) {
@@ -0,0 +1,62 @@
+package strategy
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// unixHostSuffix ends the stand-in hosts of Unix socket backends. The
+// .invalid TLD is reserved, so no real host can clash with them.
+const unixHostSuffix = ".unix.invalid"
+
+// UnixHost returns the host that stands for the Unix socket at path in the
+// plain HTTP URL of a unix:// backend. Each socket has its own, so HTTP
+// connections to different sockets are never pooled together.
+func UnixHost(path string) string {
+	return hex.EncodeToString([]byte(path)) + unixHostSuffix
+}
+
+// UnixSocket returns the socket path that host, with or without a port,
+// stands for, and false when it is an ordinary host.
+func UnixSocket(host string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	encoded, ok := strings.CutSuffix(host, unixHostSuffix)
+	if !ok {
+		return "", false
+	}
+	path, err := hex.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+	return string(path), true
+}
+
+// IsUnix reports whether b is reached over a Unix domain socket.
+func (b *Backend) IsUnix() bool {
+	_, ok := UnixSocket(b.URL.Host)
+	return ok
+}
+
+// unixURL returns the plain HTTP URL standing for unix:///path/to.sock.
+func unixURL(u *url.URL) *url.URL {
+	return &url.URL{Scheme: "http", Host: UnixHost(u.Path), RawQuery: u.RawQuery}
+}
+
+// DialFunc is the signature of net.Dialer.DialContext.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// UnixDialer returns a dial function that reaches the stand-in hosts of Unix
+// socket backends over their socket, and every other address as dial does.
+func UnixDialer(dial DialFunc) DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if path, ok := UnixSocket(addr); ok {
+			return dial(ctx, "unix", path)
+		}
+		return dial(ctx, network, addr)
+	}
+}
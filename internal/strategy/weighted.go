@@ -12,6 +12,9 @@ import "sync"
 //  2. Select the backend with the highest currentWeight.
 //  3. Subtract the sum of all healthy weights from the selected backend's
 //     currentWeight.
+//
+// Backends at MaxConns take no part in the round, exactly as if they were
+// unhealthy, so their share is spread over the remaining backends.
 type WeightedRoundRobin struct {
 	mu      sync.Mutex
 	entries []*wrrEntry
@@ -34,38 +37,62 @@ func (w *WeightedRoundRobin) Next() (*Backend, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	// Collect healthy entries and their total weight.
-	var healthy []*wrrEntry
+	// Collect healthy entries with free connection slots and their total weight.
+	var eligible []*wrrEntry
+	healthy := 0
 	total := 0
 	for _, e := range w.entries {
-		if e.backend.IsHealthy() {
-			healthy = append(healthy, e)
-			total += e.backend.Weight
+		if !e.backend.IsHealthy() {
+			continue
+		}
+		healthy++
+		if e.backend.IsSaturated() {
+			continue
 		}
+		eligible = append(eligible, e)
+		total += e.backend.Weight
 	}
-	if len(healthy) == 0 {
+	if healthy == 0 {
 		return nil, ErrNoHealthyBackend
 	}
 
-	// Step 1 — raise each healthy backend's currentWeight by its weight.
-	for _, e := range healthy {
-		e.currentWeight += e.backend.Weight
-	}
+	for len(eligible) > 0 {
+		// Step 1 — raise each eligible backend's currentWeight by its weight.
+		for _, e := range eligible {
+			e.currentWeight += e.backend.Weight
+		}
 
-	// Step 2 — pick the backend with the highest currentWeight.
-	best := healthy[0]
-	for _, e := range healthy[1:] {
-		if e.currentWeight > best.currentWeight {
-			best = e
+		// Step 2 — pick the backend with the highest currentWeight.
+		bestIdx := 0
+		for i, e := range eligible[1:] {
+			if e.currentWeight > eligible[bestIdx].currentWeight {
+				bestIdx = i + 1
+			}
 		}
-	}
+		best := eligible[bestIdx]
 
-	// Step 3 — subtract the total so the winner doesn't monopolise the next
-	// several rounds.
-	best.currentWeight -= total
+		// Step 3 — subtract the total so the winner doesn't monopolise the next
+		// several rounds.
+		best.currentWeight -= total
 
-	best.backend.IncConns()
-	return best.backend, nil
+		if best.backend.TryIncConns() {
+			return best.backend, nil
+		}
+
+		// The winner filled up concurrently (another picker sharing the
+		// backend during a hot-reload); drop it and run another round.
+		total -= best.backend.Weight
+		eligible = append(eligible[:bestIdx], eligible[bestIdx+1:]...)
+	}
+	return nil, ErrAllSaturated
 }
 
 func (w *WeightedRoundRobin) Done(b *Backend) { b.DecConns() }
+
+func (w *WeightedRoundRobin) Backends() []*Backend {
+	out := make([]*Backend, len(w.entries))
+	for i, e := range w.entries {
+		out[i] = e.backend
+	}
+	return out
+}
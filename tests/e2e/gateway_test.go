@@ -1,12 +1,23 @@
 package e2e
 
 import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/quic-go/quic-go/http3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"golb/internal/proxyproto"
 )
 
 // ── Health endpoint ──────────────────────────────────────────────────────────
@@ -93,6 +104,33 @@ func TestE2E_PassiveFailover_Returns502OnDeadBackend(t *testing.T) {
 	assert.True(t, got502, "at least one request to the dead backend must return 502")
 }
 
+// ── Pools and routes ─────────────────────────────────────────────────────────
+
+func TestE2E_Routes_SendPrefixToNamedPool(t *testing.T) {
+	web := newEchoBackend(t, "web")
+	api := newEchoBackend(t, "api")
+
+	cfg := gatewayConfig{
+		addr:     freeAddr(t),
+		backends: []string{web.URL},
+		extra: fmt.Sprintf(`pools:
+  - name: api
+    backends:
+      - url: %q
+routes:
+  - path_prefix: /api
+    pool: api
+  - path_prefix: /
+`, api.URL),
+	}
+	gw := startGateway(t, cfg.YAML())
+
+	_, body := doGet(t, "http://"+gw.addr+"/api/users")
+	assert.Equal(t, "api", body)
+	_, body = doGet(t, "http://"+gw.addr+"/index.html")
+	assert.Equal(t, "web", body, "unmatched prefixes fall through to the default pool")
+}
+
 // ── Rate limiting ─────────────────────────────────────────────────────────────
 
 func TestE2E_RateLimit_Blocks_After_Burst(t *testing.T) {
@@ -204,3 +242,284 @@ func TestE2E_HotReload_AddsBackend(t *testing.T) {
 	assert.Greater(t, seen["b1"], 0, "b1 must still receive traffic after reload")
 	assert.Greater(t, seen["b2"], 0, "b2 must receive traffic after reload")
 }
+
+// ── Listeners: HTTP/2, h2c and HTTP/3 ────────────────────────────────────────
+
+func TestE2E_Listeners_ServeH2H2CAndH3ThroughOneChain(t *testing.T) {
+	backend := newEchoBackend(t, "hello")
+	certFile, keyFile, roots := selfSignedCert(t)
+	tlsAddr := freeAddr(t)
+	_, port, _ := net.SplitHostPort(tlsAddr)
+
+	cfg := gatewayConfig{
+		addr:     freeAddr(t),
+		backends: []string{backend.URL},
+		extra: fmt.Sprintf(`server:
+  h2c: true
+  tls:
+    cert_file: %q
+    key_file: %q
+    listen_addr: %q
+  http2:
+    max_concurrent_streams: 100
+  http3:
+    enabled: true
+    alt_svc_max_age: "1h"
+`, certFile, keyFile, tlsAddr),
+	}
+	gw := startGateway(t, cfg.YAML())
+
+	get := func(t *testing.T, client *http.Client, url string) *http.Response {
+		t.Helper()
+		resp, err := client.Get(url)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "hello", string(body))
+		assert.NotEmpty(t, resp.Header.Get("X-Request-Id"), "every listener runs the middleware chain")
+		return resp
+	}
+
+	t.Run("https negotiates HTTP/2 and advertises HTTP/3", func(t *testing.T) {
+		client := &http.Client{Timeout: 5 * time.Second, Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots},
+			ForceAttemptHTTP2: true,
+		}}
+		resp := get(t, client, "https://"+tlsAddr+"/")
+		assert.Equal(t, 2, resp.ProtoMajor)
+		assert.Equal(t, `h3=":`+port+`"; ma=3600`, resp.Header.Get("Alt-Svc"))
+	})
+
+	t.Run("plaintext accepts h2c", func(t *testing.T) {
+		protocols := new(http.Protocols)
+		protocols.SetUnencryptedHTTP2(true)
+		client := &http.Client{Timeout: 5 * time.Second, Transport: &http.Transport{Protocols: protocols}}
+		resp := get(t, client, "http://"+gw.addr+"/")
+		assert.Equal(t, 2, resp.ProtoMajor)
+		assert.Empty(t, resp.Header.Get("Alt-Svc"), "only HTTPS responses advertise HTTP/3")
+	})
+
+	t.Run("plaintext still serves HTTP/1.1", func(t *testing.T) {
+		resp := get(t, &http.Client{Timeout: 5 * time.Second}, "http://"+gw.addr+"/")
+		assert.Equal(t, 1, resp.ProtoMajor)
+	})
+
+	t.Run("http3 over QUIC", func(t *testing.T) {
+		tr := &http3.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+		t.Cleanup(func() { _ = tr.Close() })
+		resp := get(t, &http.Client{Timeout: 5 * time.Second, Transport: tr}, "https://"+tlsAddr+"/")
+		assert.Equal(t, 3, resp.ProtoMajor)
+	})
+}
+
+// ── Layer-4 TCP listeners ────────────────────────────────────────────────────
+
+func TestE2E_TCPListener_BalancesRawConnections(t *testing.T) {
+	var names []string
+	var urls string
+	for _, name := range []string{"replica-1", "replica-2"} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = ln.Close() })
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					_, _ = io.WriteString(conn, name+"\n")
+					_, _ = io.Copy(conn, conn)
+				}()
+			}
+		}()
+		names = append(names, name)
+		urls += fmt.Sprintf("      - url: \"tcp://%s\"\n", ln.Addr())
+	}
+
+	tcpAddr := freeAddr(t)
+	cfg := gatewayConfig{
+		addr:        freeAddr(t),
+		backends:    []string{newEchoBackend(t, "web").URL},
+		healthCheck: true,
+		extra:       fmt.Sprintf("pools:\n  - name: pg\n    backends:\n%stcp:\n  - name: postgres\n    listen_addr: %q\n    pool: pg\n", urls, tcpAddr),
+	}
+	startGateway(t, cfg.YAML())
+
+	seen := map[string]bool{}
+	for range 4 {
+		conn, err := net.Dial("tcp", tcpAddr)
+		require.NoError(t, err)
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		seen[strings.TrimSpace(string(buf[:n]))] = true
+
+		_, err = conn.Write([]byte("PING"))
+		require.NoError(t, err)
+		n, err = io.ReadFull(conn, buf[:4])
+		require.NoError(t, err)
+		assert.Equal(t, "PING", string(buf[:n]))
+		_ = conn.Close()
+	}
+	for _, name := range names {
+		assert.True(t, seen[name], "%s should receive connections", name)
+	}
+}
+
+func TestE2E_UDPListener_BalancesDatagramSessions(t *testing.T) {
+	var urls string
+	for _, name := range []string{"ns1", "ns2"} {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = pc.Close() })
+		go func() {
+			buf := make([]byte, 512)
+			for {
+				n, addr, err := pc.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				reply := name + ":" + string(buf[:n])
+				if string(buf[:n]) == "health?" {
+					reply = "ok"
+				}
+				_, _ = pc.WriteTo([]byte(reply), addr)
+			}
+		}()
+		urls += fmt.Sprintf("      - url: \"udp://%s\"\n", pc.LocalAddr())
+	}
+
+	udpAddr := freeAddr(t)
+	cfg := gatewayConfig{
+		addr:        freeAddr(t),
+		backends:    []string{newEchoBackend(t, "web").URL},
+		healthCheck: true,
+		extra: fmt.Sprintf("pools:\n  - name: dns\n    health_check: {send: \"health?\", expect: ok}\n    backends:\n%s"+
+			"udp:\n  - name: dns\n    listen_addr: %q\n    pool: dns\n", urls, udpAddr),
+	}
+	startGateway(t, cfg.YAML())
+
+	// Datagrams sent before the listener is bound are lost, not refused.
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("udp", udpAddr)
+		if err != nil {
+			return false
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(100 * time.Millisecond))
+		_, _ = conn.Write([]byte("ready?"))
+		_, err = conn.Read(make([]byte, 512))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	seen := map[string]bool{}
+	for range 4 {
+		conn, err := net.Dial("udp", udpAddr)
+		require.NoError(t, err)
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Write([]byte("example.com?"))
+		require.NoError(t, err)
+		buf := make([]byte, 512)
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		name, query, _ := strings.Cut(string(buf[:n]), ":")
+		assert.Equal(t, "example.com?", query)
+		seen[name] = true
+		_ = conn.Close()
+	}
+	assert.Equal(t, map[string]bool{"ns1": true, "ns2": true}, seen)
+}
+
+// ── PROXY protocol ────────────────────────────────────────────────────────────
+
+func TestE2E_ProxyProtocol_PreservesClientAddressEndToEnd(t *testing.T) {
+	// The backend reads the header the gateway sends and answers with the
+	// client address it announces.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	backend := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.RemoteAddr)
+	})}
+	go func() { _ = backend.Serve(proxyproto.NewListener(ln, []*net.IPNet{loopback}, time.Second)) }()
+	t.Cleanup(func() { _ = backend.Close() })
+
+	cfg := gatewayConfig{
+		addr:        freeAddr(t),
+		backends:    []string{"http://" + ln.Addr().String()},
+		healthCheck: true, // probes announce no client, and must still pass
+		extra: fmt.Sprintf(`server:
+  proxy_protocol:
+    trusted_cidrs: ["127.0.0.2"]
+pools:
+  - name: app
+    send_proxy_protocol: v2
+    backends:
+      - url: "http://%s"
+routes:
+  - path_prefix: /
+    pool: app
+`, ln.Addr()),
+	}
+	gw := startGateway(t, cfg.YAML())
+
+	// A trusted load balancer announces the client.
+	dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.2")}, Timeout: 5 * time.Second}
+	conn, err := dialer.Dial("tcp", gw.addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "PROXY TCP4 203.0.113.7 127.0.0.1 4242 80\r\n"+
+		"GET /whoami HTTP/1.1\r\nHost: gateway\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "203.0.113.7:4242", string(body))
+
+	// Other sources are not trusted; the gateway announces their own address.
+	status, body2 := doGet(t, "http://"+gw.addr+"/whoami")
+	assert.Equal(t, http.StatusOK, status)
+	assert.True(t, strings.HasPrefix(body2, "127.0.0.1:"), body2)
+}
+
+// ── Unix domain sockets ──────────────────────────────────────────────────────
+
+func TestE2E_UnixSockets_ListenAndProxyOverSockets(t *testing.T) {
+	dir := t.TempDir()
+	ln, err := net.Listen("unix", filepath.Join(dir, "app.sock"))
+	require.NoError(t, err)
+	backend := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "app via %s", r.Host)
+	})}
+	go func() { _ = backend.Serve(ln) }()
+	t.Cleanup(func() { _ = backend.Close() })
+
+	sock := filepath.Join(dir, "gw.sock")
+	cfg := gatewayConfig{
+		addr:        "unix://" + sock,
+		backends:    []string{"unix://" + filepath.Join(dir, "app.sock")},
+		healthCheck: true, // probed over the socket as well
+		extra:       "server:\n  unix_socket_mode: \"0660\"\n",
+	}
+	startGateway(t, cfg.YAML())
+
+	fi, err := os.Stat(sock)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o660), fi.Mode().Perm())
+
+	client := &http.Client{Transport: unixTransport(sock), Timeout: 5 * time.Second}
+	for range 3 {
+		resp, err := client.Get("http://gateway/hello")
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "app via localhost", string(body))
+	}
+}
@@ -5,15 +5,24 @@
 package e2e
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"log"
+	"math/big"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
@@ -107,13 +116,19 @@ func rewriteConfig(t *testing.T, gw *gatewayProcess, configYAML string) {
 	require.NoError(t, os.WriteFile(gw.cfgFile, []byte(configYAML), 0o644))
 }
 
-// waitReady polls GET /healthz on addr until it returns 200 or times out.
+// waitReady polls GET /healthz on addr, a TCP address or a unix:// socket,
+// until it returns 200 or times out.
 func waitReady(t *testing.T, addr string) {
 	t.Helper()
 	client := &http.Client{Timeout: 200 * time.Millisecond}
+	base := "http://" + addr
+	if path, ok := strings.CutPrefix(addr, "unix://"); ok {
+		client.Transport = unixTransport(path)
+		base = "http://gateway"
+	}
 	deadline := time.Now().Add(8 * time.Second)
 	for time.Now().Before(deadline) {
-		resp, err := client.Get("http://" + addr + "/healthz")
+		resp, err := client.Get(base + "/healthz")
 		if err == nil && resp.StatusCode == http.StatusOK {
 			resp.Body.Close()
 			return
@@ -137,6 +152,14 @@ func freeAddr(t *testing.T) string {
 	return addr
 }
 
+// unixTransport sends every request over the Unix socket at path.
+func unixTransport(path string) *http.Transport {
+	return &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", path)
+	}}
+}
+
 // newEchoBackend starts an httptest.Server that always responds with body.
 func newEchoBackend(t *testing.T, body string) *httptest.Server {
 	t.Helper()
@@ -147,6 +170,39 @@ func newEchoBackend(t *testing.T, body string) *httptest.Server {
 	return srv
 }
 
+// selfSignedCert writes a certificate and key for 127.0.0.1 to the test's
+// temp dir and returns their paths with a pool that trusts the certificate.
+func selfSignedCert(t *testing.T) (certFile, keyFile string, roots *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "flux-e2e"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	roots = x509.NewCertPool()
+	roots.AddCert(cert)
+	return certFile, keyFile, roots
+}
+
 // makeJWT creates a signed HS256 JWT token with a 1-hour expiry.
 func makeJWT(t *testing.T, secret string) string {
 	t.Helper()
@@ -183,6 +239,7 @@ type gatewayConfig struct {
 	healthCheck bool
 	rateLimit   *rateLimitCfg
 	auth        *authCfg
+	extra       string // raw YAML appended verbatim (pools, routes, …)
 }
 
 type rateLimitCfg struct {
@@ -241,6 +298,7 @@ health_check:
 		out += "auth:\n  enabled: false\n"
 	}
 
+	out += c.extra
 	return out
 }
 